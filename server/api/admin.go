@@ -42,9 +42,15 @@ type AdminCreateUpdateUserRequest struct {
 
 // AdminSettingsResponse представляет настройки системы
 type AdminSettingsResponse struct {
-	RegistrationEnabled bool `json:"registration_enabled"`
-	MaintenanceMode     bool `json:"maintenance_mode"`
-	EnableGeminiPro     bool `json:"enable_gemini_pro"` // Новое поле
+	RegistrationEnabled bool   `json:"registration_enabled"`
+	MaintenanceMode     bool   `json:"maintenance_mode"`
+	EnableGeminiPro     bool   `json:"enable_gemini_pro"` // Новое поле
+	RequireAdmin2FA     bool   `json:"require_admin_2fa"`
+	MaxFileSizeMB       int    `json:"max_file_size_mb"`
+	AllowedMimeTypes    string `json:"allowed_mime_types"`
+	UserQuotaMB         int    `json:"user_quota_mb"`
+	TotalQuotaMB        int    `json:"total_quota_mb"`
+	RetentionDays       int    `json:"retention_days"`
 }
 
 // AdminUpdateSettingsRequest представляет запрос на обновление настроек
@@ -52,6 +58,13 @@ type AdminUpdateSettingsRequest struct {
 	RegistrationEnabled bool `json:"registration_enabled"`
 	MaintenanceMode     bool `json:"maintenance_mode"`
 	EnableGeminiPro     bool `json:"enable_gemini_pro"` // Новое поле
+	// Настройки ниже меняются, только если переданы: прежние версии панели их не отправляют
+	RequireAdmin2FA  *bool   `json:"require_admin_2fa"`
+	MaxFileSizeMB    *int    `json:"max_file_size_mb" binding:"omitempty,min=1,max=1000"`
+	AllowedMimeTypes *string `json:"allowed_mime_types"`
+	UserQuotaMB      *int    `json:"user_quota_mb" binding:"omitempty,min=0"`
+	TotalQuotaMB     *int    `json:"total_quota_mb" binding:"omitempty,min=0"`
+	RetentionDays    *int    `json:"retention_days" binding:"omitempty,min=0,max=3650"`
 }
 
 // AdminStatsResponse представляет статистику системы
@@ -115,6 +128,12 @@ func (s *Server) handleAdminGetSettings(c *gin.Context) {
 		RegistrationEnabled: s.config.Server.RegistrationEnabled,
 		MaintenanceMode:     s.config.Server.MaintenanceMode,
 		EnableGeminiPro:     s.config.Server.EnableGeminiPro, // Читаем новое поле
+		RequireAdmin2FA:     s.config.Server.RequireAdmin2FA,
+		MaxFileSizeMB:       s.config.FileStorage.MaxSizeMB,
+		AllowedMimeTypes:    s.config.FileStorage.AllowedMimeTypes,
+		UserQuotaMB:         s.config.FileStorage.UserQuotaMB,
+		TotalQuotaMB:        s.config.FileStorage.TotalQuotaMB,
+		RetentionDays:       s.config.FileStorage.RetentionDays,
 	}
 
 	c.JSON(http.StatusOK, settings)
@@ -137,17 +156,52 @@ func (s *Server) handleAdminUpdateSettings(c *gin.Context) {
 
 	logger.Debugf("Получен запрос на обновление настроек: %+v", req)
 
+	var allowedMimeTypes string
+	if req.AllowedMimeTypes != nil {
+		var err error
+		if allowedMimeTypes, err = normalizeMimeList(*req.AllowedMimeTypes); err != nil {
+			SendBadRequest(c, "Некорректный список разрешенных типов файлов: "+err.Error())
+			return
+		}
+	}
+
 	// Блокируем конфигурацию для записи
 	s.configLock.Lock()
 	// Обновляем значения в конфигурации сервера из запроса (req)
 	s.config.Server.RegistrationEnabled = req.RegistrationEnabled
 	s.config.Server.MaintenanceMode = req.MaintenanceMode
 	s.config.Server.EnableGeminiPro = req.EnableGeminiPro // Обновляем новое поле
+	if req.RequireAdmin2FA != nil {
+		s.config.Server.RequireAdmin2FA = *req.RequireAdmin2FA
+	}
+	// Лимиты загрузки файлов читаются при каждой загрузке, перезапуск не нужен
+	if req.MaxFileSizeMB != nil {
+		s.config.FileStorage.MaxSizeMB = *req.MaxFileSizeMB
+	}
+	if req.AllowedMimeTypes != nil {
+		s.config.FileStorage.AllowedMimeTypes = allowedMimeTypes
+	}
+	// Квоты проверяются при каждой загрузке, новый срок хранения применит следующий проход очистки
+	if req.UserQuotaMB != nil {
+		s.config.FileStorage.UserQuotaMB = *req.UserQuotaMB
+	}
+	if req.TotalQuotaMB != nil {
+		s.config.FileStorage.TotalQuotaMB = *req.TotalQuotaMB
+	}
+	if req.RetentionDays != nil {
+		s.config.FileStorage.RetentionDays = *req.RetentionDays
+	}
 	// Создаем копию обновленных значений для логирования (уже после обновления!)
 	updatedSettingsForLog := AdminSettingsResponse{
 		RegistrationEnabled: s.config.Server.RegistrationEnabled,
 		MaintenanceMode:     s.config.Server.MaintenanceMode,
 		EnableGeminiPro:     s.config.Server.EnableGeminiPro, // Добавляем в лог
+		RequireAdmin2FA:     s.config.Server.RequireAdmin2FA,
+		MaxFileSizeMB:       s.config.FileStorage.MaxSizeMB,
+		AllowedMimeTypes:    s.config.FileStorage.AllowedMimeTypes,
+		UserQuotaMB:         s.config.FileStorage.UserQuotaMB,
+		TotalQuotaMB:        s.config.FileStorage.TotalQuotaMB,
+		RetentionDays:       s.config.FileStorage.RetentionDays,
 	}
 	s.configLock.Unlock() // Разблокируем сразу после обновления в памяти
 
@@ -158,11 +212,17 @@ func (s *Server) handleAdminUpdateSettings(c *gin.Context) {
 		return
 	}
 
-	logger.Infof("Настройки успешно обновлены администратором %s: Регистрация=%t, Обслуживание=%t, GeminiPro=%t",
+	logger.Infof("Настройки успешно обновлены администратором %s: Регистрация=%t, Обслуживание=%t, GeminiPro=%t, 2FA для администраторов=%t, Размер файла=%d МБ, Типы файлов=%s, Квота пользователя=%d МБ, Общая квота=%d МБ, Срок хранения=%d дн.",
 		c.GetString("username"), // Получаем имя пользователя из контекста
 		updatedSettingsForLog.RegistrationEnabled,
 		updatedSettingsForLog.MaintenanceMode,
-		updatedSettingsForLog.EnableGeminiPro) // Добавляем в лог
+		updatedSettingsForLog.EnableGeminiPro, // Добавляем в лог
+		updatedSettingsForLog.RequireAdmin2FA,
+		updatedSettingsForLog.MaxFileSizeMB,
+		updatedSettingsForLog.AllowedMimeTypes,
+		updatedSettingsForLog.UserQuotaMB,
+		updatedSettingsForLog.TotalQuotaMB,
+		updatedSettingsForLog.RetentionDays)
 
 	// Возвращаем обновленные настройки (уже после сохранения)
 	c.JSON(http.StatusOK, updatedSettingsForLog)
@@ -220,6 +280,10 @@ func (s *Server) handleAdminUpdateUser(c *gin.Context) {
 	// Обновление пароля, если он предоставлен
 	if req.Password != "" {
 		logger.Debugf("handleAdminUpdateUser: Обновление пароля для пользователя ID %d", userID)
+		if err := s.validatePassword(req.Password, req.Username); err != nil {
+			SendAPIError(c, err)
+			return
+		}
 		user.Password = req.Password
 		if err := user.HashPassword(); err != nil {
 			logger.Errorf("handleAdminUpdateUser: Ошибка хеширования нового пароля для пользователя ID %d: %v", userID, err)
@@ -237,6 +301,12 @@ func (s *Server) handleAdminUpdateUser(c *gin.Context) {
 
 	logger.Infof("handleAdminUpdateUser: Пользователь ID %d успешно обновлен", userID)
 
+	// Новая роль и блокировка применяются к следующему запросу пользователя
+	s.userStatuses.invalidate(user.ID)
+	if user.Blocked {
+		s.disconnectUser(user.ID, wsCloseUserBlocked, "user blocked")
+	}
+
 	// Формирование ответа (без пароля)
 	respUser := AdminUserResponse{
 		ID:        user.ID,
@@ -302,6 +372,15 @@ func (s *Server) handleAdminDeleteUser(c *gin.Context) {
 	}
 
 	logger.Infof("handleAdminDeleteUser: Пользователь с ID %d успешно удален администратором ID: %d", userID, currentUserID)
+
+	// Отзываем сессии удаленного пользователя и закрываем его подключения
+	revoked, err := s.db.RevokeUserSessions(user.ID, 0)
+	if err != nil {
+		logger.Errorf("handleAdminDeleteUser: Ошибка отзыва сессий пользователя ID %d: %v", userID, err)
+	}
+	s.revokeSessions(revoked...)
+	s.userStatuses.invalidate(user.ID)
+	s.disconnectUser(user.ID, wsCloseUserBlocked, "user deleted")
 	c.JSON(http.StatusOK, gin.H{"message": "Пользователь успешно удален"})
 }
 
@@ -321,12 +400,8 @@ func (s *Server) handleAdminStats(c *gin.Context) {
 	// Уточните имя модели сообщения, если оно другое (например, models.ChatMessage)
 	s.db.DB.Model(&models.Message{}).Count(&messageCount)
 
-	// Подсчет активных WebSocket соединений (примерный)
-	activeConnections := 0
-	s.wsClients.Range(func(_, _ interface{}) bool {
-		activeConnections++
-		return true
-	})
+	// Подсчет активных подключений (WebSocket, SSE, long-poll)
+	activeConnections := s.hub.count()
 
 	stats := AdminStatsResponse{
 		UserCount:         userCount,
@@ -422,6 +497,10 @@ func (s *Server) handleAdminBlockUser(c *gin.Context) {
 	}
 	user.Blocked = true // Обновляем состояние в локальной переменной для ответа
 
+	// Применяем блокировку сразу: сбрасываем кеш проверок и отключаем пользователя
+	s.userStatuses.invalidate(user.ID)
+	s.disconnectUser(user.ID, wsCloseUserBlocked, "user blocked")
+
 	logger.Infof("handleAdminBlockUser: Пользователь ID %d успешно заблокирован администратором ID %d", userID, currentUserID)
 
 	// Формирование ответа
@@ -479,6 +558,7 @@ func (s *Server) handleAdminUnblockUser(c *gin.Context) {
 		return
 	}
 	user.Blocked = false // Обновляем состояние в локальной переменной для ответа
+	s.userStatuses.invalidate(user.ID)
 
 	logger.Infof("handleAdminUnblockUser: Пользователь ID %d успешно разблокирован", userID)
 
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminUpdateSettingsKeepsOmittedFields(t *testing.T) {
+	// Настройки сохраняются в файл, в тесте - во временный
+	t.Setenv("CONFIG_PATH", filepath.Join(t.TempDir(), "config.json"))
+
+	s := newTestServer(t, newTestConfig())
+	s.config.Server.RequireAdmin2FA = true
+	s.config.FileStorage.MaxSizeMB = 50
+	s.router.PUT("/api/admin/settings", func(c *gin.Context) {
+		c.Set("role", "admin")
+		c.Set("username", "admin")
+	}, s.handleAdminUpdateSettings)
+
+	update := func(body string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPut, "/api/admin/settings", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("сохранение настроек: статус %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	// Так сохраняет настройки панель администратора, не знающая о 2FA и лимитах файлов
+	update(`{"registration_enabled": true, "maintenance_mode": false, "enable_gemini_pro": false}`)
+	if !s.config.Server.RequireAdmin2FA {
+		t.Error("требование 2FA для администраторов снято запросом без require_admin_2fa")
+	}
+	if s.config.FileStorage.MaxSizeMB != 50 {
+		t.Errorf("максимальный размер файла %d МБ, ожидалось 50", s.config.FileStorage.MaxSizeMB)
+	}
+	if !s.config.Server.RegistrationEnabled {
+		t.Error("переданная настройка регистрации не применена")
+	}
+
+	update(`{"registration_enabled": true, "require_admin_2fa": false}`)
+	if s.config.Server.RequireAdmin2FA {
+		t.Error("явно переданное require_admin_2fa: false не применено")
+	}
+}
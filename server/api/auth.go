@@ -3,12 +3,12 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
-	"messenger/middleware"
 	"messenger/models"
 )
 
@@ -18,19 +18,22 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token string      `json:"token"`
-	User  models.User `json:"user"`
+	Token        string      `json:"token"`
+	RefreshToken string      `json:"refresh_token"`
+	ExpiresIn    int64       `json:"expires_in"` // Срок жизни access токена в секундах
+	User         models.User `json:"user"`
 }
 
 type InitSetupRequest struct {
 	AdminUsername string `json:"admin_username" binding:"required,min=3,max=30"`
-	AdminPassword string `json:"admin_password" binding:"required,min=8"`
+	AdminPassword string `json:"admin_password" binding:"required"`
 }
 
 // Структура запроса на регистрацию
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=30"`
-	Password string `json:"password" binding:"required,min=8"`
+	Password string `json:"password" binding:"required"`       // Требования задает политика паролей
+	Email    string `json:"email" binding:"omitempty,max=254"` // Обязателен, если включено подтверждение email
 }
 
 // Проверка, инициализирована ли система
@@ -70,6 +73,11 @@ func (s *Server) handleInitialSetup(c *gin.Context) {
 		return
 	}
 
+	if err := s.validatePassword(req.AdminPassword, req.AdminUsername); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
 	// Создаем администратора
 	admin := models.User{
 		Username: req.AdminUsername,
@@ -93,20 +101,14 @@ func (s *Server) handleInitialSetup(c *gin.Context) {
 		return
 	}
 
-	// Генерируем JWT токен для автоматического входа
-	token, err := middleware.GenerateToken(admin.ID, admin.Username, admin.Role, s.config.JWT.Secret, s.config.JWT.Expiry)
+	// Создаем сессию для автоматического входа
+	response, err := s.issueSession(c, &admin)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации токена"})
+		SendAPIError(c, err)
 		return
 	}
 
-	// Не возвращаем пароль
-	admin.Password = ""
-
-	c.JSON(http.StatusOK, LoginResponse{
-		Token: token,
-		User:  admin,
-	})
+	c.JSON(http.StatusOK, response)
 }
 
 // Обработчик входа в систему
@@ -121,11 +123,10 @@ func (s *Server) handleLogin(c *gin.Context) {
 	// Безопасное логирование (без пароля)
 	fmt.Printf("Попытка входа: пользователь %s\n", req.Username)
 
-	// Ищем пользователя
-	var user models.User
-	result := s.db.DB.Where("username = ?", req.Username).First(&user)
-	if result.Error != nil {
-		fmt.Printf("Ошибка поиска пользователя %s: %v\n", req.Username, result.Error)
+	// Проверяем пароль способами из конфигурации (локальная база, LDAP)
+	identity, err := s.authenticators.Authenticate(c.Request.Context(), req.Username, req.Password)
+	if err != nil {
+		fmt.Printf("Неудачная попытка входа пользователя %s: %v\n", req.Username, err)
 
 		// Используем одинаковое сообщение об ошибке независимо от причины
 		// (защита от перечисления пользователей)
@@ -136,39 +137,41 @@ func (s *Server) handleLogin(c *gin.Context) {
 		return
 	}
 
-	fmt.Printf("Пользователь найден: %s, ID: %d, Роль: %s\n", user.Username, user.ID, user.Role)
-
-	// Проверяем пароль
-	if !user.CheckPassword(req.Password) {
-		fmt.Printf("Неверный пароль для пользователя %s\n", req.Username)
-
-		// Используем то же сообщение об ошибке, что и выше
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверное имя пользователя или пароль"})
-
-		// Добавляем небольшую задержку для защиты от атак перебором
-		time.Sleep(300 * time.Millisecond)
+	user, err := s.resolveLoginUser(identity)
+	if err != nil {
+		SendAPIError(c, err)
 		return
 	}
 
-	fmt.Printf("Пароль верный для пользователя %s, генерируем токен\n", req.Username)
+	fmt.Printf("Пользователь найден: %s, ID: %d, Роль: %s, источник: %s\n", user.Username, user.ID, user.Role, identity.Source)
 
-	// Генерируем JWT токен
-	token, err := middleware.GenerateToken(user.ID, user.Username, user.Role, s.config.JWT.Secret, s.config.JWT.Expiry)
-	if err != nil {
-		fmt.Printf("Ошибка генерации токена: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации токена"})
+	// Заблокированный пользователь не может войти
+	if user.Blocked {
+		fmt.Printf("Попытка входа заблокированного пользователя %s\n", req.Username)
+		SendAPIError(c, ErrUserBlocked())
 		return
 	}
 
-	fmt.Printf("Успешный вход пользователя %s\n", req.Username)
+	if s.emailVerificationPending(user) {
+		fmt.Printf("Попытка входа пользователя %s с неподтвержденным email\n", req.Username)
+		SendError(c, http.StatusForbidden, ErrCodeEmailNotVerified, "Подтвердите email по ссылке из письма")
+		return
+	}
 
-	// Не возвращаем пароль
-	user.Password = ""
+	// Администратор потребовал сменить пароль: вход продолжится после смены
+	if user.PasswordResetRequired {
+		challenge, err := s.passwordResetChallenge(user)
+		if err != nil {
+			SendAPIError(c, err)
+			return
+		}
+		fmt.Printf("Пользователь %s должен сменить пароль\n", req.Username)
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
 
-	c.JSON(http.StatusOK, LoginResponse{
-		Token: token,
-		User:  user,
-	})
+	// Второй шаг входа (2FA) или выдача сессии
+	s.completeLogin(c, user)
 }
 
 // Добавим проверку состояния аутентификации для клиента
@@ -199,7 +202,7 @@ func (s *Server) handleAuthCheck(c *gin.Context) {
 func (s *Server) handleRegister(c *gin.Context) {
 	// Проверяем, разрешена ли регистрация в конфигурации
 	if !s.config.Server.RegistrationEnabled {
-		SendError(c, http.StatusForbidden, "REGISTRATION_DISABLED", "Регистрация временно отключена администратором")
+		SendError(c, http.StatusForbidden, ErrCodeRegistrationDisabled, "Регистрация временно отключена администратором")
 		return
 	}
 
@@ -222,9 +225,25 @@ func (s *Server) handleRegister(c *gin.Context) {
 		return
 	}
 
+	if err := s.validatePassword(req.Password, req.Username); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	email := strings.TrimSpace(req.Email)
+	if email == "" && s.config.Mail.RequireVerification {
+		SendBadRequest(c, "Укажите email: он потребуется для подтверждения учетной записи")
+		return
+	}
+	if err := s.checkProfileEmail(0, email); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
 	// Создаем нового пользователя
 	newUser := models.User{
 		Username: req.Username,
+		Email:    email,
 		Password: req.Password,
 		Role:     "user", // Стандартная роль для новых пользователей
 	}
@@ -242,8 +261,25 @@ func (s *Server) handleRegister(c *gin.Context) {
 		return
 	}
 
-	// Генерируем JWT токен для автоматического входа после регистрации
-	token, err := middleware.GenerateToken(newUser.ID, newUser.Username, newUser.Role, s.config.JWT.Secret, s.config.JWT.Expiry)
+	if newUser.Email != "" {
+		if err := s.sendVerificationEmail(&newUser); err != nil {
+			fmt.Printf("Ошибка отправки письма подтверждения пользователю %s: %v\n", newUser.Username, err)
+		}
+	}
+
+	// Без подтвержденного email вход запрещен: сессию не выдаем
+	if s.emailVerificationPending(&newUser) {
+		fmt.Printf("Успешная регистрация пользователя %s, ожидается подтверждение email\n", newUser.Username)
+		c.JSON(http.StatusCreated, gin.H{
+			"message":                     "Пользователь зарегистрирован. Подтвердите email по ссылке из письма",
+			"user":                        newUser,
+			"email_verification_required": true,
+		})
+		return
+	}
+
+	// Создаем сессию для автоматического входа после регистрации
+	response, err := s.issueSession(c, &newUser)
 	if err != nil {
 		// Пользователь создан, но токен не сгенерирован. Логируем ошибку.
 		fmt.Printf("Ошибка генерации токена для нового пользователя %s: %v\n", newUser.Username, err)
@@ -258,12 +294,6 @@ func (s *Server) handleRegister(c *gin.Context) {
 
 	fmt.Printf("Успешная регистрация пользователя %s\n", newUser.Username)
 
-	// Не возвращаем пароль
-	newUser.Password = ""
-
-	// Возвращаем токен и данные пользователя
-	c.JSON(http.StatusCreated, LoginResponse{
-		Token: token,
-		User:  newUser,
-	})
+	// Возвращаем токены и данные пользователя
+	c.JSON(http.StatusCreated, response)
 }
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"messenger/logger"
+	"messenger/middleware"
+)
+
+// Сколько хранится результат проверки сессии или пользователя для JWTAuth
+const authStatusTTL = 30 * time.Second
+
+// statusCache хранит недавние результаты проверок по ID, чтобы не обращаться к БД на каждый запрос
+type statusCache[V any] struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	entries map[uint]cachedStatus[V]
+}
+
+type cachedStatus[V any] struct {
+	value     V
+	checkedAt time.Time
+}
+
+func newStatusCache[V any](ttl time.Duration) *statusCache[V] {
+	return &statusCache[V]{ttl: ttl, entries: make(map[uint]cachedStatus[V])}
+}
+
+func (sc *statusCache[V]) get(id uint) (V, bool) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	entry, ok := sc.entries[id]
+	if !ok || time.Since(entry.checkedAt) > sc.ttl {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+func (sc *statusCache[V]) set(id uint, value V) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.entries[id] = cachedStatus[V]{value: value, checkedAt: time.Now()}
+}
+
+// invalidate удаляет запись, следующая проверка пойдет в БД
+func (sc *statusCache[V]) invalidate(id uint) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delete(sc.entries, id)
+}
+
+// cleanup удаляет устаревшие записи
+func (sc *statusCache[V]) cleanup() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for id, entry := range sc.entries {
+		if time.Since(entry.checkedAt) > sc.ttl {
+			delete(sc.entries, id)
+		}
+	}
+}
+
+// userStatus - закешированное состояние пользователя
+type userStatus struct {
+	exists  bool
+	blocked bool
+	role    string
+}
+
+// authorizeClaims проверяет сессию и пользователя access токена.
+// Используется JWTAuth и всеми транспортами потока событий
+func (s *Server) authorizeClaims(claims *middleware.JWTClaims) error {
+	if err := s.validateSessionClaims(claims); err != nil {
+		return err
+	}
+	return s.validateUserClaims(claims)
+}
+
+// validateUserClaims отклоняет токены удаленных и заблокированных пользователей
+// и подставляет в claims актуальную роль из БД
+func (s *Server) validateUserClaims(claims *middleware.JWTClaims) error {
+	status, ok := s.userStatuses.get(claims.UserID)
+	if !ok {
+		user, err := s.db.GetUserByID(claims.UserID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			// Ошибка БД не должна выкидывать всех пользователей
+			logger.Errorf("Ошибка проверки пользователя %d: %v", claims.UserID, err)
+			return nil
+		}
+		if user != nil {
+			status = userStatus{exists: true, blocked: user.Blocked, role: user.Role}
+		}
+		s.userStatuses.set(claims.UserID, status)
+	}
+
+	if !status.exists {
+		return errors.New("User not found")
+	}
+	if status.blocked {
+		return errors.New("User is blocked")
+	}
+
+	claims.Role = status.role
+	return nil
+}
+
+// cleanupAuthCaches периодически удаляет устаревшие записи кешей проверок
+func (s *Server) cleanupAuthCaches() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.sessions.cleanup()
+		s.userStatuses.cleanup()
+	}
+}
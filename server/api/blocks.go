@@ -0,0 +1,158 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"messenger/logger"
+)
+
+// BlockedUserResponse - заблокированный пользователь в списке блокировок
+type BlockedUserResponse struct {
+	User      ProfileResponse `json:"user"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// handleGetBlocks возвращает пользователей, заблокированных текущим пользователем
+func (s *Server) handleGetBlocks(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	blocks, err := s.db.GetUserBlocks(userID)
+	if err != nil {
+		logger.Errorf("Блокировки: Ошибка получения блокировок пользователя %d: %v", userID, err)
+		SendInternalError(c, "Ошибка получения списка блокировок")
+		return
+	}
+
+	response := make([]BlockedUserResponse, 0, len(blocks))
+	for _, block := range blocks {
+		response = append(response, BlockedUserResponse{
+			User:      newProfileResponse(&block.Blocked),
+			CreatedAt: block.CreatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"blocks": response})
+}
+
+// handleBlockUser блокирует пользователя из URL. Общие группы сохраняются,
+// но его сообщения и присутствие перестают доходить до текущего пользователя
+func (s *Server) handleBlockUser(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	blockedID, ok := s.blockTargetID(c)
+	if !ok {
+		return
+	}
+	if blockedID == userID {
+		SendBadRequest(c, "Нельзя заблокировать самого себя")
+		return
+	}
+	if _, err := s.db.GetUserByID(blockedID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			SendNotFound(c, "Пользователь не найден")
+		} else {
+			logger.Errorf("Блокировки: Ошибка поиска пользователя %d: %v", blockedID, err)
+			SendInternalError(c, "Ошибка базы данных при поиске пользователя")
+		}
+		return
+	}
+
+	if err := s.db.BlockUser(userID, blockedID); err != nil {
+		logger.Errorf("Блокировки: Ошибка блокировки пользователя %d пользователем %d: %v", blockedID, userID, err)
+		SendInternalError(c, "Ошибка сохранения блокировки")
+		return
+	}
+
+	logger.Infof("Блокировки: Пользователь %d заблокировал пользователя %d", userID, blockedID)
+	c.JSON(http.StatusOK, gin.H{"message": "Пользователь заблокирован"})
+}
+
+// handleUnblockUser снимает блокировку пользователя из URL
+func (s *Server) handleUnblockUser(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	blockedID, ok := s.blockTargetID(c)
+	if !ok {
+		return
+	}
+
+	removed, err := s.db.UnblockUser(userID, blockedID)
+	if err != nil {
+		logger.Errorf("Блокировки: Ошибка снятия блокировки пользователя %d пользователем %d: %v", blockedID, userID, err)
+		SendInternalError(c, "Ошибка снятия блокировки")
+		return
+	}
+	if !removed {
+		SendNotFound(c, "Пользователь не заблокирован")
+		return
+	}
+
+	logger.Infof("Блокировки: Пользователь %d разблокировал пользователя %d", userID, blockedID)
+	c.JSON(http.StatusOK, gin.H{"message": "Блокировка снята"})
+}
+
+// blockTargetID разбирает ID пользователя из URL; при ошибке ответ уже отправлен
+func (s *Server) blockTargetID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil || id == 0 {
+		SendBadRequest(c, "Неверный ID пользователя")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// checkDirectChatBlocked запрещает переписку в личном чате, если один из собеседников заблокировал другого
+func (s *Server) checkDirectChatBlocked(userID, chatID uint) error {
+	users, err := s.db.GetChatUsers(chatID)
+	if err != nil {
+		logger.Errorf("Ошибка получения участников чата %d: %v", chatID, err)
+		return ErrInternal("Ошибка получения участников чата")
+	}
+	for _, user := range users {
+		if user.ID == userID {
+			continue
+		}
+		blocked, err := s.db.IsBlockedBetween(userID, user.ID)
+		if err != nil {
+			logger.Errorf("Ошибка проверки блокировки пользователей %d и %d: %v", userID, user.ID, err)
+			return ErrInternal("Ошибка проверки блокировки")
+		}
+		if blocked {
+			return ErrForbidden("Переписка с этим пользователем недоступна")
+		}
+	}
+	return nil
+}
+
+// blockerSet возвращает пользователей, заблокировавших userID. При ошибке базы
+// возвращает пустое множество: рассылка важнее фильтрации
+func (s *Server) blockerSet(userID uint) map[uint]bool {
+	ids, err := s.db.GetBlockerIDs(userID)
+	if err != nil {
+		logger.Errorf("Ошибка получения блокировок пользователя %d: %v", userID, err)
+	}
+	return idSet(ids)
+}
+
+// blockPeerSet возвращает пользователей, связанных с userID блокировкой в любую сторону.
+// Им не рассылаются события присутствия (набор текста, прочтение, профиль)
+func (s *Server) blockPeerSet(userID uint) map[uint]bool {
+	ids, err := s.db.GetBlockPeerIDs(userID)
+	if err != nil {
+		logger.Errorf("Ошибка получения блокировок пользователя %d: %v", userID, err)
+	}
+	return idSet(ids)
+}
+
+func idSet(ids []uint) map[uint]bool {
+	set := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
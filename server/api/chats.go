@@ -196,6 +196,16 @@ func (s *Server) handleCreateChat(c *gin.Context) {
 			SendBadRequest(c, "Нельзя создать чат с самим собой")
 			return
 		}
+		blocked, err := s.db.IsBlockedBetween(currentUserID, req.UserIDs[0])
+		if err != nil {
+			logger.Errorf("Ошибка проверки блокировки при создании чата: %v", err)
+			SendInternalError(c, "Ошибка при проверке пользователей")
+			return
+		}
+		if blocked {
+			SendForbidden(c, "Нельзя создать чат с этим пользователем")
+			return
+		}
 		// TODO: Проверить, существует ли уже личный чат между этими двумя пользователями
 		req.Name = ""
 	} else if req.Type == "group" {
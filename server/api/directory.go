@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"messenger/auth"
+	"messenger/config"
+	"messenger/database"
+	"messenger/logger"
+	"messenger/models"
+)
+
+// Если за один проход синхронизации пропало больше половины пользователей каталога
+// (и их не меньше этого числа), вероятнее ошибка в base_dn или фильтре, чем увольнения
+const directorySyncSafetyMin = 10
+
+// newAuthenticators собирает цепочку способов входа из Auth.Backends
+func newAuthenticators(cfg *config.Config, db *database.Database) (auth.Chain, auth.Directory) {
+	var chain auth.Chain
+	var directory auth.Directory
+
+	for _, backend := range cfg.Auth.Backends {
+		switch backend {
+		case auth.SourceLocal:
+			chain = append(chain, auth.NewLocal(db))
+		case auth.SourceLDAP:
+			if !cfg.LDAP.Enabled {
+				logger.Warnf("Способ входа ldap указан в auth.backends, но LDAP выключен")
+				continue
+			}
+			ldapAuth := auth.NewLDAP(cfg.LDAP)
+			chain = append(chain, ldapAuth)
+			directory = ldapAuth
+		}
+	}
+
+	if len(chain) == 0 {
+		logger.Warnf("Не задан ни один способ входа, используется локальный")
+		chain = auth.Chain{auth.NewLocal(db)}
+	}
+	return chain, directory
+}
+
+// resolveLoginUser находит пользователя мессенджера для подтвержденной учетной записи.
+// Пользователь каталога создается при первом входе, его роль обновляется по группам
+func (s *Server) resolveLoginUser(identity *auth.Identity) (*models.User, error) {
+	user, err := s.db.GetUserByUsername(identity.Username)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Errorf("Вход: Ошибка поиска пользователя %s: %v", identity.Username, err)
+		return nil, ErrInternal("Ошибка базы данных")
+	}
+
+	if identity.Source == auth.SourceLocal {
+		if user == nil {
+			return nil, ErrUnauthorized("Неверное имя пользователя или пароль")
+		}
+		return user, nil
+	}
+
+	if user == nil {
+		if !s.config.LDAP.AutoProvision {
+			return nil, ErrForbidden("Учетная запись не найдена, обратитесь к администратору")
+		}
+		return s.provisionDirectoryUser(identity)
+	}
+
+	// Локальную учетную запись с тем же именем не связываем автоматически:
+	// иначе сотрудник из каталога получил бы чужой (например, административный) доступ
+	if user.AuthSource != identity.Source {
+		logger.Warnf("Вход: Имя %s из каталога %s занято локальной учетной записью %d", identity.Username, identity.Source, user.ID)
+		return nil, ErrForbidden("Имя пользователя занято локальной учетной записью, обратитесь к администратору")
+	}
+
+	if s.directory != nil {
+		if err := s.applyExternalRole(user, s.directory.RoleFor(identity.Groups)); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// provisionDirectoryUser создает пользователя каталога при первом входе
+func (s *Server) provisionDirectoryUser(identity *auth.Identity) (*models.User, error) {
+	role := s.config.LDAP.DefaultRole
+	if s.directory != nil {
+		if mapped := s.directory.RoleFor(identity.Groups); mapped != "" {
+			role = mapped
+		}
+	}
+
+	user := models.User{
+		Username:   identity.Username,
+		Password:   randomHex(32), // Пароль проверяет каталог, локальный вход невозможен
+		Role:       role,
+		AuthSource: identity.Source,
+	}
+	// Email уникален: если он уже занят, создаем пользователя без него
+	if identity.Email != "" {
+		if _, err := s.db.GetUserByEmail(identity.Email); errors.Is(err, gorm.ErrRecordNotFound) {
+			user.Email = identity.Email
+		}
+	}
+
+	if err := s.db.DB.Create(&user).Error; err != nil {
+		logger.Errorf("Вход: Ошибка создания пользователя каталога %s: %v", identity.Username, err)
+		return nil, ErrInternal("Ошибка создания пользователя")
+	}
+
+	logger.Infof("Вход: Создан пользователь каталога %s (ID %d, роль %s)", user.Username, user.ID, user.Role)
+	return &user, nil
+}
+
+// applyExternalRole обновляет роль, назначенную внешним источником (IdP, каталог).
+// Пустая роль означает, что источник ролями не управляет
+func (s *Server) applyExternalRole(user *models.User, role string) error {
+	if role == "" || role == user.Role {
+		return nil
+	}
+
+	if err := s.db.DB.Model(user).Update("role", role).Error; err != nil {
+		logger.Errorf("Ошибка обновления роли пользователя %d: %v", user.ID, err)
+		return ErrInternal("Ошибка обновления роли")
+	}
+	logger.Infof("Роль пользователя %s изменена: %s -> %s", user.Username, user.Role, role)
+	user.Role = role
+	s.userStatuses.invalidate(user.ID)
+	return nil
+}
+
+// syncDirectory периодически сверяет пользователей с каталогом:
+// пропавшие и отключенные в каталоге блокируются, роли обновляются по группам.
+// Разблокировка вернувшихся сотрудников остается за администратором
+func (s *Server) syncDirectory(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.syncDirectoryOnce(context.Background())
+	}
+}
+
+// syncDirectoryOnce выполняет один проход синхронизации с каталогом
+func (s *Server) syncDirectoryOnce(ctx context.Context) {
+	users, err := s.db.GetUsersBySource(s.directory.Name())
+	if err != nil {
+		logger.Errorf("Синхронизация каталога: Ошибка получения пользователей: %v", err)
+		return
+	}
+
+	var departed []models.User
+	for i := range users {
+		user := &users[i]
+		identity, err := s.directory.Lookup(ctx, user.Username)
+		switch {
+		case errors.Is(err, auth.ErrUnknownUser):
+			departed = append(departed, *user)
+		case err != nil:
+			// Каталог недоступен: прерываем проход, чтобы не заблокировать всех подряд
+			logger.Errorf("Синхронизация каталога: %v", err)
+			return
+		case identity.Disabled:
+			departed = append(departed, *user)
+		default:
+			if err := s.applyExternalRole(user, s.directory.RoleFor(identity.Groups)); err != nil {
+				logger.Errorf("Синхронизация каталога: Ошибка обновления роли %s: %v", user.Username, err)
+			}
+		}
+	}
+
+	if len(users) >= directorySyncSafetyMin && len(departed) > len(users)/2 {
+		logger.Errorf("Синхронизация каталога: В каталоге не найдено %d из %d пользователей, блокировка пропущена (проверьте base_dn и user_filter)", len(departed), len(users))
+		return
+	}
+
+	for i := range departed {
+		s.blockDepartedUser(&departed[i])
+	}
+	logger.Infof("Синхронизация каталога: Проверено пользователей: %d, заблокировано: %d", len(users), len(departed))
+}
+
+// blockDepartedUser блокирует пользователя, пропавшего из каталога, и завершает его сессии
+func (s *Server) blockDepartedUser(user *models.User) {
+	if err := s.db.DB.Model(user).Update("blocked", true).Error; err != nil {
+		logger.Errorf("Синхронизация каталога: Ошибка блокировки пользователя %d: %v", user.ID, err)
+		return
+	}
+
+	revoked, err := s.db.RevokeUserSessions(user.ID, 0)
+	if err != nil {
+		logger.Errorf("Синхронизация каталога: Ошибка отзыва сессий пользователя %d: %v", user.ID, err)
+	}
+	s.revokeSessions(revoked...)
+	s.userStatuses.invalidate(user.ID)
+	s.disconnectUser(user.ID, wsCloseUserBlocked, "user blocked")
+
+	logger.Infof("Синхронизация каталога: Пользователь %s (ID %d) заблокирован: отсутствует или отключен в каталоге", user.Username, user.ID)
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"messenger/auth"
+	"messenger/config"
+	"messenger/middleware"
+	"messenger/models"
+)
+
+const testDirectoryAdmins = "cn=admins,ou=groups,dc=example,dc=com"
+
+// stubDirectory - каталог в памяти: способ входа и источник данных для синхронизации
+type stubDirectory struct {
+	users     map[string]*auth.Identity
+	passwords map[string]string
+	err       error // Каталог недоступен
+}
+
+func (d *stubDirectory) Name() string { return auth.SourceLDAP }
+
+func (d *stubDirectory) Authenticate(ctx context.Context, username, password string) (*auth.Identity, error) {
+	identity, err := d.Lookup(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if identity.Disabled || d.passwords[username] != password {
+		return nil, auth.ErrInvalidCredentials
+	}
+	return identity, nil
+}
+
+func (d *stubDirectory) Lookup(ctx context.Context, username string) (*auth.Identity, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	identity, ok := d.users[username]
+	if !ok {
+		return nil, auth.ErrUnknownUser
+	}
+	return identity, nil
+}
+
+func (d *stubDirectory) RoleFor(groups []string) string {
+	return auth.MapRole(groups, map[string]string{testDirectoryAdmins: "admin"}, "user")
+}
+
+// withStubDirectory подключает каталог к серверу так же, как newAuthenticators для ldap, local
+func withStubDirectory(s *Server, dir *stubDirectory) {
+	s.authenticators = auth.Chain{dir, auth.NewLocal(s.db)}
+	s.directory = dir
+}
+
+// createDirectoryUser создает пользователя, пришедшего из каталога
+func createDirectoryUser(t *testing.T, s *Server, username, role string) *models.User {
+	t.Helper()
+	user := createTestUser(t, s, username, role)
+	if err := s.db.DB.Model(user).Update("auth_source", auth.SourceLDAP).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func reloadUser(t *testing.T, s *Server, id uint) *models.User {
+	t.Helper()
+	user, err := s.db.GetUserByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestNewAuthenticatorsOrder(t *testing.T) {
+	tests := []struct {
+		name          string
+		backends      []string
+		ldapEnabled   bool
+		want          []string
+		wantDirectory bool
+	}{
+		{name: "ldap, затем local", backends: []string{"ldap", "local"}, ldapEnabled: true, want: []string{"ldap", "local"}, wantDirectory: true},
+		{name: "local, затем ldap", backends: []string{"local", "ldap"}, ldapEnabled: true, want: []string{"local", "ldap"}, wantDirectory: true},
+		{name: "LDAP выключен", backends: []string{"ldap", "local"}, ldapEnabled: false, want: []string{"local"}},
+		{name: "пустой список", backends: nil, want: []string{"local"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig()
+			cfg.Auth.Backends = tt.backends
+			cfg.LDAP = config.LDAPConfig{Enabled: tt.ldapEnabled, URL: "ldap://ldap.example.com"}
+
+			chain, directory := newAuthenticators(cfg, nil)
+			var names []string
+			for _, authenticator := range chain {
+				names = append(names, authenticator.Name())
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("цепочка %v, ожидалась %v", names, tt.want)
+			}
+			if (directory != nil) != tt.wantDirectory {
+				t.Errorf("каталог %v, ожидался: %v", directory, tt.wantDirectory)
+			}
+		})
+	}
+}
+
+func TestLoginThroughDirectoryChain(t *testing.T) {
+	s := newTestServer(t, newTestConfig())
+	s.config.LDAP.AutoProvision = true
+	s.config.LDAP.DefaultRole = "user"
+	s.router.POST("/api/auth/login", s.handleLogin)
+
+	withStubDirectory(s, &stubDirectory{
+		users: map[string]*auth.Identity{
+			"alice": {Source: auth.SourceLDAP, Username: "alice", Email: "alice@example.com", Groups: []string{testDirectoryAdmins}},
+			"admin": {Source: auth.SourceLDAP, Username: "admin"},
+		},
+		passwords: map[string]string{"alice": "alice-password", "admin": "directory-password"},
+	})
+	local := createTestUser(t, s, "admin", "admin")
+	createTestUser(t, s, "bob", "user")
+
+	login := func(username, password string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(LoginRequest{Username: username, Password: password})
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/login", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Пользователь каталога создается при первом входе с ролью по группам
+	if rec := login("alice", "alice-password"); rec.Code != http.StatusOK {
+		t.Fatalf("вход alice: статус %d: %s", rec.Code, rec.Body)
+	}
+	alice, err := s.db.GetUserByUsername("alice")
+	if err != nil || alice.AuthSource != auth.SourceLDAP || alice.Role != "admin" {
+		t.Fatalf("пользователь каталога создан как %+v (%v)", alice, err)
+	}
+
+	// Каталог не знает bob: вход проверяет следующий способ цепочки
+	if rec := login("bob", "password"); rec.Code != http.StatusOK {
+		t.Errorf("локальный вход bob: статус %d: %s", rec.Code, rec.Body)
+	}
+
+	// Каталог знает admin, поэтому неверный для каталога пароль до локальной базы не доходит
+	if rec := login("admin", "password"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("вход admin с локальным паролем: статус %d, ожидался 401", rec.Code)
+	}
+	// Верный пароль каталога не дает доступ к локальной учетной записи с тем же именем
+	if rec := login("admin", "directory-password"); rec.Code != http.StatusForbidden {
+		t.Errorf("вход admin паролем каталога: статус %d, ожидался 403", rec.Code)
+	}
+	if got := reloadUser(t, s, local.ID); got.AuthSource != auth.SourceLocal {
+		t.Errorf("локальная учетная запись связана с каталогом: %+v", got)
+	}
+}
+
+func TestDirectorySyncBlocksDepartedUsers(t *testing.T) {
+	s := newTestServer(t, newTestConfig())
+
+	alice := createDirectoryUser(t, s, "alice", "user")
+	bob := createDirectoryUser(t, s, "bob", "user")
+	carol := createDirectoryUser(t, s, "carol", "admin")
+	local := createTestUser(t, s, "admin", "admin")
+
+	bobSession, bobToken := newTestSession(t, s, bob)
+	_, aliceToken := newTestSession(t, s, alice)
+
+	withStubDirectory(s, &stubDirectory{users: map[string]*auth.Identity{
+		"alice": {Source: auth.SourceLDAP, Username: "alice", Groups: []string{testDirectoryAdmins}},
+		"carol": {Source: auth.SourceLDAP, Username: "carol", Disabled: true},
+		// bob уволен и из каталога удален
+	}})
+
+	s.syncDirectoryOnce(context.Background())
+
+	if got := reloadUser(t, s, bob.ID); !got.Blocked {
+		t.Error("пропавший из каталога bob не заблокирован")
+	}
+	if got := reloadUser(t, s, carol.ID); !got.Blocked {
+		t.Error("отключенная в каталоге carol не заблокирована")
+	}
+	if got := reloadUser(t, s, alice.ID); got.Blocked || got.Role != "admin" {
+		t.Errorf("alice после синхронизации: blocked=%v, роль %q", got.Blocked, got.Role)
+	}
+	if got := reloadUser(t, s, local.ID); got.Blocked {
+		t.Error("локальная учетная запись заблокирована синхронизацией каталога")
+	}
+
+	// Сессии заблокированного пользователя отозваны, его токены больше не принимаются
+	session, err := s.db.GetSessionByID(bobSession)
+	if err != nil || session.IsActive() {
+		t.Errorf("сессия bob активна после блокировки (%v)", err)
+	}
+	if err := authorizeToken(s, bobToken); err == nil {
+		t.Error("токен заблокированного пользователя принимается")
+	}
+	if err := authorizeToken(s, aliceToken); err != nil {
+		t.Errorf("токен alice отклонен: %v", err)
+	}
+}
+
+func TestDirectorySyncSkipsWhenUnavailable(t *testing.T) {
+	s := newTestServer(t, newTestConfig())
+	bob := createDirectoryUser(t, s, "bob", "user")
+	withStubDirectory(s, &stubDirectory{err: errors.New("connection refused")})
+
+	s.syncDirectoryOnce(context.Background())
+
+	if got := reloadUser(t, s, bob.ID); got.Blocked {
+		t.Error("пользователь заблокирован при недоступном каталоге")
+	}
+}
+
+func TestDirectorySyncSafetyThreshold(t *testing.T) {
+	s := newTestServer(t, newTestConfig())
+
+	// Каталог внезапно не находит почти никого: вероятнее ошибка в base_dn, чем увольнения
+	users := make([]*models.User, directorySyncSafetyMin)
+	identities := map[string]*auth.Identity{}
+	for i := range users {
+		users[i] = createDirectoryUser(t, s, fmt.Sprintf("employee%d", i), "user")
+	}
+	for _, user := range users[:directorySyncSafetyMin/2-1] {
+		identities[user.Username] = &auth.Identity{Source: auth.SourceLDAP, Username: user.Username}
+	}
+	withStubDirectory(s, &stubDirectory{users: identities})
+
+	s.syncDirectoryOnce(context.Background())
+
+	for _, user := range users {
+		if got := reloadUser(t, s, user.ID); got.Blocked {
+			t.Fatalf("пользователь %s заблокирован, хотя пропала большая часть каталога", user.Username)
+		}
+	}
+}
+
+// authorizeToken проверяет access токен так же, как JWTAuth и WebSocketHandler
+func authorizeToken(s *Server, token string) error {
+	claims, err := middleware.ValidateToken(token, s.config.JWT.Secret)
+	if err != nil {
+		return err
+	}
+	return s.authorizeClaims(claims)
+}
@@ -0,0 +1,316 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"messenger/auth"
+	"messenger/config"
+	"messenger/database"
+	"messenger/logger"
+	"messenger/mailer"
+	"messenger/models"
+)
+
+const (
+	mailSendTimeout = 30 * time.Second // Предельное время отправки одного письма
+	// Сколько хранить истекшие и использованные токены из писем
+	userTokenRetention = 7 * 24 * time.Hour
+)
+
+// VerifyEmailRequest - подтверждение email по токену из письма
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ForgotPasswordRequest - запрос письма для сброса пароля
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,max=254"`
+}
+
+// RecoverPasswordRequest - новый пароль по токену из письма
+type RecoverPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// newMailer создает отправителя писем; при ошибке настройки письма пишутся в лог
+func newMailer(cfg *config.Config) mailer.Mailer {
+	m, err := mailer.New(cfg.Mail)
+	if err != nil {
+		logger.Errorf("Почта: %v. Письма будут выводиться в лог", err)
+		return mailer.NewLog()
+	}
+	logger.Infof("Почта: Способ отправки писем: %s", cfg.Mail.Driver)
+	return m
+}
+
+// sendMail отправляет письмо в фоне, чтобы время ответа не зависело от почтового сервера
+// и не выдавало, существует ли адрес
+func (s *Server) sendMail(msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+		if err := s.mailer.Send(ctx, msg); err != nil {
+			logger.Errorf("Почта: Ошибка отправки письма %q: %v", msg.Subject, err)
+		}
+	}()
+}
+
+// mailLink формирует ссылку на страницу веб-клиента с токеном из письма
+func (s *Server) mailLink(page, token string) string {
+	base := strings.TrimRight(s.config.Mail.BaseURL, "/")
+	return base + "/" + page + "?token=" + url.QueryEscape(token)
+}
+
+// issueUserToken создает одноразовый токен для письма; в базе хранится только его хеш
+func (s *Server) issueUserToken(user *models.User, purpose string, ttl time.Duration) (string, error) {
+	token := randomHex(32)
+	record := &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hashRefreshToken(token),
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := s.db.CreateUserToken(record); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sendVerificationEmail отправляет письмо со ссылкой подтверждения email
+func (s *Server) sendVerificationEmail(user *models.User) error {
+	ttl := time.Duration(s.config.Mail.VerificationTTL) * time.Hour
+	token, err := s.issueUserToken(user, models.TokenPurposeEmailVerify, ttl)
+	if err != nil {
+		logger.Errorf("Почта: Ошибка создания токена подтверждения для пользователя %d: %v", user.ID, err)
+		return ErrInternal("Ошибка отправки письма")
+	}
+
+	s.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Подтверждение email",
+		Text: fmt.Sprintf("Здравствуйте, %s!\n\n"+
+			"Чтобы подтвердить адрес электронной почты, перейдите по ссылке:\n%s\n\n"+
+			"Ссылка действует %d ч. Если вы не регистрировались, просто проигнорируйте это письмо.\n",
+			user.Username, s.mailLink("verify-email", token), s.config.Mail.VerificationTTL),
+	})
+	logger.Infof("Почта: Пользователю %d отправлено письмо подтверждения email", user.ID)
+	return nil
+}
+
+// sendUserTokenError отвечает на ошибку проверки токена из письма
+func (s *Server) sendUserTokenError(c *gin.Context, err error) {
+	if errors.Is(err, database.ErrTokenInvalid) {
+		SendBadRequest(c, "Ссылка недействительна или истекла")
+		return
+	}
+	logger.Errorf("Почта: Ошибка проверки токена из письма: %v", err)
+	SendInternalError(c, "Ошибка базы данных")
+}
+
+// emailVerificationPending сообщает, что вход запрещен до подтверждения email.
+// Учетные записи без email (созданные администратором, из каталога) не ограничиваются
+func (s *Server) emailVerificationPending(user *models.User) bool {
+	return s.config.Mail.RequireVerification && user.Email != "" && !user.EmailVerified &&
+		(user.AuthSource == "" || user.AuthSource == auth.SourceLocal)
+}
+
+// handleVerifyEmail подтверждает email по токену из письма
+func (s *Server) handleVerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendBadRequest(c, "Неверный формат запроса", err.Error())
+		return
+	}
+
+	token, err := s.db.ConsumeUserToken(models.TokenPurposeEmailVerify, hashRefreshToken(req.Token))
+	if err != nil {
+		s.sendUserTokenError(c, err)
+		return
+	}
+
+	// Письмо могло прийти на прежний адрес, если пользователь успел сменить email
+	verified, err := s.db.SetEmailVerified(token.UserID, token.Email)
+	if err != nil {
+		logger.Errorf("Почта: Ошибка подтверждения email пользователя %d: %v", token.UserID, err)
+		SendInternalError(c, "Ошибка базы данных")
+		return
+	}
+	if !verified {
+		SendBadRequest(c, "Ссылка недействительна или истекла")
+		return
+	}
+
+	logger.Infof("Почта: Пользователь %d подтвердил email", token.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "Email подтвержден"})
+}
+
+// handleResendVerification повторно отправляет письмо подтверждения текущему пользователю
+func (s *Server) handleResendVerification(c *gin.Context) {
+	userID := c.GetUint("userID")
+	user, err := s.db.GetUserByID(userID)
+	if err != nil {
+		SendNotFound(c, "Пользователь не найден")
+		return
+	}
+	if user.Email == "" {
+		SendBadRequest(c, "Email не указан")
+		return
+	}
+	if user.EmailVerified {
+		SendBadRequest(c, "Email уже подтвержден")
+		return
+	}
+
+	if allowed, retryAfter := s.passwordLimiter.Allow("verify:" + strconv.FormatUint(uint64(userID), 10)); !allowed {
+		SendAPIError(c, ErrRateLimited(retryAfter))
+		return
+	}
+
+	if err := s.sendVerificationEmail(user); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Письмо отправлено"})
+}
+
+// handleForgotPassword отправляет письмо со ссылкой сброса пароля. Ответ одинаков
+// независимо от того, найден ли пользователь (защита от перечисления адресов)
+func (s *Server) handleForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendBadRequest(c, "Неверный формат запроса", err.Error())
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	for _, key := range []string{"forgot:" + email, "forgot-ip:" + c.ClientIP()} {
+		if allowed, retryAfter := s.passwordLimiter.Allow(key); !allowed {
+			SendAPIError(c, ErrRateLimited(retryAfter))
+			return
+		}
+	}
+
+	response := gin.H{"message": "Если адрес зарегистрирован, на него отправлено письмо со ссылкой для сброса пароля"}
+
+	user, err := s.db.GetUserByEmail(email)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Errorf("Сброс пароля: Ошибка поиска пользователя по email: %v", err)
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	// Пароли внешних каталогов здесь не меняются, заблокированным вход все равно закрыт
+	if user.Blocked || (user.AuthSource != "" && user.AuthSource != auth.SourceLocal) {
+		logger.Warnf("Сброс пароля: Запрос для пользователя %d отклонен (заблокирован или внешний источник)", user.ID)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	ttl := time.Duration(s.config.Mail.ResetTTL) * time.Minute
+	token, err := s.issueUserToken(user, models.TokenPurposePasswordReset, ttl)
+	if err != nil {
+		logger.Errorf("Сброс пароля: Ошибка создания токена для пользователя %d: %v", user.ID, err)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	s.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Сброс пароля",
+		Text: fmt.Sprintf("Здравствуйте, %s!\n\n"+
+			"Для вашей учетной записи запрошен сброс пароля. Чтобы задать новый пароль, перейдите по ссылке:\n%s\n\n"+
+			"Ссылка одноразовая и действует %d мин. Если вы не запрашивали сброс, проигнорируйте это письмо.\n",
+			user.Username, s.mailLink("reset-password", token), s.config.Mail.ResetTTL),
+	})
+	logger.Infof("Сброс пароля: Пользователю %d отправлено письмо", user.ID)
+	c.JSON(http.StatusOK, response)
+}
+
+// handleRecoverPassword задает новый пароль по токену из письма и завершает все сессии пользователя
+func (s *Server) handleRecoverPassword(c *gin.Context) {
+	var req RecoverPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendBadRequest(c, "Неверный формат запроса", err.Error())
+		return
+	}
+
+	tokenHash := hashRefreshToken(req.Token)
+	token, err := s.db.GetActiveUserToken(models.TokenPurposePasswordReset, tokenHash)
+	if err != nil {
+		s.sendUserTokenError(c, err)
+		return
+	}
+
+	// Ссылка, отправленная на прежний адрес, после смены email не действует
+	user, err := s.db.GetUserByID(token.UserID)
+	if err != nil || !strings.EqualFold(user.Email, token.Email) {
+		SendBadRequest(c, "Ссылка недействительна или истекла")
+		return
+	}
+	if user.Blocked {
+		SendAPIError(c, ErrUserBlocked())
+		return
+	}
+	if user.AuthSource != "" && user.AuthSource != auth.SourceLocal {
+		SendBadRequest(c, "Пароль учетной записи управляется внешним каталогом")
+		return
+	}
+
+	// Пароль проверяется до погашения токена, чтобы при слабом пароле ссылка оставалась действующей
+	if err := s.validatePassword(req.NewPassword, user.Username); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+	if _, err := s.db.ConsumeUserToken(models.TokenPurposePasswordReset, tokenHash); err != nil {
+		s.sendUserTokenError(c, err)
+		return
+	}
+	if err := s.setPassword(user, req.NewPassword); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	// Переход по ссылке из письма подтверждает владение адресом
+	if _, err := s.db.SetEmailVerified(user.ID, token.Email); err != nil {
+		logger.Errorf("Сброс пароля: Ошибка подтверждения email пользователя %d: %v", user.ID, err)
+	}
+
+	revoked, err := s.db.RevokeUserSessions(user.ID, 0)
+	if err != nil {
+		logger.Errorf("Сброс пароля: Ошибка отзыва сессий пользователя %d: %v", user.ID, err)
+	}
+	s.revokeSessions(revoked...)
+
+	logger.Infof("Сброс пароля: Пользователь %d задал новый пароль по ссылке из письма, завершено сессий: %d", user.ID, len(revoked))
+	c.JSON(http.StatusOK, gin.H{"message": "Пароль изменен, войдите с новым паролем"})
+}
+
+// cleanupUserTokens периодически удаляет истекшие и использованные токены из писем
+func (s *Server) cleanupUserTokens() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := s.db.DeleteExpiredUserTokens(time.Now().Add(-userTokenRetention))
+		if err != nil {
+			logger.Errorf("Ошибка удаления устаревших токенов из писем: %v", err)
+			continue
+		}
+		if deleted > 0 {
+			logger.Infof("Удалено устаревших токенов из писем: %d", deleted)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"messenger/mailer"
+	"messenger/models"
+)
+
+// Ссылка из письма: https://messenger.test/<страница>?token=<токен>
+var mailLinkPattern = regexp.MustCompile(`https://messenger\.test/([a-z-]+)\?token=(\S+)`)
+
+// captureMailer собирает письма вместо отправки. Сервер отправляет письма в фоне,
+// поэтому тест дожидается их через канал
+type captureMailer struct {
+	sent chan mailer.Message
+}
+
+func (m *captureMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent <- msg
+	return nil
+}
+
+// next дожидается письма и возвращает токен из ссылки на страницу page
+func (m *captureMailer) next(t *testing.T, to, page string) string {
+	t.Helper()
+	select {
+	case msg := <-m.sent:
+		if msg.To != to {
+			t.Fatalf("письмо отправлено на %s, ожидался %s", msg.To, to)
+		}
+		match := mailLinkPattern.FindStringSubmatch(msg.Text)
+		if match == nil || match[1] != page {
+			t.Fatalf("в письме нет ссылки на %s:\n%s", page, msg.Text)
+		}
+		token, err := url.QueryUnescape(match[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	case <-time.After(5 * time.Second):
+		t.Fatalf("письмо на %s не отправлено", to)
+		return ""
+	}
+}
+
+// expectNone проверяет, что писем не было
+func (m *captureMailer) expectNone(t *testing.T) {
+	t.Helper()
+	select {
+	case msg := <-m.sent:
+		t.Fatalf("отправлено лишнее письмо на %s: %s", msg.To, msg.Subject)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// newMailTestServer собирает сервер с маршрутами регистрации, входа и ссылок из писем
+func newMailTestServer(t *testing.T) (*Server, *captureMailer) {
+	t.Helper()
+	cfg := newTestConfig()
+	cfg.Server.RegistrationEnabled = true
+	cfg.Mail.From = "noreply@messenger.test"
+	cfg.Mail.BaseURL = "https://messenger.test/"
+	cfg.Mail.RequireVerification = true
+	cfg.Mail.VerificationTTL = 48
+	cfg.Mail.ResetTTL = 30
+	cfg.PasswordPolicy.MinLength = 10
+
+	s := newTestServer(t, cfg)
+	m := &captureMailer{sent: make(chan mailer.Message, 10)}
+	s.mailer = m
+
+	s.router.POST("/api/register", s.handleRegister)
+	s.router.POST("/api/auth/login", s.handleLogin)
+	s.router.POST("/api/auth/email/verify", s.handleVerifyEmail)
+	s.router.POST("/api/auth/password/forgot", s.handleForgotPassword)
+	s.router.POST("/api/auth/password/recover", s.handleRecoverPassword)
+	return s, m
+}
+
+// postJSON выполняет POST запрос к серверу
+func postJSON(s *Server, path string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// errorCode возвращает код ошибки из ответа APIError
+func errorCode(rec *httptest.ResponseRecorder) string {
+	var apiErr APIError
+	json.Unmarshal(rec.Body.Bytes(), &apiErr)
+	return apiErr.Code
+}
+
+func login(s *Server, username, password string) *httptest.ResponseRecorder {
+	return postJSON(s, "/api/auth/login", LoginRequest{Username: username, Password: password})
+}
+
+// createMailUser создает пользователя с email и паролем password
+func createMailUser(t *testing.T, s *Server, username, email string, verified bool) *models.User {
+	t.Helper()
+	user := createTestUser(t, s, username, "user")
+	err := s.db.DB.Model(user).Updates(map[string]interface{}{"email": email, "email_verified": verified}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Email, user.EmailVerified = email, verified
+	return user
+}
+
+// expireUserTokens переносит срок действия всех токенов из писем в прошлое
+func expireUserTokens(t *testing.T, s *Server) {
+	t.Helper()
+	err := s.db.DB.Model(&models.UserToken{}).Where("1 = 1").Update("expires_at", time.Now().Add(-time.Minute)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistrationEmailVerification(t *testing.T) {
+	s, m := newMailTestServer(t)
+
+	rec := postJSON(s, "/api/register", RegisterRequest{Username: "alice", Password: "correct horse", Email: "alice@example.com"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("регистрация: статус %d: %s", rec.Code, rec.Body)
+	}
+	var registered struct {
+		Token                     string `json:"token"`
+		EmailVerificationRequired bool   `json:"email_verification_required"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &registered)
+	if registered.Token != "" || !registered.EmailVerificationRequired {
+		t.Fatalf("до подтверждения email выдана сессия: %s", rec.Body)
+	}
+	token := m.next(t, "alice@example.com", "verify-email")
+
+	// Без подтвержденного email вход запрещен
+	if rec := login(s, "alice", "correct horse"); rec.Code != http.StatusForbidden || errorCode(rec) != ErrCodeEmailNotVerified {
+		t.Fatalf("вход до подтверждения: статус %d: %s", rec.Code, rec.Body)
+	}
+
+	if rec := postJSON(s, "/api/auth/email/verify", VerifyEmailRequest{Token: token}); rec.Code != http.StatusOK {
+		t.Fatalf("подтверждение email: статус %d: %s", rec.Code, rec.Body)
+	}
+	user, err := s.db.GetUserByUsername("alice")
+	if err != nil || !user.EmailVerified {
+		t.Fatalf("email не отмечен подтвержденным (%v)", err)
+	}
+	if rec := login(s, "alice", "correct horse"); rec.Code != http.StatusOK {
+		t.Errorf("вход после подтверждения: статус %d: %s", rec.Code, rec.Body)
+	}
+
+	// Ссылка одноразовая
+	if rec := postJSON(s, "/api/auth/email/verify", VerifyEmailRequest{Token: token}); rec.Code != http.StatusBadRequest {
+		t.Errorf("повторное подтверждение: статус %d, ожидался 400", rec.Code)
+	}
+}
+
+func TestRegistrationRequiresEmail(t *testing.T) {
+	s, m := newMailTestServer(t)
+
+	rec := postJSON(s, "/api/register", RegisterRequest{Username: "alice", Password: "correct horse"})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("регистрация без email: статус %d, ожидался 400", rec.Code)
+	}
+	m.expectNone(t)
+}
+
+func TestEmailVerificationExpired(t *testing.T) {
+	s, m := newMailTestServer(t)
+
+	postJSON(s, "/api/register", RegisterRequest{Username: "alice", Password: "correct horse", Email: "alice@example.com"})
+	token := m.next(t, "alice@example.com", "verify-email")
+	expireUserTokens(t, s)
+
+	if rec := postJSON(s, "/api/auth/email/verify", VerifyEmailRequest{Token: token}); rec.Code != http.StatusBadRequest {
+		t.Errorf("истекшая ссылка: статус %d, ожидался 400", rec.Code)
+	}
+	if user, _ := s.db.GetUserByUsername("alice"); user.EmailVerified {
+		t.Error("email подтвержден истекшей ссылкой")
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	s, m := newMailTestServer(t)
+	user := createMailUser(t, s, "alice", "alice@example.com", false)
+	sessionID, accessToken := newTestSession(t, s, user)
+
+	rec := postJSON(s, "/api/auth/password/forgot", ForgotPasswordRequest{Email: " Alice@Example.com "})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("запрос сброса: статус %d: %s", rec.Code, rec.Body)
+	}
+	token := m.next(t, "alice@example.com", "reset-password")
+
+	// Слабый пароль отклоняется, а ссылка остается действующей
+	rec = postJSON(s, "/api/auth/password/recover", RecoverPasswordRequest{Token: token, NewPassword: "short"})
+	if rec.Code != http.StatusBadRequest || errorCode(rec) != ErrCodeWeakPassword {
+		t.Fatalf("слабый пароль: статус %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = postJSON(s, "/api/auth/password/recover", RecoverPasswordRequest{Token: token, NewPassword: "new correct horse"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("сброс пароля: статус %d: %s", rec.Code, rec.Body)
+	}
+
+	// Старые сессии завершены, вход возможен только с новым паролем
+	if session, err := s.db.GetSessionByID(sessionID); err != nil || session.IsActive() {
+		t.Errorf("сессия осталась активной после сброса пароля (%v)", err)
+	}
+	if err := authorizeToken(s, accessToken); err == nil {
+		t.Error("access токен старой сессии принимается")
+	}
+	if rec := login(s, "alice", "password"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("вход со старым паролем: статус %d, ожидался 401", rec.Code)
+	}
+	// Переход по ссылке из письма подтверждает email, иначе вход был бы запрещен
+	if rec := login(s, "alice", "new correct horse"); rec.Code != http.StatusOK {
+		t.Errorf("вход с новым паролем: статус %d: %s", rec.Code, rec.Body)
+	}
+
+	// Ссылка одноразовая: повторно пароль по ней не сменить
+	rec = postJSON(s, "/api/auth/password/recover", RecoverPasswordRequest{Token: token, NewPassword: "attacker password"})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("повторное использование ссылки: статус %d, ожидался 400", rec.Code)
+	}
+	if rec := login(s, "alice", "attacker password"); rec.Code == http.StatusOK {
+		t.Error("пароль сменен повторно по использованной ссылке")
+	}
+}
+
+func TestPasswordResetExpiredToken(t *testing.T) {
+	s, m := newMailTestServer(t)
+	createMailUser(t, s, "alice", "alice@example.com", true)
+
+	postJSON(s, "/api/auth/password/forgot", ForgotPasswordRequest{Email: "alice@example.com"})
+	token := m.next(t, "alice@example.com", "reset-password")
+	expireUserTokens(t, s)
+
+	rec := postJSON(s, "/api/auth/password/recover", RecoverPasswordRequest{Token: token, NewPassword: "new correct horse"})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("истекшая ссылка: статус %d, ожидался 400", rec.Code)
+	}
+	if rec := login(s, "alice", "password"); rec.Code != http.StatusOK {
+		t.Errorf("пароль изменился по истекшей ссылке: вход со старым паролем, статус %d", rec.Code)
+	}
+}
+
+func TestPasswordResetOnlyLatestLinkWorks(t *testing.T) {
+	s, m := newMailTestServer(t)
+	createMailUser(t, s, "alice", "alice@example.com", true)
+
+	postJSON(s, "/api/auth/password/forgot", ForgotPasswordRequest{Email: "alice@example.com"})
+	first := m.next(t, "alice@example.com", "reset-password")
+	postJSON(s, "/api/auth/password/forgot", ForgotPasswordRequest{Email: "alice@example.com"})
+	second := m.next(t, "alice@example.com", "reset-password")
+
+	if rec := postJSON(s, "/api/auth/password/recover", RecoverPasswordRequest{Token: first, NewPassword: "new correct horse"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("ссылка из прежнего письма: статус %d, ожидался 400", rec.Code)
+	}
+	if rec := postJSON(s, "/api/auth/password/recover", RecoverPasswordRequest{Token: second, NewPassword: "new correct horse"}); rec.Code != http.StatusOK {
+		t.Errorf("ссылка из последнего письма: статус %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestForgotPasswordDoesNotRevealAccounts(t *testing.T) {
+	s, m := newMailTestServer(t)
+	blocked := createMailUser(t, s, "bob", "bob@example.com", true)
+	if err := s.db.DB.Model(blocked).Update("blocked", true).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	known := postJSON(s, "/api/auth/password/forgot", ForgotPasswordRequest{Email: "bob@example.com"})
+	unknown := postJSON(s, "/api/auth/password/forgot", ForgotPasswordRequest{Email: "nobody@example.com"})
+	if known.Code != http.StatusOK || unknown.Code != http.StatusOK || known.Body.String() != unknown.Body.String() {
+		t.Errorf("ответы различаются: %d %s / %d %s", known.Code, known.Body, unknown.Code, unknown.Body)
+	}
+	m.expectNone(t)
+}
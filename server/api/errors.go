@@ -1,9 +1,33 @@
 package api
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"messenger/logger"
+)
+
+// Машинно-читаемые коды ошибок, общие для REST и WebSocket
+const (
+	ErrCodeBadRequest           = "BAD_REQUEST"
+	ErrCodeUnauthorized         = "UNAUTHORIZED"
+	ErrCodeForbidden            = "FORBIDDEN"
+	ErrCodeNotFound             = "NOT_FOUND"
+	ErrCodeInternalError        = "INTERNAL_ERROR"
+	ErrCodeRegistrationDisabled = "REGISTRATION_DISABLED"
+	ErrCodeRateLimited          = "RATE_LIMITED"
+	ErrCodeUserBlocked          = "USER_BLOCKED"
+	ErrCodeWeakPassword         = "WEAK_PASSWORD" // Пароль не соответствует политике, нарушения в details
+	ErrCodeEmailNotVerified     = "EMAIL_NOT_VERIFIED"
+	ErrCodeFileTooLarge         = "FILE_TOO_LARGE"
+	ErrCodeUnsupportedFileType  = "UNSUPPORTED_FILE_TYPE"
+	ErrCodeFileTypeMismatch     = "FILE_TYPE_MISMATCH"     // Содержимое файла не соответствует заявленному типу
+	ErrCodeUploadOffset         = "UPLOAD_OFFSET_MISMATCH" // Смещение части не совпадает с принятым сервером, актуальное в details
+	ErrCodeChecksumMismatch     = "CHECKSUM_MISMATCH"
+	ErrCodeQuotaExceeded        = "QUOTA_EXCEEDED" // Файл не помещается в квоту пользователя или сервера, квота в details
 )
 
 type ErrorResponse struct {
@@ -12,6 +36,84 @@ type ErrorResponse struct {
 	Details any    `json:"details,omitempty"`
 }
 
+// APIError описывает ошибку обработки запроса независимо от транспорта.
+// Status используется только для REST, Code и Message уходят клиенту как есть.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Details any
+}
+
+func (e *APIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Response возвращает тело ошибки в том же формате, что и SendError
+func (e *APIError) Response() ErrorResponse {
+	return ErrorResponse{
+		Code:    e.Code,
+		Message: e.Message,
+		Details: e.Details,
+	}
+}
+
+func NewAPIError(status int, code string, message string, details ...any) *APIError {
+	var detailsData any
+	if len(details) > 0 {
+		detailsData = details[0]
+	}
+
+	return &APIError{
+		Status:  status,
+		Code:    code,
+		Message: message,
+		Details: detailsData,
+	}
+}
+
+func ErrBadRequest(message string, details ...any) *APIError {
+	return NewAPIError(http.StatusBadRequest, ErrCodeBadRequest, message, details...)
+}
+
+func ErrUnauthorized(message string) *APIError {
+	return NewAPIError(http.StatusUnauthorized, ErrCodeUnauthorized, message)
+}
+
+func ErrForbidden(message string) *APIError {
+	return NewAPIError(http.StatusForbidden, ErrCodeForbidden, message)
+}
+
+func ErrNotFound(message string) *APIError {
+	return NewAPIError(http.StatusNotFound, ErrCodeNotFound, message)
+}
+
+func ErrInternal(message string) *APIError {
+	return NewAPIError(http.StatusInternalServerError, ErrCodeInternalError, message)
+}
+
+// ErrUserBlocked сообщает, что учетная запись заблокирована администратором
+func ErrUserBlocked() *APIError {
+	return NewAPIError(http.StatusForbidden, ErrCodeUserBlocked, "Учетная запись заблокирована")
+}
+
+// ErrRateLimited сообщает о превышении лимита; retry_after_ms подсказывает, когда можно повторить запрос
+func ErrRateLimited(retryAfter time.Duration) *APIError {
+	return NewAPIError(http.StatusTooManyRequests, ErrCodeRateLimited, "Слишком много запросов, повторите позже",
+		map[string]int64{"retry_after_ms": retryAfter.Milliseconds()})
+}
+
+// toAPIError приводит произвольную ошибку к APIError, скрывая детали внутренних ошибок
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	logger.Errorf("Необработанная ошибка: %v", err)
+	return ErrInternal("Внутренняя ошибка сервера")
+}
+
 func SendError(c *gin.Context, status int, code string, message string, details ...any) {
 	var detailsData any
 	if len(details) > 0 {
@@ -25,22 +127,28 @@ func SendError(c *gin.Context, status int, code string, message string, details
 	})
 }
 
+// SendAPIError отправляет APIError (или внутреннюю ошибку) в REST-ответе
+func SendAPIError(c *gin.Context, err error) {
+	apiErr := toAPIError(err)
+	c.JSON(apiErr.Status, apiErr.Response())
+}
+
 func SendBadRequest(c *gin.Context, message string, details ...any) {
-	SendError(c, http.StatusBadRequest, "BAD_REQUEST", message, details...)
+	SendError(c, http.StatusBadRequest, ErrCodeBadRequest, message, details...)
 }
 
 func SendUnauthorized(c *gin.Context, message string) {
-	SendError(c, http.StatusUnauthorized, "UNAUTHORIZED", message)
+	SendError(c, http.StatusUnauthorized, ErrCodeUnauthorized, message)
 }
 
 func SendForbidden(c *gin.Context, message string) {
-	SendError(c, http.StatusForbidden, "FORBIDDEN", message)
+	SendError(c, http.StatusForbidden, ErrCodeForbidden, message)
 }
 
 func SendNotFound(c *gin.Context, message string) {
-	SendError(c, http.StatusNotFound, "NOT_FOUND", message)
+	SendError(c, http.StatusNotFound, ErrCodeNotFound, message)
 }
 
 func SendInternalError(c *gin.Context, message string) {
-	SendError(c, http.StatusInternalServerError, "INTERNAL_ERROR", message)
+	SendError(c, http.StatusInternalServerError, ErrCodeInternalError, message)
 }
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"messenger/logger"
+	"messenger/middleware"
+)
+
+// Транспорты, по которым клиент получает поток событий
+const (
+	transportWebSocket = "websocket"
+	transportSSE       = "sse"
+	transportLongPoll  = "longpoll"
+)
+
+// Параметры SSE и long-poll
+const (
+	sseHeartbeatPeriod  = 25 * time.Second // Комментарий-heartbeat не дает прокси закрыть соединение
+	sseRetryMillis      = 3000             // Интервал переподключения EventSource
+	longPollWait        = 25 * time.Second // Максимальное ожидание событий в одном запросе
+	longPollMaxEvents   = 100              // Максимум событий в одном ответе
+	longPollSessionTTL  = 2 * time.Minute  // Сессия без запросов дольше этого времени удаляется
+	longPollJanitorTick = time.Minute
+)
+
+// pollSession хранит очередь событий клиента long-poll между запросами
+type pollSession struct {
+	id       string
+	client   *WSClient
+	mu       sync.Mutex
+	lastSeen time.Time
+}
+
+// authenticateStream проверяет токен запроса на поток событий так же, как WebSocketHandler
+func (s *Server) authenticateStream(c *gin.Context) (*middleware.JWTClaims, bool) {
+	tokenString, _ := resolveToken(c)
+	if tokenString == "" {
+		SendUnauthorized(c, "Требуется авторизация")
+		return nil, false
+	}
+
+	claims, err := middleware.ValidateToken(tokenString, s.config.JWT.Secret)
+	if err != nil {
+		logger.Warnf("События: Недействительный токен %s: %v", maskToken(tokenString), err)
+		SendUnauthorized(c, "Недействительный токен")
+		return nil, false
+	}
+	if err := s.authorizeClaims(claims); err != nil {
+		SendUnauthorized(c, err.Error())
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// newStreamClient создает клиента без WebSocket соединения: кадры только накапливаются в очереди send
+func (s *Server) newStreamClient(claims *middleware.JWTClaims, transport string, r *http.Request) *WSClient {
+	return &WSClient{
+		server:        s,
+		send:          make(chan wsResponse, 256),
+		codec:         jsonCodec{},
+		userID:        claims.UserID,
+		sessionID:     claims.SessionID,
+		authenticated: true,
+		clientInfo:    r.UserAgent(),
+		limits:        newWSConnLimits(),
+		transport:     transport,
+	}
+}
+
+// handleEvents отдает поток событий по Server-Sent Events.
+// Каждый кадр передается как "event: <type>" с JSON-конвертом в data, как в WebSocket
+func (s *Server) handleEvents(c *gin.Context) {
+	claims, ok := s.authenticateStream(c)
+	if !ok {
+		return
+	}
+
+	client := s.newStreamClient(claims, transportSSE, c.Request)
+	s.registerClient(client)
+	defer s.unregisterClient(client)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Отключаем буферизацию в nginx
+	c.Status(http.StatusOK)
+
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", sseRetryMillis)
+	c.Writer.Flush()
+
+	logger.Infof("SSE: Пользователь %d подключен к потоку событий", client.userID)
+
+	heartbeat := time.NewTicker(sseHeartbeatPeriod)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			logger.Debugf("SSE: Пользователь %d отключился", client.userID)
+			return
+		case frame, ok := <-client.send:
+			if !ok {
+				// Очередь закрыта при переполнении
+				return
+			}
+			if err := writeSSEFrame(c, client.codec, frame); err != nil {
+				logger.Errorf("SSE: Ошибка отправки события пользователю %d: %v", client.userID, err)
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// writeSSEFrame записывает кадр в формате text/event-stream
+func writeSSEFrame(c *gin.Context, codec wsCodec, frame wsResponse) error {
+	data, err := codec.Encode(frame)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", frame.Type, data); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// handleEventsPoll отдает события по long-poll.
+// Запрос без session_id создает сессию, последующие запросы с session_id ждут новых событий
+func (s *Server) handleEventsPoll(c *gin.Context) {
+	claims, ok := s.authenticateStream(c)
+	if !ok {
+		return
+	}
+
+	sessionID := c.Query("session_id")
+	if sessionID == "" {
+		session, err := s.createPollSession(claims, c.Request)
+		if err != nil {
+			SendAPIError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"session_id": session.id,
+			"events":     []wsResponse{},
+		})
+		return
+	}
+
+	sessionObj, ok := s.pollSessions.Load(sessionID)
+	if !ok {
+		SendNotFound(c, "Сессия не найдена или истекла")
+		return
+	}
+	session := sessionObj.(*pollSession)
+	if session.client.userID != claims.UserID || session.client.sessionID != claims.SessionID {
+		SendNotFound(c, "Сессия не найдена или истекла")
+		return
+	}
+
+	events, alive := session.wait(c, longPollWait)
+	if !alive {
+		s.removePollSession(session)
+		SendNotFound(c, "Сессия не найдена или истекла")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": session.id,
+		"events":     events,
+	})
+}
+
+// createPollSession регистрирует нового клиента long-poll
+func (s *Server) createPollSession(claims *middleware.JWTClaims, r *http.Request) (*pollSession, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		logger.Errorf("Long-poll: Ошибка генерации идентификатора сессии: %v", err)
+		return nil, ErrInternal("Ошибка создания сессии")
+	}
+
+	session := &pollSession{
+		id:       hex.EncodeToString(buf),
+		client:   s.newStreamClient(claims, transportLongPoll, r),
+		lastSeen: time.Now(),
+	}
+	s.pollSessions.Store(session.id, session)
+	s.registerClient(session.client)
+
+	logger.Infof("Long-poll: Создана сессия для пользователя %d", claims.UserID)
+	return session, nil
+}
+
+// removePollSession удаляет сессию и отключает ее клиента
+func (s *Server) removePollSession(session *pollSession) {
+	s.pollSessions.Delete(session.id)
+	s.unregisterClient(session.client)
+}
+
+// wait ждет первое событие не дольше timeout и забирает накопившиеся следом.
+// Возвращает false, если очередь клиента закрыта
+func (p *pollSession) wait(c *gin.Context, timeout time.Duration) ([]wsResponse, bool) {
+	// Параллельные запросы одной сессии обслуживаются по очереди
+	p.mu.Lock()
+	defer func() {
+		p.lastSeen = time.Now()
+		p.mu.Unlock()
+	}()
+
+	events := []wsResponse{}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case frame, ok := <-p.client.send:
+		if !ok {
+			return nil, false
+		}
+		events = append(events, frame)
+	case <-timer.C:
+		return events, true
+	case <-c.Request.Context().Done():
+		return events, true
+	}
+
+	for len(events) < longPollMaxEvents {
+		select {
+		case frame, ok := <-p.client.send:
+			if !ok {
+				return events, true
+			}
+			events = append(events, frame)
+		default:
+			return events, true
+		}
+	}
+	return events, true
+}
+
+// cleanupPollSessions периодически удаляет сессии long-poll, которые клиент перестал опрашивать
+func (s *Server) cleanupPollSessions() {
+	ticker := time.NewTicker(longPollJanitorTick)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		s.pollSessions.Range(func(key, value interface{}) bool {
+			session := value.(*pollSession)
+			// Сессия, в которой сейчас идет ожидание, занята и не считается заброшенной
+			if !session.mu.TryLock() {
+				return true
+			}
+			expired := now.Sub(session.lastSeen) > longPollSessionTTL
+			session.mu.Unlock()
+
+			if expired {
+				s.removePollSession(session)
+				logger.Debugf("Long-poll: Сессия пользователя %d удалена по таймауту", session.client.userID)
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"messenger/logger"
+	"messenger/models"
+	"messenger/storage"
+	"messenger/utils/encryption"
+)
+
+// SignedFileURLResponse - временная ссылка на файл для встраивания (<img>, <video>)
+type SignedFileURLResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// handleFileDownload отдает файл участнику чата, в котором он был отправлен
+func (s *Server) handleFileDownload(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	file, err := s.authorizeFile(c.Param("fileId"), userID)
+	if err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	s.serveFile(c, file, userID)
+}
+
+// handleGetFileURL выдает подписанную ссылку на файл, по которой его можно получить
+// без заголовка Authorization, пока ссылка не истекла
+func (s *Server) handleGetFileURL(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	file, err := s.authorizeFile(c.Param("fileId"), userID)
+	if err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	expiresAt := time.Now().Add(time.Duration(s.config.FileStorage.SignedURLTTL) * time.Second).Truncate(time.Second)
+	inline, variant := c.Query("inline") == "1", c.Query("variant")
+
+	query := url.Values{}
+	query.Set("uid", strconv.FormatUint(uint64(userID), 10))
+	query.Set("exp", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("sig", s.signFileURL(file.ID, userID, expiresAt.Unix(), variant, inline))
+	if inline {
+		query.Set("inline", "1")
+	}
+	if variant != "" {
+		query.Set("variant", variant)
+	}
+
+	c.JSON(http.StatusOK, SignedFileURLResponse{
+		URL:       fmt.Sprintf("/api/files/%d/raw?%s", file.ID, query.Encode()),
+		ExpiresAt: expiresAt,
+	})
+}
+
+// handleSignedFileDownload отдает файл по подписанной ссылке. Доступ к чату проверяется
+// повторно, чтобы ссылка переставала работать после выхода пользователя из чата
+func (s *Server) handleSignedFileDownload(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Query("uid"), 10, 32)
+	if err != nil {
+		SendForbidden(c, "Недействительная ссылка")
+		return
+	}
+	expires, err := strconv.ParseInt(c.Query("exp"), 10, 64)
+	if err != nil {
+		SendForbidden(c, "Недействительная ссылка")
+		return
+	}
+	fileID, err := strconv.ParseUint(c.Param("fileId"), 10, 32)
+	if err != nil {
+		SendForbidden(c, "Недействительная ссылка")
+		return
+	}
+
+	// Подпись покрывает и параметры отдачи, иначе по чужой ссылке можно было бы запросить другой вариант файла
+	expected := s.signFileURL(uint(fileID), uint(userID), expires, c.Query("variant"), c.Query("inline") == "1")
+	if !hmac.Equal([]byte(expected), []byte(c.Query("sig"))) {
+		logger.Warnf("Файлы: Неверная подпись ссылки на файл #%d, IP %s", fileID, c.ClientIP())
+		SendForbidden(c, "Недействительная ссылка")
+		return
+	}
+	if time.Now().Unix() > expires {
+		SendForbidden(c, "Срок действия ссылки истек")
+		return
+	}
+
+	file, apiErr := s.authorizeFile(c.Param("fileId"), uint(userID))
+	if apiErr != nil {
+		SendAPIError(c, apiErr)
+		return
+	}
+
+	s.serveFile(c, file, uint(userID))
+}
+
+// authorizeFile находит файл по ID из URL и проверяет, что пользователь состоит в его чате.
+// Отсутствие доступа и отсутствие файла неразличимы для клиента
+func (s *Server) authorizeFile(rawID string, userID uint) (*models.File, error) {
+	fileID, err := strconv.ParseUint(rawID, 10, 32)
+	if err != nil {
+		return nil, ErrBadRequest("Некорректный ID файла")
+	}
+
+	file, err := s.db.GetFileByID(uint(fileID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound("Файл не найден")
+		}
+		logger.Errorf("Файлы: Ошибка получения файла #%d: %v", fileID, err)
+		return nil, ErrInternal("Ошибка базы данных")
+	}
+
+	allowed, err := s.db.CanAccessFile(userID, file)
+	if err != nil {
+		logger.Errorf("Файлы: Ошибка проверки доступа к файлу #%d: %v", file.ID, err)
+		return nil, ErrInternal("Ошибка базы данных")
+	}
+	if !allowed {
+		logger.Warnf("Файлы: Пользователю %d отказано в доступе к файлу #%d", userID, file.ID)
+		return nil, ErrNotFound("Файл не найден")
+	}
+	return file, nil
+}
+
+// serveFile отдает содержимое файла с заголовками для скачивания или встраивания.
+// Параметр variant выбирает миниатюру изображения вместо оригинала.
+// Зашифрованные файлы расшифровываются на лету, Range и условные запросы поддерживаются в обоих случаях
+func (s *Server) serveFile(c *gin.Context, file *models.File, userID uint) {
+	ctx := c.Request.Context()
+	key, wrappedKey, mimeType, fileName := file.StorageKey(), file.WrappedKey, file.MimeType, file.FileName
+
+	if variant := c.Query("variant"); variant != "" {
+		thumb := findThumbnail(file, variant)
+		if thumb == nil {
+			SendNotFound(c, "Миниатюра не найдена")
+			return
+		}
+		key, wrappedKey, mimeType = thumb.StorageKey, thumb.WrappedKey, thumb.MimeType
+		fileName = strings.TrimSuffix(fileName, path.Ext(fileName)) + "_" + thumb.Name + path.Ext(thumb.StorageKey)
+	}
+
+	info, err := s.storage.Stat(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			logger.Errorf("Файлы: Файл #%d отсутствует в хранилище (%s)", file.ID, key)
+			SendNotFound(c, "Файл не найден на сервере")
+			return
+		}
+		logger.Errorf("Файлы: Ошибка обращения к хранилищу для файла #%d: %v", file.ID, err)
+		SendInternalError(c, "Ошибка чтения файла")
+		return
+	}
+
+	object := storage.NewReaderAt(ctx, s.storage, key, info.Size)
+	defer object.Close()
+
+	var content io.ReadSeeker = io.NewSectionReader(object, 0, info.Size)
+	if len(wrappedKey) > 0 {
+		content, err = openEncryptedFile(object, info.Size, wrappedKey)
+		if err != nil {
+			logger.Errorf("Файлы: Ошибка расшифровки файла #%d: %v", file.ID, err)
+			SendInternalError(c, "Ошибка чтения файла")
+			return
+		}
+	}
+
+	disposition := "attachment"
+	if c.Query("inline") == "1" {
+		disposition = "inline"
+	}
+
+	logger.Infof("Файлы: Пользователь %d получил файл #%d (%s), IP %s", userID, file.ID, disposition, c.ClientIP())
+
+	c.Header("Content-Disposition", contentDisposition(disposition, fileName))
+	c.Header("Content-Type", mimeType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, no-cache")
+
+	http.ServeContent(c.Writer, c.Request, fileName, file.UpdatedAt, content)
+}
+
+// findThumbnail возвращает миниатюру файла с указанным названием (small, medium, large)
+func findThumbnail(file *models.File, name string) *models.FileThumbnail {
+	for i := range file.Thumbnails {
+		if file.Thumbnails[i].Name == name {
+			return &file.Thumbnails[i]
+		}
+	}
+	return nil
+}
+
+// openEncryptedFile возвращает читателя расшифрованного содержимого объекта размером size
+func openEncryptedFile(r io.ReaderAt, size int64, wrappedKey []byte) (*encryption.StreamReader, error) {
+	fileKey, err := encryption.UnwrapKey(wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.NewStreamReader(r, size, fileKey)
+}
+
+// signFileURL подписывает ссылку на файл для пользователя до момента expires (Unix время)
+// вместе с вариантом файла и способом отдачи. variant стоит последним, поэтому двоеточия
+// в нем не делают подписанную строку неоднозначной
+func (s *Server) signFileURL(fileID, userID uint, expires int64, variant string, inline bool) string {
+	mac := hmac.New(sha256.New, []byte(s.config.JWT.Secret))
+	fmt.Fprintf(mac, "file-url:%d:%d:%d:%t:%s", fileID, userID, expires, inline, variant)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// contentDisposition формирует заголовок Content-Disposition по RFC 6266: filename с
+// ASCII-заменой для старых клиентов и filename* с именем в UTF-8 (RFC 5987)
+func contentDisposition(disposition, name string) string {
+	var fallback strings.Builder
+	for _, r := range name {
+		switch {
+		case r == '"' || r == '\\':
+			fallback.WriteByte('_')
+		case r < 0x20 || r == 0x7f:
+			// Управляющие символы отбрасываем: они позволили бы разорвать заголовок
+		case r > 0x7e:
+			fallback.WriteByte('_')
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+	if fallback.Len() == 0 {
+		fallback.WriteString("file")
+	}
+
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, disposition, fallback.String(), rfc5987Escape(name))
+}
+
+// rfc5987Escape кодирует значение для параметра filename*: разрешены только attr-char,
+// остальные байты UTF-8 записываются как %XX
+func rfc5987Escape(value string) string {
+	const attrChars = "!#$&+-.^_`|~"
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		if ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || strings.IndexByte(attrChars, ch) >= 0 {
+			b.WriteByte(ch)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", ch)
+	}
+	return b.String()
+}
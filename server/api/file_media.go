@@ -0,0 +1,377 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"messenger/logger"
+	"messenger/media"
+	"messenger/models"
+)
+
+// Каталог общего содержимого файлов в хранилище
+const blobPrefix = "blobs/"
+
+// storeUploadedFile сохраняет содержимое нового файла и заполняет FilePath, BlobID, FileSize
+// и WrappedKey. Изображения перед сохранением очищаются от метаданных (координаты съемки и т.п.)
+// и получают миниатюры и BlurHash, у голосовых сообщений извлекаются длительность и огибающая.
+// Одинаковое содержимое хранится один раз (см. acquireBlob). При ошибке ссылка освобождается
+func (s *Server) storeUploadedFile(ctx context.Context, fileRecord *models.File, src io.Reader, size int64) error {
+	var img *preparedImage
+	switch {
+	case fileRecord.FileType == models.FileTypeVoice:
+		data, voice, err := prepareVoice(src, size, fileRecord.MimeType)
+		if err != nil {
+			return err
+		}
+		fileRecord.DurationMs = voice.Duration.Milliseconds()
+		fileRecord.Waveform = voice.Waveform
+		src = bytes.NewReader(data)
+	case media.IsProcessableImage(fileRecord.MimeType):
+		var err error
+		if img, err = prepareImage(src, fileRecord.MimeType); err != nil {
+			return err
+		}
+		defer img.Close()
+		src, size = img.file, img.size
+	}
+
+	content, hash, err := hashContent(src)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	blob, created, err := s.acquireBlob(ctx, hash, content, size)
+	if err != nil {
+		return err
+	}
+	fileRecord.BlobID = &blob.ID
+	fileRecord.FilePath = blob.StorageKey
+	fileRecord.FileSize = size
+	fileRecord.WrappedKey = blob.WrappedKey
+
+	if img == nil {
+		return nil
+	}
+	fileRecord.Width = img.info.Width
+	fileRecord.Height = img.info.Height
+	fileRecord.Orientation = img.info.Orientation
+	fileRecord.Blurhash = img.info.Blurhash
+
+	// Миниатюры общего содержимого тоже общие: берутся у существующего файла
+	if !created {
+		thumbnails, err := s.db.GetBlobThumbnails(blob.ID)
+		if err != nil {
+			s.releaseFile(context.Background(), fileRecord)
+			return err
+		}
+		for _, thumb := range thumbnails {
+			thumb.ID, thumb.FileID = 0, 0
+			fileRecord.Thumbnails = append(fileRecord.Thumbnails, thumb)
+		}
+		return nil
+	}
+
+	for _, thumb := range img.info.Thumbnails {
+		record := models.FileThumbnail{
+			Name:       thumb.Name,
+			Width:      thumb.Width,
+			Height:     thumb.Height,
+			Size:       int64(len(thumb.Data)),
+			MimeType:   thumb.MimeType,
+			StorageKey: thumbnailKey(blob.StorageKey, thumb),
+		}
+		record.WrappedKey, err = s.storeEncryptedFile(ctx, record.StorageKey, bytes.NewReader(thumb.Data), record.Size)
+		if err != nil {
+			s.storage.Delete(context.Background(), record.StorageKey)
+			for _, stored := range fileRecord.Thumbnails {
+				s.storage.Delete(context.Background(), stored.StorageKey)
+			}
+			fileRecord.Thumbnails = nil
+			s.releaseFile(context.Background(), fileRecord)
+			return err
+		}
+		fileRecord.Thumbnails = append(fileRecord.Thumbnails, record)
+	}
+	return nil
+}
+
+// acquireBlob возвращает общее содержимое с SHA-256 hash, увеличив число ссылок на него.
+// Если такого еще нет, content шифруется собственным ключом и сохраняется под ключом
+// blobs/<sha256>-<суффикс>; суффикс не дает параллельным загрузкам одного файла перезаписать
+// объекты друг друга. created сообщает, что содержимое сохранено этим вызовом
+func (s *Server) acquireBlob(ctx context.Context, hash string, content io.Reader, size int64) (blob *models.Blob, created bool, err error) {
+	var stored *models.Blob
+	for attempt := 0; attempt < 3; attempt++ {
+		blob, err = s.db.AcquireBlob(hash)
+		if err == nil {
+			if stored != nil {
+				// Параллельная загрузка сохранила то же содержимое раньше
+				s.storage.Delete(context.Background(), stored.StorageKey)
+			}
+			return blob, false, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			break
+		}
+
+		if stored == nil {
+			stored = &models.Blob{Hash: hash, Size: size, StorageKey: blobPrefix + hash + "-" + randomHex(4)}
+			// Ключ шифрования хранится в базе зашифрованным ключом сервера
+			stored.WrappedKey, err = s.storeEncryptedFile(ctx, stored.StorageKey, content, size)
+			if err != nil {
+				s.storage.Delete(context.Background(), stored.StorageKey)
+				return nil, false, err
+			}
+		}
+
+		var inserted bool
+		if inserted, err = s.db.CreateBlob(stored); err != nil {
+			break
+		}
+		if inserted {
+			return stored, true, nil
+		}
+	}
+
+	if stored != nil {
+		s.storage.Delete(context.Background(), stored.StorageKey)
+	}
+	if err == nil {
+		err = fmt.Errorf("не удалось сохранить содержимое %s", hash)
+	}
+	return nil, false, err
+}
+
+// releaseFile освобождает содержимое файла, запись о котором не была сохранена или удалена:
+// общее содержимое теряет ссылку, объекты старых файлов удаляются сразу
+func (s *Server) releaseFile(ctx context.Context, file *models.File) error {
+	if file.BlobID == nil {
+		return s.deleteFileObjects(ctx, file)
+	}
+
+	blob, err := s.db.ReleaseBlob(*file.BlobID)
+	if err != nil {
+		logger.Errorf("Файлы: Ошибка освобождения содержимого #%d: %v", *file.BlobID, err)
+		return err
+	}
+	if blob.RefCount == 0 {
+		// Если удалить не получилось, это сделает периодическая очистка
+		s.deleteBlob(ctx, blob)
+	}
+	return nil
+}
+
+// deleteBlob удаляет объекты содержимого без ссылок (вместе с миниатюрами) и запись о нем
+func (s *Server) deleteBlob(ctx context.Context, blob *models.Blob) error {
+	keys, err := s.db.GetBlobThumbnailKeys(blob.ID)
+	if err != nil {
+		logger.Errorf("Файлы: Ошибка получения миниатюр содержимого #%d: %v", blob.ID, err)
+		return err
+	}
+	for _, key := range append(keys, blob.StorageKey) {
+		if err := s.storage.Delete(ctx, key); err != nil {
+			logger.Errorf("Файлы: Ошибка удаления %s из хранилища: %v", key, err)
+			return err
+		}
+	}
+	if err := s.db.DeleteBlob(blob.ID); err != nil {
+		logger.Errorf("Файлы: Ошибка удаления записи о содержимом #%d: %v", blob.ID, err)
+		return err
+	}
+	return nil
+}
+
+// deleteFileObjects удаляет из хранилища содержимое файла, загруженного до дедупликации,
+// и его миниатюры. Возвращает первую ошибку, но пытается удалить все объекты
+func (s *Server) deleteFileObjects(ctx context.Context, file *models.File) error {
+	keys := []string{file.StorageKey()}
+	for _, thumb := range file.Thumbnails {
+		keys = append(keys, thumb.StorageKey)
+	}
+	var firstErr error
+	for _, key := range keys {
+		if err := s.storage.Delete(ctx, key); err != nil {
+			logger.Errorf("Файлы: Ошибка удаления %s из хранилища: %v", key, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// hashContent считает SHA-256 содержимого и возвращает его для повторного чтения с начала.
+// Потоки без Seek (сборка частей загрузки) копируются во временный файл
+func hashContent(src io.Reader) (io.ReadSeekCloser, string, error) {
+	hasher := sha256.New()
+
+	if rs, ok := src.(io.ReadSeeker); ok {
+		start, err := rs.Seek(0, io.SeekCurrent)
+		if err == nil {
+			_, err = io.Copy(hasher, rs)
+		}
+		if err == nil {
+			_, err = rs.Seek(start, io.SeekStart)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		return nopSeekCloser{rs}, hex.EncodeToString(hasher.Sum(nil)), nil
+	}
+
+	tmp, err := os.CreateTemp("", "messenger-upload-*")
+	if err != nil {
+		return nil, "", err
+	}
+	spool := &tempFile{tmp}
+	if _, err := io.Copy(io.MultiWriter(tmp, hasher), src); err != nil {
+		spool.Close()
+		return nil, "", err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		spool.Close()
+		return nil, "", err
+	}
+	return spool, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// nopSeekCloser не закрывает источник: им владеет вызывающий
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+// tempFile удаляет временный файл при закрытии
+type tempFile struct {
+	*os.File
+}
+
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	os.Remove(t.Name())
+	return err
+}
+
+// thumbnailKey возвращает ключ миниатюры рядом с файлом: <ключ файла>.<размер>.<jpg|png>
+func thumbnailKey(fileKey string, thumb media.Thumbnail) string {
+	ext := ".jpg"
+	if thumb.MimeType == "image/png" {
+		ext = ".png"
+	}
+	return fmt.Sprintf("%s.%s%s", strings.TrimSuffix(fileKey, path.Ext(fileKey)), thumb.Name, ext)
+}
+
+// preparedImage - изображение, очищенное от метаданных во временный файл, и сведения о нем
+type preparedImage struct {
+	file *os.File
+	size int64
+	info *media.ImageInfo
+}
+
+// prepareImage очищает изображение от метаданных и строит миниатюры
+func prepareImage(src io.Reader, mimeType string) (*preparedImage, error) {
+	img, orientation, err := stripImage(src, mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Изображение, которое не удалось декодировать, сохраняется без миниатюр
+	img.info, err = media.AnalyzeImage(img.file, orientation)
+	if err != nil {
+		logger.Warnf("Файлы: Не удалось обработать изображение: %v", err)
+		img.info = &media.ImageInfo{Orientation: orientation}
+	}
+	if _, err := img.file.Seek(0, io.SeekStart); err != nil {
+		img.Close()
+		return nil, err
+	}
+	return img, nil
+}
+
+// prepareAvatar строит квадратные миниатюры аватара размеров avatarSizes. Сам исходный файл
+// не сохраняется, поэтому от метаданных достаточно узнать ориентацию
+func prepareAvatar(src io.Reader, mimeType string) ([]media.Thumbnail, error) {
+	img, orientation, err := stripImage(src, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	defer img.Close()
+
+	thumbnails, err := media.AvatarThumbnails(img.file, orientation, avatarSizes)
+	switch {
+	case errors.Is(err, media.ErrMalformed):
+		return nil, ErrBadRequest("Некорректное изображение")
+	case errors.Is(err, media.ErrTooLarge):
+		return nil, ErrBadRequest(fmt.Sprintf("Изображение больше %d мегапикселей", media.MaxImagePixels/1_000_000))
+	}
+	return thumbnails, err
+}
+
+// stripImage копирует изображение без метаданных во временный файл (размер очищенной копии
+// нужен хранилищу заранее) и возвращает его вместе со значением EXIF Orientation
+func stripImage(src io.Reader, mimeType string) (*preparedImage, int, error) {
+	tmp, err := os.CreateTemp("", "messenger-image-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	img := &preparedImage{file: tmp}
+
+	w := bufio.NewWriter(tmp)
+	orientation, err := media.StripMetadata(w, src, mimeType)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		img.size, err = tmp.Seek(0, io.SeekCurrent)
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		img.Close()
+		if errors.Is(err, media.ErrMalformed) {
+			return nil, 0, ErrBadRequest("Некорректное изображение")
+		}
+		return nil, 0, err
+	}
+	return img, orientation, nil
+}
+
+// prepareVoice читает запись голосового сообщения в память (не больше maxVoiceSize)
+// и разбирает контейнер
+func prepareVoice(src io.Reader, size int64, mimeType string) ([]byte, *media.AudioInfo, error) {
+	if size > maxVoiceSize {
+		return nil, nil, fileTooLarge(maxVoiceSize)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(src, data); err != nil {
+		return nil, nil, err
+	}
+
+	info, err := media.AnalyzeAudio(data, mimeType)
+	if err != nil {
+		logger.Warnf("Файлы: Не удалось разобрать голосовое сообщение: %v", err)
+		return nil, nil, ErrBadRequest("Некорректная запись голосового сообщения")
+	}
+	return data, info, nil
+}
+
+// Close удаляет временный файл
+func (p *preparedImage) Close() {
+	p.file.Close()
+	os.Remove(p.file.Name())
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"messenger/logger"
+	"messenger/models"
+)
+
+const (
+	// Период проверки сроков хранения файлов
+	fileCleanupInterval = time.Hour
+	// Сколько файлов удаляется за один запрос к базе
+	fileCleanupBatch = 100
+	// Файл без сообщения удаляется не сразу: сообщение могло еще не успеть создаться
+	orphanFileGrace = 24 * time.Hour
+)
+
+// ChatRetentionRequest - срок хранения файлов чата, 0 - общий срок FileStorage.RetentionDays
+type ChatRetentionRequest struct {
+	Days *int `json:"days" binding:"required,min=0,max=3650"`
+}
+
+// retentionDays возвращает общий срок хранения файлов в днях, 0 - бессрочно
+func (s *Server) retentionDays() int {
+	s.configLock.RLock()
+	defer s.configLock.RUnlock()
+	return s.config.FileStorage.RetentionDays
+}
+
+// cleanupFiles периодически удаляет файлы с истекшим сроком хранения, файлы без сообщений
+// и содержимое, на которое не осталось ссылок
+func (s *Server) cleanupFiles() {
+	ticker := time.NewTicker(fileCleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expired := s.removeFiles("файлов с истекшим сроком хранения", func() ([]models.File, error) {
+			return s.db.GetExpiredFiles(s.retentionDays(), fileCleanupBatch)
+		})
+		orphaned := s.removeFiles("файлов без сообщений", func() ([]models.File, error) {
+			return s.db.GetOrphanedFiles(time.Now().Add(-orphanFileGrace), fileCleanupBatch)
+		})
+		if expired > 0 || orphaned > 0 {
+			logger.Infof("Файлы: Удалено файлов с истекшим сроком хранения: %d, без сообщений: %d", expired, orphaned)
+		}
+		s.removeUnreferencedBlobs()
+	}
+}
+
+// removeUnreferencedBlobs удаляет содержимое без ссылок, объекты которого не удалось удалить
+// сразу после освобождения последней ссылки
+func (s *Server) removeUnreferencedBlobs() {
+	blobs, err := s.db.GetUnreferencedBlobs(fileCleanupBatch)
+	if err != nil {
+		logger.Errorf("Файлы: Ошибка получения содержимого без ссылок: %v", err)
+		return
+	}
+	for i := range blobs {
+		s.deleteBlob(context.Background(), &blobs[i])
+	}
+}
+
+// removeFiles удаляет файлы, которые возвращает next, пока они не закончатся. Файл, объекты
+// которого не удалось удалить из хранилища, остается в базе до следующего прохода
+func (s *Server) removeFiles(kind string, next func() ([]models.File, error)) int {
+	removed := 0
+	for {
+		files, err := next()
+		if err != nil {
+			logger.Errorf("Файлы: Ошибка получения %s: %v", kind, err)
+			return removed
+		}
+
+		batchRemoved := 0
+		for i := range files {
+			// Объекты старых файлов удаляются до записи, общее содержимое - после освобождения последней ссылки
+			if files[i].BlobID == nil {
+				if err := s.deleteFileObjects(context.Background(), &files[i]); err != nil {
+					continue
+				}
+			}
+			released, err := s.db.DeleteFileRecord(&files[i])
+			if err != nil {
+				logger.Errorf("Файлы: Ошибка удаления записи о файле #%d: %v", files[i].ID, err)
+				continue
+			}
+			if released != nil {
+				s.deleteBlob(context.Background(), released)
+			}
+			batchRemoved++
+		}
+		removed += batchRemoved
+
+		// Неполная страница - последняя; если ничего не удалилось, повтор вернет те же файлы
+		if len(files) < fileCleanupBatch || batchRemoved == 0 {
+			return removed
+		}
+	}
+}
+
+// handleAdminSetChatRetention задает срок хранения файлов чата
+func (s *Server) handleAdminSetChatRetention(c *gin.Context) {
+	if c.GetString("role") != "admin" {
+		SendForbidden(c, "Требуются права администратора")
+		return
+	}
+
+	chatID, err := strconv.ParseUint(c.Param("chatId"), 10, 32)
+	if err != nil {
+		SendBadRequest(c, "Некорректный ID чата")
+		return
+	}
+	var req ChatRetentionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendBadRequest(c, "Неверный формат запроса", err.Error())
+		return
+	}
+
+	chat, err := s.db.GetChatByID(uint(chatID))
+	if err != nil {
+		SendNotFound(c, "Чат не найден")
+		return
+	}
+	if err := s.db.SetChatFileRetention(chat.ID, *req.Days); err != nil {
+		logger.Errorf("handleAdminSetChatRetention: Ошибка изменения срока хранения чата %d: %v", chat.ID, err)
+		SendInternalError(c, "Ошибка базы данных")
+		return
+	}
+
+	logger.Infof("Администратор %s установил срок хранения файлов чата %d: %d дн.", c.GetString("username"), chat.ID, *req.Days)
+	c.JSON(http.StatusOK, gin.H{"chat_id": chat.ID, "file_retention_days": *req.Days})
+}
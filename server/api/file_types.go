@@ -0,0 +1,148 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+
+	"messenger/models"
+)
+
+const (
+	// Сколько первых байт файла читается для определения типа (предел чтения mimetype)
+	sniffLen = 3072
+	// Предел размера голосового сообщения: запись целиком разбирается в памяти
+	maxVoiceSize = 20 << 20
+)
+
+// unsupportedFileType сообщает, что тип файла не входит в FileStorage.AllowedMimeTypes
+func unsupportedFileType(mimeType string) *APIError {
+	return NewAPIError(http.StatusUnsupportedMediaType, ErrCodeUnsupportedFileType, "Неподдерживаемый тип файла",
+		gin.H{"mime_type": mimeType})
+}
+
+// isAllowedFileType проверяет тип по списку FileStorage.AllowedMimeTypes. Элементы списка -
+// точные типы (с учетом синонимов, например application/x-zip-compressed для zip) или шаблоны вида image/*
+func (s *Server) isAllowedFileType(mimeType string) bool {
+	base, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	known := mimetype.Lookup(base)
+
+	s.configLock.RLock()
+	allowed := s.config.FileStorage.AllowedMimeTypes
+	s.configLock.RUnlock()
+
+	for _, entry := range strings.Split(allowed, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case strings.HasSuffix(entry, "/*"):
+			if strings.HasPrefix(base, strings.TrimSuffix(entry, "*")) {
+				return true
+			}
+		case known != nil:
+			if known.Is(entry) {
+				return true
+			}
+		case base == entry:
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeMimeList проверяет список типов из настроек и приводит его к виду "a/b,c/*"
+func normalizeMimeList(list string) (string, error) {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		check := entry
+		if strings.HasSuffix(entry, "/*") {
+			check = strings.TrimSuffix(entry, "*") + "x"
+		}
+		if base, params, err := mime.ParseMediaType(check); err != nil || len(params) > 0 || !strings.Contains(base, "/") {
+			return "", fmt.Errorf("некорректный тип %q", entry)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return "", errors.New("список разрешенных типов пуст")
+	}
+	return strings.Join(entries, ","), nil
+}
+
+// readSniffHead читает первые sniffLen байт файла (меньше, если файл короче)
+func readSniffHead(r io.Reader) ([]byte, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// checkFileType определяет тип файла по первым байтам head и сверяет его с типом, заявленным
+// клиентом. Заявленный тип должен совпадать с определенным или с одним из его родителей
+// (docx можно заявить как application/zip). Возвращает определенный тип, он и сохраняется
+func (s *Server) checkFileType(declared string, head []byte) (string, error) {
+	detected := mimetype.Detect(head)
+
+	declaredBase, _, err := mime.ParseMediaType(declared)
+	if err == nil && declaredBase != "application/octet-stream" {
+		compatible := false
+		for m := detected; m != nil; m = m.Parent() {
+			if m.Is(declaredBase) {
+				compatible = true
+				break
+			}
+		}
+		if !compatible {
+			return "", NewAPIError(http.StatusUnsupportedMediaType, ErrCodeFileTypeMismatch,
+				"Содержимое файла не соответствует заявленному типу",
+				gin.H{"declared": declaredBase, "detected": detected.String()})
+		}
+	}
+
+	if !s.isAllowedFileType(detected.String()) {
+		return "", unsupportedFileType(detected.String())
+	}
+	return detected.String(), nil
+}
+
+// checkVoiceType проверяет, что голосовое сообщение записано в Ogg/Opus или M4A, и возвращает
+// тип, под которым оно сохраняется: audio/ogg или audio/mp4. Голосовые сообщения не зависят
+// от FileStorage.AllowedMimeTypes, а заявленный тип не сверяется: M4A разных устройств
+// определяется то как audio/x-m4a, то как video/mp4, а контейнер все равно разбирается целиком
+func checkVoiceType(head []byte) (string, error) {
+	detected := mimetype.Detect(head)
+	for m := detected; m != nil; m = m.Parent() {
+		switch {
+		case m.Is("audio/ogg"):
+			return "audio/ogg", nil
+		case m.Is("audio/mp4"), m.Is("audio/x-m4a"), m.Is("video/mp4"):
+			return "audio/mp4", nil
+		}
+	}
+	return "", NewAPIError(http.StatusUnsupportedMediaType, ErrCodeUnsupportedFileType,
+		"Голосовое сообщение должно быть записано в Ogg/Opus или M4A", gin.H{"mime_type": detected.String()})
+}
+
+// checkMessageFileType проверяет тип файла сообщения типа msgType и возвращает тип для сохранения
+func (s *Server) checkMessageFileType(msgType models.MessageType, declared string, head []byte) (string, error) {
+	if msgType == models.MessageTypeVoice {
+		return checkVoiceType(head)
+	}
+	return s.checkFileType(declared, head)
+}
@@ -1,33 +1,22 @@
 package api
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"messenger/models"
-)
-
-// Конфигурация загрузки файлов
-const (
-	// Директория для хранения файлов
-	uploadDir = "./uploads"
-
-	// Максимальный размер файла (100 МБ)
-	maxFileSize = 100 * 1024 * 1024
-
-	// Разрешенные типы файлов
-	allowedMimeTypes = "image/jpeg,image/png,image/gif,application/pdf,application/msword,application/vnd.openxmlformats-officedocument.wordprocessingml.document,audio/mpeg,audio/mp4,video/mp4,video/mpeg,application/zip,application/x-zip-compressed"
+	"messenger/utils/crypto"
+	"messenger/utils/encryption"
 )
 
 // Генерация уникального токена для скачивания
@@ -58,206 +47,271 @@ func determineFileType(mimeType string) models.FileType {
 	}
 }
 
-// Проверка, разрешен ли тип файла
-func isAllowedFileType(mimeType string) bool {
-	allowedTypes := strings.Split(allowedMimeTypes, ",")
-	for _, allowedType := range allowedTypes {
-		if strings.HasPrefix(mimeType, allowedType) || allowedType == mimeType {
-			return true
+// storeEncryptedFile сохраняет содержимое src размером plainSize в хранилище под ключом key,
+// шифруя его на лету, и возвращает ключ файла, зашифрованный ключом сервера
+func (s *Server) storeEncryptedFile(ctx context.Context, key string, src io.Reader, plainSize int64) ([]byte, error) {
+	fileKey, err := encryption.NewFileKey()
+	if err != nil {
+		return nil, err
+	}
+	wrappedKey, err := encryption.WrapKey(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putEncrypted(ctx, key, src, plainSize, fileKey); err != nil {
+		return nil, err
+	}
+	return wrappedKey, nil
+}
+
+// putEncrypted сохраняет содержимое src размером plainSize под ключом key, шифруя его ключом fileKey
+func (s *Server) putEncrypted(ctx context.Context, key string, src io.Reader, plainSize int64, fileKey []byte) error {
+	// Шифрование пишет в pipe, хранилище читает из него: файл не буферизуется целиком
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := encryption.NewStreamWriter(pw, fileKey, encryption.DefaultChunkSize)
+		if err == nil {
+			_, err = io.Copy(w, src)
 		}
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	size := encryption.EncryptedSize(plainSize, encryption.DefaultChunkSize)
+	if err := s.storage.Put(ctx, key, pr, size); err != nil {
+		pr.CloseWithError(err)
+		return err
 	}
-	return false
+	return nil
 }
 
-// Структура запроса для загрузки файла
+// Структура запроса для загрузки файла в чат
 type FileUploadRequest struct {
-	RecipientID uint                  `form:"recipient_id" binding:"required"`
-	Message     string                `form:"message"`
-	File        *multipart.FileHeader `form:"file" binding:"required"`
+	ChatID  uint                  `form:"chat_id" binding:"required"`
+	Message string                `form:"message"` // Подпись к файлу
+	Type    string                `form:"type"`    // file (по умолчанию) или voice
+	File    *multipart.FileHeader `form:"file" binding:"required"`
 }
 
-// Обработчик загрузки файлов
-func (s *Server) handleFileUpload(c *gin.Context) {
-	// Получаем ID отправителя из контекста аутентификации
-	senderID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Пользователь не авторизован"})
-		return
+// fileMessageType проверяет тип сообщения с файлом из запроса; пустой тип означает file
+func fileMessageType(msgType string) (models.MessageType, error) {
+	switch models.MessageType(msgType) {
+	case "", models.MessageTypeFile:
+		return models.MessageTypeFile, nil
+	case models.MessageTypeVoice:
+		return models.MessageTypeVoice, nil
 	}
+	return "", ErrBadRequest("Недопустимый тип сообщения", gin.H{"type": msgType})
+}
 
-	// Проверяем и создаем директорию для загрузки
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		log.Printf("Ошибка создания директории для загрузки: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера при подготовке загрузки"})
-		return
+// messageFileType возвращает тип файла для сохранения: записи голосовых сообщений
+// отличаются от обычных аудиофайлов
+func messageFileType(msgType models.MessageType, mimeType string) models.FileType {
+	if msgType == models.MessageTypeVoice {
+		return models.FileTypeVoice
 	}
+	return determineFileType(mimeType)
+}
 
-	// Получаем форму
-	var req FileUploadRequest
-	if err := c.ShouldBind(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат запроса"})
-		return
-	}
+// maxFileSize возвращает максимальный размер загружаемого файла (FileStorage.MaxSizeMB)
+func (s *Server) maxFileSize() int64 {
+	s.configLock.RLock()
+	defer s.configLock.RUnlock()
+	return int64(s.config.FileStorage.MaxSizeMB) << 20
+}
 
-	// Проверяем размер файла
-	if req.File.Size > maxFileSize {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Размер файла превышает максимально допустимый"})
-		return
+// maxMessageFileSize возвращает максимальный размер файла для сообщения типа msgType
+func (s *Server) maxMessageFileSize(msgType models.MessageType) int64 {
+	limit := s.maxFileSize()
+	if msgType == models.MessageTypeVoice {
+		limit = min(limit, maxVoiceSize)
 	}
+	return limit
+}
 
-	// Получаем MIME тип
-	file, err := req.File.Open()
+// fileTooLarge возвращает ошибку превышения размера файла с допустимым размером в details
+func fileTooLarge(limit int64) *APIError {
+	return NewAPIError(http.StatusRequestEntityTooLarge, ErrCodeFileTooLarge, "Размер файла превышает максимально допустимый",
+		gin.H{"max_size": limit})
+}
+
+// fileUploadChat проверяет, что пользователь может отправить файл в чат, и возвращает чат
+func (s *Server) fileUploadChat(senderID, chatID uint) (*models.Chat, error) {
+	if !s.db.IsUserInChat(senderID, chatID) {
+		log.Printf("Попытка загрузки файла в чат %d от пользователя %d запрещена", chatID, senderID)
+		return nil, ErrForbidden("Доступ к чату запрещен")
+	}
+	chat, err := s.db.GetChatByID(chatID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка открытия файла"})
-		return
+		return nil, ErrNotFound("Чат не найден")
 	}
-	defer file.Close()
 
-	// Проверка типа файла по расширению и MIME-типу
-	mimeType := req.File.Header.Get("Content-Type")
-	if !isAllowedFileType(mimeType) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Неподдерживаемый тип файла"})
-		return
+	// В личном чате блокировка в любую сторону запрещает переписку
+	if chat.Type == "direct" {
+		if err := s.checkDirectChatBlocked(senderID, chat.ID); err != nil {
+			return nil, err
+		}
 	}
+	return chat, nil
+}
 
-	// Генерируем уникальный токен для скачивания
-	downloadToken, err := generateDownloadToken()
+// createFileMessage создает сообщение с уже сохраненным файлом и рассылает его участникам чата.
+// Запись голосового сообщения (FileTypeVoice) отправляется сообщением типа voice, остальные
+// файлы - типа file. При ошибке объект файла в хранилище удаляет вызывающий
+func (s *Server) createFileMessage(senderID uint, chat *models.Chat, caption string, fileRecord *models.File) (*messageResponse, error) {
+	sender, err := s.db.GetUserByID(senderID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации токена"})
-		return
+		return nil, ErrInternal("Ошибка получения данных пользователя")
 	}
 
-	// Генерируем уникальное имя файла
-	fileExt := filepath.Ext(req.File.Filename)
-	uniqueFileName := fmt.Sprintf("%s%s", downloadToken, fileExt)
-	filePath := filepath.Join(uploadDir, uniqueFileName)
-
-	// Сохраняем файл
-	dst, err := os.Create(filePath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания файла"})
-		return
+	// Шифруем подпись к файлу так же, как текст обычных сообщений
+	var encryptedCaption []byte
+	if caption != "" {
+		encryptedCaption, err = crypto.Encrypt([]byte(caption))
+		if err != nil {
+			log.Printf("Ошибка шифрования подписи к файлу: %v", err)
+			return nil, ErrInternal("Ошибка шифрования сообщения")
+		}
 	}
-	defer dst.Close()
 
-	_, err = io.Copy(dst, file)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения файла"})
-		return
+	msgType := models.MessageTypeFile
+	if fileRecord.FileType == models.FileTypeVoice {
+		msgType = models.MessageTypeVoice
 	}
 
-	// Начинаем транзакцию
-	tx := s.db.DB.Begin()
-	if tx.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка базы данных"})
-		return
+	message := models.Message{
+		ChatID:    chat.ID,
+		UserID:    senderID,
+		Content:   encryptedCaption,
+		Type:      string(msgType),
+		PlainText: caption, // Только для ответа, не сохраняется в БД
 	}
 
-	// Создаем сообщение
-	message := models.DirectMessage{
-		SenderID:    senderID.(uint),
-		RecipientID: req.RecipientID,
-		Content:     []byte(req.Message),
-		MessageType: "file",
-		IsRead:      false,
+	// Файл и сообщение ссылаются друг на друга, поэтому создаются в одной транзакции
+	if err := s.db.CreateFileMessage(&message, fileRecord); err != nil {
+		log.Printf("Ошибка сохранения сообщения с файлом: %v", err)
+		return nil, ErrInternal("Ошибка сохранения сообщения")
 	}
 
-	if err := tx.Create(&message).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения сообщения"})
-		return
+	// Обновляем время последней активности чата
+	chat.LastActivity = time.Now()
+	if err := s.db.UpdateChat(chat); err != nil {
+		log.Printf("Ошибка обновления времени активности чата: %v", err)
 	}
 
-	// Создаем запись о файле
-	fileRecord := models.File{
-		MessageID:     message.ID,
-		FileName:      req.File.Filename,
-		FileSize:      req.File.Size,
-		FileType:      determineFileType(mimeType),
-		FilePath:      filePath,
-		MimeType:      mimeType,
-		DownloadToken: downloadToken,
+	response := messageResponse{
+		ID:        message.ID,
+		ChatID:    message.ChatID,
+		UserID:    message.UserID,
+		Content:   caption,
+		Type:      message.Type,
+		FileID:    message.FileID,
+		File:      fileRecord,
+		CreatedAt: message.CreatedAt,
 	}
+	response.User.ID = sender.ID
+	response.User.Username = sender.Username
+	response.User.Avatar = sender.Avatar
+
+	// Рассылаем сообщение участникам чата как обычное сообщение
+	s.broadcastNewMessage(senderID, response)
 
-	if err := tx.Create(&fileRecord).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения информации о файле"})
+	log.Printf("Пользователь %d загрузил файл #%d в чат %d", senderID, fileRecord.ID, chat.ID)
+	return &response, nil
+}
+
+// Обработчик загрузки файлов: файл прикрепляется к новому сообщению типа file в чате,
+// а с type=voice отправляется голосовым сообщением. Большие файлы загружаются по частям через /api/uploads
+func (s *Server) handleFileUpload(c *gin.Context) {
+	// Получаем ID отправителя из контекста аутентификации
+	senderID := c.GetUint("userID")
+
+	// Получаем форму
+	var req FileUploadRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат запроса"})
 		return
 	}
 
-	// Фиксируем транзакцию
-	if err := tx.Commit().Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка фиксации транзакции"})
+	msgType, err := fileMessageType(req.Type)
+	if err != nil {
+		SendAPIError(c, err)
 		return
 	}
 
-	// Отправляем уведомление через WebSocket
-	message.File = &fileRecord
-
-	// Сериализуем данные в JSON
-	messageJSON, err := json.Marshal(message)
+	chat, err := s.fileUploadChat(senderID, req.ChatID)
 	if err != nil {
-		log.Printf("Ошибка сериализации сообщения: %v", err)
-	} else {
-		wsMessage := WSMessage{
-			Type:    "file",
-			Payload: json.RawMessage(messageJSON),
-		}
-
-		// Отправляем сообщение через WebSocket
-		if err := s.sendMessageToUser(req.RecipientID, wsMessage); err != nil {
-			log.Printf("Ошибка отправки сообщения о файле через WebSocket: %v", err)
-		}
+		SendAPIError(c, err)
+		return
 	}
 
-	// Возвращаем информацию о загруженном файле
-	c.JSON(http.StatusOK, gin.H{
-		"message":    "Файл успешно загружен",
-		"file":       fileRecord,
-		"message_id": message.ID,
-	})
-}
-
-// Обработчик скачивания файлов
-func (s *Server) handleFileDownload(c *gin.Context) {
-	token := c.Param("token")
-	if token == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Токен не указан"})
+	// Проверяем размер файла и свободное место в квотах
+	if limit := s.maxMessageFileSize(msgType); req.File.Size > limit {
+		SendAPIError(c, fileTooLarge(limit))
+		return
+	}
+	if err := s.checkStorageQuota(senderID, req.File.Size, ""); err != nil {
+		SendAPIError(c, err)
 		return
 	}
 
-	// Ищем файл по токену
-	var file models.File
-	if err := s.db.DB.Where("download_token = ?", token).First(&file).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Файл не найден"})
+	file, err := req.File.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка открытия файла"})
 		return
 	}
+	defer file.Close()
 
-	// Проверяем, существует ли файл на диске
-	if _, err := os.Stat(file.FilePath); os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Файл не найден на сервере"})
+	// Тип определяется по содержимому: заголовку Content-Type части формы верить нельзя
+	head, err := readSniffHead(file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		SendInternalError(c, "Ошибка чтения файла")
+		return
+	}
+	mimeType, err := s.checkMessageFileType(msgType, req.File.Header.Get("Content-Type"), head)
+	if err != nil {
+		SendAPIError(c, err)
 		return
 	}
 
-	// Устанавливаем правильные заголовки
-	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", file.FileName))
-	c.Header("Content-Type", file.MimeType)
-	c.Header("Content-Length", fmt.Sprintf("%d", file.FileSize))
-	c.Header("Cache-Control", "no-cache")
+	// Генерируем уникальный токен для скачивания
+	downloadToken, err := generateDownloadToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации токена"})
+		return
+	}
 
-	// Отправляем файл
-	c.File(file.FilePath)
-}
+	// Ключ объекта в хранилище определяется содержимым и заполняется при сохранении
+	fileRecord := models.File{
+		FileName:      req.File.Filename,
+		FileType:      messageFileType(msgType, mimeType),
+		MimeType:      mimeType,
+		DownloadToken: downloadToken,
+	}
+	if err := s.storeUploadedFile(c.Request.Context(), &fileRecord, file, req.File.Size); err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			SendAPIError(c, apiErr)
+			return
+		}
+		log.Printf("Ошибка сохранения файла: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения файла"})
+		return
+	}
 
-// sendMessageToUser отправляет сообщение через WebSocket
-func (s *Server) sendMessageToUser(userID uint, message interface{}) error {
-	data, err := json.Marshal(message)
+	response, err := s.createFileMessage(senderID, chat, req.Message, &fileRecord)
 	if err != nil {
-		return fmt.Errorf("ошибка сериализации сообщения: %v", err)
+		s.releaseFile(context.Background(), &fileRecord)
+		SendAPIError(c, err)
+		return
 	}
 
-	s.sendToUser(userID, data)
-	return nil
+	c.JSON(http.StatusCreated, gin.H{
+		"message": response,
+	})
 }
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"messenger/logger"
+)
+
+// Коды закрытия WebSocket (диапазон 4000-4999 для приложений)
+const (
+	wsCloseSessionRevoked = 4001 // Сессия отозвана (выход, завершение с другого устройства)
+	wsCloseUserBlocked    = 4003 // Пользователь заблокирован или удален администратором
+)
+
+// wsHub - единый реестр подключений клиентов. У пользователя может быть
+// несколько одновременных подключений (вкладки, устройства, SSE, long-poll),
+// кадр пользователю доставляется во все его подключения.
+type wsHub struct {
+	mu      sync.RWMutex
+	clients map[uint]map[*WSClient]struct{}
+}
+
+func newWSHub() *wsHub {
+	return &wsHub{clients: make(map[uint]map[*WSClient]struct{})}
+}
+
+// add добавляет подключение в реестр
+func (h *wsHub) add(c *WSClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	conns, ok := h.clients[c.userID]
+	if !ok {
+		conns = make(map[*WSClient]struct{})
+		h.clients[c.userID] = conns
+	}
+	conns[c] = struct{}{}
+}
+
+// remove удаляет подключение из реестра. Возвращает false, если его там уже не было
+func (h *wsHub) remove(c *WSClient) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	conns, ok := h.clients[c.userID]
+	if !ok {
+		return false
+	}
+	if _, ok := conns[c]; !ok {
+		return false
+	}
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(h.clients, c.userID)
+	}
+	return true
+}
+
+// clientsOf возвращает снимок подключений пользователя
+func (h *wsHub) clientsOf(userID uint) []*WSClient {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	conns := h.clients[userID]
+	result := make([]*WSClient, 0, len(conns))
+	for c := range conns {
+		result = append(result, c)
+	}
+	return result
+}
+
+// all возвращает снимок всех подключений
+func (h *wsHub) all() []*WSClient {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var result []*WSClient
+	for _, conns := range h.clients {
+		for c := range conns {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// isOnline сообщает, есть ли у пользователя хотя бы одно подключение
+func (h *wsHub) isOnline(userID uint) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients[userID]) > 0
+}
+
+// count возвращает общее число подключений
+func (h *wsHub) count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	total := 0
+	for _, conns := range h.clients {
+		total += len(conns)
+	}
+	return total
+}
+
+// registerClient добавляет подключение в реестр сервера
+func (s *Server) registerClient(client *WSClient) {
+	s.hub.add(client)
+	logger.Infof("Клиент %d зарегистрирован (транспорт: %s, подключений: %d)", client.userID, client.transport, len(s.hub.clientsOf(client.userID)))
+}
+
+// unregisterClient удаляет подключение из реестра и закрывает его очередь отправки.
+// Повторный вызов для того же клиента ничего не делает
+func (s *Server) unregisterClient(client *WSClient) {
+	if s.hub.remove(client) {
+		logger.Infof("Клиент %d отключается (транспорт: %s)", client.userID, client.transport)
+	}
+	client.closeSend()
+}
+
+// disconnectUser закрывает все подключения пользователя с указанным кодом и причиной
+func (s *Server) disconnectUser(userID uint, code int, reason string) {
+	for _, client := range s.hub.clientsOf(userID) {
+		logger.Infof("Закрываем подключение пользователя %d (транспорт: %s): %s", userID, client.transport, reason)
+		client.closeWithReason(code, reason)
+	}
+}
+
+// sendToUser отправляет кадр во все подключения пользователя
+func (s *Server) sendToUser(userID uint, frame wsResponse) {
+	clients := s.hub.clientsOf(userID)
+	if len(clients) == 0 {
+		logger.Debugf("Пользователь %d не подключен", userID)
+		return
+	}
+	for _, client := range clients {
+		client.sendFrame(frame)
+	}
+}
+
+// resolveToken извлекает JWT из запроса в порядке: параметр token, кука token,
+// заголовок Authorization: Bearer, подпротокол WebSocket ("token=<jwt>" или сам токен).
+// Возвращает токен и источник для логирования
+func resolveToken(c *gin.Context) (string, string) {
+	if token := c.Query("token"); token != "" {
+		return token, "query"
+	}
+	if token, err := c.Cookie("token"); err == nil && token != "" {
+		return token, "cookie"
+	}
+	if authHeader := c.GetHeader("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer "), "header"
+	}
+	for _, protocol := range websocket.Subprotocols(c.Request) {
+		if strings.HasPrefix(protocol, "token=") {
+			return strings.TrimPrefix(protocol, "token="), "subprotocol"
+		}
+		// Подпротокол кодирования кадров, а не токен
+		if isWSCodecProtocol(protocol) {
+			continue
+		}
+		return protocol, "subprotocol"
+	}
+	return "", ""
+}
+
+// checkOrigin проверяет Origin запроса на WebSocket по списку WebSocket.AllowedOrigins.
+// Пустой список разрешает любой Origin. Запросы без Origin (не из браузера)
+// и с Origin, совпадающим с Host, разрешены всегда
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	s.configLock.RLock()
+	allowed := s.config.WebSocket.AllowedOrigins
+	s.configLock.RUnlock()
+
+	if len(allowed) == 0 {
+		return true
+	}
+
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	for _, candidate := range allowed {
+		if candidate == "*" || strings.EqualFold(strings.TrimSuffix(candidate, "/"), origin) {
+			return true
+		}
+	}
+
+	logger.Warnf("WebSocket: Origin %s отклонен", origin)
+	return false
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"messenger/config"
+	"messenger/logger"
+	"messenger/middleware"
+	"messenger/redis"
+)
+
+// LockoutResponse описывает счетчик неудачных попыток входа для администратора
+type LockoutResponse struct {
+	middleware.LoginAttempt
+	Locked bool `json:"locked"`
+}
+
+// newAuthLimiter создает лимитер входа. При включенном Redis счетчики общие
+// для всех экземпляров сервера, иначе хранятся в памяти процесса
+func newAuthLimiter(cfg *config.Config, redisClient *redis.RedisClient) *middleware.AuthLimiter {
+	throttle := cfg.LoginThrottle
+	policy := middleware.LoginThrottlePolicy{
+		MaxUserAttempts: max(throttle.MaxUserAttempts, 0),
+		MaxIPAttempts:   max(throttle.MaxIPAttempts, 0),
+		BaseLockout:     time.Duration(throttle.BaseLockout) * time.Second,
+		MaxLockout:      time.Duration(throttle.MaxLockout) * time.Second,
+		ResetWindow:     time.Duration(throttle.ResetWindow) * time.Second,
+	}
+
+	if redisClient != nil && redisClient.IsEnabled() {
+		logger.Info("Счетчики попыток входа хранятся в Redis")
+		return middleware.NewAuthLimiter(redisClient.LoginAttempts(), policy)
+	}
+
+	store := middleware.NewMemoryLoginAttemptStore()
+	store.Cleanup(10 * time.Minute)
+	return middleware.NewAuthLimiter(store, policy)
+}
+
+// handleAdminGetLockouts возвращает счетчики неудачных попыток входа и активные блокировки
+func (s *Server) handleAdminGetLockouts(c *gin.Context) {
+	if c.GetString("role") != "admin" {
+		SendForbidden(c, "Требуются права администратора")
+		return
+	}
+
+	attempts, err := s.authLimiter.List(c.Request.Context())
+	if err != nil {
+		logger.Errorf("handleAdminGetLockouts: Ошибка получения попыток входа: %v", err)
+		SendInternalError(c, "Ошибка получения блокировок входа")
+		return
+	}
+
+	now := time.Now()
+	lockouts := make([]LockoutResponse, 0, len(attempts))
+	for _, attempt := range attempts {
+		lockouts = append(lockouts, LockoutResponse{
+			LoginAttempt: attempt,
+			Locked:       attempt.Locked(now),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lockouts": lockouts})
+}
+
+// handleAdminClearLockout снимает блокировку по ключу (ip:<адрес> или user:<имя>)
+func (s *Server) handleAdminClearLockout(c *gin.Context) {
+	if c.GetString("role") != "admin" {
+		SendForbidden(c, "Требуются права администратора")
+		return
+	}
+
+	key := c.Param("key")
+	if key == "" {
+		SendBadRequest(c, "Не указан ключ блокировки")
+		return
+	}
+
+	if err := s.authLimiter.Clear(c.Request.Context(), key); err != nil {
+		logger.Errorf("handleAdminClearLockout: Ошибка снятия блокировки %s: %v", key, err)
+		SendInternalError(c, "Ошибка снятия блокировки")
+		return
+	}
+
+	logger.Infof("handleAdminClearLockout: Администратор %d снял блокировку входа %s", c.GetUint("userID"), key)
+	c.JSON(http.StatusOK, gin.H{"message": "Блокировка снята"})
+}
+
+// handleAdminClearLockouts сбрасывает все счетчики и блокировки входа
+func (s *Server) handleAdminClearLockouts(c *gin.Context) {
+	if c.GetString("role") != "admin" {
+		SendForbidden(c, "Требуются права администратора")
+		return
+	}
+
+	cleared, err := s.authLimiter.ClearAll(c.Request.Context())
+	if err != nil {
+		logger.Errorf("handleAdminClearLockouts: Ошибка сброса блокировок: %v", err)
+		SendInternalError(c, "Ошибка сброса блокировок")
+		return
+	}
+
+	logger.Infof("handleAdminClearLockouts: Администратор %d сбросил блокировки входа (%d)", c.GetUint("userID"), cleared)
+	c.JSON(http.StatusOK, gin.H{"cleared": cleared})
+}
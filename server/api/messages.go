@@ -1,18 +1,14 @@
 package api
 
 import (
-	"encoding/json"
-	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/gorilla/websocket"
 
 	"messenger/logger"
 	"messenger/models"
-	"messenger/redis"
 	"messenger/utils/crypto"
 )
 
@@ -22,37 +18,30 @@ type SendMessageRequest struct {
 	Content     string `json:"content" binding:"required"`
 }
 
-// Запрос на отметку сообщений как прочитанных
-type MarkReadRequest struct {
-	SenderID uint `json:"sender_id" binding:"required"`
-}
-
-// Структура для создания нового сообщения
-type newMessagePayload struct {
-	ChatID  uint   `json:"chat_id"`
-	Content string `json:"content"`
-	Type    string `json:"type"`
-	FileID  *uint  `json:"file_id,omitempty"`
-}
-
 // Структура для новых сообщений
 type newMessageRequest struct {
 	Content string `json:"content" binding:"required"`
-	Type    string `json:"type" binding:"required,oneof=text file"`
-	FileID  *uint  `json:"file_id,omitempty"`
+	Type    string `json:"type"`
+}
+
+// Структура запроса статуса набора текста
+type typingRequest struct {
+	Status bool `json:"status"`
 }
 
 // Структура для сообщений с сервера
 type messageResponse struct {
-	ID        uint         `json:"id"`
-	ChatID    uint         `json:"chat_id"`
-	UserID    uint         `json:"user_id"`
-	Content   string       `json:"content"`
-	Type      string       `json:"type"`
-	FileID    *uint        `json:"file_id,omitempty"`
-	File      *models.File `json:"file,omitempty"`
-	CreatedAt time.Time    `json:"created_at"`
-	User      struct {
+	ID      uint         `json:"id"`
+	ChatID  uint         `json:"chat_id"`
+	UserID  uint         `json:"user_id"`
+	Content string       `json:"content"`
+	Type    string       `json:"type"`
+	FileID  *uint        `json:"file_id,omitempty"`
+	File    *models.File `json:"file,omitempty"`
+	// Кто из получателей прослушал голосовое сообщение (только в истории чата)
+	ListenedBy []uint    `json:"listened_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	User       struct {
 		ID       uint   `json:"id"`
 		Username string `json:"username"`
 		Avatar   string `json:"avatar,omitempty"`
@@ -61,7 +50,7 @@ type messageResponse struct {
 
 // handleGetMessages возвращает сообщения чата
 func (s *Server) handleGetMessages(c *gin.Context) {
-	userID := c.GetUint("user_id")
+	userID := c.GetUint("userID")
 
 	// Получаем ID чата из параметров URL
 	chatIDStr := c.Param("chatID")
@@ -77,15 +66,37 @@ func (s *Server) handleGetMessages(c *gin.Context) {
 		return
 	}
 
+	// Сообщения заблокированных пользователей в общих группах не показываем
+	blockedIDs, err := s.db.GetBlockedIDs(userID)
+	if err != nil {
+		logger.Errorf("Ошибка получения блокировок пользователя %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сообщений"})
+		return
+	}
+
 	// Получаем сообщения чата
 	limit := 50 // можно сделать параметром
-	messages, err := s.db.GetChatMessages(uint(chatID), limit)
+	messages, err := s.db.GetChatMessages(uint(chatID), limit, blockedIDs...)
 	if err != nil {
 		logger.Errorf("Ошибка получения сообщений: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сообщений"})
 		return
 	}
 
+	// Отметки о прослушивании голосовых сообщений
+	var voiceIDs []uint
+	for _, msg := range messages {
+		if msg.Type == string(models.MessageTypeVoice) {
+			voiceIDs = append(voiceIDs, msg.ID)
+		}
+	}
+	listeners, err := s.db.GetMessageListeners(voiceIDs)
+	if err != nil {
+		logger.Errorf("Ошибка получения отметок о прослушивании: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сообщений"})
+		return
+	}
+
 	// Преобразуем сообщения для ответа
 	var response []messageResponse
 	for _, msg := range messages {
@@ -105,14 +116,15 @@ func (s *Server) handleGetMessages(c *gin.Context) {
 
 		// Создаем объект ответа
 		msgResp := messageResponse{
-			ID:        msg.ID,
-			ChatID:    msg.ChatID,
-			UserID:    msg.UserID,
-			Content:   content,
-			Type:      msg.Type,
-			FileID:    msg.FileID,
-			File:      msg.File,
-			CreatedAt: msg.CreatedAt,
+			ID:         msg.ID,
+			ChatID:     msg.ChatID,
+			UserID:     msg.UserID,
+			Content:    content,
+			Type:       msg.Type,
+			FileID:     msg.FileID,
+			File:       msg.File,
+			ListenedBy: listeners[msg.ID],
+			CreatedAt:  msg.CreatedAt,
 		}
 
 		// Добавляем информацию о пользователе
@@ -128,70 +140,172 @@ func (s *Server) handleGetMessages(c *gin.Context) {
 	})
 }
 
-// handleSendMessage отправляет новое сообщение в чат
+// handleSendMessage отправляет новое сообщение в чат (REST-аналог кадра "message")
 func (s *Server) handleSendMessage(c *gin.Context) {
-	userID := c.GetUint("user_id")
+	if !s.checkRESTRateLimit(c, WSTypeMessage) {
+		return
+	}
+	userID := c.GetUint("userID")
 
 	// Получаем ID чата из параметров URL
 	chatIDStr := c.Param("chatID")
 	chatID, err := strconv.ParseUint(chatIDStr, 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID чата"})
+		SendBadRequest(c, "Некорректный ID чата")
 		return
 	}
 
-	// Проверяем доступ к чату
-	if !s.db.IsUserInChat(userID, uint(chatID)) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "У вас нет доступа к этому чату"})
+	// Получаем данные сообщения из запроса
+	var req newMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendBadRequest(c, "Некорректные данные сообщения")
 		return
 	}
 
-	// Получаем данные сообщения из запроса
-	var req newMessageRequest
+	response, err := s.postChatMessage(userID, uint(chatID), req.Content, req.Type)
+	if err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": response,
+	})
+}
+
+// handleSendTyping рассылает статус набора текста (REST-аналог кадра "typing")
+func (s *Server) handleSendTyping(c *gin.Context) {
+	if !s.checkRESTRateLimit(c, WSTypeTyping) {
+		return
+	}
+	userID := c.GetUint("userID")
+
+	chatID, err := strconv.ParseUint(c.Param("chatID"), 10, 32)
+	if err != nil {
+		SendBadRequest(c, "Некорректный ID чата")
+		return
+	}
+
+	var req typingRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные сообщения"})
+		SendBadRequest(c, "Некорректный формат данных о наборе текста")
+		return
+	}
+
+	if err := s.setTypingStatus(userID, uint(chatID), req.Status); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// handleReadMessage отмечает сообщение как прочитанное (REST-аналог кадра "read")
+func (s *Server) handleReadMessage(c *gin.Context) {
+	if !s.checkRESTRateLimit(c, WSTypeRead) {
+		return
+	}
+	userID := c.GetUint("userID")
+
+	messageID, err := strconv.ParseUint(c.Param("messageID"), 10, 32)
+	if err != nil {
+		SendBadRequest(c, "Некорректный ID сообщения")
+		return
+	}
+
+	if err := s.markMessageRead(userID, uint(messageID)); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// handleListenMessage отмечает голосовое сообщение как прослушанное (REST-аналог кадра "listened")
+func (s *Server) handleListenMessage(c *gin.Context) {
+	if !s.checkRESTRateLimit(c, WSTypeListened) {
+		return
+	}
+	userID := c.GetUint("userID")
+
+	messageID, err := strconv.ParseUint(c.Param("messageID"), 10, 32)
+	if err != nil {
+		SendBadRequest(c, "Некорректный ID сообщения")
 		return
 	}
 
+	if err := s.markMessageListened(userID, uint(messageID)); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// postChatMessage сохраняет новое сообщение и рассылает его остальным участникам чата.
+// Используется всеми транспортами: WebSocket кадром "message" и REST
+func (s *Server) postChatMessage(userID, chatID uint, content, msgType string) (*messageResponse, error) {
+	if chatID == 0 || content == "" {
+		return nil, ErrBadRequest("Отсутствуют обязательные поля")
+	}
+	if msgType == "" {
+		msgType = string(models.MessageTypeText)
+	}
+	if msgType != string(models.MessageTypeText) {
+		return nil, ErrBadRequest("Недопустимый тип сообщения", gin.H{"type": msgType})
+	}
+
+	// Проверка доступа к чату
+	if !s.db.IsUserInChat(userID, chatID) {
+		logger.Warnf("Попытка отправки сообщения в чат %d от пользователя %d запрещена", chatID, userID)
+		return nil, ErrForbidden("Доступ к чату запрещен")
+	}
+
+	// Получаем информацию о чате
+	chat, err := s.db.GetChatByID(chatID)
+	if err != nil {
+		return nil, ErrNotFound("Чат не найден")
+	}
+
+	// В личном чате блокировка в любую сторону запрещает переписку
+	if chat.Type == "direct" {
+		if err := s.checkDirectChatBlocked(userID, chatID); err != nil {
+			return nil, err
+		}
+	}
+
 	// Получаем информацию о пользователе
 	user, err := s.db.GetUserByID(userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения данных пользователя"})
-		return
+		return nil, ErrInternal("Ошибка получения данных пользователя")
 	}
 
 	// Шифруем содержимое сообщения
-	encryptedContent, err := crypto.Encrypt([]byte(req.Content))
+	encryptedContent, err := crypto.Encrypt([]byte(content))
 	if err != nil {
 		logger.Errorf("Ошибка шифрования сообщения: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка шифрования сообщения"})
-		return
+		return nil, ErrInternal("Ошибка шифрования сообщения")
 	}
 
 	// Создаем новое сообщение
 	message := models.Message{
-		ChatID:    uint(chatID),
+		ChatID:    chatID,
 		UserID:    userID,
 		Content:   encryptedContent,
-		Type:      req.Type,
-		FileID:    req.FileID,
-		PlainText: req.Content, // Только для ответа, не сохраняется в БД
+		Type:      msgType,
+		PlainText: content, // Только для ответа, не сохраняется в БД
 	}
 
 	// Сохраняем сообщение в базе данных
 	if err := s.db.CreateMessage(&message); err != nil {
 		logger.Errorf("Ошибка создания сообщения: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания сообщения"})
-		return
+		return nil, ErrInternal("Ошибка сохранения сообщения")
 	}
 
 	// Обновляем время последней активности чата
-	chat, err := s.db.GetChatByID(uint(chatID))
-	if err == nil && chat != nil {
-		chat.LastActivity = time.Now()
-		if err := s.db.UpdateChat(chat); err != nil {
-			logger.Errorf("Ошибка обновления времени активности чата: %v", err)
-		}
+	chat.LastActivity = time.Now()
+	if err := s.db.UpdateChat(chat); err != nil {
+		logger.Errorf("Ошибка обновления времени активности чата: %v", err)
 	}
 
 	// Формируем ответ
@@ -199,10 +313,8 @@ func (s *Server) handleSendMessage(c *gin.Context) {
 		ID:        message.ID,
 		ChatID:    message.ChatID,
 		UserID:    message.UserID,
-		Content:   req.Content, // Отправляем открытый текст в ответе
+		Content:   content, // Отправляем открытый текст в ответе
 		Type:      message.Type,
-		FileID:    message.FileID,
-		File:      message.File,
 		CreatedAt: message.CreatedAt,
 	}
 
@@ -211,229 +323,136 @@ func (s *Server) handleSendMessage(c *gin.Context) {
 	response.User.Username = user.Username
 	response.User.Avatar = user.Avatar
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message": response,
-	})
-}
-
-// Обработчик отметки сообщений как прочитанных
-func (s *Server) handleMarkMessagesAsRead(c *gin.Context) {
-	// Получаем ID текущего пользователя из контекста аутентификации
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Пользователь не авторизован"})
-		return
-	}
-
-	// Парсим запрос
-	var req MarkReadRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат запроса"})
-		return
-	}
+	// Отправитель получает сообщение в ответе на свой запрос
+	s.broadcastNewMessage(userID, response)
 
-	// Обновляем сообщения в БД
-	if err := s.db.DB.Model(&models.Message{}).
-		Where("sender_id = ? AND recipient_id = ? AND is_read = ?", req.SenderID, userID, false).
-		Updates(map[string]interface{}{"is_read": true}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления статуса сообщений"})
-		return
-	}
-
-	// Отправляем уведомление о прочтении через WebSocket
-	// Формируем WebSocket сообщение
-	chatID := uint(req.SenderID)
-	wsMessage := WSMessage{
-		Type: "read",
-		Payload: ReadReceiptPayload{
-			ChatID:    chatID,
-			UserID:    userID,
-			Timestamp: time.Now(),
-		},
-	}
-
-	// Если включен Redis, отправляем уведомление через него
-	if s.redis != nil && s.redis.IsEnabled() {
-		// Создаем канал для личного чата
-		channel := redis.CreatePrivateChatChannel(userID.(uint), req.SenderID)
-
-		// Создаем сообщение для Redis
-		redisMsg := redis.PubSubMessage{
-			Type:        "read_receipt",
-			RecipientID: req.SenderID,
-			SenderID:    userID.(uint),
-			Content:     []byte(fmt.Sprintf(`{"reader_id":%d}`, userID)),
-		}
+	return &response, nil
+}
 
-		// Публикуем сообщение
-		if err := s.redis.PublishMessage(channel, redisMsg); err != nil {
-			fmt.Printf("Ошибка публикации уведомления о прочтении в Redis: %v\n", err)
-		}
-	} else {
-		// Если Redis не используется, отправляем через прямое WebSocket соединение
-		s.mu.Lock()
-		client, ok := s.clients[req.SenderID]
-		s.mu.Unlock()
-
-		if ok {
-			// Сериализуем сообщение в JSON
-			messageJSON, err := serializeWSMessage(wsMessage)
-			if err != nil {
-				fmt.Printf("Ошибка сериализации уведомления: %v\n", err)
-			} else {
-				// Отправляем уведомление отправителю
-				client.send <- messageJSON
-			}
-		}
+// setTypingStatus проверяет доступ к чату и рассылает статус набора текста
+func (s *Server) setTypingStatus(userID, chatID uint, status bool) error {
+	if !s.db.IsUserInChat(userID, chatID) {
+		return ErrForbidden("Доступ к чату запрещен")
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "success"})
+	// Отправляем статус печати всем участникам чата кроме текущего
+	s.broadcastTypingStatus(userID, chatID, status)
+	return nil
 }
 
-// Функция для обработки новых сообщений от клиента
-func (s *Server) handleNewMessage(c *gin.Context, conn *websocket.Conn, userID uint, msg wsMessage) {
-	// Получаем информацию из payload
-	var payload newMessagePayload
-
-	// Конвертируем payload в нужный формат
-	payloadData, err := json.Marshal(msg.Payload)
+// markMessageRead отмечает сообщение как прочитанное и уведомляет участников чата
+func (s *Server) markMessageRead(userID, messageID uint) error {
+	// Получаем сообщение для проверки чата
+	message, err := s.db.GetMessageByID(messageID)
 	if err != nil {
-		s.sendWSError(conn, "invalid_payload", "Неверный формат данных")
-		return
+		return ErrNotFound("Сообщение не найдено")
 	}
 
-	if err := json.Unmarshal(payloadData, &payload); err != nil {
-		s.sendWSError(conn, "invalid_payload", "Неверный формат данных сообщения")
-		return
+	// Проверка доступа к чату до изменения статуса
+	if !s.db.IsUserInChat(userID, message.ChatID) {
+		return ErrForbidden("Доступ к чату запрещен")
 	}
 
-	// Проверяем обязательные поля
-	if payload.ChatID == 0 || payload.Content == "" {
-		s.sendWSError(conn, "invalid_payload", "Отсутствуют обязательные поля")
-		return
+	if err := s.db.MarkMessageAsRead(messageID, userID); err != nil {
+		return ErrInternal("Ошибка при отметке сообщения как прочитанного")
 	}
 
-	// Проверяем доступ пользователя к чату
-	chat, err := s.db.GetChatByID(payload.ChatID)
+	// Отправляем статус прочтения всем участникам чата
+	s.broadcastReadStatus(userID, message)
+	return nil
+}
+
+// markMessageListened отмечает голосовое сообщение как прослушанное (и заодно прочитанное)
+// и уведомляет участников чата. Отправитель свои сообщения не отмечает
+func (s *Server) markMessageListened(userID, messageID uint) error {
+	message, err := s.db.GetMessageByID(messageID)
 	if err != nil {
-		s.sendWSError(conn, "chat_not_found", "Чат не найден")
-		return
+		return ErrNotFound("Сообщение не найдено")
 	}
 
-	// Проверяем, является ли пользователь участником чата
-	if !s.db.IsUserInChat(userID, chat.ID) {
-		s.sendWSError(conn, "access_denied", "Вы не являетесь участником этого чата")
-		return
+	if !s.db.IsUserInChat(userID, message.ChatID) {
+		return ErrForbidden("Доступ к чату запрещен")
 	}
-
-	// Шифруем содержимое сообщения перед сохранением
-	encryptedContent, err := crypto.Encrypt([]byte(payload.Content))
-	if err != nil {
-		s.sendWSError(conn, "encryption_error", "Ошибка шифрования сообщения")
-		return
+	if message.Type != string(models.MessageTypeVoice) {
+		return ErrBadRequest("Сообщение не является голосовым")
 	}
-
-	// Создаем новое сообщение
-	message := &models.Message{
-		ChatID:    payload.ChatID,
-		UserID:    userID,
-		Content:   encryptedContent, // Сохраняем шифрованное содержимое
-		PlainText: payload.Content,  // Временно храним расшифрованный текст
-		Type:      payload.Type,
-		FileID:    payload.FileID,
-		CreatedAt: time.Now(),
+	if message.UserID == userID {
+		return nil
 	}
 
-	// Сохраняем сообщение в БД
-	if err := s.db.CreateMessage(message); err != nil {
-		s.sendWSError(conn, "db_error", "Ошибка сохранения сообщения")
-		return
+	if err := s.db.MarkMessageListened(messageID, userID); err != nil {
+		return ErrInternal("Ошибка при отметке сообщения как прослушанного")
 	}
-
-	// Обновляем last_message и last_activity в чате
-	chat.LastActivity = time.Now()
-	s.db.UpdateChat(chat)
-
-	// Подготавливаем данные пользователя для отправки
-	user, err := s.db.GetUserByID(userID)
-	if err == nil {
-		message.User = *user
+	if err := s.db.MarkMessageAsRead(messageID, userID); err != nil {
+		return ErrInternal("Ошибка при отметке сообщения как прочитанного")
 	}
 
-	// Отправляем сообщение текущему пользователю для подтверждения
-	s.sendWSResponse(conn, "message_sent", message)
-
-	// Отправляем сообщение всем участникам чата
-	s.broadcastMessageToChat(payload.ChatID, userID, message)
+	s.broadcastReadStatus(userID, message)
+	s.broadcastListenedStatus(userID, message)
+	return nil
 }
 
-// Функция для отправки сообщения всем участникам чата
-func (s *Server) broadcastMessageToChat(chatID, senderID uint, message *models.Message) {
-	// Получаем список пользователей чата
-	chatUsers, err := s.db.GetChatUsers(chatID)
+// broadcastNewMessage отправляет новое сообщение всем участникам чата кроме отправителя
+// и пользователей, заблокировавших отправителя
+func (s *Server) broadcastNewMessage(senderID uint, message messageResponse) {
+	users, err := s.db.GetChatUsers(message.ChatID)
 	if err != nil {
+		logger.Errorf("Ошибка получения участников чата: %v", err)
 		return
 	}
+	blockers := s.blockerSet(senderID)
 
-	// Отправляем сообщение каждому участнику чата, кроме отправителя
-	for _, user := range chatUsers {
-		if user.ID == senderID {
-			continue // Пропускаем отправителя
+	for _, user := range users {
+		if user.ID == senderID || blockers[user.ID] {
+			continue
 		}
 
-		// Проверяем, подключен ли пользователь
-		s.wsClients.Range(func(k, v interface{}) bool {
-			clientUserID, ok := k.(uint)
-			if !ok {
-				return true // Продолжаем перебор
-			}
-
-			if clientUserID == user.ID {
-				conn, ok := v.(*websocket.Conn)
-				if ok {
-					// Отправляем уведомление о новом сообщении
-					s.sendWSResponse(conn, "new_message", message)
-				}
-			}
-			return true
-		})
+		s.sendToUser(user.ID, wsResponse{Type: WSTypeMessage, Payload: message})
 	}
 }
 
-// Сериализация WebSocket сообщения в JSON
-func serializeWSMessage(message interface{}) ([]byte, error) {
-	return json.Marshal(message)
-}
-
-// Отправка ошибки через WebSocket
-func (s *Server) sendWSError(conn *websocket.Conn, code string, message string) error {
-	response := map[string]interface{}{
-		"type": "error",
-		"payload": map[string]string{
-			"code":    code,
-			"message": message,
-		},
-	}
+// handleMarkMessagesAsRead отмечает все сообщения чата от других участников как прочитанные
+func (s *Server) handleMarkMessagesAsRead(c *gin.Context) {
+	userID := c.GetUint("userID")
 
-	data, err := json.Marshal(response)
+	chatID, err := strconv.ParseUint(c.Param("chatID"), 10, 32)
 	if err != nil {
-		return err
+		SendBadRequest(c, "Некорректный ID чата")
+		return
 	}
 
-	return conn.WriteMessage(websocket.TextMessage, data)
-}
-
-// Отправка ответа через WebSocket
-func (s *Server) sendWSResponse(conn *websocket.Conn, msgType string, payload interface{}) error {
-	response := map[string]interface{}{
-		"type":    msgType,
-		"payload": payload,
+	if !s.db.IsUserInChat(userID, uint(chatID)) {
+		SendForbidden(c, "Доступ к чату запрещен")
+		return
 	}
 
-	data, err := json.Marshal(response)
+	// Обновляем сообщения в БД
+	marked, err := s.db.MarkChatMessagesAsRead(uint(chatID), userID)
 	if err != nil {
-		return err
+		logger.Errorf("Ошибка отметки сообщений чата %d как прочитанных: %v", chatID, err)
+		SendInternalError(c, "Ошибка обновления статуса сообщений")
+		return
+	}
+
+	// Уведомляем участников чата о прочтении
+	if marked > 0 {
+		receipt := ReadReceiptPayload{
+			ChatID:    uint(chatID),
+			UserID:    userID,
+			Timestamp: time.Now(),
+		}
+		users, err := s.db.GetChatUsers(uint(chatID))
+		if err != nil {
+			logger.Errorf("Ошибка получения участников чата: %v", err)
+		}
+		peers := s.blockPeerSet(userID)
+		for _, user := range users {
+			if peers[user.ID] {
+				continue
+			}
+			s.sendToUser(user.ID, wsResponse{Type: WSTypeRead, Payload: receipt})
+		}
 	}
 
-	return conn.WriteMessage(websocket.TextMessage, data)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "marked": marked})
 }
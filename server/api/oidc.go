@@ -0,0 +1,422 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+
+	"messenger/auth"
+	"messenger/logger"
+	"messenger/models"
+	"messenger/utils/crypto"
+)
+
+const (
+	oidcFlowCookie = "oidc_flow"      // Зашифрованное состояние входа между редиректами
+	oidcFlowTTL    = 10 * time.Minute // Время на прохождение входа у провайдера
+)
+
+// Символы, допустимые в имени пользователя, созданного по данным IdP
+var oidcUsernameSanitizer = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// oidcClient - настроенный клиент провайдера. Создается при первом входе,
+// чтобы недоступность IdP не мешала запуску сервера
+type oidcClient struct {
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// oidcClientHolder лениво инициализирует клиент провайдера
+type oidcClientHolder struct {
+	mu     sync.Mutex
+	client *oidcClient
+}
+
+// oidcFlowState хранит state, nonce и PKCE verifier в зашифрованной куке
+type oidcFlowState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Expires  int64  `json:"expires"`
+}
+
+// oidcIdentity - данные пользователя из ID токена
+type oidcIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	Groups        []string
+}
+
+// oidc возвращает клиент провайдера, выполняя discovery при первом вызове
+func (s *Server) oidc(ctx context.Context) (*oidcClient, error) {
+	s.oidcHolder.mu.Lock()
+	defer s.oidcHolder.mu.Unlock()
+
+	if s.oidcHolder.client != nil {
+		return s.oidcHolder.client, nil
+	}
+
+	cfg := s.config.OIDC
+	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения конфигурации OIDC провайдера %s: %w", cfg.IssuerURL, err)
+	}
+
+	s.oidcHolder.client = &oidcClient{
+		oauth2: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       cfg.Scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}
+	logger.Infof("OIDC: Провайдер %s настроен", cfg.IssuerURL)
+	return s.oidcHolder.client, nil
+}
+
+// handleOIDCLogin перенаправляет пользователя к провайдеру (authorization code + PKCE)
+func (s *Server) handleOIDCLogin(c *gin.Context) {
+	if !s.config.OIDC.Enabled {
+		SendNotFound(c, "Вход через SSO не настроен")
+		return
+	}
+
+	client, err := s.oidc(c.Request.Context())
+	if err != nil {
+		logger.Errorf("OIDC: %v", err)
+		SendError(c, http.StatusBadGateway, ErrCodeInternalError, "Провайдер SSO недоступен")
+		return
+	}
+
+	flow := oidcFlowState{
+		State:    randomHex(16),
+		Nonce:    randomHex(16),
+		Verifier: oauth2.GenerateVerifier(),
+		Expires:  time.Now().Add(oidcFlowTTL).Unix(),
+	}
+	if err := s.setOIDCFlowCookie(c, flow); err != nil {
+		logger.Errorf("OIDC: Ошибка сохранения состояния входа: %v", err)
+		SendInternalError(c, "Ошибка начала входа через SSO")
+		return
+	}
+
+	authURL := client.oauth2.AuthCodeURL(flow.State, oidc.Nonce(flow.Nonce), oauth2.S256ChallengeOption(flow.Verifier))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// handleOIDCCallback обменивает код на токены провайдера, связывает или создает пользователя
+// и выдает обычную сессию. Локальная 2FA не запрашивается: второй фактор - забота IdP
+func (s *Server) handleOIDCCallback(c *gin.Context) {
+	if !s.config.OIDC.Enabled {
+		SendNotFound(c, "Вход через SSO не настроен")
+		return
+	}
+
+	flow, err := s.readOIDCFlowCookie(c)
+	if err != nil {
+		logger.Warnf("OIDC: %v", err)
+		s.oidcFail(c, ErrBadRequest("Сеанс входа через SSO истек, начните заново"))
+		return
+	}
+
+	if errCode := c.Query("error"); errCode != "" {
+		logger.Warnf("OIDC: Провайдер вернул ошибку %s: %s", errCode, c.Query("error_description"))
+		s.oidcFail(c, ErrUnauthorized("Вход через SSO отклонен провайдером"))
+		return
+	}
+	if c.Query("state") != flow.State {
+		s.oidcFail(c, ErrBadRequest("Некорректный параметр state"))
+		return
+	}
+
+	identity, err := s.exchangeOIDCCode(c.Request.Context(), c.Query("code"), flow)
+	if err != nil {
+		logger.Warnf("OIDC: %v", err)
+		s.oidcFail(c, ErrUnauthorized("Не удалось подтвердить вход через SSO"))
+		return
+	}
+
+	user, err := s.resolveOIDCUser(identity)
+	if err != nil {
+		s.oidcFail(c, err)
+		return
+	}
+	if user.Blocked {
+		s.oidcFail(c, ErrUserBlocked())
+		return
+	}
+
+	response, err := s.issueSession(c, user)
+	if err != nil {
+		s.oidcFail(c, err)
+		return
+	}
+	logger.Infof("OIDC: Успешный вход пользователя %s (ID %d)", user.Username, user.ID)
+
+	frontend := s.config.OIDC.FrontendRedirectURL
+	if frontend == "" {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// Токены передаются во фрагменте: он не уходит на сервер и не попадает в логи прокси
+	fragment := url.Values{}
+	fragment.Set("token", response.Token)
+	fragment.Set("refresh_token", response.RefreshToken)
+	fragment.Set("expires_in", strconv.FormatInt(response.ExpiresIn, 10))
+	c.Redirect(http.StatusFound, frontend+"#"+fragment.Encode())
+}
+
+// exchangeOIDCCode получает и проверяет ID токен провайдера
+func (s *Server) exchangeOIDCCode(ctx context.Context, code string, flow *oidcFlowState) (*oidcIdentity, error) {
+	if code == "" {
+		return nil, errors.New("в ответе провайдера нет кода авторизации")
+	}
+
+	client, err := s.oidc(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := client.oauth2.Exchange(ctx, code, oauth2.VerifierOption(flow.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка обмена кода авторизации: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("в ответе провайдера нет id_token")
+	}
+
+	idToken, err := client.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("недействительный id_token: %w", err)
+	}
+	if idToken.Nonce != flow.Nonce {
+		return nil, errors.New("nonce id_token не совпадает")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("ошибка разбора claims id_token: %w", err)
+	}
+
+	identity := &oidcIdentity{Subject: idToken.Subject}
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	identity.Username, _ = claims[s.config.OIDC.UsernameClaim].(string)
+	identity.Groups = claimStrings(claims[s.config.OIDC.GroupsClaim])
+	return identity, nil
+}
+
+// resolveOIDCUser находит пользователя по subject, связывает по подтвержденному email
+// или создает нового, затем применяет роль по группам IdP
+func (s *Server) resolveOIDCUser(identity *oidcIdentity) (*models.User, error) {
+	user, err := s.db.GetUserByOIDCSubject(identity.Subject)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Errorf("OIDC: Ошибка поиска пользователя по subject: %v", err)
+		return nil, ErrInternal("Ошибка базы данных")
+	}
+
+	// Связывание существующей учетной записи только по email, подтвержденному провайдером
+	if user == nil && identity.Email != "" && identity.EmailVerified {
+		existing, err := s.db.GetUserByEmail(identity.Email)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Errorf("OIDC: Ошибка поиска пользователя по email: %v", err)
+			return nil, ErrInternal("Ошибка базы данных")
+		}
+		if existing != nil {
+			if existing.OIDCSubject != "" {
+				logger.Warnf("OIDC: Пользователь %d уже связан с другим subject", existing.ID)
+				return nil, ErrForbidden("Учетная запись уже связана с другим пользователем SSO")
+			}
+			existing.OIDCSubject = identity.Subject
+			if err := s.db.DB.Model(existing).Update("oidc_subject", identity.Subject).Error; err != nil {
+				logger.Errorf("OIDC: Ошибка связывания пользователя %d: %v", existing.ID, err)
+				return nil, ErrInternal("Ошибка связывания учетной записи")
+			}
+			logger.Infof("OIDC: Пользователь %s (ID %d) связан с SSO", existing.Username, existing.ID)
+			user = existing
+		}
+	}
+
+	if user == nil {
+		if !s.config.OIDC.AutoProvision {
+			return nil, ErrForbidden("Учетная запись не найдена, обратитесь к администратору")
+		}
+		if user, err = s.provisionOIDCUser(identity); err != nil {
+			return nil, err
+		}
+	}
+
+	// Роль определяется группами IdP, если задано соответствие
+	if len(s.config.OIDC.RoleMapping) > 0 {
+		if err := s.applyExternalRole(user, s.mapOIDCRole(identity.Groups)); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// provisionOIDCUser создает пользователя при первом входе через SSO
+func (s *Server) provisionOIDCUser(identity *oidcIdentity) (*models.User, error) {
+	username, err := s.uniqueUsername(identity)
+	if err != nil {
+		logger.Errorf("OIDC: Ошибка подбора имени пользователя: %v", err)
+		return nil, ErrInternal("Ошибка создания пользователя")
+	}
+
+	user := models.User{
+		Username:    username,
+		Password:    randomHex(32), // Локальный вход невозможен, пока пароль не задан явно
+		Role:        s.mapOIDCRole(identity.Groups),
+		OIDCSubject: identity.Subject,
+	}
+	if identity.EmailVerified {
+		user.Email = identity.Email
+	}
+
+	if err := s.db.DB.Create(&user).Error; err != nil {
+		logger.Errorf("OIDC: Ошибка создания пользователя %s: %v", username, err)
+		return nil, ErrInternal("Ошибка создания пользователя")
+	}
+
+	logger.Infof("OIDC: Создан пользователь %s (ID %d, роль %s)", user.Username, user.ID, user.Role)
+	return &user, nil
+}
+
+// uniqueUsername подбирает свободное имя пользователя на основе данных IdP
+func (s *Server) uniqueUsername(identity *oidcIdentity) (string, error) {
+	base := identity.Username
+	if base == "" && identity.Email != "" {
+		base = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+	base = oidcUsernameSanitizer.ReplaceAllString(base, "")
+	if len(base) < 3 {
+		base = "user-" + identity.Subject
+		base = oidcUsernameSanitizer.ReplaceAllString(base, "")
+	}
+	if len(base) > 26 {
+		base = base[:26]
+	}
+
+	candidate := base
+	for i := 2; i < 100; i++ {
+		exists, err := s.db.UsernameExists(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+		candidate = base + "-" + strconv.Itoa(i)
+	}
+	return base + "-" + randomHex(3), nil
+}
+
+// mapOIDCRole выбирает роль по группам: admin имеет приоритет, иначе первая найденная
+func (s *Server) mapOIDCRole(groups []string) string {
+	return auth.MapRole(groups, s.config.OIDC.RoleMapping, s.config.OIDC.DefaultRole)
+}
+
+// oidcFail сообщает об ошибке входа: редиректом на фронтенд или JSON
+func (s *Server) oidcFail(c *gin.Context, err error) {
+	frontend := s.config.OIDC.FrontendRedirectURL
+	if frontend == "" {
+		SendAPIError(c, err)
+		return
+	}
+
+	apiErr := toAPIError(err)
+	fragment := url.Values{}
+	fragment.Set("error", apiErr.Code)
+	fragment.Set("error_description", apiErr.Message)
+	c.Redirect(http.StatusFound, frontend+"#"+fragment.Encode())
+}
+
+// setOIDCFlowCookie сохраняет состояние входа в зашифрованной куке
+func (s *Server) setOIDCFlowCookie(c *gin.Context, flow oidcFlowState) error {
+	data, err := json.Marshal(flow)
+	if err != nil {
+		return err
+	}
+	encrypted, err := crypto.EncryptString(string(data))
+	if err != nil {
+		return err
+	}
+
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode) // Кука должна прийти с редиректом от провайдера
+	c.SetCookie(oidcFlowCookie, encrypted, int(oidcFlowTTL.Seconds()), "/api/auth/oidc", "", secure, true)
+	return nil
+}
+
+// readOIDCFlowCookie читает и удаляет куку состояния входа
+func (s *Server) readOIDCFlowCookie(c *gin.Context) (*oidcFlowState, error) {
+	encrypted, err := c.Cookie(oidcFlowCookie)
+	if err != nil {
+		return nil, errors.New("кука состояния входа не найдена")
+	}
+	c.SetCookie(oidcFlowCookie, "", -1, "/api/auth/oidc", "", false, true)
+
+	data, err := crypto.DecryptString(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка расшифровки состояния входа: %w", err)
+	}
+
+	var flow oidcFlowState
+	if err := json.Unmarshal([]byte(data), &flow); err != nil {
+		return nil, fmt.Errorf("ошибка разбора состояния входа: %w", err)
+	}
+	if time.Now().Unix() > flow.Expires {
+		return nil, errors.New("состояние входа истекло")
+	}
+	return &flow, nil
+}
+
+// claimStrings приводит claim со списком групп к []string (провайдеры отдают массив или строку)
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				result = append(result, str)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// randomHex возвращает n случайных байт в hex
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		// crypto/rand не возвращает ошибок на поддерживаемых платформах
+		panic(fmt.Sprintf("ошибка генератора случайных чисел: %v", err))
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,437 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"messenger/config"
+	"messenger/middleware"
+	"messenger/models"
+)
+
+const (
+	testOIDCClientID = "messenger"
+	testOIDCKeyID    = "test-key"
+)
+
+// mockIdP - OIDC провайдер на httptest: discovery, JWKS и token endpoint.
+// Страницу входа заменяет authorize: тест сам выдает код для параметров из редиректа
+type mockIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockAuthCode
+}
+
+// mockAuthCode - выданный код авторизации и все, что нужно для ответа на его обмен
+type mockAuthCode struct {
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key, codes: make(map[string]mockAuthCode)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.handleDiscovery)
+	mux.HandleFunc("/jwks", idp.handleJWKS)
+	mux.HandleFunc("/token", idp.handleToken)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *mockIdP) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                idp.URL,
+		"authorization_endpoint":                idp.URL + "/authorize",
+		"token_endpoint":                        idp.URL + "/token",
+		"jwks_uri":                              idp.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (idp *mockIdP) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	pub := idp.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": testOIDCKeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// handleToken обменивает код на id_token, проверяя PKCE verifier по challenge из запроса авторизации
+func (idp *mockIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	idp.mu.Lock()
+	code, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   idp.URL,
+		"aud":   testOIDCClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": code.nonce,
+	}
+	for name, value := range code.claims {
+		claims[name] = value
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = testOIDCKeyID
+	signed, err := idToken.SignedString(idp.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "idp-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+// authorize выдает код для параметров редиректа на страницу входа провайдера
+func (idp *mockIdP) authorize(t *testing.T, authURL string, claims jwt.MapClaims) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, idp.URL+"/authorize") {
+		t.Fatalf("редирект на %s, ожидался провайдер", authURL)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("в запросе авторизации нет PKCE: %s", authURL)
+	}
+
+	code := randomHex(8)
+	idp.mu.Lock()
+	idp.codes[code] = mockAuthCode{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"), claims: claims}
+	idp.mu.Unlock()
+	return code
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// newOIDCTestServer настраивает сервер на вход через mockIdP
+func newOIDCTestServer(t *testing.T, idp *mockIdP, configure func(cfg *config.Config)) *Server {
+	t.Helper()
+	cfg := newTestConfig()
+	cfg.OIDC.Enabled = true
+	cfg.OIDC.IssuerURL = idp.URL
+	cfg.OIDC.ClientID = testOIDCClientID
+	cfg.OIDC.ClientSecret = "secret"
+	cfg.OIDC.RedirectURL = "http://messenger.test/api/auth/oidc/callback"
+	cfg.OIDC.Scopes = []string{"openid", "profile", "email"}
+	cfg.OIDC.UsernameClaim = "preferred_username"
+	cfg.OIDC.GroupsClaim = "groups"
+	cfg.OIDC.DefaultRole = "user"
+	cfg.OIDC.AutoProvision = true
+	if configure != nil {
+		configure(cfg)
+	}
+
+	s := newTestServer(t, cfg)
+	s.router.GET("/api/auth/oidc/login", s.handleOIDCLogin)
+	s.router.GET("/api/auth/oidc/callback", s.handleOIDCCallback)
+	return s
+}
+
+// oidcFlow - начатый вход: редирект к провайдеру и кука состояния
+type oidcFlow struct {
+	authURL string
+	cookie  *http.Cookie
+}
+
+func startOIDCLogin(t *testing.T, s *Server) oidcFlow {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("начало входа: статус %d: %s", rec.Code, rec.Body)
+	}
+
+	flow := oidcFlow{authURL: rec.Header().Get("Location")}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcFlowCookie {
+			flow.cookie = cookie
+		}
+	}
+	if flow.cookie == nil {
+		t.Fatal("кука состояния входа не установлена")
+	}
+	return flow
+}
+
+func (f oidcFlow) state(t *testing.T) string {
+	t.Helper()
+	u, err := url.Parse(f.authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query().Get("state")
+}
+
+// finishOIDCLogin возвращается от провайдера с кодом и state
+func finishOIDCLogin(s *Server, cookie *http.Cookie, code, state string) *httptest.ResponseRecorder {
+	query := url.Values{"code": {code}, "state": {state}}
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+query.Encode(), nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// oidcLogin проходит вход целиком и возвращает ответ сервера с сессией
+func oidcLogin(t *testing.T, s *Server, idp *mockIdP, claims jwt.MapClaims) LoginResponse {
+	t.Helper()
+	flow := startOIDCLogin(t, s)
+	code := idp.authorize(t, flow.authURL, claims)
+
+	rec := finishOIDCLogin(s, flow.cookie, code, flow.state(t))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("вход через SSO: статус %d: %s", rec.Code, rec.Body)
+	}
+	var response LoginResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("разбор ответа: %v", err)
+	}
+	return response
+}
+
+func countUsers(t *testing.T, s *Server) int64 {
+	t.Helper()
+	var count int64
+	if err := s.db.DB.Model(&models.User{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestOIDCProvisionsUserAndIssuesSession(t *testing.T) {
+	idp := newMockIdP(t)
+	s := newOIDCTestServer(t, idp, nil)
+
+	response := oidcLogin(t, s, idp, jwt.MapClaims{
+		"sub":                "idp-subject-1",
+		"email":              "alice@example.com",
+		"email_verified":     true,
+		"preferred_username": "Alice Smith",
+	})
+
+	// Обычный access токен сервера, привязанный к новой сессии
+	claims, err := middleware.ValidateToken(response.Token, s.config.JWT.Secret)
+	if err != nil {
+		t.Fatalf("выдан недействительный токен: %v", err)
+	}
+	if err := s.authorizeClaims(claims); err != nil {
+		t.Fatalf("сессия токена не принимается: %v", err)
+	}
+	if response.RefreshToken == "" || response.ExpiresIn != int64(s.accessTokenTTL().Seconds()) {
+		t.Errorf("неполный ответ: refresh_token %q, expires_in %d", response.RefreshToken, response.ExpiresIn)
+	}
+	session, err := s.db.GetSessionByTokenHash(hashRefreshToken(response.RefreshToken))
+	if err != nil || session.ID != claims.SessionID {
+		t.Fatalf("refresh токен не соответствует сессии %d: %v", claims.SessionID, err)
+	}
+
+	user, err := s.db.GetUserByOIDCSubject("idp-subject-1")
+	if err != nil {
+		t.Fatalf("пользователь не создан: %v", err)
+	}
+	if user.ID != claims.UserID || user.Username != "AliceSmith" || user.Email != "alice@example.com" || user.Role != "user" {
+		t.Errorf("создан пользователь %+v", user)
+	}
+
+	// Повторный вход находит того же пользователя по subject
+	oidcLogin(t, s, idp, jwt.MapClaims{"sub": "idp-subject-1", "preferred_username": "alice"})
+	if got := countUsers(t, s); got != 1 {
+		t.Errorf("после повторного входа пользователей %d, ожидался 1", got)
+	}
+}
+
+func TestOIDCProvisioningDisabled(t *testing.T) {
+	idp := newMockIdP(t)
+	s := newOIDCTestServer(t, idp, func(cfg *config.Config) { cfg.OIDC.AutoProvision = false })
+
+	flow := startOIDCLogin(t, s)
+	code := idp.authorize(t, flow.authURL, jwt.MapClaims{"sub": "stranger"})
+	rec := finishOIDCLogin(s, flow.cookie, code, flow.state(t))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("статус %d, ожидался 403: %s", rec.Code, rec.Body)
+	}
+	if got := countUsers(t, s); got != 0 {
+		t.Errorf("создано пользователей: %d", got)
+	}
+}
+
+func TestOIDCLinksExistingUserByVerifiedEmail(t *testing.T) {
+	idp := newMockIdP(t)
+	s := newOIDCTestServer(t, idp, nil)
+
+	existing := createTestUser(t, s, "alice", "user")
+	if err := s.db.DB.Model(existing).Update("email", "alice@example.com").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Неподтвержденный провайдером email не дает связать учетную запись
+	oidcLogin(t, s, idp, jwt.MapClaims{
+		"sub":            "unverified-subject",
+		"email":          "alice@example.com",
+		"email_verified": false,
+	})
+	if linked, _ := s.db.GetUserByOIDCSubject("unverified-subject"); linked == nil || linked.ID == existing.ID {
+		t.Fatal("учетная запись связана по неподтвержденному email")
+	}
+
+	response := oidcLogin(t, s, idp, jwt.MapClaims{
+		"sub":            "idp-subject-1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+	})
+	if response.User.ID != existing.ID {
+		t.Fatalf("вход выполнен пользователем %d, ожидался %d", response.User.ID, existing.ID)
+	}
+	linked, err := s.db.GetUserByOIDCSubject("idp-subject-1")
+	if err != nil || linked.ID != existing.ID {
+		t.Fatalf("subject не связан с пользователем %d: %v", existing.ID, err)
+	}
+
+	// Связанную запись нельзя перехватить другим subject с тем же email
+	flow := startOIDCLogin(t, s)
+	code := idp.authorize(t, flow.authURL, jwt.MapClaims{
+		"sub":            "idp-subject-2",
+		"email":          "alice@example.com",
+		"email_verified": true,
+	})
+	if rec := finishOIDCLogin(s, flow.cookie, code, flow.state(t)); rec.Code != http.StatusForbidden {
+		t.Errorf("вход другим subject: статус %d, ожидался 403", rec.Code)
+	}
+}
+
+func TestOIDCGroupRoleMapping(t *testing.T) {
+	idp := newMockIdP(t)
+	s := newOIDCTestServer(t, idp, func(cfg *config.Config) {
+		cfg.OIDC.RoleMapping = map[string]string{
+			"messenger-admins":     "admin",
+			"messenger-moderators": "moderator",
+		}
+	})
+
+	tests := []struct {
+		name   string
+		groups interface{}
+		want   string
+	}{
+		{name: "группа модераторов", groups: []string{"staff", "messenger-moderators"}, want: "moderator"},
+		{name: "admin важнее", groups: []string{"messenger-moderators", "Messenger-Admins"}, want: "admin"},
+		{name: "группа строкой", groups: "messenger-moderators", want: "moderator"},
+		{name: "группы отозваны", groups: []string{"staff"}, want: "user"},
+		{name: "без групп", groups: nil, want: "user"},
+	}
+
+	// Один и тот же пользователь: роль пересчитывается при каждом входе
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := jwt.MapClaims{"sub": "idp-subject-1", "preferred_username": "alice"}
+			if tt.groups != nil {
+				claims["groups"] = tt.groups
+			}
+			response := oidcLogin(t, s, idp, claims)
+
+			if response.User.Role != tt.want {
+				t.Errorf("роль в ответе %q, ожидалась %q", response.User.Role, tt.want)
+			}
+			user, err := s.db.GetUserByID(response.User.ID)
+			if err != nil || user.Role != tt.want {
+				t.Errorf("роль в БД %q, ожидалась %q (%v)", user.Role, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestOIDCStateMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+	s := newOIDCTestServer(t, idp, nil)
+
+	flow := startOIDCLogin(t, s)
+	code := idp.authorize(t, flow.authURL, jwt.MapClaims{"sub": "idp-subject-1"})
+
+	rec := finishOIDCLogin(s, flow.cookie, code, "forged-state")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("статус %d, ожидался 400: %s", rec.Code, rec.Body)
+	}
+	if got := countUsers(t, s); got != 0 {
+		t.Errorf("создано пользователей: %d", got)
+	}
+}
+
+func TestOIDCPKCEVerifierMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+	s := newOIDCTestServer(t, idp, nil)
+
+	// Код выдан для challenge чужого входа: verifier из куки этого входа не подходит
+	victim := startOIDCLogin(t, s)
+	attacker := startOIDCLogin(t, s)
+	code := idp.authorize(t, attacker.authURL, jwt.MapClaims{"sub": "idp-subject-1"})
+
+	rec := finishOIDCLogin(s, victim.cookie, code, victim.state(t))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("статус %d, ожидался 401: %s", rec.Code, rec.Body)
+	}
+	if got := countUsers(t, s); got != 0 {
+		t.Errorf("создано пользователей: %d", got)
+	}
+}
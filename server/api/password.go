@@ -0,0 +1,274 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"messenger/auth"
+	"messenger/config"
+	"messenger/logger"
+	"messenger/middleware"
+	"messenger/models"
+)
+
+const (
+	passwordResetPendingTTL = 10 * time.Minute // Срок жизни токена обязательной смены пароля
+	passwordAttemptsRate    = 5.0 / 60         // Попыток ввода текущего пароля в секунду на пользователя
+	passwordAttemptsBurst   = 5
+)
+
+// ChangePasswordRequest - смена пароля пользователем
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// PasswordResetRequest - новый пароль при обязательной смене во время входа
+type PasswordResetRequest struct {
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// PasswordResetChallengeResponse - ответ на вход, когда администратор потребовал сменить пароль
+type PasswordResetChallengeResponse struct {
+	PasswordResetRequired bool   `json:"password_reset_required"`
+	PendingToken          string `json:"pending_token"`
+	ExpiresIn             int64  `json:"expires_in"`
+}
+
+// validatePassword проверяет пароль по политике из конфигурации
+func (s *Server) validatePassword(password, username string) error {
+	violations := s.passwordPolicy.Validate(password, username)
+	if len(violations) == 0 {
+		return nil
+	}
+	return NewAPIError(http.StatusBadRequest, ErrCodeWeakPassword, "Пароль не соответствует требованиям",
+		map[string][]string{"violations": violations})
+}
+
+// newPasswordPolicy создает политику паролей; без списка утекших паролей сервер продолжает работу
+func newPasswordPolicy(cfg *config.Config) *auth.PasswordPolicy {
+	policy, err := auth.NewPasswordPolicy(cfg.PasswordPolicy)
+	if err != nil {
+		logger.Warnf("Политика паролей: %v. Проверка по списку утекших паролей отключена", err)
+	} else if policy.BreachedCount() > 0 {
+		logger.Infof("Политика паролей: Загружено утекших паролей: %d", policy.BreachedCount())
+	}
+	return policy
+}
+
+// passwordResetChallenge выдает промежуточный токен для обязательной смены пароля
+func (s *Server) passwordResetChallenge(user *models.User) (*PasswordResetChallengeResponse, error) {
+	token, err := middleware.GeneratePendingToken(user.ID, user.Username, middleware.PurposePasswordReset, s.config.JWT.Secret, passwordResetPendingTTL)
+	if err != nil {
+		logger.Errorf("Ошибка генерации токена смены пароля для пользователя %d: %v", user.ID, err)
+		return nil, ErrInternal("Ошибка генерации токена")
+	}
+
+	return &PasswordResetChallengeResponse{
+		PasswordResetRequired: true,
+		PendingToken:          token,
+		ExpiresIn:             int64(passwordResetPendingTTL.Seconds()),
+	}, nil
+}
+
+// completeLogin завершает вход после проверки пароля: запрашивает второй фактор или выдает сессию
+func (s *Server) completeLogin(c *gin.Context, user *models.User) {
+	challenge, err := s.twoFactorChallenge(user)
+	if err != nil {
+		SendAPIError(c, err)
+		return
+	}
+	if challenge != nil {
+		logger.Infof("Для пользователя %s требуется второй шаг входа", user.Username)
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	response, err := s.issueSession(c, user)
+	if err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	logger.Infof("Успешный вход пользователя %s", user.Username)
+	c.JSON(http.StatusOK, response)
+}
+
+// handleChangePassword меняет пароль текущего пользователя и завершает остальные его сессии
+func (s *Server) handleChangePassword(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendBadRequest(c, "Неверный формат запроса", err.Error())
+		return
+	}
+
+	user, err := s.db.GetUserByID(userID)
+	if err != nil {
+		SendNotFound(c, "Пользователь не найден")
+		return
+	}
+	if user.AuthSource != "" && user.AuthSource != auth.SourceLocal {
+		SendForbidden(c, "Пароль учетной записи управляется внешним каталогом")
+		return
+	}
+
+	if allowed, retryAfter := s.passwordLimiter.Allow(strconv.FormatUint(uint64(userID), 10)); !allowed {
+		SendAPIError(c, ErrRateLimited(retryAfter))
+		return
+	}
+	if !user.CheckPassword(req.CurrentPassword) {
+		logger.Warnf("Смена пароля: Неверный текущий пароль пользователя %d", userID)
+		SendForbidden(c, "Неверный текущий пароль")
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		SendBadRequest(c, "Новый пароль должен отличаться от текущего")
+		return
+	}
+
+	if err := s.setPassword(user, req.NewPassword); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	// Остальные сессии завершаются: пароль мог быть скомпрометирован
+	revoked, err := s.db.RevokeUserSessions(userID, c.GetUint("sessionID"))
+	if err != nil {
+		logger.Errorf("Смена пароля: Ошибка отзыва сессий пользователя %d: %v", userID, err)
+	}
+	s.revokeSessions(revoked...)
+
+	logger.Infof("Смена пароля: Пользователь %d сменил пароль, завершено сессий: %d", userID, len(revoked))
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Пароль изменен",
+		"revoked_sessions": len(revoked),
+	})
+}
+
+// handlePasswordReset задает новый пароль по промежуточному токену обязательной смены
+// и продолжает вход (второй фактор или выдача сессии)
+func (s *Server) handlePasswordReset(c *gin.Context) {
+	var req PasswordResetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendBadRequest(c, "Неверный формат запроса", err.Error())
+		return
+	}
+
+	user, err := s.db.GetUserByID(c.GetUint("userID"))
+	if err != nil {
+		SendUnauthorized(c, "Пользователь не найден")
+		return
+	}
+	if user.Blocked {
+		SendAPIError(c, ErrUserBlocked())
+		return
+	}
+	if !user.PasswordResetRequired {
+		SendBadRequest(c, "Смена пароля не требуется")
+		return
+	}
+	if user.CheckPassword(req.NewPassword) {
+		SendBadRequest(c, "Новый пароль должен отличаться от текущего")
+		return
+	}
+
+	if err := s.setPassword(user, req.NewPassword); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+	logger.Infof("Смена пароля: Пользователь %d сменил пароль по требованию администратора", user.ID)
+
+	s.completeLogin(c, user)
+}
+
+// setPassword проверяет пароль по политике, сохраняет его хеш и снимает требование смены
+func (s *Server) setPassword(user *models.User, password string) error {
+	if err := s.validatePassword(password, user.Username); err != nil {
+		return err
+	}
+
+	user.Password = password
+	if err := user.HashPassword(); err != nil {
+		logger.Errorf("Ошибка хеширования пароля пользователя %d: %v", user.ID, err)
+		return ErrInternal("Ошибка шифрования пароля")
+	}
+	if err := s.db.UpdatePassword(user.ID, user.Password); err != nil {
+		logger.Errorf("Ошибка сохранения пароля пользователя %d: %v", user.ID, err)
+		return ErrInternal("Ошибка сохранения пароля")
+	}
+	user.PasswordResetRequired = false
+	return nil
+}
+
+// handleAdminRequirePasswordReset требует от пользователя сменить пароль при следующем входе.
+// Текущие сессии пользователя завершаются
+func (s *Server) handleAdminRequirePasswordReset(c *gin.Context) {
+	s.setPasswordResetRequired(c, true)
+}
+
+// handleAdminCancelPasswordReset снимает требование смены пароля
+func (s *Server) handleAdminCancelPasswordReset(c *gin.Context) {
+	s.setPasswordResetRequired(c, false)
+}
+
+// setPasswordResetRequired устанавливает или снимает требование смены пароля для пользователя из URL
+func (s *Server) setPasswordResetRequired(c *gin.Context, required bool) {
+	if c.GetString("role") != "admin" {
+		SendForbidden(c, "Требуются права администратора")
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		SendBadRequest(c, "Неверный ID пользователя")
+		return
+	}
+
+	user, err := s.db.GetUserByID(uint(userID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			SendNotFound(c, "Пользователь не найден")
+		} else {
+			logger.Errorf("Смена пароля: Ошибка поиска пользователя %d: %v", userID, err)
+			SendInternalError(c, "Ошибка базы данных при поиске пользователя")
+		}
+		return
+	}
+	if required && user.AuthSource != "" && user.AuthSource != auth.SourceLocal {
+		SendBadRequest(c, "Пароль учетной записи управляется внешним каталогом")
+		return
+	}
+
+	if err := s.db.SetPasswordResetRequired(user.ID, required); err != nil {
+		logger.Errorf("Смена пароля: Ошибка обновления пользователя %d: %v", user.ID, err)
+		SendInternalError(c, "Ошибка базы данных при обновлении пользователя")
+		return
+	}
+
+	revokedCount := 0
+	if required {
+		revoked, err := s.db.RevokeUserSessions(user.ID, 0)
+		if err != nil {
+			logger.Errorf("Смена пароля: Ошибка отзыва сессий пользователя %d: %v", user.ID, err)
+		}
+		s.revokeSessions(revoked...)
+		revokedCount = len(revoked)
+	}
+
+	if required {
+		logger.Infof("Смена пароля: Администратор %d потребовал смену пароля пользователя %d", c.GetUint("userID"), user.ID)
+	} else {
+		logger.Infof("Смена пароля: Администратор %d отменил смену пароля пользователя %d", c.GetUint("userID"), user.ID)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"password_reset_required": required,
+		"revoked_sessions":        revokedCount,
+	})
+}
@@ -0,0 +1,457 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"messenger/auth"
+	"messenger/logger"
+	"messenger/media"
+	"messenger/models"
+	"messenger/storage"
+	"messenger/utils/encryption"
+)
+
+// Размеры миниатюр аватара (квадрат, сторона в пикселях). Последний - размер по умолчанию
+var avatarSizes = []int{64, 128, 256}
+
+// Токен аватара - hex из generateDownloadToken
+var avatarTokenPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Каталог миниатюр аватаров в хранилище файлов
+const avatarPrefix = "avatars/"
+
+// UpdateProfileRequest - изменение профиля; поля без значения не меняются
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name" binding:"omitempty,max=64"`
+	Email       *string `json:"email" binding:"omitempty,max=254"`
+	Bio         *string `json:"bio" binding:"omitempty,max=500"`
+	StatusText  *string `json:"status_text" binding:"omitempty,max=140"`
+	// На адрес приходят ссылки сброса пароля, поэтому смена email требует
+	// текущий пароль и второй фактор, если он включен
+	CurrentPassword string `json:"current_password"`
+	TwoFactorCodeRequest
+}
+
+// ProfileResponse - публичная часть профиля, которую видят собеседники
+type ProfileResponse struct {
+	ID          uint   `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
+	Avatar      string `json:"avatar,omitempty"`
+	Bio         string `json:"bio,omitempty"`
+	StatusText  string `json:"status_text,omitempty"`
+}
+
+func newProfileResponse(user *models.User) ProfileResponse {
+	return ProfileResponse{
+		ID:          user.ID,
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Avatar:      user.Avatar,
+		Bio:         user.Bio,
+		StatusText:  user.StatusText,
+	}
+}
+
+// handleGetMe возвращает профиль текущего пользователя
+func (s *Server) handleGetMe(c *gin.Context) {
+	user, err := s.db.GetUserByID(c.GetUint("userID"))
+	if err != nil {
+		SendNotFound(c, "Пользователь не найден")
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// handleUpdateMe изменяет отображаемое имя, email, описание и статус текущего пользователя
+func (s *Server) handleUpdateMe(c *gin.Context) {
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendBadRequest(c, "Неверный формат запроса", err.Error())
+		return
+	}
+
+	user, err := s.db.GetUserByID(c.GetUint("userID"))
+	if err != nil {
+		SendNotFound(c, "Пользователь не найден")
+		return
+	}
+
+	updates := make(map[string]interface{})
+	emailChanged := false
+	if req.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*req.DisplayName)
+		updates["display_name"] = user.DisplayName
+	}
+	if req.Bio != nil {
+		user.Bio = strings.TrimSpace(*req.Bio)
+		updates["bio"] = user.Bio
+	}
+	if req.StatusText != nil {
+		user.StatusText = strings.TrimSpace(*req.StatusText)
+		updates["status_text"] = user.StatusText
+	}
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if !strings.EqualFold(email, user.Email) {
+			if err := s.confirmEmailChange(user, req); err != nil {
+				SendAPIError(c, err)
+				return
+			}
+		}
+		if err := s.checkProfileEmail(user.ID, email); err != nil {
+			SendAPIError(c, err)
+			return
+		}
+		// Новый адрес нужно подтвердить заново
+		if !strings.EqualFold(email, user.Email) {
+			user.EmailVerified = false
+			updates["email_verified"] = false
+			emailChanged = email != ""
+		}
+		user.Email = email
+		if email == "" {
+			updates["email"] = nil // Уникальный индекс допускает несколько NULL, но не несколько пустых строк
+		} else {
+			updates["email"] = email
+		}
+	}
+
+	if len(updates) == 0 {
+		c.JSON(http.StatusOK, user)
+		return
+	}
+
+	if err := s.db.DB.Model(&models.User{}).Where("id = ?", user.ID).Updates(updates).Error; err != nil {
+		logger.Errorf("Профиль: Ошибка сохранения профиля пользователя %d: %v", user.ID, err)
+		SendInternalError(c, "Ошибка сохранения профиля")
+		return
+	}
+
+	logger.Infof("Профиль: Пользователь %d изменил профиль", user.ID)
+	if emailChanged {
+		if err := s.sendVerificationEmail(user); err != nil {
+			logger.Errorf("Профиль: Ошибка отправки письма подтверждения пользователю %d: %v", user.ID, err)
+		}
+	}
+	s.broadcastProfile(user)
+	c.JSON(http.StatusOK, user)
+}
+
+// confirmEmailChange проверяет текущий пароль и второй фактор перед сменой email.
+// Без этого украденной сессии хватило бы, чтобы подставить свой адрес и сбросить пароль
+func (s *Server) confirmEmailChange(user *models.User, req UpdateProfileRequest) error {
+	if user.AuthSource != "" && user.AuthSource != auth.SourceLocal {
+		return ErrForbidden("Email учетной записи управляется внешним каталогом")
+	}
+	if req.CurrentPassword == "" {
+		return ErrBadRequest("Для смены email требуется текущий пароль")
+	}
+
+	if allowed, retryAfter := s.passwordLimiter.Allow(strconv.FormatUint(uint64(user.ID), 10)); !allowed {
+		return ErrRateLimited(retryAfter)
+	}
+	if !user.CheckPassword(req.CurrentPassword) {
+		logger.Warnf("Профиль: Неверный текущий пароль при смене email пользователя %d", user.ID)
+		return ErrForbidden("Неверный текущий пароль")
+	}
+	if user.TOTPEnabled {
+		return s.verifySecondFactor(user, req.TwoFactorCodeRequest)
+	}
+	return nil
+}
+
+// checkProfileEmail проверяет формат email и что он не занят другим пользователем
+func (s *Server) checkProfileEmail(userID uint, email string) error {
+	if email == "" {
+		return nil
+	}
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return ErrBadRequest("Некорректный email")
+	}
+
+	existing, err := s.db.GetUserByEmail(email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Errorf("Профиль: Ошибка проверки email: %v", err)
+		return ErrInternal("Ошибка базы данных")
+	}
+	if existing != nil && existing.ID != userID {
+		return NewAPIError(http.StatusConflict, ErrCodeBadRequest, "Email уже используется")
+	}
+	return nil
+}
+
+// handleUploadAvatar принимает изображение, уменьшает его до квадратных миниатюр avatarSizes
+// и назначает аватаром текущего пользователя. Тип и размер файла проверяются так же, как
+// у файлов сообщений, миниатюры шифруются общим ключом аватара
+func (s *Server) handleUploadAvatar(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		SendBadRequest(c, "Не передан файл avatar")
+		return
+	}
+	if limit := s.maxFileSize(); header.Size > limit {
+		SendAPIError(c, fileTooLarge(limit))
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		SendInternalError(c, "Ошибка открытия файла")
+		return
+	}
+	defer file.Close()
+
+	// Тип определяется по содержимому, аватаром может быть только изображение, которое умеет обработать media
+	head, err := readSniffHead(file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		SendInternalError(c, "Ошибка чтения файла")
+		return
+	}
+	mimeType, err := s.checkFileType(header.Header.Get("Content-Type"), head)
+	if err != nil {
+		SendAPIError(c, err)
+		return
+	}
+	if !media.IsProcessableImage(mimeType) {
+		SendAPIError(c, unsupportedFileType(mimeType))
+		return
+	}
+
+	thumbnails, err := prepareAvatar(file, mimeType)
+	if err != nil {
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			logger.Errorf("Профиль: Ошибка обработки аватара пользователя %d: %v", userID, err)
+		}
+		SendAPIError(c, err)
+		return
+	}
+
+	token, err := generateDownloadToken()
+	if err != nil {
+		SendInternalError(c, "Ошибка генерации токена")
+		return
+	}
+	ctx := c.Request.Context()
+	wrappedKey, err := s.storeAvatarThumbnails(ctx, token, thumbnails)
+	if err != nil {
+		logger.Errorf("Профиль: Ошибка сохранения аватара пользователя %d: %v", userID, err)
+		SendInternalError(c, "Ошибка сохранения аватара")
+		return
+	}
+
+	user, err := s.db.GetUserByID(userID)
+	if err != nil {
+		s.removeAvatarObjects(context.Background(), token)
+		SendNotFound(c, "Пользователь не найден")
+		return
+	}
+	previous := user.Avatar
+
+	user.Avatar, user.AvatarKey = "/api/avatars/"+token, wrappedKey
+	err = s.db.DB.Model(&models.User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"avatar": user.Avatar, "avatar_key": user.AvatarKey}).Error
+	if err != nil {
+		s.removeAvatarObjects(context.Background(), token)
+		logger.Errorf("Профиль: Ошибка сохранения аватара пользователя %d: %v", userID, err)
+		SendInternalError(c, "Ошибка сохранения аватара")
+		return
+	}
+	s.removeAvatarObjects(ctx, avatarToken(previous))
+
+	logger.Infof("Профиль: Пользователь %d загрузил аватар (%s)", userID, mimeType)
+	s.broadcastProfile(user)
+	c.JSON(http.StatusOK, gin.H{
+		"avatar": user.Avatar,
+		"sizes":  avatarSizes,
+	})
+}
+
+// handleDeleteAvatar удаляет аватар текущего пользователя
+func (s *Server) handleDeleteAvatar(c *gin.Context) {
+	user, err := s.db.GetUserByID(c.GetUint("userID"))
+	if err != nil {
+		SendNotFound(c, "Пользователь не найден")
+		return
+	}
+	if user.Avatar == "" {
+		c.JSON(http.StatusOK, gin.H{"message": "Аватар не установлен"})
+		return
+	}
+
+	err = s.db.DB.Model(&models.User{}).Where("id = ?", user.ID).
+		Updates(map[string]interface{}{"avatar": "", "avatar_key": nil}).Error
+	if err != nil {
+		logger.Errorf("Профиль: Ошибка удаления аватара пользователя %d: %v", user.ID, err)
+		SendInternalError(c, "Ошибка удаления аватара")
+		return
+	}
+	s.removeAvatarObjects(c.Request.Context(), avatarToken(user.Avatar))
+	user.Avatar, user.AvatarKey = "", nil
+
+	s.broadcastProfile(user)
+	c.JSON(http.StatusOK, gin.H{"message": "Аватар удален"})
+}
+
+// handleGetAvatar отдает миниатюру аватара. Параметр size выбирает размер из avatarSizes.
+// Миниатюры расшифровываются на лету, аватары, загруженные до шифрования, отдаются как есть
+func (s *Server) handleGetAvatar(c *gin.Context) {
+	token := c.Param("token")
+	if !avatarTokenPattern.MatchString(token) {
+		SendNotFound(c, "Аватар не найден")
+		return
+	}
+
+	size := avatarSizes[len(avatarSizes)-1]
+	if raw := c.Query("size"); raw != "" {
+		requested, err := strconv.Atoi(raw)
+		if err != nil {
+			SendBadRequest(c, "Некорректный размер")
+			return
+		}
+		size = nearestAvatarSize(requested)
+	}
+
+	owner, err := s.db.GetUserByAvatar("/api/avatars/" + token)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Errorf("Профиль: Ошибка поиска владельца аватара %s: %v", token, err)
+		}
+		SendNotFound(c, "Аватар не найден")
+		return
+	}
+
+	ctx := c.Request.Context()
+	key := avatarKey(token, size)
+	info, err := s.storage.Stat(ctx, key)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			logger.Errorf("Профиль: Ошибка обращения к хранилищу для аватара %s: %v", token, err)
+		}
+		SendNotFound(c, "Аватар не найден")
+		return
+	}
+
+	object := storage.NewReaderAt(ctx, s.storage, key, info.Size)
+	defer object.Close()
+
+	var content io.ReadSeeker = io.NewSectionReader(object, 0, info.Size)
+	if len(owner.AvatarKey) > 0 {
+		content, err = openEncryptedFile(object, info.Size, owner.AvatarKey)
+		if err != nil {
+			logger.Errorf("Профиль: Ошибка расшифровки аватара %s: %v", token, err)
+			SendInternalError(c, "Ошибка чтения аватара")
+			return
+		}
+	}
+
+	// Токен меняется при каждой загрузке, поэтому файл можно кешировать надолго
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("Content-Type", "image/jpeg")
+	http.ServeContent(c.Writer, c.Request, path.Base(key), info.ModTime, content)
+}
+
+// broadcastProfile рассылает изменения профиля собеседникам по общим чатам и другим подключениям пользователя
+func (s *Server) broadcastProfile(user *models.User) {
+	partners, err := s.db.GetChatPartnerIDs(user.ID)
+	if err != nil {
+		logger.Errorf("Профиль: Ошибка получения собеседников пользователя %d: %v", user.ID, err)
+	}
+
+	frame := wsResponse{Type: WSTypeProfile, Payload: newProfileResponse(user)}
+	s.sendToUser(user.ID, frame)
+	peers := s.blockPeerSet(user.ID)
+	for _, partnerID := range partners {
+		if peers[partnerID] {
+			continue
+		}
+		s.sendToUser(partnerID, frame)
+	}
+}
+
+// storeAvatarThumbnails шифрует миниатюры аватара одним новым ключом и сохраняет их в хранилище.
+// Возвращает ключ, зашифрованный ключом сервера
+func (s *Server) storeAvatarThumbnails(ctx context.Context, token string, thumbnails []media.Thumbnail) ([]byte, error) {
+	fileKey, err := encryption.NewFileKey()
+	if err != nil {
+		return nil, err
+	}
+	wrappedKey, err := encryption.WrapKey(fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, thumb := range thumbnails {
+		err := s.putEncrypted(ctx, avatarKey(token, thumb.Width), bytes.NewReader(thumb.Data), int64(len(thumb.Data)), fileKey)
+		if err != nil {
+			s.removeAvatarObjects(context.Background(), token)
+			return nil, err
+		}
+	}
+	return wrappedKey, nil
+}
+
+// removeAvatarObjects удаляет миниатюры аватара из хранилища; пустой токен игнорируется
+func (s *Server) removeAvatarObjects(ctx context.Context, token string) {
+	if !avatarTokenPattern.MatchString(token) {
+		return
+	}
+	for _, size := range avatarSizes {
+		if err := s.storage.Delete(ctx, avatarKey(token, size)); err != nil {
+			logger.Warnf("Профиль: Ошибка удаления миниатюры аватара %s: %v", token, err)
+		}
+	}
+}
+
+// avatarToken извлекает токен из URL аватара
+func avatarToken(avatarURL string) string {
+	return strings.TrimPrefix(avatarURL, "/api/avatars/")
+}
+
+// avatarKey возвращает ключ миниатюры аватара в хранилище: avatars/<токен>_<размер>.jpg
+func avatarKey(token string, size int) string {
+	return fmt.Sprintf("%s%s_%d.jpg", avatarPrefix, token, size)
+}
+
+// AvatarStorageKeys возвращает ключи всех миниатюр аватара с URL avatarURL
+// (для переноса между хранилищами). Для пустого или чужого URL список пуст
+func AvatarStorageKeys(avatarURL string) []string {
+	token := avatarToken(avatarURL)
+	if !avatarTokenPattern.MatchString(token) {
+		return nil
+	}
+	keys := make([]string, 0, len(avatarSizes))
+	for _, size := range avatarSizes {
+		keys = append(keys, avatarKey(token, size))
+	}
+	return keys
+}
+
+// nearestAvatarSize возвращает наименьший размер не меньше запрошенного
+func nearestAvatarSize(requested int) int {
+	for _, size := range avatarSizes {
+		if size >= requested {
+			return size
+		}
+	}
+	return avatarSizes[len(avatarSizes)-1]
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp/totp"
+
+	"messenger/auth"
+	"messenger/models"
+	"messenger/utils/crypto"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// patchMe выполняет PATCH /api/me от имени user
+func patchMe(s *Server, user *models.User, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPatch, "/api/me", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Test-User", user.Username)
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func newProfileTestServer(t *testing.T) (*Server, *captureMailer) {
+	t.Helper()
+	s, m := newMailTestServer(t)
+	s.router.PATCH("/api/me", func(c *gin.Context) {
+		user, err := s.db.GetUserByUsername(c.GetHeader("X-Test-User"))
+		if err != nil {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Set("userID", user.ID)
+	}, s.handleUpdateMe)
+	return s, m
+}
+
+func enableTestTOTP(t *testing.T, s *Server, user *models.User) {
+	t.Helper()
+	secret, err := crypto.EncryptString(testTOTPSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.db.DB.Model(user).Updates(map[string]interface{}{"totp_secret": secret, "totp_enabled": true}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateEmailRequiresPassword(t *testing.T) {
+	s, m := newProfileTestServer(t)
+	user := createMailUser(t, s, "alice", "alice@example.com", true)
+
+	// Украденной сессии недостаточно, чтобы подставить свой адрес
+	for name, body := range map[string]gin.H{
+		"без пароля":      {"email": "mallory@example.com"},
+		"неверный пароль": {"email": "mallory@example.com", "current_password": "wrong"},
+	} {
+		if rec := patchMe(s, user, body); rec.Code != http.StatusBadRequest && rec.Code != http.StatusForbidden {
+			t.Errorf("%s: статус %d: %s", name, rec.Code, rec.Body)
+		}
+	}
+	if got := reloadUser(t, s, user.ID); got.Email != "alice@example.com" || !got.EmailVerified {
+		t.Fatalf("email изменен без пароля: %q, подтвержден %v", got.Email, got.EmailVerified)
+	}
+	m.expectNone(t)
+
+	// Остальные поля профиля и тот же адрес пароля не требуют
+	if rec := patchMe(s, user, gin.H{"display_name": "Алиса", "email": "Alice@example.com"}); rec.Code != http.StatusOK {
+		t.Errorf("изменение имени: статус %d: %s", rec.Code, rec.Body)
+	}
+
+	rec := patchMe(s, user, gin.H{"email": "alice@new.example.com", "current_password": "password"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("смена email с паролем: статус %d: %s", rec.Code, rec.Body)
+	}
+	if got := reloadUser(t, s, user.ID); got.Email != "alice@new.example.com" || got.EmailVerified {
+		t.Errorf("после смены email: %q, подтвержден %v", got.Email, got.EmailVerified)
+	}
+	m.next(t, "alice@new.example.com", "verify-email")
+}
+
+func TestUpdateEmailRequiresSecondFactor(t *testing.T) {
+	s, _ := newProfileTestServer(t)
+	user := createMailUser(t, s, "alice", "alice@example.com", true)
+	enableTestTOTP(t, s, user)
+
+	// Сброс пароля по ссылке второй фактор не спрашивает, поэтому его спрашивает смена адреса
+	for name, body := range map[string]gin.H{
+		"без кода":     {"email": "mallory@example.com", "current_password": "password"},
+		"неверный код": {"email": "mallory@example.com", "current_password": "password", "code": "000000"},
+	} {
+		if rec := patchMe(s, user, body); rec.Code == http.StatusOK {
+			t.Errorf("%s: email изменен", name)
+		}
+	}
+	if got := reloadUser(t, s, user.ID); got.Email != "alice@example.com" {
+		t.Fatalf("email изменен без второго фактора: %q", got.Email)
+	}
+
+	code, err := totp.GenerateCodeCustom(testTOTPSecret, time.Now(), totpOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := patchMe(s, user, gin.H{"email": "alice@new.example.com", "current_password": "password", "code": code})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("смена email с паролем и кодом: статус %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestUpdateEmailExternalAccount(t *testing.T) {
+	s, _ := newProfileTestServer(t)
+	user := createDirectoryUser(t, s, "alice", "user")
+
+	rec := patchMe(s, user, gin.H{"email": "mallory@example.com", "current_password": "password"})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("смена email учетной записи %s: статус %d, ожидался 403", auth.SourceLDAP, rec.Code)
+	}
+}
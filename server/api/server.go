@@ -2,39 +2,31 @@ package api
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/gorilla/websocket"
 
+	"messenger/auth"
 	"messenger/config"
 	"messenger/database"
 	"messenger/logger"
+	"messenger/mailer"
 	"messenger/middleware"
 	"messenger/models"
+	"messenger/ratelimit"
 	"messenger/redis"
+	"messenger/storage"
 	"messenger/utils/crypto"
+	"messenger/utils/encryption"
 )
 
-// Upgrader для WebSocket соединений
-var upgrader = &websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// В продакшне здесь нужна проверка Origin
-		return true // Для разработки разрешаем все соединения
-	},
-}
-
 // Структура сервера API
 type Server struct {
 	router *gin.Engine
@@ -44,15 +36,47 @@ type Server struct {
 
 	configLock sync.RWMutex
 
-	// Управление клиентами WebSocket
-	clients map[uint]*Client
-	mu      sync.Mutex
+	// Реестр подключений клиентов (WebSocket, SSE, long-poll)
+	hub *wsHub
 
 	// Для graceful shutdown
 	httpServer *http.Server
 
-	// Добавляем поле wsClients
-	wsClients sync.Map
+	// Обработчики входящих WebSocket кадров по типу
+	wsHandlers map[string]WSHandlerFunc
+
+	// Лимиты частоты входящих WebSocket кадров
+	wsLimiter *wsRateLimiter
+
+	// Сессии long-poll по идентификатору
+	pollSessions sync.Map
+
+	// Кеши проверок сессий и пользователей для JWTAuth
+	sessions     *statusCache[sessionStatus]
+	userStatuses *statusCache[userStatus]
+
+	// Лимит попыток ввода кодов 2FA по пользователю
+	twoFactorLimiter *ratelimit.KeyedLimiter
+
+	// Политика паролей и лимит попыток ввода текущего пароля
+	passwordPolicy  *auth.PasswordPolicy
+	passwordLimiter *ratelimit.KeyedLimiter
+
+	// Лимит неудачных попыток входа по имени пользователя и IP
+	authLimiter *middleware.AuthLimiter
+
+	// Клиент OIDC провайдера для входа через SSO
+	oidcHolder oidcClientHolder
+
+	// Способы проверки пароля при входе и каталог для синхронизации (nil, если LDAP выключен)
+	authenticators auth.Chain
+	directory      auth.Directory
+
+	// Отправка писем (подтверждение email, сброс пароля)
+	mailer mailer.Mailer
+
+	// Хранилище содержимого загруженных файлов (локальный каталог или S3)
+	storage storage.Storage
 }
 
 // Config содержит настройки сервера
@@ -68,6 +92,17 @@ func NewServer(cfg *config.Config, db *database.Database) *Server {
 	if err := crypto.InitCrypto(); err != nil {
 		log.Fatalf("Ошибка инициализации криптографического модуля: %v", err)
 	}
+	// Ключ сервера шифрует ключи загруженных файлов
+	if err := encryption.InitSSE(os.Getenv("SERVER_ENCRYPTION_KEY")); err != nil {
+		log.Fatalf("Ошибка инициализации шифрования файлов: %v", err)
+	}
+
+	// Хранилище загруженных файлов
+	fileStorage, err := storage.New(cfg)
+	if err != nil {
+		log.Fatalf("Ошибка инициализации хранилища файлов: %v", err)
+	}
+	logger.Infof("Хранилище файлов: %s", fileStorage.Name())
 
 	// Настраиваем режим Gin в зависимости от настроек
 	if cfg.Server.Debug {
@@ -80,7 +115,6 @@ func NewServer(cfg *config.Config, db *database.Database) *Server {
 
 	// Инициализация Redis, если он включен в конфигурации
 	var redisClient *redis.RedisClient
-	var err error
 
 	logger.Info("Инициализация Redis")
 	redisClient, err = redis.NewRedisClient(cfg)
@@ -90,23 +124,51 @@ func NewServer(cfg *config.Config, db *database.Database) *Server {
 	}
 
 	server := &Server{
-		router:  router,
-		config:  cfg,
-		db:      db,
-		clients: make(map[uint]*Client),
-		redis:   redisClient,
-	}
+		router:       router,
+		config:       cfg,
+		db:           db,
+		hub:          newWSHub(),
+		sessions:     newStatusCache[sessionStatus](authStatusTTL),
+		userStatuses: newStatusCache[userStatus](authStatusTTL),
+
+		twoFactorLimiter: ratelimit.NewKeyedLimiter(twoFactorAttemptsRate, twoFactorAttemptsBurst),
+		passwordPolicy:   newPasswordPolicy(cfg),
+		passwordLimiter:  ratelimit.NewKeyedLimiter(passwordAttemptsRate, passwordAttemptsBurst),
+		redis:            redisClient,
+		wsHandlers:       make(map[string]WSHandlerFunc),
+		wsLimiter:        newWSRateLimiter(cfg),
+		mailer:           newMailer(cfg),
+		storage:          fileStorage,
+	}
+
+	// Способы входа и синхронизация с каталогом
+	server.authenticators, server.directory = newAuthenticators(cfg, db)
+	if server.directory != nil && cfg.LDAP.SyncInterval > 0 {
+		go server.syncDirectory(time.Duration(cfg.LDAP.SyncInterval) * time.Minute)
+	}
+
+	// Регистрация обработчиков WebSocket кадров
+	server.registerWSHandlers()
+
+	// Очистка заброшенных сессий long-poll
+	go server.cleanupPollSessions()
+
+	// Очистка истекших и отозванных сессий
+	go server.cleanupSessions()
+	go server.cleanupAuthCaches()
+	go server.cleanupUserTokens()
+	go server.cleanupUploads()
+	go server.cleanupFiles()
+	server.twoFactorLimiter.Cleanup(10*time.Minute, 10*time.Minute)
+	server.passwordLimiter.Cleanup(10*time.Minute, 10*time.Minute)
 
 	// Настройка middleware
 	logger.Debug("Настройка CORS middleware")
 	router.Use(middleware.CORS())
 
-	// Защита от брутфорса
+	// Защита от брутфорса: подключается к маршруту входа в setupRoutes
 	logger.Info("Настройка защиты от брутфорса")
-	authLimiter := middleware.NewAuthLimiter(5, 15*time.Minute, 30*time.Minute)
-	router.Use(authLimiter.Middleware())
-	// Запускаем периодическую очистку устаревших блокировок
-	authLimiter.Cleanup(1 * time.Hour)
+	server.authLimiter = newAuthLimiter(cfg, redisClient)
 
 	// Настройка маршрутов
 	logger.Debug("Настройка маршрутов API")
@@ -148,48 +210,22 @@ func (s *Server) setupRedisSubscriptions() error {
 
 // Обработка сообщений из Redis
 func (s *Server) handleRedisMessage(msg redis.PubSubMessage) {
-	// Пропускаем сообщения к самому себе (если отправитель и получатель - один и тот же сервер)
 	recipientID := msg.RecipientID
 
-	s.mu.Lock()
-	client, exists := s.clients[recipientID]
-	s.mu.Unlock()
-
-	if exists {
-		ctx := map[string]interface{}{
-			"message_type": msg.Type,
-			"recipient_id": recipientID,
-			"sender_id":    msg.SenderID,
-		}
-
-		logger.DebugWithContext(ctx, "Получено сообщение из Redis")
-
-		// Формируем WebSocket сообщение
-		wsMsg := WSMessage{
-			Type:    msg.Type,
-			Payload: msg.Content,
-		}
-
-		// Сериализуем сообщение
-		data, err := json.Marshal(wsMsg)
-		if err != nil {
-			logger.ErrorWithContext(ctx, "Ошибка сериализации сообщения из Redis:", err)
-			return
-		}
-
-		// Отправляем сообщение клиенту
-		select {
-		case client.send <- data:
-			// Сообщение успешно отправлено
-			logger.DebugWithContext(ctx, "Сообщение из Redis успешно отправлено клиенту")
-		default:
-			// Буфер клиента полон, закрываем соединение
-			logger.WarnWithContext(ctx, "Буфер клиента полон, закрытие соединения")
-			s.unregisterClient(client)
-		}
-	} else {
+	if !s.hub.isOnline(recipientID) {
 		logger.Debugf("Сообщение из Redis проигнорировано: клиент %d не найден", recipientID)
+		return
 	}
+
+	ctx := map[string]interface{}{
+		"message_type": msg.Type,
+		"recipient_id": recipientID,
+		"sender_id":    msg.SenderID,
+	}
+	logger.DebugWithContext(ctx, "Получено сообщение из Redis")
+
+	// Отправляем сообщение во все подключения получателя
+	s.sendToUser(recipientID, wsResponse{Type: msg.Type, Payload: msg.Content})
 }
 
 // Подписка на глобальный канал Redis
@@ -211,7 +247,10 @@ func (s *Server) setupRoutes() {
 	public := s.router.Group("/api")
 	{
 		// Авторизация
-		public.POST("/auth/login", s.handleLogin)
+		public.POST("/auth/login", s.authLimiter.Middleware(), s.handleLogin)
+		public.POST("/auth/refresh", s.handleRefresh)
+		public.GET("/auth/oidc/login", s.handleOIDCLogin)
+		public.GET("/auth/oidc/callback", s.handleOIDCCallback)
 		public.POST("/register", s.handleRegister)
 
 		// Проверка работы сервера
@@ -219,25 +258,79 @@ func (s *Server) setupRoutes() {
 			c.JSON(http.StatusOK, gin.H{"status": "ok"})
 		})
 
-		// Скачивание файлов (публичный доступ по токену)
-		public.GET("/files/download/:token", s.handleFileDownload)
+		// Скачивание файла по подписанной временной ссылке из /files/:fileId/url
+		public.GET("/files/:fileId/raw", s.handleSignedFileDownload)
+
+		// Подтверждение email и восстановление пароля по ссылке из письма
+		public.POST("/auth/email/verify", s.handleVerifyEmail)
+		public.POST("/auth/password/forgot", s.handleForgotPassword)
+		public.POST("/auth/password/recover", s.handleRecoverPassword)
+
+		// Миниатюры аватаров (публичный доступ по токену)
+		public.GET("/avatars/:token", s.handleGetAvatar)
 
 		// WebSocket для чата и звонков (перемещен из защищенной группы)
-		public.GET("/ws", s.handleWebSocket)
+		public.GET("/ws", s.WebSocketHandler)
+
+		// Поток событий для клиентов без WebSocket (аутентификация как у /ws)
+		public.GET("/events", s.handleEvents)
+		public.GET("/events/poll", s.handleEventsPoll)
 	}
 
 	// Защищенные маршруты
+	// Второй шаг входа по промежуточному токену из ответа /api/auth/login
+	twoFactor := s.router.Group("/api/auth/2fa")
+	{
+		verify := twoFactor.Group("", middleware.PendingTokenAuth(s.config.JWT.Secret, middleware.PurposeTwoFactor))
+		verify.POST("/verify", s.handleTwoFactorVerify)
+
+		// Обязательное подключение 2FA для администраторов
+		enroll := twoFactor.Group("/enroll", middleware.PendingTokenAuth(s.config.JWT.Secret, middleware.PurposeTwoFactorEnroll))
+		enroll.POST("/setup", s.handleTwoFactorSetup)
+		enroll.POST("/enable", s.handleTwoFactorEnable)
+	}
+
+	// Обязательная смена пароля по промежуточному токену из ответа /api/auth/login
+	passwordReset := s.router.Group("/api/auth/password", middleware.PendingTokenAuth(s.config.JWT.Secret, middleware.PurposePasswordReset))
+	passwordReset.POST("/reset", s.handlePasswordReset)
+
 	auth := s.router.Group("/api")
-	auth.Use(middleware.JWTAuth(s.config.JWT.Secret))
+	auth.Use(middleware.JWTAuth(s.config.JWT.Secret, s.authorizeClaims))
 	{
 		// Проверка аутентификации
 		auth.GET("/auth/check", s.handleAuthCheck)
+		auth.POST("/auth/logout", s.handleLogout)
+		auth.GET("/auth/sessions", s.handleGetSessions)
+		auth.DELETE("/auth/sessions", s.handleRevokeOtherSessions)
+		auth.DELETE("/auth/sessions/:sessionID", s.handleRevokeSession)
+
+		// Двухфакторная аутентификация
+		auth.GET("/auth/2fa", s.handleTwoFactorStatus)
+		auth.POST("/auth/2fa/setup", s.handleTwoFactorSetup)
+		auth.POST("/auth/2fa/enable", s.handleTwoFactorEnable)
+		auth.POST("/auth/2fa/disable", s.handleTwoFactorDisable)
+		auth.POST("/auth/2fa/recovery-codes", s.handleTwoFactorRecoveryCodes)
+
+		// Повторная отправка письма подтверждения email
+		auth.POST("/auth/email/resend", s.handleResendVerification)
+
+		// Профиль и смена пароля текущим пользователем
+		auth.GET("/me", s.handleGetMe)
+		auth.PATCH("/me", s.handleUpdateMe)
+		auth.POST("/me/avatar", s.handleUploadAvatar)
+		auth.DELETE("/me/avatar", s.handleDeleteAvatar)
+		auth.POST("/me/password", s.handleChangePassword)
+
+		// Блокировка других пользователей
+		auth.GET("/me/blocks", s.handleGetBlocks)
+		auth.PUT("/me/blocks/:userId", s.handleBlockUser)
+		auth.DELETE("/me/blocks/:userId", s.handleUnblockUser)
 
 		// Пользователи (доступ только админу - проверка внутри обработчиков)
 		auth.GET("/users", s.handleGetUsers) // Может быть админским
 		// TODO: Добавить PUT /users/:id и DELETE /users/:id, если нужно для админки
-		auth.PUT("/users/:id", s.handleAdminUpdateUser)    // Добавлен обработчик обновления
-		auth.DELETE("/users/:id", s.handleAdminDeleteUser) // Добавлен обработчик удаления
+		auth.PUT("/users/:userId", s.handleAdminUpdateUser)    // Добавлен обработчик обновления
+		auth.DELETE("/users/:userId", s.handleAdminDeleteUser) // Добавлен обработчик удаления
 
 		// Список пользователей для чата (доступно всем авторизованным)
 		auth.GET("/chat/users", s.handleGetChatUsers)
@@ -253,9 +346,24 @@ func (s *Server) setupRoutes() {
 
 		// API для сообщений в чатах
 		auth.GET("/chat/:chatID/messages", s.handleGetMessages)
+		auth.POST("/chat/:chatID/messages", s.handleSendMessage)
+		auth.POST("/chat/:chatID/typing", s.handleSendTyping)
+		auth.POST("/messages/:messageID/read", s.handleReadMessage)
+		auth.POST("/messages/:messageID/listened", s.handleListenMessage)
+		auth.POST("/chat/:chatID/read", s.handleMarkMessagesAsRead)
 
 		// API для файлов
 		auth.POST("/files/upload", s.handleFileUpload)
+		auth.GET("/files/:fileId/download", s.handleFileDownload)
+		auth.GET("/files/:fileId/url", s.handleGetFileURL)
+
+		// Загрузка больших файлов по частям с возобновлением после обрыва
+		auth.POST("/uploads", s.handleCreateUpload)
+		auth.GET("/uploads/:uploadId", s.handleGetUpload)
+		auth.HEAD("/uploads/:uploadId", s.handleGetUpload)
+		auth.PATCH("/uploads/:uploadId", s.handlePatchUpload)
+		auth.POST("/uploads/:uploadId/complete", s.handleCompleteUpload)
+		auth.DELETE("/uploads/:uploadId", s.handleDeleteUpload)
 
 		// --- Админские маршруты ---
 		// Группируем админские маршруты для наглядности (хотя middleware уже применен)
@@ -273,6 +381,22 @@ func (s *Server) setupRoutes() {
 			// Маршруты для блокировки/разблокировки пользователей
 			admin.PUT("/users/:userId/block", s.handleAdminBlockUser)     // Новый маршрут
 			admin.PUT("/users/:userId/unblock", s.handleAdminUnblockUser) // Новый маршрут
+
+			// Обязательная смена пароля при следующем входе
+			admin.PUT("/users/:userId/password-reset", s.handleAdminRequirePasswordReset)
+			admin.DELETE("/users/:userId/password-reset", s.handleAdminCancelPasswordReset)
+
+			// Блокировки входа после неудачных попыток
+			admin.GET("/lockouts", s.handleAdminGetLockouts)
+			admin.DELETE("/lockouts", s.handleAdminClearLockouts)
+			admin.DELETE("/lockouts/:key", s.handleAdminClearLockout)
+
+			// Место, занятое файлами, и сроки хранения
+			admin.GET("/storage", s.handleAdminStorageSummary)
+			admin.GET("/storage/users", s.handleAdminStorageUsers)
+			admin.GET("/storage/users/:userId", s.handleAdminStorageUser)
+			admin.GET("/storage/chats", s.handleAdminStorageChats)
+			admin.PUT("/chats/:chatId/retention", s.handleAdminSetChatRetention)
 		}
 	}
 
@@ -323,137 +447,6 @@ func (s *Server) Run() error {
 	return nil
 }
 
-// Регистрация клиента WebSocket
-func (s *Server) registerClient(client *Client) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Закрываем существующее соединение
-	existingClient, ok := s.clients[client.userID]
-	if ok {
-		logger.Infof("Закрываем существующее соединение для пользователя %d", client.userID)
-		close(existingClient.send)
-	}
-
-	s.clients[client.userID] = client
-	logger.Infof("Клиент %d зарегистрирован", client.userID)
-}
-
-// Отключение клиента WebSocket
-func (s *Server) unregisterClient(client *Client) {
-	if client.userID == 0 {
-		// Клиент еще не аутентифицирован
-		return
-	}
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Проверяем, что это именно тот клиент
-	if _, ok := s.clients[client.userID]; ok {
-		logger.Infof("Клиент %d отключается", client.userID)
-		delete(s.clients, client.userID)
-		close(client.send)
-	}
-}
-
-// Обработка входящего сообщения
-func (s *Server) processMessage(client *Client, message []byte) {
-	// Разбираем сообщение
-	var msg wsMessage
-	if err := json.Unmarshal(message, &msg); err != nil {
-		logger.Errorf("Ошибка разбора сообщения: %v", err)
-		return
-	}
-
-	// Обрабатываем сообщение в зависимости от типа
-	switch msg.Type {
-	case "text":
-		// Обработка текстового сообщения
-		var textMsg map[string]interface{}
-		if err := json.Unmarshal([]byte(msg.Payload), &textMsg); err != nil {
-			logger.Errorf("Ошибка разбора текстового сообщения: %v", err)
-			return
-		}
-
-		// Извлекаем получателя
-		recipientID, ok := textMsg["recipient_id"].(float64)
-		if !ok {
-			logger.Error("Не указан получатель сообщения")
-			return
-		}
-
-		// Здесь должна быть логика обработки и сохранения сообщения
-		// ...
-
-		// Отправляем сообщение получателю
-		s.sendToUser(uint(recipientID), message)
-
-	case "typing":
-		// Обработка индикатора набора текста
-		// ...
-
-	default:
-		logger.Warnf("Неизвестный тип сообщения: %s", msg.Type)
-	}
-}
-
-// sendToUser отправляет данные через WebSocket указанному пользователю
-func (s *Server) sendToUser(userID uint, data []byte) {
-	// Проверяем, подключен ли пользователь
-	if clientObj, ok := s.wsClients.Load(userID); ok {
-		if client, ok := clientObj.(*WSClient); ok {
-			select {
-			case client.send <- data:
-				// Сообщение успешно отправлено в канал
-				logger.Debugf("Сообщение успешно отправлено пользователю %d", userID)
-			default:
-				// Буфер полон, отключаем клиента
-				logger.Warnf("Буфер клиента %d полон, отключение", userID)
-				s.wsClients.Delete(userID)
-				close(client.send)
-			}
-		}
-	} else {
-		logger.Debugf("Пользователь %d не подключен к WebSocket", userID)
-	}
-}
-
-// Отправка истории сообщений
-func (s *Server) sendMessageHistory(client *Client) {
-	// Получаем непрочитанные сообщения
-	var messages []models.DirectMessage
-	if err := s.db.DB.Where("recipient_id = ? AND is_read = ?", client.userID, false).Find(&messages).Error; err != nil {
-		logger.Errorf("Ошибка получения истории сообщений: %v", err)
-		return
-	}
-
-	// Отправляем каждое сообщение
-	for _, msg := range messages {
-		// Формируем сообщение WebSocket
-		wsMsg := WSMessage{
-			Type: "text",
-			Payload: json.RawMessage(fmt.Sprintf(`{
-				"id": %d,
-				"sender_id": %d,
-				"recipient_id": %d,
-				"content": %q,
-				"created_at": %q,
-				"is_read": false
-			}`, msg.ID, msg.SenderID, msg.RecipientID, msg.Content, msg.CreatedAt)),
-		}
-
-		// Отправляем клиенту
-		data, err := json.Marshal(wsMsg)
-		if err != nil {
-			logger.Errorf("Ошибка сериализации сообщения: %v", err)
-			continue
-		}
-
-		client.send <- data
-	}
-}
-
 // Обработчик для сброса системы (только для разработки)
 func (s *Server) handleResetSystem(c *gin.Context) {
 	if os.Getenv("APP_ENV") == "production" {
@@ -470,95 +463,6 @@ func (s *Server) handleResetSystem(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Система сброшена успешно"})
 }
 
-// handleWebSocket обрабатывает WebSocket соединения
-func (s *Server) handleWebSocket(c *gin.Context) {
-	// Записываем детальное логирование всех возможных мест, где может быть токен
-	logger.Debugf("WebSocket: Начало обработки соединения, адрес: %s", c.Request.RemoteAddr)
-	logger.Debugf("WebSocket: Все заголовки запроса: %v", c.Request.Header)
-
-	// Получаем токен из URL-параметра
-	tokenString := c.Query("token")
-
-	// Проверяем наличие токена в заголовке
-	authHeader := c.GetHeader("Authorization")
-	logger.Debugf("WebSocket: Заголовок Authorization: '%s'", authHeader)
-
-	// Проверяем наличие токена в куках
-	tokenCookie, err := c.Cookie("token")
-	if err != nil {
-		logger.Debugf("WebSocket: Токен в куках не найден: %v", err)
-	} else {
-		logger.Debugf("WebSocket: Токен из куки: '%s'", tokenCookie)
-		if tokenString == "" {
-			tokenString = tokenCookie
-			logger.Debugf("WebSocket: Используем токен из куки")
-		}
-	}
-
-	// Проверяем токен в заголовке
-	if tokenString == "" && strings.HasPrefix(authHeader, "Bearer ") {
-		tokenString = strings.TrimPrefix(authHeader, "Bearer ")
-		logger.Debugf("WebSocket: Используем токен из заголовка Authorization")
-	}
-
-	if tokenString == "" {
-		logger.Warnf("WebSocket: Токен не найден ни в URL, ни в заголовке, ни в куках")
-		c.Status(http.StatusUnauthorized) // Только статус без JSON для лучшей обработки ошибок WebSocket
-		return
-	}
-
-	// Вместо полагания на JWTAuth middleware, напрямую проверяем токен
-	claims, err := middleware.ValidateToken(tokenString, s.config.JWT.Secret)
-	if err != nil {
-		logger.Warnf("WebSocket: Недействительный токен: %v", err)
-		c.Status(http.StatusUnauthorized) // Только статус без JSON для лучшей обработки ошибок WebSocket
-		return
-	}
-
-	// Получаем userID из проверенных JWT claims
-	userID := claims.UserID
-	logger.Debugf("WebSocket: Успешная аутентификация пользователя ID=%d", userID)
-
-	// Обновляем соединение до WebSocket
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
-		logger.Errorf("WebSocket: Ошибка обновления соединения до WebSocket: %v", err)
-		return
-	}
-	logger.Debugf("WebSocket: Соединение успешно обновлено до WebSocket для пользователя ID=%d", userID)
-
-	// Создаем клиента
-	client := &WSClient{
-		server:        s,
-		conn:          conn,
-		send:          make(chan []byte, 256),
-		userID:        userID,
-		authenticated: true,
-		clientInfo:    c.Request.UserAgent(),
-	}
-
-	// Сохраняем клиента в карте соединений
-	s.wsClients.Store(userID, client)
-	logger.Debugf("WebSocket: Клиент сохранен в карте соединений, UserAgent: %s", c.Request.UserAgent())
-
-	// Отправляем пользователю сообщение для подтверждения соединения
-	debugMsg := fmt.Sprintf("Соединение WebSocket установлено для пользователя ID=%d", userID)
-	debugData, _ := json.Marshal(map[string]interface{}{
-		"type": "debug",
-		"payload": map[string]string{
-			"message": debugMsg,
-		},
-	})
-	conn.WriteMessage(websocket.TextMessage, debugData)
-	logger.Debugf("WebSocket: Отправлено отладочное сообщение подтверждения")
-
-	// Запускаем горутины для чтения и записи
-	go client.writePump()
-	go client.readPump()
-
-	logger.Infof("WebSocket: Пользователь %d успешно подключен по WebSocket", userID)
-}
-
 // Инициализирует систему, если нет ни одного пользователя
 func (s *Server) initializeSystemIfNeeded() {
 	// Проверяем, инициализирована ли система
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+
+	"messenger/config"
+	"messenger/database"
+	"messenger/middleware"
+	"messenger/models"
+	"messenger/ratelimit"
+	"messenger/storage"
+	"messenger/utils/crypto"
+	"messenger/utils/encryption"
+)
+
+const testJWTSecret = "test-jwt-secret"
+
+func TestMain(m *testing.M) {
+	// Ключ сервера, как в NewServer: шифрует куку входа через SSO и ключи файлов
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatal(err)
+	}
+	os.Setenv("SERVER_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(key))
+	if err := crypto.InitCrypto(); err != nil {
+		log.Fatalf("Ошибка инициализации криптографического модуля: %v", err)
+	}
+	if err := encryption.InitSSE(os.Getenv("SERVER_ENCRYPTION_KEY")); err != nil {
+		log.Fatalf("Ошибка инициализации шифрования файлов: %v", err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// newTestConfig возвращает минимальную конфигурацию для тестового сервера
+func newTestConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.JWT.Secret = testJWTSecret
+	cfg.JWT.AccessExpiry = 15
+	cfg.JWT.Expiry = 24
+	cfg.WebSocket.MaxMessageSize = 64 * 1024
+	cfg.Auth.Backends = []string{"local"}
+	return cfg
+}
+
+// newTestServer собирает сервер так же, как NewServer, но поверх SQLite во временном
+// каталоге и без Redis. Маршруты не регистрируются: тест подключает только нужные ему
+func newTestServer(t *testing.T, cfg *config.Config) *Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	dsn := filepath.Join(t.TempDir(), "messenger.db") + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"
+	gdb, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: gormlogger.Discard})
+	if err != nil {
+		t.Fatalf("открытие тестовой БД: %v", err)
+	}
+	if err := database.Migrate(gdb); err != nil {
+		t.Fatalf("миграция тестовой БД: %v", err)
+	}
+	db := &database.Database{DB: gdb}
+	t.Cleanup(func() { db.Close() })
+
+	fileStorage, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		router:       gin.New(),
+		config:       cfg,
+		db:           db,
+		hub:          newWSHub(),
+		sessions:     newStatusCache[sessionStatus](authStatusTTL),
+		userStatuses: newStatusCache[userStatus](authStatusTTL),
+
+		twoFactorLimiter: ratelimit.NewKeyedLimiter(twoFactorAttemptsRate, twoFactorAttemptsBurst),
+		passwordPolicy:   newPasswordPolicy(cfg),
+		passwordLimiter:  ratelimit.NewKeyedLimiter(passwordAttemptsRate, passwordAttemptsBurst),
+		wsHandlers:       make(map[string]WSHandlerFunc),
+		wsLimiter:        newWSRateLimiter(cfg),
+		mailer:           newMailer(cfg),
+		storage:          fileStorage,
+	}
+	s.authenticators, s.directory = newAuthenticators(cfg, db)
+	s.registerWSHandlers()
+	return s
+}
+
+// createTestUser создает пользователя с паролем password
+func createTestUser(t *testing.T, s *Server, username, role string) *models.User {
+	t.Helper()
+	user := &models.User{Username: username, Password: "password", Role: role}
+	if err := user.HashPassword(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.DB.Create(user).Error; err != nil {
+		t.Fatalf("создание пользователя %s: %v", username, err)
+	}
+	return user
+}
+
+// newTestSession создает сессию пользователя и возвращает ее ID и access токен
+func newTestSession(t *testing.T, s *Server, user *models.User) (uint, string) {
+	t.Helper()
+	now := time.Now()
+	session := models.Session{
+		UserID:           user.ID,
+		RefreshTokenHash: hashRefreshToken(user.Username + now.String()),
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(s.sessionTTL()),
+	}
+	if err := s.db.CreateSession(&session); err != nil {
+		t.Fatalf("создание сессии: %v", err)
+	}
+	token, err := middleware.GenerateToken(user.ID, user.Username, user.Role, session.ID, s.config.JWT.Secret, s.accessTokenTTL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session.ID, token
+}
@@ -0,0 +1,323 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"messenger/logger"
+	"messenger/middleware"
+	"messenger/models"
+)
+
+// Сколько хранятся истекшие и отозванные сессии перед удалением
+const staleSessionRetention = 7 * 24 * time.Hour
+
+// Запрос на обновление токенов
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// sessionResponse описывает сессию в списке устройств пользователя
+type sessionResponse struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+// sessionStatus - закешированный результат проверки сессии
+type sessionStatus struct {
+	userID uint
+	active bool
+}
+
+// hashRefreshToken возвращает хеш refresh токена для хранения в БД
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateRefreshToken создает случайный непрозрачный refresh токен
+func generateRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// accessTokenTTL возвращает срок жизни access токена
+func (s *Server) accessTokenTTL() time.Duration {
+	return time.Duration(s.config.JWT.AccessExpiry) * time.Minute
+}
+
+// sessionTTL возвращает срок жизни сессии (refresh токена)
+func (s *Server) sessionTTL() time.Duration {
+	return time.Duration(s.config.JWT.Expiry) * time.Hour
+}
+
+// issueSession создает новую сессию пользователя и выдает пару токенов
+func (s *Server) issueSession(c *gin.Context, user *models.User) (*LoginResponse, error) {
+	refreshToken, err := generateRefreshToken()
+	if err != nil {
+		logger.Errorf("Ошибка генерации refresh токена: %v", err)
+		return nil, ErrInternal("Ошибка генерации токена")
+	}
+
+	now := time.Now()
+	session := models.Session{
+		UserID:           user.ID,
+		RefreshTokenHash: hashRefreshToken(refreshToken),
+		UserAgent:        c.Request.UserAgent(),
+		IP:               c.ClientIP(),
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(s.sessionTTL()),
+	}
+	if err := s.db.CreateSession(&session); err != nil {
+		logger.Errorf("Ошибка создания сессии для пользователя %d: %v", user.ID, err)
+		return nil, ErrInternal("Ошибка создания сессии")
+	}
+
+	return s.tokenResponse(user, session.ID, refreshToken)
+}
+
+// tokenResponse выпускает access токен для сессии и собирает ответ
+func (s *Server) tokenResponse(user *models.User, sessionID uint, refreshToken string) (*LoginResponse, error) {
+	token, err := middleware.GenerateToken(user.ID, user.Username, user.Role, sessionID, s.config.JWT.Secret, s.accessTokenTTL())
+	if err != nil {
+		logger.Errorf("Ошибка генерации токена: %v", err)
+		return nil, ErrInternal("Ошибка генерации токена")
+	}
+
+	// Не возвращаем пароль
+	user.Password = ""
+
+	return &LoginResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.accessTokenTTL().Seconds()),
+		User:         *user,
+	}, nil
+}
+
+// validateSessionClaims проверяет, что сессия access токена существует и не отозвана
+func (s *Server) validateSessionClaims(claims *middleware.JWTClaims) error {
+	if claims.SessionID == 0 {
+		return errors.New("Token is not bound to a session")
+	}
+
+	status, ok := s.sessions.get(claims.SessionID)
+	if !ok {
+		session, err := s.db.GetSessionByID(claims.SessionID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			// Ошибка БД не должна выкидывать всех пользователей
+			logger.Errorf("Ошибка проверки сессии %d: %v", claims.SessionID, err)
+			return nil
+		}
+		status = sessionStatus{}
+		if session != nil {
+			status.userID = session.UserID
+			status.active = session.IsActive()
+		}
+		s.sessions.set(claims.SessionID, status)
+	}
+
+	if !status.active || status.userID != claims.UserID {
+		return errors.New("Session has been revoked")
+	}
+	return nil
+}
+
+// handleRefresh обменивает refresh токен на новую пару токенов.
+// Refresh токен одноразовый: повторное использование замененного токена отзывает сессию
+func (s *Server) handleRefresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendBadRequest(c, "Неверный формат запроса")
+		return
+	}
+
+	hash := hashRefreshToken(req.RefreshToken)
+	session, err := s.db.GetSessionByTokenHash(hash)
+	if err != nil {
+		// Токен мог быть уже заменен: значит, он утек или клиент повторил запрос
+		if reused, lookupErr := s.db.GetSessionByPreviousTokenHash(hash); lookupErr == nil && reused.IsActive() {
+			logger.Warnf("Повторное использование refresh токена сессии %d пользователя %d, сессия отозвана", reused.ID, reused.UserID)
+			if err := s.db.RevokeSession(reused.ID); err != nil {
+				logger.Errorf("Ошибка отзыва сессии %d: %v", reused.ID, err)
+				SendInternalError(c, "Ошибка завершения сессии")
+				return
+			}
+			s.revokeSessions(reused.ID)
+		}
+		SendUnauthorized(c, "Недействительный refresh токен")
+		return
+	}
+
+	if !session.IsActive() {
+		SendUnauthorized(c, "Сессия истекла или отозвана")
+		return
+	}
+
+	user, err := s.db.GetUserByID(session.UserID)
+	if err != nil {
+		if err := s.db.RevokeSession(session.ID); err != nil {
+			logger.Errorf("Ошибка отзыва сессии %d: %v", session.ID, err)
+			SendInternalError(c, "Ошибка завершения сессии")
+			return
+		}
+		s.revokeSessions(session.ID)
+		SendUnauthorized(c, "Пользователь не найден")
+		return
+	}
+	if user.Blocked {
+		SendAPIError(c, ErrUserBlocked())
+		return
+	}
+
+	refreshToken, err := generateRefreshToken()
+	if err != nil {
+		logger.Errorf("Ошибка генерации refresh токена: %v", err)
+		SendInternalError(c, "Ошибка генерации токена")
+		return
+	}
+
+	rotated, err := s.db.RotateSessionToken(session.ID, hash, hashRefreshToken(refreshToken), time.Now().Add(s.sessionTTL()))
+	if err != nil {
+		logger.Errorf("Ошибка обновления сессии %d: %v", session.ID, err)
+		SendInternalError(c, "Ошибка обновления сессии")
+		return
+	}
+	if !rotated {
+		SendUnauthorized(c, "Недействительный refresh токен")
+		return
+	}
+
+	response, err := s.tokenResponse(user, session.ID, refreshToken)
+	if err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// handleLogout отзывает текущую сессию
+func (s *Server) handleLogout(c *gin.Context) {
+	sessionID := c.GetUint("sessionID")
+
+	if err := s.db.RevokeSession(sessionID); err != nil {
+		logger.Errorf("Ошибка отзыва сессии %d: %v", sessionID, err)
+		SendInternalError(c, "Ошибка завершения сессии")
+		return
+	}
+	s.revokeSessions(sessionID)
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// handleGetSessions возвращает действующие сессии текущего пользователя
+func (s *Server) handleGetSessions(c *gin.Context) {
+	userID := c.GetUint("userID")
+	currentID := c.GetUint("sessionID")
+
+	sessions, err := s.db.GetActiveUserSessions(userID)
+	if err != nil {
+		logger.Errorf("Ошибка получения сессий пользователя %d: %v", userID, err)
+		SendInternalError(c, "Ошибка получения сессий")
+		return
+	}
+
+	response := make([]sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, sessionResponse{Session: session, Current: session.ID == currentID})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": response})
+}
+
+// handleRevokeSession завершает одну из сессий текущего пользователя
+func (s *Server) handleRevokeSession(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	sessionID, err := strconv.ParseUint(c.Param("sessionID"), 10, 32)
+	if err != nil {
+		SendBadRequest(c, "Некорректный ID сессии")
+		return
+	}
+
+	session, err := s.db.GetSessionByID(uint(sessionID))
+	if err != nil || session.UserID != userID {
+		SendNotFound(c, "Сессия не найдена")
+		return
+	}
+
+	if err := s.db.RevokeSession(session.ID); err != nil {
+		logger.Errorf("Ошибка отзыва сессии %d: %v", session.ID, err)
+		SendInternalError(c, "Ошибка завершения сессии")
+		return
+	}
+	s.revokeSessions(session.ID)
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// handleRevokeOtherSessions завершает все сессии текущего пользователя, кроме текущей
+func (s *Server) handleRevokeOtherSessions(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	ids, err := s.db.RevokeUserSessions(userID, c.GetUint("sessionID"))
+	if err != nil {
+		logger.Errorf("Ошибка отзыва сессий пользователя %d: %v", userID, err)
+		SendInternalError(c, "Ошибка завершения сессий")
+		return
+	}
+	s.revokeSessions(ids...)
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "revoked": len(ids)})
+}
+
+// revokeSessions помечает сессии отозванными в кеше и закрывает их подключения.
+// Запись в БД выполняет вызывающий код
+func (s *Server) revokeSessions(sessionIDs ...uint) {
+	if len(sessionIDs) == 0 {
+		return
+	}
+
+	revoked := make(map[uint]bool, len(sessionIDs))
+	for _, id := range sessionIDs {
+		revoked[id] = true
+		s.sessions.set(id, sessionStatus{active: false})
+	}
+
+	for _, client := range s.hub.all() {
+		if revoked[client.sessionID] {
+			logger.Infof("Закрываем подключение пользователя %d: сессия %d отозвана", client.userID, client.sessionID)
+			client.closeWithReason(wsCloseSessionRevoked, "session revoked")
+		}
+	}
+}
+
+// cleanupSessions периодически удаляет старые сессии из БД
+func (s *Server) cleanupSessions() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := s.db.DeleteStaleSessions(time.Now().Add(-staleSessionRetention))
+		if err != nil {
+			logger.Errorf("Ошибка удаления устаревших сессий: %v", err)
+			continue
+		}
+		if deleted > 0 {
+			logger.Infof("Удалено устаревших сессий: %d", deleted)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"messenger/middleware"
+)
+
+// loginTokens входит через /api/auth/login и возвращает выданную пару токенов
+func loginTokens(t *testing.T, s *Server, username, password string) LoginResponse {
+	t.Helper()
+	rec := login(s, username, password)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("вход %s: статус %d: %s", username, rec.Code, rec.Body)
+	}
+	var response LoginResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func refresh(t *testing.T, s *Server, refreshToken string) (int, LoginResponse) {
+	t.Helper()
+	rec := postJSON(s, "/api/auth/refresh", RefreshRequest{RefreshToken: refreshToken})
+	var response LoginResponse
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	return rec.Code, response
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	s := newTestServer(t, newTestConfig())
+	s.router.POST("/api/auth/login", s.handleLogin)
+	s.router.POST("/api/auth/refresh", s.handleRefresh)
+	createTestUser(t, s, "alice", "user")
+
+	first := loginTokens(t, s, "alice", "password")
+	code, second := refresh(t, s, first.RefreshToken)
+	if code != http.StatusOK || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("обновление токенов: статус %d, refresh токен %q", code, second.RefreshToken)
+	}
+	claims, err := middleware.ValidateToken(second.Token, s.config.JWT.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Замененный токен предъявлен снова: он утек, сессия отзывается целиком
+	if code, _ := refresh(t, s, first.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("повторное использование refresh токена: статус %d, ожидался 401", code)
+	}
+
+	session, err := s.db.GetSessionByID(claims.SessionID)
+	if err != nil || session.IsActive() {
+		t.Fatalf("сессия не отозвана в БД (%v)", err)
+	}
+	// Действующий refresh токен той же сессии тоже больше не работает
+	if code, _ := refresh(t, s, second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("обновление после отзыва сессии: статус %d, ожидался 401", code)
+	}
+
+	// Без кеша статус сессии читается из БД, и access токен все равно отклоняется
+	s.sessions.invalidate(claims.SessionID)
+	if err := authorizeToken(s, second.Token); err == nil {
+		t.Error("access токен отозванной сессии принимается после сброса кеша")
+	}
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"messenger/logger"
+)
+
+// storageUsageQuery - постраничный вывод занятого места
+type storageUsageQuery struct {
+	Limit  int `form:"limit" binding:"omitempty,min=1,max=500"`
+	Offset int `form:"offset" binding:"omitempty,min=0"`
+}
+
+// StorageSummaryResponse - общее занятое место и настройки квот и хранения
+type StorageSummaryResponse struct {
+	Files         int64 `json:"files"`
+	Bytes         int64 `json:"bytes"`
+	UploadsBytes  int64 `json:"uploads_bytes"` // Место, занятое незавершенными загрузками
+	TotalQuota    int64 `json:"total_quota"`   // 0 - без ограничения
+	UserQuota     int64 `json:"user_quota"`
+	RetentionDays int   `json:"retention_days"`
+}
+
+// UserStorageResponse - место, занятое файлами пользователя
+type UserStorageResponse struct {
+	UserID       uint  `json:"user_id"`
+	Bytes        int64 `json:"bytes"`
+	UploadsBytes int64 `json:"uploads_bytes"`
+	Quota        int64 `json:"quota"`
+}
+
+// storageQuotas возвращает квоты пользователя и сервера в байтах, 0 - без ограничения
+func (s *Server) storageQuotas() (user, total int64) {
+	s.configLock.RLock()
+	defer s.configLock.RUnlock()
+	return int64(s.config.FileStorage.UserQuotaMB) << 20, int64(s.config.FileStorage.TotalQuotaMB) << 20
+}
+
+// quotaExceeded сообщает, что файл не помещается в квоту (scope: user или total)
+func quotaExceeded(scope string, quota, used int64) *APIError {
+	message := "Превышена квота на хранение файлов"
+	if scope == "total" {
+		message = "На сервере закончилось место для файлов"
+	}
+	return NewAPIError(http.StatusInsufficientStorage, ErrCodeQuotaExceeded, message,
+		gin.H{"scope": scope, "quota": quota, "used": used})
+}
+
+// checkStorageQuota проверяет, что файл размером size поместится в квоты пользователя и сервера.
+// Кроме сохраненных файлов учитываются незавершенные загрузки, кроме exceptUpload:
+// место под них занято с момента создания загрузки
+func (s *Server) checkStorageQuota(userID uint, size int64, exceptUpload string) error {
+	userQuota, totalQuota := s.storageQuotas()
+
+	if userQuota > 0 {
+		stored, err := s.db.GetUserFileUsage(userID)
+		if err != nil {
+			logger.Errorf("Квоты: Ошибка подсчета места пользователя %d: %v", userID, err)
+			return ErrInternal("Ошибка базы данных")
+		}
+		uploads, err := s.db.GetUploadsSize(userID, exceptUpload)
+		if err != nil {
+			logger.Errorf("Квоты: Ошибка подсчета загрузок пользователя %d: %v", userID, err)
+			return ErrInternal("Ошибка базы данных")
+		}
+		if used := stored + uploads; used+size > userQuota {
+			return quotaExceeded("user", userQuota, used)
+		}
+	}
+
+	if totalQuota > 0 {
+		_, stored, err := s.db.GetTotalFileUsage()
+		if err != nil {
+			logger.Errorf("Квоты: Ошибка подсчета занятого места: %v", err)
+			return ErrInternal("Ошибка базы данных")
+		}
+		uploads, err := s.db.GetUploadsSize(0, exceptUpload)
+		if err != nil {
+			logger.Errorf("Квоты: Ошибка подсчета загрузок: %v", err)
+			return ErrInternal("Ошибка базы данных")
+		}
+		if used := stored + uploads; used+size > totalQuota {
+			logger.Warnf("Квоты: Общая квота исчерпана (%d из %d байт)", used, totalQuota)
+			return quotaExceeded("total", totalQuota, used)
+		}
+	}
+	return nil
+}
+
+// handleAdminStorageSummary возвращает общее занятое место и действующие квоты
+func (s *Server) handleAdminStorageSummary(c *gin.Context) {
+	if c.GetString("role") != "admin" {
+		SendForbidden(c, "Требуются права администратора")
+		return
+	}
+
+	files, bytes, err := s.db.GetTotalFileUsage()
+	if err != nil {
+		logger.Errorf("handleAdminStorageSummary: Ошибка подсчета занятого места: %v", err)
+		SendInternalError(c, "Ошибка базы данных")
+		return
+	}
+	uploads, err := s.db.GetUploadsSize(0, "")
+	if err != nil {
+		logger.Errorf("handleAdminStorageSummary: Ошибка подсчета загрузок: %v", err)
+		SendInternalError(c, "Ошибка базы данных")
+		return
+	}
+
+	userQuota, totalQuota := s.storageQuotas()
+	c.JSON(http.StatusOK, StorageSummaryResponse{
+		Files:         files,
+		Bytes:         bytes,
+		UploadsBytes:  uploads,
+		TotalQuota:    totalQuota,
+		UserQuota:     userQuota,
+		RetentionDays: s.retentionDays(),
+	})
+}
+
+// handleAdminStorageUsers возвращает пользователей в порядке убывания занятого места
+func (s *Server) handleAdminStorageUsers(c *gin.Context) {
+	if c.GetString("role") != "admin" {
+		SendForbidden(c, "Требуются права администратора")
+		return
+	}
+
+	var query storageUsageQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		SendBadRequest(c, "Некорректные параметры запроса", err.Error())
+		return
+	}
+	usage, err := s.db.GetUsersStorageUsage(pageLimit(query.Limit), query.Offset)
+	if err != nil {
+		logger.Errorf("handleAdminStorageUsers: Ошибка подсчета места пользователей: %v", err)
+		SendInternalError(c, "Ошибка базы данных")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": usage})
+}
+
+// handleAdminStorageUser возвращает место, занятое файлами и загрузками пользователя
+func (s *Server) handleAdminStorageUser(c *gin.Context) {
+	if c.GetString("role") != "admin" {
+		SendForbidden(c, "Требуются права администратора")
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		SendBadRequest(c, "Некорректный ID пользователя")
+		return
+	}
+	if _, err := s.db.GetUserByID(uint(userID)); err != nil {
+		SendNotFound(c, "Пользователь не найден")
+		return
+	}
+
+	bytes, err := s.db.GetUserFileUsage(uint(userID))
+	if err != nil {
+		logger.Errorf("handleAdminStorageUser: Ошибка подсчета места пользователя %d: %v", userID, err)
+		SendInternalError(c, "Ошибка базы данных")
+		return
+	}
+	uploads, err := s.db.GetUploadsSize(uint(userID), "")
+	if err != nil {
+		logger.Errorf("handleAdminStorageUser: Ошибка подсчета загрузок пользователя %d: %v", userID, err)
+		SendInternalError(c, "Ошибка базы данных")
+		return
+	}
+
+	userQuota, _ := s.storageQuotas()
+	c.JSON(http.StatusOK, UserStorageResponse{
+		UserID:       uint(userID),
+		Bytes:        bytes,
+		UploadsBytes: uploads,
+		Quota:        userQuota,
+	})
+}
+
+// handleAdminStorageChats возвращает чаты в порядке убывания занятого места
+func (s *Server) handleAdminStorageChats(c *gin.Context) {
+	if c.GetString("role") != "admin" {
+		SendForbidden(c, "Требуются права администратора")
+		return
+	}
+
+	var query storageUsageQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		SendBadRequest(c, "Некорректные параметры запроса", err.Error())
+		return
+	}
+	usage, err := s.db.GetChatsStorageUsage(pageLimit(query.Limit), query.Offset)
+	if err != nil {
+		logger.Errorf("handleAdminStorageChats: Ошибка подсчета места чатов: %v", err)
+		SendInternalError(c, "Ошибка базы данных")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"chats": usage})
+}
+
+// pageLimit возвращает размер страницы по умолчанию, если он не указан
+func pageLimit(limit int) int {
+	if limit == 0 {
+		return 50
+	}
+	return limit
+}
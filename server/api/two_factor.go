@@ -0,0 +1,437 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+
+	"messenger/logger"
+	"messenger/middleware"
+	"messenger/models"
+	"messenger/utils/crypto"
+)
+
+const (
+	twoFactorIssuer        = "Messenger"     // Издатель в otpauth URI
+	twoFactorPendingTTL    = 5 * time.Minute // Срок жизни промежуточного токена входа
+	totpPeriod             = 30              // Шаг TOTP в секундах
+	totpSkew               = 1               // Допустимое расхождение часов в шагах
+	recoveryCodesCount     = 10
+	twoFactorAttemptsRate  = 5.0 / 60 // Попыток ввода кода в секунду на пользователя
+	twoFactorAttemptsBurst = 5
+)
+
+var totpOpts = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// TwoFactorChallengeResponse - ответ на вход, когда нужен второй шаг
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`       // Нужно ввести код
+	SetupRequired     bool   `json:"two_factor_setup_required,omitempty"` // Нужно подключить 2FA
+	PendingToken      string `json:"pending_token"`
+	ExpiresIn         int64  `json:"expires_in"`
+}
+
+// TwoFactorCodeRequest содержит код TOTP или код восстановления
+type TwoFactorCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// TwoFactorDisableRequest - отключение 2FA требует пароль и код
+type TwoFactorDisableRequest struct {
+	Password string `json:"password" binding:"required"`
+	TwoFactorCodeRequest
+}
+
+// TwoFactorEnableResponse возвращает коды восстановления; при обязательном подключении во время входа
+// дополнительно содержит токены сессии
+type TwoFactorEnableResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	*LoginResponse
+}
+
+// twoFactorChallenge определяет, нужен ли пользователю второй шаг входа.
+// Возвращает nil, если можно сразу выдавать сессию
+func (s *Server) twoFactorChallenge(user *models.User) (*TwoFactorChallengeResponse, error) {
+	s.configLock.RLock()
+	requireAdmin := s.config.Server.RequireAdmin2FA
+	s.configLock.RUnlock()
+
+	var purpose string
+	switch {
+	case user.TOTPEnabled:
+		purpose = middleware.PurposeTwoFactor
+	case requireAdmin && user.Role == "admin":
+		purpose = middleware.PurposeTwoFactorEnroll
+	default:
+		return nil, nil
+	}
+
+	token, err := middleware.GeneratePendingToken(user.ID, user.Username, purpose, s.config.JWT.Secret, twoFactorPendingTTL)
+	if err != nil {
+		logger.Errorf("Ошибка генерации промежуточного токена для пользователя %d: %v", user.ID, err)
+		return nil, ErrInternal("Ошибка генерации токена")
+	}
+
+	return &TwoFactorChallengeResponse{
+		TwoFactorRequired: purpose == middleware.PurposeTwoFactor,
+		SetupRequired:     purpose == middleware.PurposeTwoFactorEnroll,
+		PendingToken:      token,
+		ExpiresIn:         int64(twoFactorPendingTTL.Seconds()),
+	}, nil
+}
+
+// handleTwoFactorStatus возвращает состояние 2FA текущего пользователя
+func (s *Server) handleTwoFactorStatus(c *gin.Context) {
+	user, err := s.db.GetUserByID(c.GetUint("userID"))
+	if err != nil {
+		SendNotFound(c, "Пользователь не найден")
+		return
+	}
+
+	remaining, err := s.db.CountUnusedRecoveryCodes(user.ID)
+	if err != nil {
+		logger.Errorf("Ошибка подсчета кодов восстановления пользователя %d: %v", user.ID, err)
+	}
+
+	s.configLock.RLock()
+	required := s.config.Server.RequireAdmin2FA && user.Role == "admin"
+	s.configLock.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  user.TOTPEnabled,
+		"required":                 required,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// handleTwoFactorSetup создает новый секрет TOTP. 2FA включается только после подтверждения кодом
+func (s *Server) handleTwoFactorSetup(c *gin.Context) {
+	user, err := s.db.GetUserByID(c.GetUint("userID"))
+	if err != nil {
+		SendNotFound(c, "Пользователь не найден")
+		return
+	}
+	if user.TOTPEnabled {
+		SendBadRequest(c, "Двухфакторная аутентификация уже включена")
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      twoFactorIssuer,
+		AccountName: user.Username,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		logger.Errorf("Ошибка генерации секрета TOTP для пользователя %d: %v", user.ID, err)
+		SendInternalError(c, "Ошибка генерации секрета")
+		return
+	}
+
+	encrypted, err := crypto.EncryptString(key.Secret())
+	if err != nil {
+		logger.Errorf("Ошибка шифрования секрета TOTP: %v", err)
+		SendInternalError(c, "Ошибка шифрования секрета")
+		return
+	}
+
+	if err := s.db.SetTOTPSecret(user.ID, encrypted); err != nil {
+		logger.Errorf("Ошибка сохранения секрета TOTP пользователя %d: %v", user.ID, err)
+		SendInternalError(c, "Ошибка сохранения секрета")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      key.Secret(),
+		"otpauth_url": key.URL(),
+	})
+}
+
+// handleTwoFactorEnable подтверждает секрет кодом и включает 2FA.
+// При обязательном подключении во время входа сразу выдает сессию
+func (s *Server) handleTwoFactorEnable(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		SendBadRequest(c, "Требуется код подтверждения")
+		return
+	}
+
+	user, err := s.db.GetUserByID(c.GetUint("userID"))
+	if err != nil {
+		SendNotFound(c, "Пользователь не найден")
+		return
+	}
+	if user.TOTPEnabled {
+		SendBadRequest(c, "Двухфакторная аутентификация уже включена")
+		return
+	}
+	if user.TOTPSecret == "" {
+		SendBadRequest(c, "Сначала получите секрет через /api/auth/2fa/setup")
+		return
+	}
+
+	if !s.allowTwoFactorAttempt(user.ID) {
+		SendAPIError(c, ErrRateLimited(time.Minute))
+		return
+	}
+
+	counter, ok := verifyTOTP(user, req.Code)
+	if !ok {
+		SendBadRequest(c, "Неверный код подтверждения")
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		logger.Errorf("Ошибка генерации кодов восстановления: %v", err)
+		SendInternalError(c, "Ошибка генерации кодов восстановления")
+		return
+	}
+
+	if err := s.db.EnableTOTP(user.ID, counter, hashes); err != nil {
+		logger.Errorf("Ошибка включения 2FA для пользователя %d: %v", user.ID, err)
+		SendInternalError(c, "Ошибка включения двухфакторной аутентификации")
+		return
+	}
+	logger.Infof("Пользователь %d включил двухфакторную аутентификацию", user.ID)
+
+	response := TwoFactorEnableResponse{RecoveryCodes: codes}
+	if c.GetString("purpose") == middleware.PurposeTwoFactorEnroll {
+		if response.LoginResponse, err = s.issueSession(c, user); err != nil {
+			SendAPIError(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// handleTwoFactorVerify завершает вход: обменивает промежуточный токен и код на сессию
+func (s *Server) handleTwoFactorVerify(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendBadRequest(c, "Неверный формат запроса")
+		return
+	}
+
+	user, err := s.db.GetUserByID(c.GetUint("userID"))
+	if err != nil {
+		SendUnauthorized(c, "Пользователь не найден")
+		return
+	}
+	if user.Blocked {
+		SendAPIError(c, ErrUserBlocked())
+		return
+	}
+
+	if err := s.verifySecondFactor(user, req); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	response, err := s.issueSession(c, user)
+	if err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// handleTwoFactorDisable отключает 2FA после проверки пароля и кода
+func (s *Server) handleTwoFactorDisable(c *gin.Context) {
+	var req TwoFactorDisableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendBadRequest(c, "Требуются пароль и код")
+		return
+	}
+
+	user, err := s.db.GetUserByID(c.GetUint("userID"))
+	if err != nil {
+		SendNotFound(c, "Пользователь не найден")
+		return
+	}
+	if !user.TOTPEnabled {
+		SendBadRequest(c, "Двухфакторная аутентификация не включена")
+		return
+	}
+
+	s.configLock.RLock()
+	required := s.config.Server.RequireAdmin2FA && user.Role == "admin"
+	s.configLock.RUnlock()
+	if required {
+		SendForbidden(c, "Двухфакторная аутентификация обязательна для администраторов")
+		return
+	}
+
+	if !user.CheckPassword(req.Password) {
+		SendBadRequest(c, "Неверный пароль")
+		return
+	}
+	if err := s.verifySecondFactor(user, req.TwoFactorCodeRequest); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	if err := s.db.DisableTOTP(user.ID); err != nil {
+		logger.Errorf("Ошибка отключения 2FA для пользователя %d: %v", user.ID, err)
+		SendInternalError(c, "Ошибка отключения двухфакторной аутентификации")
+		return
+	}
+	logger.Infof("Пользователь %d отключил двухфакторную аутентификацию", user.ID)
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// handleTwoFactorRecoveryCodes выпускает новый набор кодов восстановления взамен старого
+func (s *Server) handleTwoFactorRecoveryCodes(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		SendBadRequest(c, "Требуется код подтверждения")
+		return
+	}
+
+	user, err := s.db.GetUserByID(c.GetUint("userID"))
+	if err != nil {
+		SendNotFound(c, "Пользователь не найден")
+		return
+	}
+	if !user.TOTPEnabled {
+		SendBadRequest(c, "Двухфакторная аутентификация не включена")
+		return
+	}
+
+	// Новые коды выдаются только по коду TOTP, не по коду восстановления
+	if err := s.verifySecondFactor(user, TwoFactorCodeRequest{Code: req.Code}); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		logger.Errorf("Ошибка генерации кодов восстановления: %v", err)
+		SendInternalError(c, "Ошибка генерации кодов восстановления")
+		return
+	}
+	if err := s.db.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+		logger.Errorf("Ошибка сохранения кодов восстановления пользователя %d: %v", user.ID, err)
+		SendInternalError(c, "Ошибка сохранения кодов восстановления")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// verifySecondFactor проверяет код TOTP или погашает код восстановления
+func (s *Server) verifySecondFactor(user *models.User, req TwoFactorCodeRequest) error {
+	if req.Code == "" && req.RecoveryCode == "" {
+		return ErrBadRequest("Требуется код подтверждения или код восстановления")
+	}
+	if !s.allowTwoFactorAttempt(user.ID) {
+		return ErrRateLimited(time.Minute)
+	}
+
+	if req.RecoveryCode != "" {
+		used, err := s.db.UseRecoveryCode(user.ID, hashRecoveryCode(req.RecoveryCode))
+		if err != nil {
+			logger.Errorf("Ошибка проверки кода восстановления пользователя %d: %v", user.ID, err)
+			return ErrInternal("Ошибка проверки кода")
+		}
+		if !used {
+			return ErrUnauthorized("Неверный код восстановления")
+		}
+		logger.Infof("Пользователь %d использовал код восстановления", user.ID)
+		return nil
+	}
+
+	counter, ok := verifyTOTP(user, req.Code)
+	if !ok {
+		return ErrUnauthorized("Неверный код подтверждения")
+	}
+
+	// Каждый код принимается один раз, даже в пределах окна допустимого расхождения
+	advanced, err := s.db.AdvanceTOTPCounter(user.ID, counter)
+	if err != nil {
+		logger.Errorf("Ошибка сохранения шага TOTP пользователя %d: %v", user.ID, err)
+		return ErrInternal("Ошибка проверки кода")
+	}
+	if !advanced {
+		return ErrUnauthorized("Код уже использован")
+	}
+	return nil
+}
+
+// allowTwoFactorAttempt ограничивает частоту ввода кодов для пользователя
+func (s *Server) allowTwoFactorAttempt(userID uint) bool {
+	allowed, _ := s.twoFactorLimiter.Allow(strconv.FormatUint(uint64(userID), 10))
+	return allowed
+}
+
+// verifyTOTP проверяет код по секрету пользователя и возвращает совпавший временной шаг.
+// Шаги не новее последнего принятого отклоняются
+func verifyTOTP(user *models.User, code string) (int64, bool) {
+	secret, err := crypto.DecryptString(user.TOTPSecret)
+	if err != nil {
+		logger.Errorf("Ошибка расшифровки секрета TOTP пользователя %d: %v", user.ID, err)
+		return 0, false
+	}
+
+	code = strings.TrimSpace(code)
+	current := time.Now().Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= user.TOTPLastCounter {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totpOpts)
+		if err != nil {
+			logger.Errorf("Ошибка вычисления кода TOTP: %v", err)
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// generateRecoveryCodes создает коды восстановления вида xxxxx-xxxxx и их хеши
+func generateRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+
+	for i := 0; i < recoveryCodesCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(buf))[:10]
+		code := raw[:5] + "-" + raw[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode нормализует код (регистр, дефисы, пробелы) и возвращает его хеш
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
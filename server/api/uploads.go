@@ -0,0 +1,564 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"messenger/database"
+	"messenger/logger"
+	"messenger/models"
+	"messenger/storage"
+)
+
+// Загрузка файлов по частям:
+//
+//	POST   /api/uploads                 - создать загрузку (имя, размер, чат, необязательный SHA-256)
+//	GET    /api/uploads/:id             - узнать принятое смещение (также HEAD, заголовок Upload-Offset)
+//	PATCH  /api/uploads/:id             - дописать часть с заголовками Upload-Offset и Content-Length
+//	POST   /api/uploads/:id/complete    - проверить SHA-256, собрать файл и отправить его в чат
+//	DELETE /api/uploads/:id             - отменить загрузку
+//
+// После обрыва связи клиент запрашивает смещение и продолжает с него. Незавершенные
+// загрузки удаляются через FileStorage.UploadTTL часов после последней принятой части
+const (
+	// Максимум одновременных незавершенных загрузок пользователя
+	maxActiveUploads = 10
+	// Период удаления брошенных загрузок
+	uploadCleanupInterval = 15 * time.Minute
+	// Сколько просроченных загрузок удаляется за один проход
+	uploadCleanupBatch = 100
+	// Каталог частей незавершенных загрузок в хранилище
+	uploadPartsPrefix = "partial/"
+)
+
+// CreateUploadRequest - параметры новой загрузки
+type CreateUploadRequest struct {
+	ChatID   uint   `json:"chat_id" binding:"required"`
+	FileName string `json:"file_name" binding:"required,max=255"`
+	Size     int64  `json:"size" binding:"required,min=1"`
+	MimeType string `json:"mime_type" binding:"required"`
+	Checksum string `json:"checksum" binding:"omitempty,len=64,hexadecimal"` // SHA-256 всего файла в hex
+	Type     string `json:"type"`                                            // file (по умолчанию) или voice
+}
+
+// CompleteUploadRequest - завершение загрузки. Checksum обязателен, если не был указан при создании
+type CompleteUploadRequest struct {
+	Checksum string `json:"checksum" binding:"omitempty,len=64,hexadecimal"`
+	Message  string `json:"message"` // Подпись к файлу
+}
+
+// UploadResponse - состояние загрузки
+type UploadResponse struct {
+	ID          string    `json:"id"`
+	ChatID      uint      `json:"chat_id"`
+	FileName    string    `json:"file_name"`
+	MimeType    string    `json:"mime_type"`
+	Type        string    `json:"type"`
+	Size        int64     `json:"size"`
+	Offset      int64     `json:"offset"`
+	PartMaxSize int64     `json:"part_max_size"` // Максимальный размер одного PATCH
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// uploadLimits возвращает максимальный размер части и срок жизни незавершенной загрузки
+func (s *Server) uploadLimits() (partMax int64, ttl time.Duration) {
+	s.configLock.RLock()
+	defer s.configLock.RUnlock()
+	return int64(s.config.FileStorage.UploadPartMaxMB) << 20, time.Duration(s.config.FileStorage.UploadTTL) * time.Hour
+}
+
+func (s *Server) newUploadResponse(upload *models.Upload) UploadResponse {
+	partMax, _ := s.uploadLimits()
+	return UploadResponse{
+		ID:          upload.ID,
+		ChatID:      upload.ChatID,
+		FileName:    upload.FileName,
+		MimeType:    upload.MimeType,
+		Type:        upload.MessageType,
+		Size:        upload.Size,
+		Offset:      upload.Offset,
+		PartMaxSize: partMax,
+		ExpiresAt:   upload.ExpiresAt,
+	}
+}
+
+// uploadOffsetMismatch сообщает клиенту смещение, с которого нужно продолжить
+func uploadOffsetMismatch(message string, offset int64) *APIError {
+	return NewAPIError(http.StatusConflict, ErrCodeUploadOffset, message, gin.H{"offset": offset})
+}
+
+// handleCreateUpload начинает загрузку по частям. Права на чат, размер и тип проверяются сразу,
+// чтобы клиент не передавал файл, который все равно не будет принят
+func (s *Server) handleCreateUpload(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var req CreateUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendBadRequest(c, "Неверный формат запроса", err.Error())
+		return
+	}
+
+	msgType, err := fileMessageType(req.Type)
+	if err != nil {
+		SendAPIError(c, err)
+		return
+	}
+	if limit := s.maxMessageFileSize(msgType); req.Size > limit {
+		SendAPIError(c, fileTooLarge(limit))
+		return
+	}
+	// Окончательно тип проверяется по содержимому при завершении загрузки
+	if msgType == models.MessageTypeFile && !s.isAllowedFileType(req.MimeType) {
+		SendAPIError(c, unsupportedFileType(req.MimeType))
+		return
+	}
+	if _, err := s.fileUploadChat(userID, req.ChatID); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+	// Место в квоте занимается сразу, чтобы не принимать части файла, который не поместится
+	if err := s.checkStorageQuota(userID, req.Size, ""); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	active, err := s.db.CountUserUploads(userID)
+	if err != nil {
+		logger.Errorf("Загрузки: Ошибка подсчета загрузок пользователя %d: %v", userID, err)
+		SendInternalError(c, "Ошибка базы данных")
+		return
+	}
+	if active >= maxActiveUploads {
+		SendError(c, http.StatusTooManyRequests, ErrCodeRateLimited, "Слишком много незавершенных загрузок")
+		return
+	}
+
+	_, ttl := s.uploadLimits()
+	upload := models.Upload{
+		ID:          randomHex(16),
+		UserID:      userID,
+		ChatID:      req.ChatID,
+		FileName:    filepath.Base(req.FileName),
+		MimeType:    req.MimeType,
+		MessageType: string(msgType),
+		Size:        req.Size,
+		Checksum:    strings.ToLower(req.Checksum),
+		Status:      models.UploadStatusActive,
+		ExpiresAt:   time.Now().Add(ttl),
+	}
+	if err := s.db.CreateUpload(&upload); err != nil {
+		logger.Errorf("Загрузки: Ошибка создания загрузки: %v", err)
+		SendInternalError(c, "Ошибка базы данных")
+		return
+	}
+
+	logger.Infof("Загрузки: Пользователь %d начал загрузку %s (%d байт) в чат %d", userID, upload.ID, upload.Size, upload.ChatID)
+	c.Header("Location", "/api/uploads/"+upload.ID)
+	c.Header("Upload-Offset", "0")
+	c.JSON(http.StatusCreated, s.newUploadResponse(&upload))
+}
+
+// handleGetUpload возвращает принятое смещение, с которого клиент продолжает загрузку
+func (s *Server) handleGetUpload(c *gin.Context) {
+	upload, err := s.getUpload(c)
+	if err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, s.newUploadResponse(upload))
+}
+
+// handlePatchUpload принимает очередную часть. Смещение в Upload-Offset должно совпадать
+// с принятым сервером, иначе часть отклоняется с актуальным смещением
+func (s *Server) handlePatchUpload(c *gin.Context) {
+	upload, err := s.getUpload(c)
+	if err != nil {
+		SendAPIError(c, err)
+		return
+	}
+	if upload.Status != models.UploadStatusActive {
+		SendAPIError(c, uploadOffsetMismatch("Загрузка уже завершается", upload.Offset))
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		SendBadRequest(c, "Требуется заголовок Upload-Offset")
+		return
+	}
+	if offset != upload.Offset {
+		SendAPIError(c, uploadOffsetMismatch("Смещение не совпадает с принятым сервером", upload.Offset))
+		return
+	}
+
+	length := c.Request.ContentLength
+	partMax, ttl := s.uploadLimits()
+	switch {
+	case length <= 0:
+		SendBadRequest(c, "Требуется заголовок Content-Length")
+		return
+	case length > partMax:
+		SendError(c, http.StatusRequestEntityTooLarge, ErrCodeFileTooLarge, "Часть больше допустимого размера",
+			gin.H{"part_max_size": partMax})
+		return
+	case offset+length > upload.Size:
+		SendBadRequest(c, "Часть выходит за объявленный размер файла")
+		return
+	}
+
+	// Каждая часть - отдельный объект со своим ключом шифрования. Случайный суффикс не дает
+	// повторной отправке с тем же смещением перезаписать уже принятую часть
+	ctx := c.Request.Context()
+	part := models.UploadPart{
+		UploadID:   upload.ID,
+		Offset:     offset,
+		Size:       length,
+		StorageKey: fmt.Sprintf("%s%s/%d-%s", uploadPartsPrefix, upload.ID, offset, randomHex(4)),
+	}
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, length)
+	part.WrappedKey, err = s.storeEncryptedFile(ctx, part.StorageKey, body, length)
+	if err != nil {
+		s.storage.Delete(context.Background(), part.StorageKey)
+		logger.Warnf("Загрузки: Часть загрузки %s со смещением %d не сохранена: %v", upload.ID, offset, err)
+		SendInternalError(c, "Ошибка сохранения части файла")
+		return
+	}
+
+	if err := s.db.AddUploadPart(&part, time.Now().Add(ttl)); err != nil {
+		s.storage.Delete(context.Background(), part.StorageKey)
+		if errors.Is(err, database.ErrUploadConflict) {
+			// Параллельный запрос успел раньше: сообщаем актуальное состояние
+			current, getErr := s.db.GetUpload(upload.ID, upload.UserID)
+			if getErr != nil {
+				SendNotFound(c, "Загрузка не найдена")
+				return
+			}
+			SendAPIError(c, uploadOffsetMismatch("Смещение не совпадает с принятым сервером", current.Offset))
+			return
+		}
+		logger.Errorf("Загрузки: Ошибка сохранения части загрузки %s: %v", upload.ID, err)
+		SendInternalError(c, "Ошибка базы данных")
+		return
+	}
+
+	upload.Offset += length
+	upload.ExpiresAt = time.Now().Add(ttl)
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.JSON(http.StatusOK, s.newUploadResponse(upload))
+}
+
+// handleCompleteUpload собирает части в файл, сверяя SHA-256, и отправляет файл в чат так же,
+// как /api/files/upload
+func (s *Server) handleCompleteUpload(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var req CompleteUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendBadRequest(c, "Неверный формат запроса", err.Error())
+		return
+	}
+
+	upload, err := s.getUpload(c)
+	if err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	checksum := strings.ToLower(req.Checksum)
+	switch {
+	case checksum == "" && upload.Checksum == "":
+		SendBadRequest(c, "Требуется контрольная сумма SHA-256")
+		return
+	case checksum == "":
+		checksum = upload.Checksum
+	case upload.Checksum != "" && checksum != upload.Checksum:
+		SendBadRequest(c, "Контрольная сумма отличается от указанной при создании загрузки")
+		return
+	}
+	if upload.Offset != upload.Size {
+		SendAPIError(c, uploadOffsetMismatch("Файл загружен не полностью", upload.Offset))
+		return
+	}
+
+	// Права проверяются повторно: за время загрузки пользователь мог покинуть чат
+	chat, err := s.fileUploadChat(userID, upload.ChatID)
+	if err != nil {
+		SendAPIError(c, err)
+		return
+	}
+	// Квоту могли уменьшить после создания загрузки
+	if err := s.checkStorageQuota(userID, upload.Size, upload.ID); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
+	// Параллельное завершение или отправка части получат конфликт
+	_, ttl := s.uploadLimits()
+	if err := s.db.SetUploadStatus(upload.ID, models.UploadStatusActive, models.UploadStatusCompleting, time.Now().Add(ttl)); err != nil {
+		if errors.Is(err, database.ErrUploadConflict) {
+			SendAPIError(c, uploadOffsetMismatch("Загрузка уже завершается", upload.Offset))
+			return
+		}
+		logger.Errorf("Загрузки: Ошибка смены состояния загрузки %s: %v", upload.ID, err)
+		SendInternalError(c, "Ошибка базы данных")
+		return
+	}
+	// При ошибке до создания сообщения загрузку можно завершить повторно
+	reopen := func() {
+		if err := s.db.SetUploadStatus(upload.ID, models.UploadStatusCompleting, models.UploadStatusActive, time.Now().Add(ttl)); err != nil {
+			logger.Errorf("Загрузки: Ошибка возврата загрузки %s в работу: %v", upload.ID, err)
+		}
+	}
+
+	downloadToken, err := generateDownloadToken()
+	if err != nil {
+		reopen()
+		SendInternalError(c, "Ошибка генерации токена")
+		return
+	}
+
+	ctx := c.Request.Context()
+	parts, err := newUploadPartsReader(ctx, s.storage, upload)
+	if err != nil {
+		reopen()
+		logger.Errorf("Загрузки: Загрузка %s повреждена: %v", upload.ID, err)
+		SendInternalError(c, "Ошибка сборки файла")
+		return
+	}
+	defer parts.Close()
+
+	// Тип определяется по первым байтам собранного файла; несовпадение с заявленным
+	// при создании не исправить дозагрузкой, поэтому такая загрузка удаляется
+	head, err := readSniffHead(parts)
+	if err != nil {
+		reopen()
+		logger.Errorf("Загрузки: Ошибка чтения частей загрузки %s: %v", upload.ID, err)
+		SendInternalError(c, "Ошибка сборки файла")
+		return
+	}
+	msgType := models.MessageType(upload.MessageType)
+	mimeType, err := s.checkMessageFileType(msgType, upload.MimeType, head)
+	if err != nil {
+		parts.Close()
+		s.discardUpload(context.Background(), upload)
+		SendAPIError(c, err)
+		return
+	}
+
+	fileRecord := models.File{
+		FileName:      upload.FileName,
+		FileType:      messageFileType(msgType, mimeType),
+		MimeType:      mimeType,
+		DownloadToken: downloadToken,
+	}
+
+	// Контрольная сумма считается по присланным байтам, до очистки изображения от метаданных
+	hasher := sha256.New()
+	content := io.TeeReader(io.MultiReader(bytes.NewReader(head), parts), hasher)
+	if err := s.storeUploadedFile(ctx, &fileRecord, content, upload.Size); err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			// Поврежденное изображение или запись не исправить повторным завершением
+			parts.Close()
+			s.discardUpload(context.Background(), upload)
+			SendAPIError(c, apiErr)
+			return
+		}
+		reopen()
+		logger.Errorf("Загрузки: Ошибка сборки файла загрузки %s: %v", upload.ID, err)
+		SendInternalError(c, "Ошибка сборки файла")
+		return
+	}
+
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != checksum {
+		// Данные повреждены где-то по пути: части не годятся, загрузку нужно начать заново
+		s.releaseFile(context.Background(), &fileRecord)
+		s.discardUpload(context.Background(), upload)
+		logger.Warnf("Загрузки: Контрольная сумма загрузки %s не совпала (%s вместо %s)", upload.ID, actual, checksum)
+		SendError(c, http.StatusUnprocessableEntity, ErrCodeChecksumMismatch, "Контрольная сумма файла не совпадает, загрузите файл заново",
+			gin.H{"expected": checksum, "actual": actual})
+		return
+	}
+
+	response, err := s.createFileMessage(userID, chat, req.Message, &fileRecord)
+	if err != nil {
+		s.releaseFile(context.Background(), &fileRecord)
+		reopen()
+		SendAPIError(c, err)
+		return
+	}
+
+	// Части больше не нужны; если удалить их не удалось, это сделает очистка по сроку
+	s.discardUpload(context.Background(), upload)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": response,
+	})
+}
+
+// handleDeleteUpload отменяет загрузку и удаляет принятые части
+func (s *Server) handleDeleteUpload(c *gin.Context) {
+	upload, err := s.getUpload(c)
+	if err != nil {
+		SendAPIError(c, err)
+		return
+	}
+	if upload.Status != models.UploadStatusActive {
+		SendAPIError(c, uploadOffsetMismatch("Загрузка уже завершается", upload.Offset))
+		return
+	}
+
+	if err := s.discardUpload(c.Request.Context(), upload); err != nil {
+		SendInternalError(c, "Ошибка удаления загрузки")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Загрузка отменена"})
+}
+
+// getUpload находит загрузку текущего пользователя по ID из URL
+func (s *Server) getUpload(c *gin.Context) (*models.Upload, error) {
+	upload, err := s.db.GetUpload(c.Param("uploadId"), c.GetUint("userID"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound("Загрузка не найдена")
+		}
+		logger.Errorf("Загрузки: Ошибка получения загрузки: %v", err)
+		return nil, ErrInternal("Ошибка базы данных")
+	}
+	return upload, nil
+}
+
+// discardUpload удаляет объекты частей и запись о загрузке. Если какую-то часть удалить
+// не удалось, запись остается, и очистка по сроку повторит попытку
+func (s *Server) discardUpload(ctx context.Context, upload *models.Upload) error {
+	for _, part := range upload.Parts {
+		if err := s.storage.Delete(ctx, part.StorageKey); err != nil {
+			logger.Errorf("Загрузки: Ошибка удаления части %s: %v", part.StorageKey, err)
+			return err
+		}
+	}
+	if err := s.db.DeleteUpload(upload.ID); err != nil {
+		logger.Errorf("Загрузки: Ошибка удаления загрузки %s: %v", upload.ID, err)
+		return err
+	}
+	return nil
+}
+
+// cleanupUploads периодически удаляет брошенные загрузки вместе с частями
+func (s *Server) cleanupUploads() {
+	ticker := time.NewTicker(uploadCleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		uploads, err := s.db.GetExpiredUploads(time.Now(), uploadCleanupBatch)
+		if err != nil {
+			logger.Errorf("Загрузки: Ошибка получения брошенных загрузок: %v", err)
+			continue
+		}
+
+		removed := 0
+		for i := range uploads {
+			if s.discardUpload(context.Background(), &uploads[i]) == nil {
+				removed++
+			}
+		}
+		if removed > 0 {
+			logger.Infof("Загрузки: Удалено брошенных загрузок: %d", removed)
+		}
+	}
+}
+
+// uploadPartsReader последовательно читает расшифрованные части загрузки
+type uploadPartsReader struct {
+	ctx   context.Context
+	st    storage.Storage
+	parts []models.UploadPart
+
+	object  *storage.ReaderAt
+	current io.Reader
+}
+
+// newUploadPartsReader проверяет, что части покрывают файл без пропусков и наложений
+func newUploadPartsReader(ctx context.Context, st storage.Storage, upload *models.Upload) (*uploadPartsReader, error) {
+	var next int64
+	for _, part := range upload.Parts {
+		if part.Offset != next {
+			return nil, fmt.Errorf("часть со смещением %d, ожидалось %d", part.Offset, next)
+		}
+		next += part.Size
+	}
+	if next != upload.Size {
+		return nil, fmt.Errorf("части покрывают %d байт из %d", next, upload.Size)
+	}
+	return &uploadPartsReader{ctx: ctx, st: st, parts: upload.Parts}, nil
+}
+
+func (r *uploadPartsReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.parts) == 0 {
+				return 0, io.EOF
+			}
+			if err := r.openPart(r.parts[0]); err != nil {
+				return 0, err
+			}
+			r.parts = r.parts[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if errors.Is(err, io.EOF) {
+			r.Close()
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *uploadPartsReader) openPart(part models.UploadPart) error {
+	info, err := r.st.Stat(r.ctx, part.StorageKey)
+	if err != nil {
+		return fmt.Errorf("часть %s: %w", part.StorageKey, err)
+	}
+	object := storage.NewReaderAt(r.ctx, r.st, part.StorageKey, info.Size)
+	content, err := openEncryptedFile(object, info.Size, part.WrappedKey)
+	if err != nil {
+		object.Close()
+		return fmt.Errorf("часть %s: %w", part.StorageKey, err)
+	}
+	if content.Size() != part.Size {
+		object.Close()
+		return fmt.Errorf("часть %s: размер %d вместо %d", part.StorageKey, content.Size(), part.Size)
+	}
+	r.object, r.current = object, content
+	return nil
+}
+
+// Close закрывает текущую часть
+func (r *uploadPartsReader) Close() error {
+	r.current = nil
+	if r.object == nil {
+		return nil
+	}
+	err := r.object.Close()
+	r.object = nil
+	return err
+}
@@ -95,6 +95,11 @@ func (s *Server) handleCreateUser(c *gin.Context) {
 		return
 	}
 
+	if err := s.validatePassword(req.Password, req.Username); err != nil {
+		SendAPIError(c, err)
+		return
+	}
+
 	// Создаем пользователя
 	user := models.User{
 		Username: req.Username,
@@ -3,6 +3,7 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strings"
@@ -15,7 +16,6 @@ import (
 	"messenger/logger"
 	"messenger/middleware"
 	"messenger/models"
-	"messenger/utils/crypto"
 )
 
 // Константы для WebSocket
@@ -25,36 +25,32 @@ const (
 	pongWait   = 60 * time.Second
 	pingPeriod = (pongWait * 9) / 10
 
-	// Максимальный размер сообщения
-	maxMessageSize = 10 * 1024 // 10KB
-
 	// Типы сообщений WebSocket
-	WSTypeMessage = "message"
-	WSTypeTyping  = "typing"
-	WSTypeRead    = "read"
-	WSTypeError   = "error"
-	WSTypeDebug   = "debug" // Добавляем тип сообщения для отладки
+	WSTypeMessage  = "message"
+	WSTypeTyping   = "typing"
+	WSTypeRead     = "read"
+	WSTypeListened = "listened" // Прослушивание голосового сообщения
+	WSTypeError    = "error"
+	WSTypeAck      = "ack"     // Подтверждение кадра, на который нет содержательного ответа
+	WSTypePing     = "ping"    // Прикладной ping от клиента для проверки соединения
+	WSTypeDebug    = "debug"   // Добавляем тип сообщения для отладки
+	WSTypeProfile  = "profile" // Изменение профиля собеседника (имя, аватар, статус)
 )
 
-// Client представляет WebSocket клиента (для обратной совместимости)
-type Client struct {
-	server        *Server
-	conn          *websocket.Conn
-	send          chan []byte
-	userID        uint
-	authenticated bool
-	mu            sync.Mutex
-}
-
 // WSClient представляет WebSocket клиента
 type WSClient struct {
 	server        *Server
 	conn          *websocket.Conn
-	send          chan []byte
+	send          chan wsResponse // Кадры кодируются в writePump кодеком соединения
+	codec         wsCodec
 	userID        uint
+	sessionID     uint // Сессия токена, с которым клиент подключился
 	authenticated bool
-	mu            sync.Mutex
+	mu            sync.Mutex // Защищает отправку в send и его закрытие
+	closed        bool
 	clientInfo    string // Добавляем информацию о клиенте для логирования
+	limits        *wsConnLimits
+	transport     string // websocket, sse или longpoll; у sse и longpoll нет conn
 }
 
 // WSMessage представляет сообщение WebSocket
@@ -66,12 +62,14 @@ type WSMessage struct {
 // wsMessage представляет входящее сообщение от клиента
 type wsMessage struct {
 	Type    string          `json:"type"`
+	ReqID   string          `json:"req_id,omitempty"` // Идентификатор запроса, который клиент получит в ответе
 	Payload json.RawMessage `json:"payload"`
 }
 
 // wsResponse представляет исходящее сообщение к клиенту
 type wsResponse struct {
 	Type    string      `json:"type"`
+	ReqID   string      `json:"req_id,omitempty"`
 	Payload interface{} `json:"payload"`
 }
 
@@ -106,155 +104,106 @@ type debugPayload struct {
 	MessageData interface{}            `json:"message_data"`
 }
 
-// errorPayload представляет структуру данных для сообщений об ошибках
-type errorPayload struct {
-	Message string `json:"message"`
-	Code    int    `json:"code"`
-}
-
-// WebSocketHandler обрабатывает WebSocket соединения
+// WebSocketHandler - единственная точка подключения по WebSocket.
+// Токен извлекается resolveToken, Origin проверяется по списку из конфигурации
 func (s *Server) WebSocketHandler(c *gin.Context) {
-	// Получаем токен из различных источников
-	tokenString := c.Query("token")
-
-	// Добавляем расширенное логирование заголовков
-	headers := c.Request.Header
-	logger.Debugf("WebSocketHandler: Заголовки запроса: %+v", headers)
-
-	// Если токен не найден в URL, проверяем в заголовке Sec-WebSocket-Protocol
-	if tokenString == "" {
-		// Проверяем токен в заголовке Sec-WebSocket-Protocol
-		if protocols := c.GetHeader("Sec-WebSocket-Protocol"); protocols != "" {
-			logger.Debugf("Получены протоколы WebSocket: %s", protocols)
-			tokenParts := strings.Split(protocols, ", ")
-			for _, part := range tokenParts {
-				if strings.HasPrefix(part, "token=") {
-					tokenString = strings.TrimPrefix(part, "token=")
-					logger.Debugf("Найден токен в протоколе: %s...", tokenString[:10])
-					break
-				} else {
-					// Проверяем, может быть сам протокол является токеном
-					logger.Debugf("Проверяем протокол как потенциальный токен: %s", part)
-					// Сохраняем часть как потенциальный токен
-					if tokenString == "" {
-						tokenString = part
-						logger.Debugf("Используем протокол как токен: %s...", part[:10])
-					}
-				}
-			}
-		}
-	}
-
-	// Проверяем заголовок Authorization, если токен всё еще не найден
-	if tokenString == "" {
-		if authHeader := c.GetHeader("Authorization"); authHeader != "" {
-			logger.Debugf("Найден заголовок Authorization: %s", authHeader)
-			if strings.HasPrefix(authHeader, "Bearer ") {
-				tokenString = strings.TrimPrefix(authHeader, "Bearer ")
-				logger.Debugf("Извлечен токен из заголовка Authorization: %s...", tokenString[:10])
-			}
-		}
-	}
+	logger.Debugf("WebSocket: Начало обработки соединения, адрес: %s", c.Request.RemoteAddr)
 
-	// Если токен все еще не найден
+	tokenString, source := resolveToken(c)
 	if tokenString == "" {
-		logger.Warn("WebSocketHandler: Запрос без токена")
+		logger.Warn("WebSocket: Запрос без токена")
 		c.Status(http.StatusUnauthorized) // Только статус без JSON для лучшей обработки ошибок WebSocket
 		return
 	}
 
-	// Вместо установки заголовка Authorization для JWTAuth middleware,
-	// напрямую проверяем токен здесь, так как маршрут теперь публичный
+	// Маршрут публичный, поэтому токен проверяем здесь, а не в JWTAuth middleware
 	claims, err := middleware.ValidateToken(tokenString, s.config.JWT.Secret)
 	if err != nil {
-		logger.Warnf("WebSocketHandler: Недействительный токен: %v", err)
+		logger.Warnf("WebSocket: Недействительный токен %s (источник: %s): %v", maskToken(tokenString), source, err)
 		c.Status(http.StatusUnauthorized) // Только статус без JSON для лучшей обработки ошибок WebSocket
 		return
 	}
+	if err := s.authorizeClaims(claims); err != nil {
+		logger.Warnf("WebSocket: Подключение пользователя %d (сессия %d) отклонено: %v", claims.UserID, claims.SessionID, err)
+		c.Status(http.StatusUnauthorized)
+		return
+	}
 
-	// Получаем userID из проверенных claims
 	userID := claims.UserID
-	logger.Infof("WebSocketHandler: Успешная аутентификация пользователя %d", userID)
-
-	// Настраиваем upgrader для текущего запроса
-	upgrader := websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		// Отключаем проверку происхождения для отладки
-		CheckOrigin: func(r *http.Request) bool {
-			origin := r.Header.Get("Origin")
-			logger.Debugf("WebSocketHandler: Проверка Origin: %s", origin)
-			return true // Разрешаем любой Origin для отладки
-		},
-	}
+	logger.Debugf("WebSocket: Успешная аутентификация пользователя %d (источник токена: %s)", userID, source)
 
 	// Собираем информацию о клиенте для логирования
 	clientInfo := c.ClientIP() + " - " + c.Request.UserAgent()
-	logger.Debugf("WebSocketHandler: Информация о клиенте: %s", clientInfo)
-
-	// Обновляем соединение до WebSocket с указанием подпротоколов
-	protocols := strings.Split(c.GetHeader("Sec-WebSocket-Protocol"), ", ")
-	logger.Debugf("WebSocketHandler: Запрошенные протоколы: %v", protocols)
 
 	responseHeader := http.Header{}
-	// Если есть запрошенные протоколы, устанавливаем первый из них
-	if len(protocols) > 0 && protocols[0] != "" {
-		responseHeader.Set("Sec-WebSocket-Protocol", protocols[0])
-		logger.Debugf("WebSocketHandler: Устанавливаем протокол в ответе: %s", protocols[0])
+	codec, negotiated := selectWSCodec(c.Request)
+	if negotiated {
+		// Клиент запросил бинарное (или явно JSON) кодирование кадров
+		responseHeader.Set("Sec-WebSocket-Protocol", codec.Subprotocol())
+	} else if source == "subprotocol" {
+		// Браузер требует, чтобы сервер вернул один из запрошенных подпротоколов
+		responseHeader.Set("Sec-WebSocket-Protocol", websocket.Subprotocols(c.Request)[0])
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		EnableCompression: s.config.WebSocket.EnableCompression,
+		CheckOrigin:       s.checkOrigin,
 	}
 
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, responseHeader)
 	if err != nil {
-		logger.Errorf("Ошибка обновления соединения до WebSocket: %v", err)
+		logger.Errorf("WebSocket: Ошибка обновления соединения до WebSocket: %v", err)
 		return
 	}
+	s.configureWSConn(conn)
 
-	// Создаем клиента
 	client := &WSClient{
 		server:        s,
 		conn:          conn,
-		send:          make(chan []byte, 256),
+		send:          make(chan wsResponse, 256),
+		codec:         codec,
 		userID:        userID,
+		sessionID:     claims.SessionID,
 		authenticated: true,
 		clientInfo:    clientInfo,
+		limits:        newWSConnLimits(),
+		transport:     transportWebSocket,
 	}
+	s.registerClient(client)
 
-	// Сохраняем клиента в карте соединений
-	s.wsClients.Store(userID, client)
-
-	// Отправляем диагностическое сообщение клиенту
-	debugMsg := wsResponse{
-		Type: WSTypeDebug,
-		Payload: debugPayload{
-			ClientInfo: map[string]interface{}{
-				"ip":           clientInfo,
-				"join_time":    time.Now(),
-				"session_time": time.Since(time.Now()).String(),
-			},
-			UserID:      clientInfo,
-			Timestamp:   time.Now(),
-			MessageData: "WebSocket соединение установлено успешно",
-		},
-	}
-	debugJSON, _ := json.Marshal(debugMsg)
-	client.send <- debugJSON
+	// Отправляем пользователю сообщение для подтверждения соединения
+	client.sendResponse(WSTypeDebug, map[string]string{
+		"message": fmt.Sprintf("Соединение WebSocket установлено для пользователя ID=%d", userID),
+	})
 
 	// Запускаем горутины для чтения и записи
 	go client.writePump()
 	go client.readPump()
 
-	logger.Infof("Пользователь %d подключен по WebSocket (клиент: %s)", userID, clientInfo)
+	logger.Infof("Пользователь %d подключен по WebSocket (клиент: %s, кодирование: %s)", userID, clientInfo, codec.Subprotocol())
+}
+
+// configureWSConn применяет к соединению настройки из конфигурации
+func (s *Server) configureWSConn(conn *websocket.Conn) {
+	conn.SetReadLimit(int64(s.config.WebSocket.MaxMessageSize))
+	if s.config.WebSocket.EnableCompression {
+		conn.EnableWriteCompression(true)
+		if err := conn.SetCompressionLevel(s.config.WebSocket.CompressionLevel); err != nil {
+			logger.Warnf("WebSocket: Некорректный уровень сжатия %d: %v", s.config.WebSocket.CompressionLevel, err)
+		}
+	}
 }
 
 // readPump читает сообщения от клиента
 func (c *WSClient) readPump() {
 	defer func() {
-		c.server.wsClients.Delete(c.userID)
+		c.server.unregisterClient(c)
 		c.conn.Close()
 		logger.Infof("Пользователь %d отключен от WebSocket (клиент: %s)", c.userID, c.clientInfo)
 	}()
 
-	c.conn.SetReadLimit(maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error {
 		logger.Debugf("WebSocket: Получен PONG от пользователя %d (клиент: %s)", c.userID, c.clientInfo)
@@ -281,7 +230,7 @@ func (c *WSClient) readPump() {
 	}
 }
 
-// writePump отправляет сообщения клиенту
+// writePump кодирует кадры кодеком соединения и отправляет их клиенту
 func (c *WSClient) writePump() {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
@@ -292,7 +241,7 @@ func (c *WSClient) writePump() {
 
 	for {
 		select {
-		case message, ok := <-c.send:
+		case frame, ok := <-c.send:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// Канал закрыт
@@ -301,28 +250,8 @@ func (c *WSClient) writePump() {
 				return
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				logger.Errorf("WebSocket: Ошибка получения writer для пользователя %d (клиент: %s): %v", c.userID, c.clientInfo, err)
-				return
-			}
-
-			// Логируем отправляемое сообщение
-			logger.Debugf("WebSocket: Отправка сообщения пользователю %d (клиент: %s): %s", c.userID, c.clientInfo, string(message))
-
-			w.Write(message)
-
-			// Добавляем все ожидающие сообщения в текущую отправку
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte("\n"))
-				msg := <-c.send
-				logger.Debugf("WebSocket: Добавление в пакет сообщения для пользователя %d (клиент: %s): %s", c.userID, c.clientInfo, string(msg))
-				w.Write(msg)
-			}
-
-			if err := w.Close(); err != nil {
-				logger.Errorf("WebSocket: Ошибка закрытия writer для пользователя %d (клиент: %s): %v", c.userID, c.clientInfo, err)
+			if err := c.writeFrames(frame); err != nil {
+				logger.Errorf("WebSocket: Ошибка отправки пользователю %d (клиент: %s): %v", c.userID, c.clientInfo, err)
 				return
 			}
 		case <-ticker.C:
@@ -336,179 +265,145 @@ func (c *WSClient) writePump() {
 	}
 }
 
-// processMessage обрабатывает входящее сообщение
-func (c *WSClient) processMessage(msg []byte) {
-	var wsMsg wsMessage
-	if err := json.Unmarshal(msg, &wsMsg); err != nil {
-		logger.Errorf("WebSocket: Ошибка разбора JSON от пользователя %d (клиент: %s): %v", c.userID, c.clientInfo, err)
-		c.sendError("Некорректный формат сообщения")
-		return
-	}
-
-	logger.Debugf("WebSocket: Обработка сообщения типа '%s' от пользователя %d (клиент: %s)", wsMsg.Type, c.userID, c.clientInfo)
-
-	switch wsMsg.Type {
-	case WSTypeMessage:
-		var payload wsNewMessagePayload
-		if err := json.Unmarshal(wsMsg.Payload, &payload); err != nil {
-			logger.Errorf("WebSocket: Ошибка разбора payload для сообщения от пользователя %d (клиент: %s): %v", c.userID, c.clientInfo, err)
-			c.sendError("Некорректный формат данных сообщения")
-			return
-		}
-
-		// Проверка доступа к чату
-		if !c.server.db.IsUserInChat(c.userID, payload.ChatID) {
-			logger.Warnf("WebSocket: Попытка доступа к чату %d от пользователя %d (клиент: %s) запрещена", payload.ChatID, c.userID, c.clientInfo)
-			c.sendError("Доступ к чату запрещен")
-			return
-		}
-
-		// Обработка нового сообщения через функцию в messages.go
-		c.processNewMessage(payload)
-
-	case WSTypeTyping:
-		var payload typingPayload
-		if err := json.Unmarshal(wsMsg.Payload, &payload); err != nil {
-			c.sendError("Некорректный формат данных о наборе текста")
-			return
-		}
-
-		// Проверка доступа к чату
-		if !c.server.db.IsUserInChat(c.userID, payload.ChatID) {
-			c.sendError("Доступ к чату запрещен")
-			return
+// writeFrames отправляет кадр и все ожидающие в очереди кадры.
+// Текстовые JSON кадры объединяются в одно сообщение через перевод строки,
+// бинарные кадры отправляются отдельными сообщениями
+func (c *WSClient) writeFrames(first wsResponse) error {
+	frames := []wsResponse{first}
+	n := len(c.send)
+	for i := 0; i < n; i++ {
+		frames = append(frames, <-c.send)
+	}
+
+	messageType := c.codec.MessageType()
+	var w io.WriteCloser
+	written := 0
+	for _, frame := range frames {
+		data, err := c.codec.Encode(frame)
+		if err != nil {
+			logger.Errorf("WebSocket: Ошибка кодирования кадра '%s' (%s) для пользователя %d: %v", frame.Type, c.codec.Subprotocol(), c.userID, err)
+			continue
 		}
 
-		// Отправляем статус печати всем участникам чата кроме текущего
-		c.server.broadcastTypingStatus(c.userID, payload.ChatID, payload.Status)
+		logger.Debugf("WebSocket: Отправка кадра '%s' пользователю %d (клиент: %s, %d байт)", frame.Type, c.userID, c.clientInfo, len(data))
 
-	case WSTypeRead:
-		var payload readPayload
-		if err := json.Unmarshal(wsMsg.Payload, &payload); err != nil {
-			c.sendError("Некорректный формат данных о прочтении")
-			return
-		}
-
-		// Отмечаем сообщение как прочитанное
-		if err := c.server.db.MarkMessageAsRead(payload.MessageID, c.userID); err != nil {
-			c.sendError("Ошибка при отметке сообщения как прочитанного")
-			return
+		if messageType == websocket.BinaryMessage {
+			if err := c.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				return err
+			}
+			continue
 		}
 
-		// Получаем сообщение для проверки чата
-		message, err := c.server.db.GetMessageByID(payload.MessageID)
-		if err != nil {
-			c.sendError("Сообщение не найдено")
-			return
+		if w == nil {
+			if w, err = c.conn.NextWriter(websocket.TextMessage); err != nil {
+				return err
+			}
 		}
-
-		// Проверка доступа к чату
-		if !c.server.db.IsUserInChat(c.userID, message.ChatID) {
-			c.sendError("Доступ к чату запрещен")
-			return
+		if written > 0 {
+			w.Write([]byte("\n"))
 		}
+		w.Write(data)
+		written++
+	}
 
-		// Отправляем статус прочтения всем участникам чата
-		c.server.broadcastReadStatus(c.userID, message)
+	if w != nil {
+		return w.Close()
 	}
+	return nil
 }
 
-// processNewMessage обрабатывает новое сообщение из WebSocket
-func (c *WSClient) processNewMessage(payload wsNewMessagePayload) {
-	// Получаем информацию о чате
-	chat, err := c.server.db.GetChatByID(payload.ChatID)
+// processMessage разбирает входящий кадр и передает его зарегистрированному обработчику
+func (c *WSClient) processMessage(msg []byte) {
+	wsMsg, err := c.codec.Decode(msg)
 	if err != nil {
-		c.sendError("Чат не найден")
+		logger.Errorf("WebSocket: Ошибка разбора кадра (%s) от пользователя %d (клиент: %s): %v", c.codec.Subprotocol(), c.userID, c.clientInfo, err)
+		c.sendError("", ErrBadRequest("Некорректный формат сообщения"))
 		return
 	}
 
-	// Получаем информацию о пользователе
-	user, err := c.server.db.GetUserByID(c.userID)
-	if err != nil {
-		c.sendError("Ошибка получения данных пользователя")
-		return
-	}
+	logger.Debugf("WebSocket: Обработка сообщения типа '%s' (req_id: '%s') от пользователя %d (клиент: %s)", wsMsg.Type, wsMsg.ReqID, c.userID, c.clientInfo)
 
-	// Шифруем содержимое сообщения
-	encryptedContent, err := crypto.Encrypt([]byte(payload.Content))
-	if err != nil {
-		c.sendError("Ошибка шифрования сообщения")
+	// Проверяем лимиты до обработки, чтобы поток кадров не доходил до базы данных
+	if allowed, retryAfter := c.checkRateLimit(wsMsg.Type); !allowed {
+		c.rejectRateLimited(&wsMsg, retryAfter)
 		return
 	}
 
-	// Создаем новое сообщение
-	message := models.Message{
-		ChatID:    payload.ChatID,
-		UserID:    c.userID,
-		Content:   encryptedContent,
-		Type:      payload.Type,
-		PlainText: payload.Content, // Для ответа клиенту
-	}
-
-	// Сохраняем сообщение в базе данных
-	if err := c.server.db.CreateMessage(&message); err != nil {
-		c.sendError("Ошибка сохранения сообщения")
+	handler, ok := c.server.wsHandlers[wsMsg.Type]
+	if !ok {
+		logger.Warnf("WebSocket: Неизвестный тип сообщения '%s' от пользователя %d", wsMsg.Type, c.userID)
+		c.sendError(wsMsg.ReqID, ErrBadRequest("Неизвестный тип сообщения", gin.H{"type": wsMsg.Type}))
 		return
 	}
 
-	// Обновляем время последней активности чата
-	chat.LastActivity = time.Now()
-	if err := c.server.db.UpdateChat(chat); err != nil {
-		logger.Errorf("Ошибка обновления времени активности чата: %v", err)
+	result, err := handler(c, &wsMsg)
+	if err != nil {
+		c.sendError(wsMsg.ReqID, err)
+		return
 	}
 
-	// Формируем ответное сообщение
-	msgResponse := messageResponse{
-		ID:        message.ID,
-		ChatID:    message.ChatID,
-		UserID:    message.UserID,
-		Content:   payload.Content, // Отправляем открытый текст в ответе
-		Type:      message.Type,
-		CreatedAt: message.CreatedAt,
+	// Ответ на кадр имеет тот же тип, что и запрос; если ответа нет, подтверждаем получение
+	if result != nil {
+		c.sendReply(wsMsg.ReqID, wsMsg.Type, result)
+	} else if wsMsg.ReqID != "" {
+		c.sendReply(wsMsg.ReqID, WSTypeAck, nil)
 	}
-
-	// Добавляем информацию о пользователе
-	msgResponse.User.ID = user.ID
-	msgResponse.User.Username = user.Username
-	msgResponse.User.Avatar = user.Avatar
-
-	// Отправляем сообщение текущему пользователю
-	c.sendResponse(WSTypeMessage, msgResponse)
-
-	// Отправляем сообщение другим участникам чата
-	c.broadcastMessageToChat(payload.ChatID, msgResponse)
 }
 
-// sendResponse отправляет ответ клиенту
+// sendResponse отправляет клиенту кадр, не связанный с его запросом
 func (c *WSClient) sendResponse(msgType string, payload interface{}) {
-	response := wsResponse{
+	c.sendFrame(wsResponse{
 		Type:    msgType,
 		Payload: payload,
-	}
+	})
+}
 
-	data, err := json.Marshal(response)
-	if err != nil {
-		logger.Errorf("Ошибка маршалинга ответа: %v", err)
-		return
-	}
+// sendReply отправляет ответ на кадр клиента, повторяя его req_id
+func (c *WSClient) sendReply(reqID, msgType string, payload interface{}) {
+	c.sendFrame(wsResponse{
+		Type:    msgType,
+		ReqID:   reqID,
+		Payload: payload,
+	})
+}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// sendError отправляет клиенту структурированную ошибку с тем же кодом, что и в REST API
+func (c *WSClient) sendError(reqID string, err error) {
+	apiErr := toAPIError(err)
+	c.sendReply(reqID, WSTypeError, apiErr.Response())
+}
 
+// sendFrame ставит кадр в очередь отправки, кодирование выполняет транспорт клиента.
+// При переполнении очереди клиент отключается
+func (c *WSClient) sendFrame(frame wsResponse) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
 	select {
-	case c.send <- data:
+	case c.send <- frame:
 		// Успешно отправлено в канал
+		c.mu.Unlock()
+		return
 	default:
-		// Буфер полон, закрываем соединение
-		c.server.wsClients.Delete(c.userID)
-		close(c.send)
-		logger.Warnf("Клиент %d отключен: буфер полон", c.userID)
 	}
+	c.mu.Unlock()
+
+	// Буфер полон, отключаем клиента
+	logger.Warnf("Клиент %d отключен: буфер полон (транспорт: %s)", c.userID, c.transport)
+	c.server.unregisterClient(c)
 }
 
-// sendError отправляет сообщение об ошибке клиенту
-func (c *WSClient) sendError(message string) {
-	c.sendResponse(WSTypeError, gin.H{"message": message})
+// closeSend закрывает очередь отправки; writePump или обработчик SSE/long-poll завершаются следом
+func (c *WSClient) closeSend() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
 }
 
 // broadcastTypingStatus отправляет статус набора текста всем участникам чата
@@ -527,69 +422,64 @@ func (s *Server) broadcastTypingStatus(senderID, chatID uint, status bool) {
 		"status":  status,
 	}
 
-	// Отправляем статус каждому участнику чата кроме отправителя
+	// Отправляем статус каждому участнику чата кроме отправителя и связанных с ним блокировкой
+	peers := s.blockPeerSet(senderID)
 	for _, user := range users {
-		if user.ID == senderID {
+		if user.ID == senderID || peers[user.ID] {
 			continue
 		}
 
-		// Получаем WebSocket клиента для пользователя
-		if clientObj, ok := s.wsClients.Load(user.ID); ok {
-			if client, ok := clientObj.(*WSClient); ok {
-				client.sendResponse(WSTypeTyping, typingData)
-			}
-		}
+		s.sendToUser(user.ID, wsResponse{Type: WSTypeTyping, Payload: typingData})
 	}
 }
 
-// broadcastMessageToChat отправляет сообщение всем участникам чата кроме отправителя
-func (c *WSClient) broadcastMessageToChat(chatID uint, message messageResponse) {
+// broadcastReadStatus отправляет статус прочтения сообщения
+func (s *Server) broadcastReadStatus(userID uint, message *models.Message) {
+	// Данные о прочтении
+	readData := gin.H{
+		"user_id":    userID,
+		"message_id": message.ID,
+		"chat_id":    message.ChatID,
+	}
+
 	// Получаем всех участников чата
-	users, err := c.server.db.GetChatUsers(chatID)
+	users, err := s.db.GetChatUsers(message.ChatID)
 	if err != nil {
 		logger.Errorf("Ошибка получения участников чата: %v", err)
 		return
 	}
 
-	// Отправляем сообщение каждому участнику чата кроме отправителя
+	// Отправляем статус каждому участнику чата, кроме связанных с читателем блокировкой
+	peers := s.blockPeerSet(userID)
 	for _, user := range users {
-		if user.ID == c.userID {
+		if peers[user.ID] {
 			continue
 		}
-
-		// Получаем WebSocket клиента для пользователя
-		if clientObj, ok := c.server.wsClients.Load(user.ID); ok {
-			if client, ok := clientObj.(*WSClient); ok {
-				client.sendResponse(WSTypeMessage, message)
-			}
-		}
+		s.sendToUser(user.ID, wsResponse{Type: WSTypeRead, Payload: readData})
 	}
 }
 
-// broadcastReadStatus отправляет статус прочтения сообщения
-func (s *Server) broadcastReadStatus(userID uint, message *models.Message) {
-	// Данные о прочтении
-	readData := gin.H{
+// broadcastListenedStatus отправляет отметку о прослушивании голосового сообщения участникам
+// чата, кроме связанных со слушателем блокировкой
+func (s *Server) broadcastListenedStatus(userID uint, message *models.Message) {
+	listenedData := gin.H{
 		"user_id":    userID,
 		"message_id": message.ID,
 		"chat_id":    message.ChatID,
 	}
 
-	// Получаем всех участников чата
 	users, err := s.db.GetChatUsers(message.ChatID)
 	if err != nil {
 		logger.Errorf("Ошибка получения участников чата: %v", err)
 		return
 	}
 
-	// Отправляем статус каждому участнику чата
+	peers := s.blockPeerSet(userID)
 	for _, user := range users {
-		// Получаем WebSocket клиента для пользователя
-		if clientObj, ok := s.wsClients.Load(user.ID); ok {
-			if client, ok := clientObj.(*WSClient); ok {
-				client.sendResponse(WSTypeRead, readData)
-			}
+		if peers[user.ID] {
+			continue
 		}
+		s.sendToUser(user.ID, wsResponse{Type: WSTypeListened, Payload: listenedData})
 	}
 }
 
@@ -608,18 +498,10 @@ func (c *WSClient) sendDebugMessage(data interface{}) {
 		MessageData: data,
 	}
 
-	msg := WSMessage{
+	c.send <- wsResponse{
 		Type:    WSTypeDebug,
 		Payload: payload,
 	}
-
-	msgBytes, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("WebSocket: Ошибка при формировании JSON отладочного сообщения: %v", err)
-		return
-	}
-
-	c.send <- msgBytes
 }
 
 // maskToken маскирует токен для безопасного отображения в логах
@@ -645,27 +527,3 @@ func getUserIP(r *http.Request) string {
 	// Иначе берем RemoteAddr из запроса
 	return strings.Split(r.RemoteAddr, ":")[0]
 }
-
-// sendErrorMessage отправляет сообщение об ошибке клиенту
-func (c *WSClient) sendErrorMessage(message string) {
-	log.Printf("WebSocket: Отправка сообщения об ошибке клиенту user_id=%d, ip=%s: %s",
-		c.userID, c.clientInfo, message)
-
-	payload := errorPayload{
-		Code:    400, // используем код по умолчанию
-		Message: message,
-	}
-
-	msg := WSMessage{
-		Type:    WSTypeError,
-		Payload: payload,
-	}
-
-	msgBytes, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("WebSocket: Ошибка при формировании JSON сообщения об ошибке: %v", err)
-		return
-	}
-
-	c.send <- msgBytes
-}
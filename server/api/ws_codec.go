@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Подпротоколы WebSocket, определяющие кодирование кадров
+const (
+	WSProtocolJSON    = "json"
+	WSProtocolMsgpack = "msgpack"
+	WSProtocolCBOR    = "cbor"
+)
+
+// wsCodec кодирует и декодирует логический конверт кадра (type, req_id, payload)
+type wsCodec interface {
+	// Subprotocol возвращает имя подпротокола кодека
+	Subprotocol() string
+	// MessageType возвращает тип WebSocket сообщения (текстовый или бинарный)
+	MessageType() int
+	Encode(frame wsResponse) ([]byte, error)
+	Decode(data []byte) (wsMessage, error)
+}
+
+// wsCodecs содержит поддерживаемые кодеки по имени подпротокола
+var wsCodecs = map[string]wsCodec{
+	WSProtocolJSON:    jsonCodec{},
+	WSProtocolMsgpack: msgpackCodec{},
+	WSProtocolCBOR:    newCBORCodec(),
+}
+
+// selectWSCodec выбирает кодек по первому поддерживаемому подпротоколу из Sec-WebSocket-Protocol.
+// Если клиент не запросил ни одного известного подпротокола, используется JSON без согласования
+func selectWSCodec(r *http.Request) (wsCodec, bool) {
+	for _, protocol := range websocket.Subprotocols(r) {
+		if codec, ok := wsCodecs[strings.ToLower(protocol)]; ok {
+			return codec, true
+		}
+	}
+	return jsonCodec{}, false
+}
+
+// isWSCodecProtocol сообщает, является ли подпротокол именем кодека (а не токеном)
+func isWSCodecProtocol(protocol string) bool {
+	_, ok := wsCodecs[strings.ToLower(protocol)]
+	return ok
+}
+
+// wsBinaryFrame - конверт входящего кадра для бинарных кодеков
+type wsBinaryFrame struct {
+	Type    string      `json:"type"`
+	ReqID   string      `json:"req_id,omitempty"`
+	Payload interface{} `json:"payload"`
+}
+
+// toWSMessage приводит payload бинарного кадра к JSON, с которым работают обработчики
+func (f wsBinaryFrame) toWSMessage() (wsMessage, error) {
+	msg := wsMessage{Type: f.Type, ReqID: f.ReqID}
+	if f.Payload == nil {
+		return msg, nil
+	}
+
+	payload, err := json.Marshal(f.Payload)
+	if err != nil {
+		return msg, fmt.Errorf("ошибка преобразования payload: %w", err)
+	}
+	msg.Payload = payload
+	return msg, nil
+}
+
+// binaryPayload раскрывает заранее сериализованный JSON, чтобы бинарный кодек не передал его как байты
+func binaryPayload(frame wsResponse) (wsResponse, error) {
+	raw, ok := frame.Payload.(json.RawMessage)
+	if !ok {
+		return frame, nil
+	}
+
+	var payload interface{}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return frame, fmt.Errorf("ошибка разбора JSON payload: %w", err)
+	}
+	frame.Payload = payload
+	return frame, nil
+}
+
+// jsonCodec - текстовые JSON кадры (по умолчанию)
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string { return WSProtocolJSON }
+
+func (jsonCodec) MessageType() int { return websocket.TextMessage }
+
+func (jsonCodec) Encode(frame wsResponse) ([]byte, error) {
+	return json.Marshal(frame)
+}
+
+func (jsonCodec) Decode(data []byte) (wsMessage, error) {
+	var msg wsMessage
+	err := json.Unmarshal(data, &msg)
+	return msg, err
+}
+
+// msgpackCodec - бинарные кадры MessagePack с теми же именами полей, что и в JSON
+type msgpackCodec struct{}
+
+func (msgpackCodec) Subprotocol() string { return WSProtocolMsgpack }
+
+func (msgpackCodec) MessageType() int { return websocket.BinaryMessage }
+
+func (msgpackCodec) Encode(frame wsResponse) ([]byte, error) {
+	frame, err := binaryPayload(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(frame); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Decode(data []byte) (wsMessage, error) {
+	var frame wsBinaryFrame
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(&frame); err != nil {
+		return wsMessage{}, err
+	}
+	return frame.toWSMessage()
+}
+
+// cborCodec - бинарные кадры CBOR (RFC 8949), поля структур берутся из json тегов.
+// Время передается строкой RFC 3339, как в JSON; нулевое время кодируется как null
+type cborCodec struct {
+	enc cbor.EncMode
+	dec cbor.DecMode
+}
+
+func newCBORCodec() cborCodec {
+	enc, err := cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	if err != nil {
+		panic(fmt.Sprintf("ошибка настройки CBOR кодировщика: %v", err))
+	}
+
+	// Карты декодируем со строковыми ключами, чтобы payload можно было преобразовать в JSON
+	dec, err := cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}{})}.DecMode()
+	if err != nil {
+		panic(fmt.Sprintf("ошибка настройки CBOR декодировщика: %v", err))
+	}
+
+	return cborCodec{enc: enc, dec: dec}
+}
+
+func (cborCodec) Subprotocol() string { return WSProtocolCBOR }
+
+func (cborCodec) MessageType() int { return websocket.BinaryMessage }
+
+func (c cborCodec) Encode(frame wsResponse) ([]byte, error) {
+	frame, err := binaryPayload(frame)
+	if err != nil {
+		return nil, err
+	}
+	return c.enc.Marshal(frame)
+}
+
+func (c cborCodec) Decode(data []byte) (wsMessage, error) {
+	var frame wsBinaryFrame
+	if err := c.dec.Unmarshal(data, &frame); err != nil {
+		return wsMessage{}, err
+	}
+	return frame.toWSMessage()
+}
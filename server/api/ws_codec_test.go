@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"messenger/models"
+)
+
+// testMessageFrame возвращает типичный кадр о новом сообщении с файлом
+func testMessageFrame() wsResponse {
+	fileID := uint(314)
+	createdAt := time.Date(2026, 10, 19, 12, 30, 45, 123456789, time.UTC)
+	msg := messageResponse{
+		ID:        1024,
+		ChatID:    42,
+		UserID:    7,
+		Content:   "Привет! Отправляю отчет за квартал, посмотри, пожалуйста, до завтра",
+		Type:      string(models.MessageTypeFile),
+		FileID:    &fileID,
+		CreatedAt: createdAt,
+		File: &models.File{
+			ID:        fileID,
+			MessageID: 1024,
+			FileName:  "report-q3.pdf",
+			FileSize:  482133,
+			FileType:  models.FileTypeDocument,
+			MimeType:  "application/pdf",
+			CreatedAt: createdAt,
+			UpdatedAt: createdAt,
+		},
+	}
+	msg.User.ID = 7
+	msg.User.Username = "alice"
+	msg.User.Avatar = "/api/avatars/0123456789abcdef0123456789abcdef"
+
+	return wsResponse{Type: WSTypeMessage, ReqID: "c1f0a7", Payload: msg}
+}
+
+// normalizedPayload приводит payload к значениям encoding/json для сравнения
+func normalizedPayload(t testing.TB, payload json.RawMessage) interface{} {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		t.Fatalf("payload не является JSON: %v", err)
+	}
+	return value
+}
+
+func TestWSCodecsRoundTrip(t *testing.T) {
+	frame := testMessageFrame()
+	raw, err := json.Marshal(frame.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := normalizedPayload(t, raw)
+
+	// Заранее сериализованный payload (json.RawMessage) должен давать тот же конверт
+	frames := map[string]wsResponse{
+		"struct": frame,
+		"raw":    {Type: frame.Type, ReqID: frame.ReqID, Payload: json.RawMessage(raw)},
+	}
+
+	for name, codec := range wsCodecs {
+		for kind, f := range frames {
+			data, err := codec.Encode(f)
+			if err != nil {
+				t.Fatalf("%s/%s: ошибка кодирования: %v", name, kind, err)
+			}
+			msg, err := codec.Decode(data)
+			if err != nil {
+				t.Fatalf("%s/%s: ошибка декодирования: %v", name, kind, err)
+			}
+
+			if msg.Type != frame.Type || msg.ReqID != frame.ReqID {
+				t.Errorf("%s/%s: конверт %q/%q, ожидался %q/%q", name, kind, msg.Type, msg.ReqID, frame.Type, frame.ReqID)
+			}
+			if got := normalizedPayload(t, msg.Payload); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s/%s: payload отличается от JSON:\n got: %v\nwant: %v", name, kind, got, want)
+			}
+		}
+	}
+}
+
+func TestWSCodecsEmptyPayload(t *testing.T) {
+	for name, codec := range wsCodecs {
+		data, err := codec.Encode(wsResponse{Type: WSTypeAck, ReqID: "r1"})
+		if err != nil {
+			t.Fatalf("%s: ошибка кодирования: %v", name, err)
+		}
+		msg, err := codec.Decode(data)
+		if err != nil {
+			t.Fatalf("%s: ошибка декодирования: %v", name, err)
+		}
+		if msg.Type != WSTypeAck || msg.ReqID != "r1" {
+			t.Errorf("%s: получен конверт %q/%q", name, msg.Type, msg.ReqID)
+		}
+		if len(msg.Payload) > 0 && string(msg.Payload) != "null" {
+			t.Errorf("%s: пустой payload декодирован как %s", name, msg.Payload)
+		}
+	}
+}
+
+func benchmarkEncode(b *testing.B, codec wsCodec) {
+	frame := testMessageFrame()
+	data, err := codec.Encode(frame)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := codec.Encode(frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+	// Размер кадра - главное, ради чего выбирают бинарное кодирование
+	b.ReportMetric(float64(len(data)), "bytes/frame")
+}
+
+func benchmarkDecode(b *testing.B, codec wsCodec) {
+	data, err := codec.Encode(testMessageFrame())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := codec.Decode(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeJSON(b *testing.B)    { benchmarkEncode(b, wsCodecs[WSProtocolJSON]) }
+func BenchmarkEncodeMsgpack(b *testing.B) { benchmarkEncode(b, wsCodecs[WSProtocolMsgpack]) }
+func BenchmarkEncodeCBOR(b *testing.B)    { benchmarkEncode(b, wsCodecs[WSProtocolCBOR]) }
+
+func BenchmarkDecodeJSON(b *testing.B)    { benchmarkDecode(b, wsCodecs[WSProtocolJSON]) }
+func BenchmarkDecodeMsgpack(b *testing.B) { benchmarkDecode(b, wsCodecs[WSProtocolMsgpack]) }
+func BenchmarkDecodeCBOR(b *testing.B)    { benchmarkDecode(b, wsCodecs[WSProtocolCBOR]) }
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+
+	"messenger/logger"
+)
+
+// WSHandlerFunc обрабатывает входящий кадр определенного типа.
+// Непустой результат отправляется клиенту кадром того же типа с req_id запроса,
+// ошибка - кадром "error" (APIError передается клиенту как есть).
+type WSHandlerFunc func(c *WSClient, msg *wsMessage) (interface{}, error)
+
+// RegisterWSHandler регистрирует обработчик для типа кадра.
+// Регистрация выполняется до запуска сервера, повторная регистрация заменяет обработчик.
+func (s *Server) RegisterWSHandler(frameType string, handler WSHandlerFunc) {
+	if _, exists := s.wsHandlers[frameType]; exists {
+		logger.Warnf("WebSocket: Обработчик для типа '%s' будет заменен", frameType)
+	}
+	s.wsHandlers[frameType] = handler
+}
+
+// registerWSHandlers регистрирует стандартные обработчики кадров
+func (s *Server) registerWSHandlers() {
+	s.RegisterWSHandler(WSTypeMessage, handleWSMessage)
+	s.RegisterWSHandler(WSTypeTyping, handleWSTyping)
+	s.RegisterWSHandler(WSTypeRead, handleWSRead)
+	s.RegisterWSHandler(WSTypeListened, handleWSListened)
+	s.RegisterWSHandler(WSTypePing, handleWSPing)
+}
+
+// handleWSPing отвечает подтверждением на прикладной ping клиента
+func handleWSPing(c *WSClient, msg *wsMessage) (interface{}, error) {
+	return nil, nil
+}
+
+// handleWSMessage обрабатывает отправку нового сообщения в чат
+func handleWSMessage(c *WSClient, msg *wsMessage) (interface{}, error) {
+	var payload wsNewMessagePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		logger.Errorf("WebSocket: Ошибка разбора payload для сообщения от пользователя %d (клиент: %s): %v", c.userID, c.clientInfo, err)
+		return nil, ErrBadRequest("Некорректный формат данных сообщения")
+	}
+
+	return c.server.postChatMessage(c.userID, payload.ChatID, payload.Content, payload.Type)
+}
+
+// handleWSTyping рассылает статус набора текста участникам чата
+func handleWSTyping(c *WSClient, msg *wsMessage) (interface{}, error) {
+	var payload typingPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return nil, ErrBadRequest("Некорректный формат данных о наборе текста")
+	}
+
+	return nil, c.server.setTypingStatus(c.userID, payload.ChatID, payload.Status)
+}
+
+// handleWSRead отмечает сообщение как прочитанное и уведомляет участников чата
+func handleWSRead(c *WSClient, msg *wsMessage) (interface{}, error) {
+	var payload readPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return nil, ErrBadRequest("Некорректный формат данных о прочтении")
+	}
+
+	return nil, c.server.markMessageRead(c.userID, payload.MessageID)
+}
+
+// handleWSListened отмечает голосовое сообщение как прослушанное и уведомляет участников чата
+func handleWSListened(c *WSClient, msg *wsMessage) (interface{}, error) {
+	var payload readPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return nil, ErrBadRequest("Некорректный формат данных о прослушивании")
+	}
+
+	return nil, c.server.markMessageListened(c.userID, payload.MessageID)
+}
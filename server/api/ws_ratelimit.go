@@ -0,0 +1,167 @@
+package api
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"messenger/config"
+	"messenger/logger"
+	"messenger/ratelimit"
+)
+
+// Тип кадра, лимиты которого применяются к типам без собственной записи в конфигурации
+const wsDefaultLimitKey = "*"
+
+// wsRateLimiter хранит лимиты кадров из конфигурации и общие для всех соединений бакеты пользователей
+type wsRateLimiter struct {
+	limits          map[string]config.WSFrameLimit
+	userLimiters    map[string]*ratelimit.KeyedLimiter // тип кадра -> бакеты по пользователям
+	maxViolations   int
+	violationWindow time.Duration
+}
+
+// wsConnLimits хранит бакеты одного соединения и учет превышений лимитов
+type wsConnLimits struct {
+	mu              sync.Mutex
+	buckets         map[string]*ratelimit.Bucket
+	violations      int
+	violationsSince time.Time
+}
+
+func newWSRateLimiter(cfg *config.Config) *wsRateLimiter {
+	l := &wsRateLimiter{
+		limits:          cfg.WebSocket.RateLimits,
+		userLimiters:    make(map[string]*ratelimit.KeyedLimiter),
+		maxViolations:   cfg.WebSocket.MaxViolations,
+		violationWindow: time.Duration(cfg.WebSocket.ViolationWindow) * time.Second,
+	}
+
+	for frameType, limit := range l.limits {
+		if limit.User.Rate > 0 {
+			userLimiter := ratelimit.NewKeyedLimiter(limit.User.Rate, limit.User.Burst)
+			userLimiter.Cleanup(10*time.Minute, 10*time.Minute)
+			l.userLimiters[frameType] = userLimiter
+		}
+	}
+
+	return l
+}
+
+// limitKey возвращает ключ конфигурации, лимиты которого применяются к типу кадра
+func (l *wsRateLimiter) limitKey(frameType string) string {
+	if _, ok := l.limits[frameType]; ok {
+		return frameType
+	}
+	return wsDefaultLimitKey
+}
+
+func newWSConnLimits() *wsConnLimits {
+	return &wsConnLimits{buckets: make(map[string]*ratelimit.Bucket)}
+}
+
+// checkRateLimit проверяет лимиты соединения и пользователя для типа кадра.
+// Возвращает false и время, через которое можно повторить кадр, если лимит превышен
+func (c *WSClient) checkRateLimit(frameType string) (bool, time.Duration) {
+	limiter := c.server.wsLimiter
+	key := limiter.limitKey(frameType)
+	limit, ok := limiter.limits[key]
+	if !ok {
+		return true, 0
+	}
+
+	// Лимит соединения
+	if limit.Connection.Rate > 0 {
+		c.limits.mu.Lock()
+		bucket, exists := c.limits.buckets[key]
+		if !exists {
+			bucket = ratelimit.NewBucket(limit.Connection.Rate, limit.Connection.Burst)
+			c.limits.buckets[key] = bucket
+		}
+		c.limits.mu.Unlock()
+
+		if allowed, retryAfter := bucket.Allow(); !allowed {
+			return false, retryAfter
+		}
+	}
+
+	// Лимит пользователя (общий для всех его соединений)
+	return limiter.allowUser(frameType, c.userID)
+}
+
+// allowUser расходует токен бакета пользователя для типа кадра. Бакет общий для всех
+// транспортов: кадров WebSocket, SSE/long-poll и их REST-аналогов
+func (l *wsRateLimiter) allowUser(frameType string, userID uint) (bool, time.Duration) {
+	userLimiter, exists := l.userLimiters[l.limitKey(frameType)]
+	if !exists {
+		return true, 0
+	}
+	return userLimiter.Allow(strconv.FormatUint(uint64(userID), 10))
+}
+
+// checkRESTRateLimit применяет к REST-аналогу кадра лимит пользователя этого кадра.
+// При превышении отвечает 429 с Retry-After и возвращает false
+func (s *Server) checkRESTRateLimit(c *gin.Context, frameType string) bool {
+	userID := c.GetUint("userID")
+	allowed, retryAfter := s.wsLimiter.allowUser(frameType, userID)
+	if allowed {
+		return true
+	}
+
+	logger.Debugf("REST: Запрос '%s' от пользователя %d отклонен лимитом, повтор через %s", frameType, userID, retryAfter)
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	SendAPIError(c, ErrRateLimited(retryAfter))
+	return false
+}
+
+// registerViolation учитывает превышение лимита и сообщает, пора ли отключить клиента
+func (c *WSClient) registerViolation() bool {
+	limiter := c.server.wsLimiter
+	if limiter.maxViolations <= 0 {
+		return false
+	}
+
+	c.limits.mu.Lock()
+	defer c.limits.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.limits.violationsSince) > limiter.violationWindow {
+		c.limits.violations = 0
+		c.limits.violationsSince = now
+	}
+	c.limits.violations++
+
+	return c.limits.violations >= limiter.maxViolations
+}
+
+// rejectRateLimited отвечает на кадр сверх лимита и отключает клиента при систематических нарушениях
+func (c *WSClient) rejectRateLimited(msg *wsMessage, retryAfter time.Duration) {
+	if c.registerViolation() {
+		logger.Warnf("WebSocket: Пользователь %d (клиент: %s) отключен за превышение лимитов", c.userID, c.clientInfo)
+		c.closeWithReason(websocket.ClosePolicyViolation, "rate limit exceeded")
+		return
+	}
+
+	logger.Debugf("WebSocket: Кадр '%s' от пользователя %d отклонен лимитом, повтор через %s", msg.Type, c.userID, retryAfter)
+	c.sendError(msg.ReqID, ErrRateLimited(retryAfter))
+}
+
+// closeWithReason отправляет клиенту close-кадр с кодом и причиной и закрывает соединение.
+// У клиентов без WebSocket соединения просто закрывается поток событий
+func (c *WSClient) closeWithReason(code int, reason string) {
+	if c.conn == nil {
+		// SSE и long-poll: закрытие очереди завершает поток событий
+		c.server.unregisterClient(c)
+		return
+	}
+
+	deadline := time.Now().Add(writeWait)
+	if err := c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline); err != nil {
+		logger.Debugf("WebSocket: Ошибка отправки close-кадра пользователю %d: %v", c.userID, err)
+	}
+	c.conn.Close()
+}
@@ -0,0 +1,99 @@
+// Пакет auth содержит подключаемые способы проверки логина и пароля
+// (локальная база, LDAP / Active Directory)
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Источники учетных записей (поле User.AuthSource)
+const (
+	SourceLocal = "local"
+	SourceLDAP  = "ldap"
+)
+
+var (
+	// ErrUnknownUser - способ не знает такого пользователя, можно попробовать следующий
+	ErrUnknownUser = errors.New("пользователь не найден")
+	// ErrInvalidCredentials - пользователь найден, но пароль неверный или учетная запись отключена
+	ErrInvalidCredentials = errors.New("неверное имя пользователя или пароль")
+)
+
+// Identity описывает пользователя, подтвержденного способом аутентификации
+type Identity struct {
+	Source      string   // Источник учетной записи (SourceLocal, SourceLDAP)
+	Username    string   // Имя пользователя в мессенджере
+	Email       string   // Email из каталога, если есть
+	DisplayName string   // Отображаемое имя из каталога, если есть
+	Groups      []string // Группы каталога для сопоставления ролей
+	Disabled    bool     // Учетная запись отключена в каталоге
+}
+
+// Authenticator проверяет логин и пароль.
+// Возвращает ErrUnknownUser, если пользователь ему неизвестен,
+// и ErrInvalidCredentials при неверном пароле
+type Authenticator interface {
+	Name() string
+	Authenticate(ctx context.Context, username, password string) (*Identity, error)
+}
+
+// Directory - каталог пользователей, который можно опрашивать без пароля пользователя.
+// Используется периодической синхронизацией для блокировки уволенных сотрудников
+type Directory interface {
+	Name() string
+	Lookup(ctx context.Context, username string) (*Identity, error)
+	// RoleFor возвращает роль по группам каталога; пустая строка - роль не управляется каталогом
+	RoleFor(groups []string) string
+}
+
+// Chain перебирает способы аутентификации по порядку.
+// Следующий способ пробуется, только если предыдущий не знает пользователя
+// или недоступен; неверный пароль прерывает перебор
+type Chain []Authenticator
+
+// Authenticate проверяет логин и пароль способами цепочки.
+// Возвращает также имя сработавшего способа для логов
+func (c Chain) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	var lastErr error = ErrUnknownUser
+	for _, authenticator := range c {
+		identity, err := authenticator.Authenticate(ctx, username, password)
+		if err == nil {
+			return identity, nil
+		}
+		if errors.Is(err, ErrInvalidCredentials) {
+			return nil, err
+		}
+		// Ошибки, кроме ErrUnknownUser (например, недоступность каталога), запоминаем,
+		// чтобы вернуть их, если ни один способ не подошел
+		if !errors.Is(err, ErrUnknownUser) || lastErr == ErrUnknownUser {
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+// MapRole выбирает роль по группам: admin имеет приоритет, иначе первая найденная.
+// Группы сравниваются без учета регистра (DN в LDAP регистронезависимы).
+// Если ни одна группа не найдена, возвращается defaultRole
+func MapRole(groups []string, mapping map[string]string, defaultRole string) string {
+	role := ""
+	for _, group := range groups {
+		for name, mapped := range mapping {
+			if !strings.EqualFold(name, group) {
+				continue
+			}
+			if mapped == "admin" {
+				return mapped
+			}
+			if role == "" {
+				role = mapped
+			}
+		}
+	}
+	if role == "" {
+		role = defaultRole
+	}
+	return role
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// stubAuthenticator отвечает заранее заданным результатом и запоминает вызовы
+type stubAuthenticator struct {
+	name  string
+	err   error
+	calls *[]string
+}
+
+func (a stubAuthenticator) Name() string { return a.name }
+
+func (a stubAuthenticator) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	*a.calls = append(*a.calls, a.name)
+	if a.err != nil {
+		return nil, a.err
+	}
+	return &Identity{Source: a.name, Username: username}, nil
+}
+
+func TestChainOrder(t *testing.T) {
+	errUnavailable := errors.New("каталог недоступен")
+
+	tests := []struct {
+		name       string
+		results    []error // Результат каждого способа цепочки по порядку
+		wantSource string
+		wantErr    error
+		wantCalls  []string
+	}{
+		{
+			name:       "первый подходящий способ",
+			results:    []error{nil, nil},
+			wantSource: "first",
+			wantCalls:  []string{"first"},
+		},
+		{
+			name:       "неизвестный пользователь передается дальше",
+			results:    []error{ErrUnknownUser, nil},
+			wantSource: "second",
+			wantCalls:  []string{"first", "second"},
+		},
+		{
+			name:      "неверный пароль прерывает перебор",
+			results:   []error{ErrInvalidCredentials, nil},
+			wantErr:   ErrInvalidCredentials,
+			wantCalls: []string{"first"},
+		},
+		{
+			name:       "недоступный способ пропускается",
+			results:    []error{errUnavailable, nil},
+			wantSource: "second",
+			wantCalls:  []string{"first", "second"},
+		},
+		{
+			name:      "ошибка недоступности важнее неизвестного пользователя",
+			results:   []error{errUnavailable, ErrUnknownUser},
+			wantErr:   errUnavailable,
+			wantCalls: []string{"first", "second"},
+		},
+		{
+			name:      "никто не знает пользователя",
+			results:   []error{ErrUnknownUser, ErrUnknownUser},
+			wantErr:   ErrUnknownUser,
+			wantCalls: []string{"first", "second"},
+		},
+		{
+			name:    "пустая цепочка",
+			wantErr: ErrUnknownUser,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			var chain Chain
+			for i, err := range tt.results {
+				name := []string{"first", "second"}[i]
+				chain = append(chain, stubAuthenticator{name: name, err: err, calls: &calls})
+			}
+
+			identity, err := chain.Authenticate(context.Background(), "alice", "secret")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && identity.Source != tt.wantSource {
+				t.Errorf("сработал способ %q, ожидался %q", identity.Source, tt.wantSource)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("вызваны способы %v, ожидались %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestMapRole(t *testing.T) {
+	mapping := map[string]string{
+		"cn=admins,ou=groups,dc=example,dc=com":  "admin",
+		"cn=support,ou=groups,dc=example,dc=com": "moderator",
+	}
+
+	tests := []struct {
+		name   string
+		groups []string
+		want   string
+	}{
+		{name: "без групп", groups: nil, want: "user"},
+		{name: "группа не сопоставлена", groups: []string{"cn=sales,ou=groups,dc=example,dc=com"}, want: "user"},
+		{name: "сопоставленная группа", groups: []string{"cn=support,ou=groups,dc=example,dc=com"}, want: "moderator"},
+		{name: "DN без учета регистра", groups: []string{"CN=Support,OU=Groups,DC=example,DC=com"}, want: "moderator"},
+		{name: "admin важнее порядка", groups: []string{"cn=support,ou=groups,dc=example,dc=com", "cn=admins,ou=groups,dc=example,dc=com"}, want: "admin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MapRole(tt.groups, mapping, "user"); got != tt.want {
+				t.Errorf("MapRole(%v) = %q, ожидалось %q", tt.groups, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"messenger/config"
+)
+
+// Флаг ACCOUNTDISABLE атрибута userAccountControl в Active Directory
+const adAccountDisabled = 0x2
+
+// ldapConn - часть ldap.Client, используемая аутентификатором
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// LDAP проверяет пароль bind-запросом к каталогу LDAP / Active Directory:
+// сервисная учетная запись ищет DN пользователя, затем выполняется bind от его имени
+type LDAP struct {
+	cfg  config.LDAPConfig
+	dial func() (ldapConn, error)
+}
+
+// NewLDAP создает способ аутентификации через LDAP
+func NewLDAP(cfg config.LDAPConfig) *LDAP {
+	l := &LDAP{cfg: cfg}
+	l.dial = l.dialServer
+	return l
+}
+
+// Name возвращает имя способа
+func (l *LDAP) Name() string {
+	return SourceLDAP
+}
+
+// Authenticate ищет пользователя в каталоге и проверяет пароль bind-запросом
+func (l *LDAP) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	// Пустой пароль в LDAP означает анонимный bind, который сервер примет как успешный
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := l.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entry, err := l.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ошибка проверки пароля в LDAP: %w", err)
+	}
+
+	identity := l.identity(entry, username)
+	if identity.Disabled {
+		return nil, ErrInvalidCredentials
+	}
+	return identity, nil
+}
+
+// Lookup ищет пользователя в каталоге от имени сервисной учетной записи
+func (l *LDAP) Lookup(ctx context.Context, username string) (*Identity, error) {
+	conn, err := l.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entry, err := l.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+	return l.identity(entry, username), nil
+}
+
+// RoleFor возвращает роль по группам каталога или пустую строку, если соответствие не задано
+func (l *LDAP) RoleFor(groups []string) string {
+	if len(l.cfg.RoleMapping) == 0 {
+		return ""
+	}
+	return MapRole(groups, l.cfg.RoleMapping, l.cfg.DefaultRole)
+}
+
+// connect подключается к серверу и выполняет bind сервисной учетной записи
+func (l *LDAP) connect() (ldapConn, error) {
+	conn, err := l.dial()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подключения к LDAP %s: %w", l.cfg.URL, err)
+	}
+
+	if l.cfg.BindDN != "" {
+		if err := conn.Bind(l.cfg.BindDN, l.cfg.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ошибка bind сервисной учетной записи LDAP: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// dialServer открывает соединение с сервером из конфигурации
+func (l *LDAP) dialServer() (ldapConn, error) {
+	timeout := time.Duration(l.cfg.Timeout) * time.Second
+	tlsConfig := &tls.Config{InsecureSkipVerify: l.cfg.InsecureSkipVerify}
+
+	conn, err := ldap.DialURL(l.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(tlsConfig),
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+
+	if l.cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ошибка StartTLS: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// findUser ищет единственную запись пользователя по фильтру из конфигурации
+func (l *LDAP) findUser(conn ldapConn, username string) (*ldap.Entry, error) {
+	request := ldap.NewSearchRequest(
+		l.cfg.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, l.cfg.Timeout, false,
+		fmt.Sprintf(l.cfg.UserFilter, ldap.EscapeFilter(username)),
+		[]string{
+			l.cfg.UsernameAttribute,
+			l.cfg.EmailAttribute,
+			l.cfg.DisplayNameAttribute,
+			l.cfg.GroupAttribute,
+			"userAccountControl",
+		},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, ErrUnknownUser
+		}
+		return nil, fmt.Errorf("ошибка поиска пользователя в LDAP: %w", err)
+	}
+
+	switch len(result.Entries) {
+	case 0:
+		return nil, ErrUnknownUser
+	case 1:
+		return result.Entries[0], nil
+	default:
+		return nil, fmt.Errorf("фильтр LDAP вернул несколько записей для пользователя %s", username)
+	}
+}
+
+// identity переводит запись каталога в Identity
+func (l *LDAP) identity(entry *ldap.Entry, username string) *Identity {
+	identity := &Identity{
+		Source:      SourceLDAP,
+		Username:    entry.GetAttributeValue(l.cfg.UsernameAttribute),
+		Email:       entry.GetAttributeValue(l.cfg.EmailAttribute),
+		DisplayName: entry.GetAttributeValue(l.cfg.DisplayNameAttribute),
+		Groups:      entry.GetAttributeValues(l.cfg.GroupAttribute),
+	}
+	if identity.Username == "" {
+		identity.Username = username
+	}
+	// Имена в мессенджере регистрозависимы, а в каталоге нет: храним в нижнем регистре
+	identity.Username = strings.ToLower(identity.Username)
+
+	if uac := entry.GetAttributeValue("userAccountControl"); uac != "" {
+		if flags, err := strconv.ParseInt(uac, 10, 64); err == nil && flags&adAccountDisabled != 0 {
+			identity.Disabled = true
+		}
+	}
+	return identity
+}
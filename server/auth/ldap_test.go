@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"messenger/config"
+)
+
+const (
+	testServiceDN       = "cn=messenger,ou=services,dc=example,dc=com"
+	testServicePassword = "service-secret"
+	testAdminsGroup     = "cn=admins,ou=groups,dc=example,dc=com"
+)
+
+// stubDirectory - каталог в памяти вместо сервера LDAP. Каждое подключение получает
+// свой stubLDAPConn, как при настоящем dial
+type stubDirectory struct {
+	cfg       config.LDAPConfig
+	entries   []*ldap.Entry
+	passwords map[string]string // Пароли по DN
+	dialErr   error
+	dials     int
+}
+
+// stubLDAPConn выполняет bind и поиск по записям stubDirectory
+type stubLDAPConn struct {
+	dir     *stubDirectory
+	boundDN string
+}
+
+func (d *stubDirectory) dial() (ldapConn, error) {
+	d.dials++
+	if d.dialErr != nil {
+		return nil, d.dialErr
+	}
+	return &stubLDAPConn{dir: d}, nil
+}
+
+func (c *stubLDAPConn) Bind(username, password string) error {
+	if username == testServiceDN && password == testServicePassword {
+		c.boundDN = username
+		return nil
+	}
+	if expected, ok := c.dir.passwords[username]; ok && expected == password {
+		c.boundDN = username
+		return nil
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+}
+
+func (c *stubLDAPConn) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if c.boundDN != testServiceDN {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("search requires service bind"))
+	}
+	if request.BaseDN != c.dir.cfg.BaseDN {
+		return nil, ldap.NewError(ldap.LDAPResultNoSuchObject, fmt.Errorf("no such object: %s", request.BaseDN))
+	}
+
+	result := &ldap.SearchResult{}
+	for _, entry := range c.dir.entries {
+		// Сравнение атрибута uid в LDAP регистронезависимо
+		uid := entry.GetAttributeValue(c.dir.cfg.UsernameAttribute)
+		if strings.EqualFold(request.Filter, fmt.Sprintf(c.dir.cfg.UserFilter, ldap.EscapeFilter(uid))) {
+			result.Entries = append(result.Entries, entry)
+		}
+	}
+	return result, nil
+}
+
+func (c *stubLDAPConn) Close() error { return nil }
+
+func newTestLDAP(t *testing.T) (*LDAP, *stubDirectory) {
+	t.Helper()
+	cfg := config.LDAPConfig{
+		Enabled:              true,
+		URL:                  "ldap://ldap.example.com",
+		BindDN:               testServiceDN,
+		BindPassword:         testServicePassword,
+		BaseDN:               "ou=people,dc=example,dc=com",
+		UserFilter:           "(&(objectClass=person)(uid=%s))",
+		UsernameAttribute:    "uid",
+		EmailAttribute:       "mail",
+		DisplayNameAttribute: "cn",
+		GroupAttribute:       "memberOf",
+		RoleMapping:          map[string]string{testAdminsGroup: "admin"},
+		DefaultRole:          "user",
+	}
+
+	dir := &stubDirectory{
+		cfg: cfg,
+		entries: []*ldap.Entry{
+			ldap.NewEntry("uid=Alice,ou=people,dc=example,dc=com", map[string][]string{
+				"uid":      {"Alice"},
+				"mail":     {"alice@example.com"},
+				"cn":       {"Alice Liddell"},
+				"memberOf": {testAdminsGroup, "cn=staff,ou=groups,dc=example,dc=com"},
+			}),
+			ldap.NewEntry("uid=bob,ou=people,dc=example,dc=com", map[string][]string{
+				"uid":                {"bob"},
+				"userAccountControl": {"514"}, // NORMAL_ACCOUNT | ACCOUNTDISABLE
+			}),
+			ldap.NewEntry("uid=a*,ou=people,dc=example,dc=com", map[string][]string{
+				"uid": {"a*"},
+			}),
+		},
+		passwords: map[string]string{
+			"uid=Alice,ou=people,dc=example,dc=com": "alice-password",
+			"uid=bob,ou=people,dc=example,dc=com":   "bob-password",
+		},
+	}
+
+	l := NewLDAP(cfg)
+	l.dial = dir.dial
+	return l, dir
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	l, _ := newTestLDAP(t)
+
+	identity, err := l.Authenticate(context.Background(), "alice", "alice-password")
+	if err != nil {
+		t.Fatalf("вход не выполнен: %v", err)
+	}
+	want := &Identity{
+		Source:      SourceLDAP,
+		Username:    "alice",
+		Email:       "alice@example.com",
+		DisplayName: "Alice Liddell",
+		Groups:      []string{testAdminsGroup, "cn=staff,ou=groups,dc=example,dc=com"},
+	}
+	if !reflect.DeepEqual(identity, want) {
+		t.Errorf("получено %+v, ожидалось %+v", identity, want)
+	}
+	if role := l.RoleFor(identity.Groups); role != "admin" {
+		t.Errorf("роль %q, ожидалась admin", role)
+	}
+}
+
+func TestLDAPAuthenticateErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  error
+	}{
+		{name: "неверный пароль", username: "alice", password: "wrong", wantErr: ErrInvalidCredentials},
+		{name: "неизвестный пользователь", username: "carol", password: "secret", wantErr: ErrUnknownUser},
+		{name: "отключен в AD", username: "bob", password: "bob-password", wantErr: ErrInvalidCredentials},
+		{name: "пустой пароль", username: "alice", password: "", wantErr: ErrInvalidCredentials},
+		// Спецсимволы фильтра экранируются: "*" не находит всех пользователей сразу
+		{name: "подстановка в фильтре", username: "*", password: "secret", wantErr: ErrUnknownUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := newTestLDAP(t)
+			if _, err := l.Authenticate(context.Background(), tt.username, tt.password); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ошибка %v, ожидалась %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLDAPEmptyPasswordSkipsServer(t *testing.T) {
+	l, dir := newTestLDAP(t)
+	if _, err := l.Authenticate(context.Background(), "alice", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("ошибка %v, ожидалась %v", err, ErrInvalidCredentials)
+	}
+	// Анонимный bind сервер принял бы как успешный, поэтому до сервера дело не доходит
+	if dir.dials != 0 {
+		t.Errorf("выполнено подключений: %d", dir.dials)
+	}
+}
+
+func TestLDAPUnavailable(t *testing.T) {
+	l, dir := newTestLDAP(t)
+	dir.dialErr = errors.New("connection refused")
+
+	_, err := l.Authenticate(context.Background(), "alice", "alice-password")
+	if err == nil || errors.Is(err, ErrUnknownUser) || errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("недоступность каталога не отличается от неверного входа: %v", err)
+	}
+
+	// В цепочке недоступный каталог не мешает локальному входу
+	var calls []string
+	chain := Chain{l, stubAuthenticator{name: SourceLocal, calls: &calls}}
+	identity, err := chain.Authenticate(context.Background(), "admin", "secret")
+	if err != nil || identity.Source != SourceLocal {
+		t.Errorf("локальный вход после недоступного LDAP: %+v, %v", identity, err)
+	}
+}
+
+func TestLDAPLookup(t *testing.T) {
+	l, _ := newTestLDAP(t)
+
+	identity, err := l.Lookup(context.Background(), "bob")
+	if err != nil {
+		t.Fatalf("поиск без пароля пользователя: %v", err)
+	}
+	if !identity.Disabled {
+		t.Error("отключенная в AD учетная запись не помечена Disabled")
+	}
+
+	if _, err := l.Lookup(context.Background(), "carol"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("ошибка %v, ожидалась %v", err, ErrUnknownUser)
+	}
+}
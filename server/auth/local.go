@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+
+	"messenger/database"
+)
+
+// Local проверяет пароль по bcrypt хешу в базе данных
+type Local struct {
+	db *database.Database
+}
+
+// NewLocal создает локальный способ аутентификации
+func NewLocal(db *database.Database) *Local {
+	return &Local{db: db}
+}
+
+// Name возвращает имя способа
+func (l *Local) Name() string {
+	return SourceLocal
+}
+
+// Authenticate сверяет пароль с хешем пользователя.
+// Пользователи внешних каталогов локальным паролем не входят
+func (l *Local) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	user, err := l.db.GetUserByUsername(username)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnknownUser
+		}
+		return nil, err
+	}
+	if user.AuthSource != "" && user.AuthSource != SourceLocal {
+		return nil, ErrUnknownUser
+	}
+	if !user.CheckPassword(password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{
+		Source:   SourceLocal,
+		Username: user.Username,
+		Email:    user.Email,
+	}, nil
+}
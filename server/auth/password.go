@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"messenger/config"
+)
+
+// PasswordPolicy проверяет пароли на длину, классы символов и наличие в списке утекших
+type PasswordPolicy struct {
+	cfg      config.PasswordPolicyConfig
+	breached map[[sha1.Size]byte]struct{}
+}
+
+// NewPasswordPolicy создает политику и загружает список утекших паролей.
+// При ошибке чтения списка политика все равно возвращается (без проверки по списку)
+func NewPasswordPolicy(cfg config.PasswordPolicyConfig) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{cfg: cfg}
+	if cfg.BreachedListPath == "" {
+		return policy, nil
+	}
+
+	breached, err := loadBreachedList(cfg.BreachedListPath)
+	if err != nil {
+		return policy, err
+	}
+	policy.breached = breached
+	return policy, nil
+}
+
+// BreachedCount возвращает размер загруженного списка утекших паролей
+func (p *PasswordPolicy) BreachedCount() int {
+	return len(p.breached)
+}
+
+// Validate возвращает список нарушений политики; пустой список - пароль подходит
+func (p *PasswordPolicy) Validate(password, username string) []string {
+	var violations []string
+
+	length := len([]rune(password))
+	if length < p.cfg.MinLength {
+		violations = append(violations, fmt.Sprintf("Пароль должен содержать не менее %d символов", p.cfg.MinLength))
+	}
+	if p.cfg.MaxLength > 0 && len(password) > p.cfg.MaxLength {
+		violations = append(violations, fmt.Sprintf("Пароль должен занимать не более %d байт", p.cfg.MaxLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.cfg.RequireUpper && !upper {
+		violations = append(violations, "Пароль должен содержать заглавную букву")
+	}
+	if p.cfg.RequireLower && !lower {
+		violations = append(violations, "Пароль должен содержать строчную букву")
+	}
+	if p.cfg.RequireDigit && !digit {
+		violations = append(violations, "Пароль должен содержать цифру")
+	}
+	if p.cfg.RequireSymbol && !symbol {
+		violations = append(violations, "Пароль должен содержать специальный символ")
+	}
+
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		violations = append(violations, "Пароль не должен содержать имя пользователя")
+	}
+
+	if _, ok := p.breached[sha1.Sum([]byte(password))]; ok {
+		violations = append(violations, "Пароль найден в списке утекших паролей")
+	}
+
+	return violations
+}
+
+// loadBreachedList читает список утекших паролей. Строка из 40 hex-символов
+// (с необязательным ":count") считается SHA-1, остальные строки - паролями открытым текстом
+func loadBreachedList(path string) (map[[sha1.Size]byte]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия списка утекших паролей %s: %w", path, err)
+	}
+	defer file.Close()
+
+	breached := make(map[[sha1.Size]byte]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		candidate := line
+		if i := strings.IndexByte(candidate, ':'); i == 2*sha1.Size {
+			candidate = candidate[:i]
+		}
+		if len(candidate) == 2*sha1.Size {
+			var sum [sha1.Size]byte
+			if _, err := hex.Decode(sum[:], []byte(candidate)); err == nil {
+				breached[sum] = struct{}{}
+				continue
+			}
+		}
+		breached[sha1.Sum([]byte(line))] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения списка утекших паролей %s: %w", path, err)
+	}
+	return breached, nil
+}
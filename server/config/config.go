@@ -7,10 +7,105 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 )
 
+// RateLimit задает параметры token bucket: скорость пополнения (в секунду) и емкость.
+// Нулевая скорость означает отсутствие ограничения
+type RateLimit struct {
+	Rate  float64 `json:"rate" validate:"min=0"`
+	Burst int     `json:"burst" validate:"min=0"`
+}
+
+// WSFrameLimit задает лимиты для типа WebSocket кадра на соединение и на пользователя
+type WSFrameLimit struct {
+	Connection RateLimit `json:"connection"`
+	User       RateLimit `json:"user"`
+}
+
+// LDAPConfig задает вход через LDAP / Active Directory и синхронизацию с каталогом
+type LDAPConfig struct {
+	Enabled bool `json:"enabled"`
+	// Адрес сервера: ldap://host:389 или ldaps://host:636
+	URL                string `json:"url" validate:"required_if=Enabled true"`
+	StartTLS           bool   `json:"start_tls"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	// Сервисная учетная запись для поиска пользователей; пустой BindDN - анонимный поиск
+	BindDN       string `json:"bind_dn"`
+	BindPassword string `json:"bind_password"`
+	BaseDN       string `json:"base_dn" validate:"required_if=Enabled true"`
+	// Фильтр поиска пользователя, %s заменяется экранированным именем (для AD: (sAMAccountName=%s))
+	UserFilter           string `json:"user_filter"`
+	UsernameAttribute    string `json:"username_attribute"`
+	EmailAttribute       string `json:"email_attribute"`
+	DisplayNameAttribute string `json:"display_name_attribute"`
+	GroupAttribute       string `json:"group_attribute"`
+	// Соответствие групп (DN) ролям; при пустом соответствии роль не меняется
+	RoleMapping map[string]string `json:"role_mapping"`
+	DefaultRole string            `json:"default_role"`
+	// Создавать пользователя при первом входе
+	AutoProvision bool `json:"auto_provision"`
+	// Интервал синхронизации с каталогом в минутах; 0 - синхронизация выключена
+	SyncInterval int `json:"sync_interval" validate:"min=0"`
+	// Таймаут подключения и запросов в секундах
+	Timeout int `json:"timeout" validate:"min=0"`
+}
+
+// PasswordPolicyConfig задает требования к паролям локальных учетных записей
+type PasswordPolicyConfig struct {
+	MinLength int `json:"min_length" validate:"min=0,max=72"`
+	// bcrypt учитывает только первые 72 байта пароля
+	MaxLength     int  `json:"max_length" validate:"min=0,max=72"`
+	RequireUpper  bool `json:"require_upper"`
+	RequireLower  bool `json:"require_lower"`
+	RequireDigit  bool `json:"require_digit"`
+	RequireSymbol bool `json:"require_symbol"`
+	// Файл со списком утекших паролей: по одному в строке, открытым текстом
+	// или SHA-1 в hex (формат Have I Been Pwned "HASH:count")
+	BreachedListPath string `json:"breached_list_path"`
+}
+
+// MailConfig задает отправку служебных писем
+type MailConfig struct {
+	Driver string `json:"driver" validate:"omitempty,oneof=smtp file log"` // smtp, file (письма в каталог) или log (в лог сервера)
+	From   string `json:"from" validate:"required"`
+	// Каталог для писем при driver=file
+	FileDir string `json:"file_dir"`
+	// Адрес веб-клиента для ссылок в письмах, например https://chat.example.com
+	BaseURL string `json:"base_url"`
+
+	// Запрещать вход, пока пользователь не подтвердил email. Email при регистрации становится обязательным
+	RequireVerification bool `json:"require_verification"`
+
+	VerificationTTL int `json:"verification_ttl" validate:"min=0"` // Срок жизни ссылки подтверждения в часах
+	ResetTTL        int `json:"reset_ttl" validate:"min=0"`        // Срок жизни ссылки сброса пароля в минутах
+
+	SMTP struct {
+		Host     string `json:"host" validate:"required_if=Driver smtp"`
+		Port     int    `json:"port" validate:"min=0,max=65535"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Security string `json:"security" validate:"omitempty,oneof=none starttls tls"`
+		Timeout  int    `json:"timeout" validate:"min=0"` // В секундах
+	} `json:"smtp"`
+}
+
+// S3Config задает S3-совместимое хранилище файлов (AWS S3, MinIO, Ceph RGW)
+type S3Config struct {
+	Endpoint  string `json:"endpoint"` // host:port без схемы, например s3.amazonaws.com или minio:9000
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	UseSSL    bool   `json:"use_ssl"`
+	// Адресация bucket в пути (minio:9000/bucket), а не в имени хоста. Нужна для MinIO
+	PathStyle bool `json:"path_style"`
+	// Префикс ключей объектов, позволяет хранить файлы нескольких установок в одном bucket
+	Prefix string `json:"prefix"`
+}
+
 type Config struct {
 	Server struct {
 		Port                string `json:"port" validate:"required"`
@@ -19,6 +114,7 @@ type Config struct {
 		RegistrationEnabled bool   `json:"registration_enabled"`
 		MaintenanceMode     bool   `json:"maintenance_mode"`
 		EnableGeminiPro     bool   `json:"enable_gemini_pro"` // Новое поле
+		RequireAdmin2FA     bool   `json:"require_admin_2fa"` // Администраторы обязаны подключить 2FA
 	} `json:"server"`
 
 	Database struct {
@@ -31,7 +127,9 @@ type Config struct {
 
 	JWT struct {
 		Secret string `json:"secret" validate:"required,min=32"`
-		Expiry int    `json:"expiry" validate:"required,min=1,max=720"` // в часах, макс. 30 дней
+		Expiry int    `json:"expiry" validate:"required,min=1,max=720"` // срок жизни сессии (refresh токена) в часах, макс. 30 дней
+		// Срок жизни access токена в минутах
+		AccessExpiry int `json:"access_expiry" validate:"min=0,max=1440"`
 	} `json:"jwt"`
 
 	SFU struct {
@@ -47,10 +145,75 @@ type Config struct {
 		Enabled  bool   `json:"enabled" validate:"required"`
 	} `json:"redis"`
 
+	WebSocket struct {
+		// Максимальный размер входящего кадра в байтах
+		MaxMessageSize int `json:"max_message_size" validate:"min=0"`
+		// Сжатие permessage-deflate и его уровень (от -2 до 9, см. compress/flate)
+		EnableCompression bool `json:"enable_compression"`
+		CompressionLevel  int  `json:"compression_level" validate:"min=-2,max=9"`
+		// Лимиты по типу кадра; ключ "*" применяется к типам без собственной записи
+		RateLimits map[string]WSFrameLimit `json:"rate_limits" validate:"dive"`
+		// Число превышений лимита за окно ViolationWindow (в секундах), после которого соединение закрывается
+		MaxViolations   int `json:"max_violations" validate:"min=0"`
+		ViolationWindow int `json:"violation_window" validate:"min=0"`
+		// Разрешенные значения заголовка Origin для WebSocket; пустой список разрешает любой Origin, "*" - тоже
+		AllowedOrigins []string `json:"allowed_origins"`
+	} `json:"websocket"`
+
+	// Вход через OpenID Connect (authorization code + PKCE)
+	OIDC struct {
+		Enabled      bool     `json:"enabled"`
+		IssuerURL    string   `json:"issuer_url" validate:"required_if=Enabled true"`
+		ClientID     string   `json:"client_id" validate:"required_if=Enabled true"`
+		ClientSecret string   `json:"client_secret"`
+		RedirectURL  string   `json:"redirect_url" validate:"required_if=Enabled true"` // Адрес /api/auth/oidc/callback
+		Scopes       []string `json:"scopes"`
+		// Claims ID токена с именем пользователя и списком групп
+		UsernameClaim string `json:"username_claim"`
+		GroupsClaim   string `json:"groups_claim"`
+		// Соответствие групп IdP ролям; при пустом соответствии роль не меняется
+		RoleMapping map[string]string `json:"role_mapping"`
+		DefaultRole string            `json:"default_role"`
+		// Создавать пользователя при первом входе, если его не удалось связать по email
+		AutoProvision bool `json:"auto_provision"`
+		// Адрес фронтенда, куда передаются токены во фрагменте URL; если пуст, callback отвечает JSON
+		FrontendRedirectURL string `json:"frontend_redirect_url"`
+	} `json:"oidc"`
+
+	// Способы проверки пароля при входе в порядке перебора: local, ldap
+	Auth struct {
+		Backends []string `json:"backends" validate:"dive,oneof=local ldap"`
+	} `json:"auth"`
+
+	LDAP LDAPConfig `json:"ldap"`
+
+	PasswordPolicy PasswordPolicyConfig `json:"password_policy"`
+
+	// Защита входа от перебора паролей. Нулевые значения заменяются значениями по умолчанию,
+	// отрицательный лимит попыток отключает проверку по соответствующему ключу
+	LoginThrottle struct {
+		MaxUserAttempts int `json:"max_user_attempts" validate:"min=-1"` // Неудачных попыток на имя пользователя до блокировки
+		MaxIPAttempts   int `json:"max_ip_attempts" validate:"min=-1"`   // Неудачных попыток с одного IP до блокировки
+		BaseLockout     int `json:"base_lockout" validate:"min=0"`       // Первая блокировка в секундах, далее удваивается
+		MaxLockout      int `json:"max_lockout" validate:"min=0"`        // Предельная блокировка в секундах
+		ResetWindow     int `json:"reset_window" validate:"min=0"`       // Сброс счетчика после паузы в секундах
+	} `json:"login_throttle"`
+
+	Mail MailConfig `json:"mail"`
+
 	FileStorage struct {
+		Backend          string `json:"backend" validate:"omitempty,oneof=local s3"` // Где хранить файлы: local (каталог Path) или s3
 		Path             string `json:"path" validate:"required"`
 		MaxSizeMB        int    `json:"max_size_mb" validate:"required,min=1,max=1000"`
 		AllowedMimeTypes string `json:"allowed_mime_types" validate:"required"`
+		SignedURLTTL     int    `json:"signed_url_ttl" validate:"min=0"`             // Срок действия подписанной ссылки на файл в секундах
+		UploadTTL        int    `json:"upload_ttl" validate:"min=0"`                 // Через сколько часов без новых частей незавершенная загрузка удаляется
+		UploadPartMaxMB  int    `json:"upload_part_max_mb" validate:"min=0,max=100"` // Максимальный размер одной части загрузки
+		UserQuotaMB      int    `json:"user_quota_mb" validate:"min=0"`              // Сколько места могут занимать файлы одного пользователя, 0 - без ограничения
+		TotalQuotaMB     int    `json:"total_quota_mb" validate:"min=0"`             // Предел для файлов всех пользователей, 0 - без ограничения
+		RetentionDays    int    `json:"retention_days" validate:"min=0"`             // Через сколько дней удаляются файлы в чатах без собственного срока, 0 - хранятся бессрочно
+
+		S3 S3Config `json:"s3"`
 	} `json:"file_storage"`
 }
 
@@ -111,6 +274,7 @@ func Load() (*Config, error) {
 	}
 	overrideBoolFromEnv("SERVER_MAINTENANCE_MODE", &config.Server.MaintenanceMode)
 	overrideBoolFromEnv("SERVER_ENABLE_GEMINI_PRO", &config.Server.EnableGeminiPro) // Чтение из переменной окружения
+	overrideBoolFromEnv("SERVER_REQUIRE_ADMIN_2FA", &config.Server.RequireAdmin2FA)
 
 	overrideFromEnv("DB_HOST", &config.Database.Host)
 	overrideFromEnv("DB_PORT", &config.Database.Port)
@@ -120,16 +284,56 @@ func Load() (*Config, error) {
 
 	overrideFromEnv("JWT_SECRET", &config.JWT.Secret)
 	overrideIntFromEnv("JWT_EXPIRY", &config.JWT.Expiry)
+	overrideIntFromEnv("JWT_ACCESS_EXPIRY", &config.JWT.AccessExpiry)
 
 	overrideFromEnv("SFU_HOST", &config.SFU.Host)
 	overrideFromEnv("SFU_PORT", &config.SFU.Port)
 
+	overrideIntFromEnv("WS_MAX_MESSAGE_SIZE", &config.WebSocket.MaxMessageSize)
+	overrideBoolFromEnv("WS_ENABLE_COMPRESSION", &config.WebSocket.EnableCompression)
+	overrideIntFromEnv("WS_COMPRESSION_LEVEL", &config.WebSocket.CompressionLevel)
+	overrideListFromEnv("WS_ALLOWED_ORIGINS", &config.WebSocket.AllowedOrigins)
+
+	overrideBoolFromEnv("OIDC_ENABLED", &config.OIDC.Enabled)
+	overrideFromEnv("OIDC_ISSUER_URL", &config.OIDC.IssuerURL)
+	overrideFromEnv("OIDC_CLIENT_ID", &config.OIDC.ClientID)
+	overrideFromEnv("OIDC_CLIENT_SECRET", &config.OIDC.ClientSecret)
+	overrideFromEnv("OIDC_REDIRECT_URL", &config.OIDC.RedirectURL)
+	overrideListFromEnv("AUTH_BACKENDS", &config.Auth.Backends)
+	overrideBoolFromEnv("LDAP_ENABLED", &config.LDAP.Enabled)
+	overrideFromEnv("LDAP_URL", &config.LDAP.URL)
+	overrideFromEnv("LDAP_BIND_DN", &config.LDAP.BindDN)
+	overrideFromEnv("LDAP_BIND_PASSWORD", &config.LDAP.BindPassword)
+	overrideFromEnv("LDAP_BASE_DN", &config.LDAP.BaseDN)
+	overrideFromEnv("PASSWORD_BREACHED_LIST", &config.PasswordPolicy.BreachedListPath)
+	overrideIntFromEnv("LOGIN_MAX_USER_ATTEMPTS", &config.LoginThrottle.MaxUserAttempts)
+	overrideIntFromEnv("LOGIN_MAX_IP_ATTEMPTS", &config.LoginThrottle.MaxIPAttempts)
+	overrideFromEnv("MAIL_DRIVER", &config.Mail.Driver)
+	overrideFromEnv("MAIL_FROM", &config.Mail.From)
+	overrideFromEnv("MAIL_BASE_URL", &config.Mail.BaseURL)
+	overrideFromEnv("SMTP_HOST", &config.Mail.SMTP.Host)
+	overrideIntFromEnv("SMTP_PORT", &config.Mail.SMTP.Port)
+	overrideFromEnv("SMTP_USERNAME", &config.Mail.SMTP.Username)
+	overrideFromEnv("SMTP_PASSWORD", &config.Mail.SMTP.Password)
+	overrideFromEnv("SMTP_SECURITY", &config.Mail.SMTP.Security)
+
 	overrideFromEnv("REDIS_HOST", &config.Redis.Host)
 	overrideFromEnv("REDIS_PORT", &config.Redis.Port)
 	overrideFromEnv("REDIS_PASSWORD", &config.Redis.Password)
 	overrideBoolFromEnv("REDIS_ENABLED", &config.Redis.Enabled)
 
+	overrideFromEnv("STORAGE_BACKEND", &config.FileStorage.Backend)
+	overrideFromEnv("S3_ENDPOINT", &config.FileStorage.S3.Endpoint)
+	overrideFromEnv("S3_REGION", &config.FileStorage.S3.Region)
+	overrideFromEnv("S3_BUCKET", &config.FileStorage.S3.Bucket)
+	overrideFromEnv("S3_ACCESS_KEY", &config.FileStorage.S3.AccessKey)
+	overrideFromEnv("S3_SECRET_KEY", &config.FileStorage.S3.SecretKey)
+	overrideBoolFromEnv("S3_USE_SSL", &config.FileStorage.S3.UseSSL)
+
 	// Устанавливаем значения по умолчанию для файлового хранилища, если не заданы
+	if config.FileStorage.Backend == "" {
+		config.FileStorage.Backend = "local"
+	}
 	if config.FileStorage.Path == "" {
 		config.FileStorage.Path = "./uploads"
 		logger.Debugf("Установлено дефолтное значение для FileStorage.Path: %s", config.FileStorage.Path)
@@ -139,9 +343,147 @@ func Load() (*Config, error) {
 		logger.Debugf("Установлено дефолтное значение для FileStorage.MaxSizeMB: %d", config.FileStorage.MaxSizeMB)
 	}
 	if config.FileStorage.AllowedMimeTypes == "" {
-		config.FileStorage.AllowedMimeTypes = "image/jpeg,image/png,image/gif,application/pdf,audio/mpeg,video/mp4"
+		config.FileStorage.AllowedMimeTypes = "image/jpeg,image/png,image/gif,application/pdf,application/msword,application/vnd.openxmlformats-officedocument.wordprocessingml.document,audio/mpeg,audio/mp4,video/mp4,video/mpeg,application/zip"
 		logger.Debugf("Установлено дефолтное значение для FileStorage.AllowedMimeTypes")
 	}
+	if config.FileStorage.SignedURLTTL == 0 {
+		config.FileStorage.SignedURLTTL = 300
+	}
+	if config.FileStorage.UploadTTL == 0 {
+		config.FileStorage.UploadTTL = 24
+	}
+	if config.FileStorage.UploadPartMaxMB == 0 {
+		config.FileStorage.UploadPartMaxMB = 16
+	}
+
+	if config.JWT.AccessExpiry == 0 {
+		config.JWT.AccessExpiry = 15
+	}
+
+	// Устанавливаем значения по умолчанию для WebSocket
+	if config.WebSocket.MaxMessageSize == 0 {
+		config.WebSocket.MaxMessageSize = 10 * 1024 // 10KB
+	}
+	if config.WebSocket.CompressionLevel == 0 {
+		config.WebSocket.CompressionLevel = 1 // flate.BestSpeed
+	}
+
+	if config.WebSocket.RateLimits == nil {
+		config.WebSocket.RateLimits = map[string]WSFrameLimit{
+			"message": {Connection: RateLimit{Rate: 5, Burst: 10}, User: RateLimit{Rate: 10, Burst: 20}},
+			"typing":  {Connection: RateLimit{Rate: 2, Burst: 5}, User: RateLimit{Rate: 4, Burst: 10}},
+			"read":    {Connection: RateLimit{Rate: 10, Burst: 30}, User: RateLimit{Rate: 20, Burst: 60}},
+			"*":       {Connection: RateLimit{Rate: 10, Burst: 20}, User: RateLimit{Rate: 20, Burst: 40}},
+		}
+		logger.Debugf("Установлены дефолтные лимиты для WebSocket кадров")
+	}
+	if config.WebSocket.MaxViolations == 0 {
+		config.WebSocket.MaxViolations = 20
+	}
+	if config.WebSocket.ViolationWindow == 0 {
+		config.WebSocket.ViolationWindow = 60
+	}
+
+	// Устанавливаем значения по умолчанию для OIDC
+	if len(config.OIDC.Scopes) == 0 {
+		config.OIDC.Scopes = []string{"openid", "profile", "email"}
+	}
+	if config.OIDC.UsernameClaim == "" {
+		config.OIDC.UsernameClaim = "preferred_username"
+	}
+	if config.OIDC.GroupsClaim == "" {
+		config.OIDC.GroupsClaim = "groups"
+	}
+	if config.OIDC.DefaultRole == "" {
+		config.OIDC.DefaultRole = "user"
+	}
+
+	// Устанавливаем значения по умолчанию для способов входа и LDAP
+	if len(config.Auth.Backends) == 0 {
+		config.Auth.Backends = []string{"local"}
+		if config.LDAP.Enabled {
+			config.Auth.Backends = []string{"ldap", "local"}
+		}
+	}
+	if config.LDAP.UserFilter == "" {
+		config.LDAP.UserFilter = "(&(objectClass=person)(uid=%s))"
+	}
+	if config.LDAP.UsernameAttribute == "" {
+		config.LDAP.UsernameAttribute = "uid"
+	}
+	if config.LDAP.EmailAttribute == "" {
+		config.LDAP.EmailAttribute = "mail"
+	}
+	if config.LDAP.DisplayNameAttribute == "" {
+		config.LDAP.DisplayNameAttribute = "displayName"
+	}
+	if config.LDAP.GroupAttribute == "" {
+		config.LDAP.GroupAttribute = "memberOf"
+	}
+	if config.LDAP.DefaultRole == "" {
+		config.LDAP.DefaultRole = "user"
+	}
+	if config.LDAP.Timeout == 0 {
+		config.LDAP.Timeout = 10
+	}
+
+	// Устанавливаем значения по умолчанию для политики паролей
+	if config.PasswordPolicy.MinLength == 0 {
+		config.PasswordPolicy.MinLength = 8
+	}
+	if config.PasswordPolicy.MaxLength == 0 {
+		config.PasswordPolicy.MaxLength = 72
+	}
+
+	// Устанавливаем значения по умолчанию для защиты входа
+	if config.LoginThrottle.MaxUserAttempts == 0 {
+		config.LoginThrottle.MaxUserAttempts = 5
+	}
+	if config.LoginThrottle.MaxIPAttempts == 0 {
+		config.LoginThrottle.MaxIPAttempts = 20
+	}
+	if config.LoginThrottle.BaseLockout == 0 {
+		config.LoginThrottle.BaseLockout = 30
+	}
+	if config.LoginThrottle.MaxLockout == 0 {
+		config.LoginThrottle.MaxLockout = 3600
+	}
+	if config.LoginThrottle.ResetWindow == 0 {
+		config.LoginThrottle.ResetWindow = 900
+	}
+
+	// Устанавливаем значения по умолчанию для почты
+	if config.Mail.Driver == "" {
+		config.Mail.Driver = "log"
+	}
+	if config.Mail.From == "" {
+		config.Mail.From = "Messenger <noreply@localhost>"
+	}
+	if config.Mail.FileDir == "" {
+		config.Mail.FileDir = "./mail"
+	}
+	if config.Mail.VerificationTTL == 0 {
+		config.Mail.VerificationTTL = 24
+	}
+	if config.Mail.ResetTTL == 0 {
+		config.Mail.ResetTTL = 60
+	}
+	if config.Mail.SMTP.Security == "" {
+		config.Mail.SMTP.Security = "starttls"
+	}
+	if config.Mail.SMTP.Port == 0 {
+		switch config.Mail.SMTP.Security {
+		case "tls":
+			config.Mail.SMTP.Port = 465
+		case "none":
+			config.Mail.SMTP.Port = 25
+		default:
+			config.Mail.SMTP.Port = 587
+		}
+	}
+	if config.Mail.SMTP.Timeout == 0 {
+		config.Mail.SMTP.Timeout = 15
+	}
 
 	// Валидация конфигурации ПОСЛЕ всех переопределений
 	logger.Debug("Валидация итоговой конфигурации...")
@@ -209,3 +551,16 @@ func overrideIntFromEnv(envVar string, target *int) {
 		}
 	}
 }
+
+// overrideListFromEnv читает список значений через запятую из переменной окружения
+func overrideListFromEnv(envVar string, target *[]string) {
+	if value := os.Getenv(envVar); value != "" {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*target = items
+	}
+}
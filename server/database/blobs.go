@@ -0,0 +1,99 @@
+package database
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"messenger/models"
+)
+
+// AcquireBlob находит используемое содержимое по SHA-256 и увеличивает число ссылок на него.
+// Возвращает gorm.ErrRecordNotFound, если такого содержимого нет
+func (db *Database) AcquireBlob(hash string) (*models.Blob, error) {
+	var blob models.Blob
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		// Блокировка строки не дает освободить последнюю ссылку одновременно с новой
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("hash = ? AND ref_count > 0", hash).
+			First(&blob).Error; err != nil {
+			return err
+		}
+		blob.RefCount++
+		return tx.Model(&blob).UpdateColumn("ref_count", blob.RefCount).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &blob, nil
+}
+
+// CreateBlob сохраняет новое содержимое с одной ссылкой. Возвращает false, если содержимое
+// с тем же SHA-256 успели сохранить параллельно: тогда нужно сослаться на него через AcquireBlob
+func (db *Database) CreateBlob(blob *models.Blob) (bool, error) {
+	blob.RefCount = 1
+	result := db.DB.Clauses(clause.OnConflict{
+		Columns:     []clause.Column{{Name: "hash"}},
+		TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "ref_count > 0"}}},
+		DoNothing:   true,
+	}).Create(blob)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReleaseBlob уменьшает число ссылок на содержимое и возвращает его с новым значением
+func (db *Database) ReleaseBlob(blobID uint) (*models.Blob, error) {
+	var blob *models.Blob
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		blob, err = releaseBlob(tx, blobID)
+		return err
+	})
+	return blob, err
+}
+
+// releaseBlob уменьшает число ссылок внутри транзакции tx
+func releaseBlob(tx *gorm.DB, blobID uint) (*models.Blob, error) {
+	var blob models.Blob
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&blob, blobID).Error; err != nil {
+		return nil, err
+	}
+	if blob.RefCount > 0 {
+		blob.RefCount--
+	}
+	if err := tx.Model(&blob).UpdateColumn("ref_count", blob.RefCount).Error; err != nil {
+		return nil, err
+	}
+	return &blob, nil
+}
+
+// GetBlobThumbnails возвращает миниатюры существующего файла с этим содержимым,
+// чтобы не строить и не хранить их повторно
+func (db *Database) GetBlobThumbnails(blobID uint) ([]models.FileThumbnail, error) {
+	var thumbnails []models.FileThumbnail
+	err := db.DB.Where("file_id = (?)",
+		db.DB.Model(&models.File{}).Select("id").Where("blob_id = ?", blobID).Order("id").Limit(1)).
+		Find(&thumbnails).Error
+	return thumbnails, err
+}
+
+// GetBlobThumbnailKeys возвращает ключи миниатюр содержимого, включая миниатюры удаленных файлов
+func (db *Database) GetBlobThumbnailKeys(blobID uint) ([]string, error) {
+	var keys []string
+	err := db.DB.Model(&models.FileThumbnail{}).
+		Distinct("file_thumbnails.storage_key").
+		Joins("JOIN files ON files.id = file_thumbnails.file_id").
+		Where("files.blob_id = ?", blobID).
+		Pluck("file_thumbnails.storage_key", &keys).Error
+	return keys, err
+}
+
+// GetUnreferencedBlobs возвращает содержимое без ссылок, объекты которого еще не удалены
+func (db *Database) GetUnreferencedBlobs(limit int) ([]models.Blob, error) {
+	var blobs []models.Blob
+	err := db.DB.Where("ref_count = 0").Order("id").Limit(limit).Find(&blobs).Error
+	return blobs, err
+}
+
+// DeleteBlob удаляет запись о содержимом, если на него по-прежнему нет ссылок
+func (db *Database) DeleteBlob(blobID uint) error {
+	return db.DB.Where("id = ? AND ref_count = 0", blobID).Delete(&models.Blob{}).Error
+}
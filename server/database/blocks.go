@@ -0,0 +1,69 @@
+package database
+
+import (
+	"gorm.io/gorm/clause"
+
+	"messenger/models"
+)
+
+// BlockUser сохраняет блокировку; повторная блокировка не считается ошибкой
+func (db *Database) BlockUser(blockerID, blockedID uint) error {
+	block := models.UserBlock{BlockerID: blockerID, BlockedID: blockedID}
+	return db.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&block).Error
+}
+
+// UnblockUser снимает блокировку. Возвращает false, если ее не было
+func (db *Database) UnblockUser(blockerID, blockedID uint) (bool, error) {
+	result := db.DB.Where("blocker_id = ? AND blocked_id = ?", blockerID, blockedID).Delete(&models.UserBlock{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetUserBlocks возвращает блокировки пользователя вместе с заблокированными пользователями
+func (db *Database) GetUserBlocks(blockerID uint) ([]models.UserBlock, error) {
+	var blocks []models.UserBlock
+	err := db.DB.Preload("Blocked").
+		Where("blocker_id = ?", blockerID).
+		Order("created_at DESC").
+		Find(&blocks).Error
+	return blocks, err
+}
+
+// GetBlockedIDs возвращает ID пользователей, которых заблокировал blockerID
+func (db *Database) GetBlockedIDs(blockerID uint) ([]uint, error) {
+	var ids []uint
+	err := db.DB.Model(&models.UserBlock{}).Where("blocker_id = ?", blockerID).Pluck("blocked_id", &ids).Error
+	return ids, err
+}
+
+// GetBlockerIDs возвращает ID пользователей, заблокировавших blockedID
+func (db *Database) GetBlockerIDs(blockedID uint) ([]uint, error) {
+	var ids []uint
+	err := db.DB.Model(&models.UserBlock{}).Where("blocked_id = ?", blockedID).Pluck("blocker_id", &ids).Error
+	return ids, err
+}
+
+// GetBlockPeerIDs возвращает ID пользователей, связанных с userID блокировкой в любую сторону
+func (db *Database) GetBlockPeerIDs(userID uint) ([]uint, error) {
+	var blocks []models.UserBlock
+	if err := db.DB.Where("blocker_id = ? OR blocked_id = ?", userID, userID).Find(&blocks).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]uint, 0, len(blocks))
+	for _, block := range blocks {
+		if block.BlockerID == userID {
+			ids = append(ids, block.BlockedID)
+		} else {
+			ids = append(ids, block.BlockerID)
+		}
+	}
+	return ids, nil
+}
+
+// IsBlockedBetween сообщает, заблокировал ли один из пользователей другого
+func (db *Database) IsBlockedBetween(userA, userB uint) (bool, error) {
+	var count int64
+	err := db.DB.Model(&models.UserBlock{}).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)", userA, userB, userB, userA).
+		Count(&count).Error
+	return count > 0, err
+}
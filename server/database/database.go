@@ -3,6 +3,8 @@ package database
 import (
 	"time"
 
+	"gorm.io/gorm"
+
 	"messenger/models"
 )
 
@@ -62,13 +64,16 @@ func (db *Database) GetChatUsers(chatID uint) ([]models.User, error) {
 	return users, nil
 }
 
-// GetChatMessages возвращает сообщения чата
-func (db *Database) GetChatMessages(chatID uint, limit int) ([]models.Message, error) {
+// GetChatMessages возвращает сообщения чата, пропуская сообщения отправителей excludeSenders
+func (db *Database) GetChatMessages(chatID uint, limit int, excludeSenders ...uint) ([]models.Message, error) {
 	var messages []models.Message
 
 	// Получаем сообщения с данными отправителя
-	result := db.DB.Preload("User").
-		Where("chat_id = ?", chatID).
+	query := db.DB.Preload("User").Preload("File").Preload("File.Thumbnails").Where("chat_id = ?", chatID)
+	if len(excludeSenders) > 0 {
+		query = query.Where("user_id NOT IN ?", excludeSenders)
+	}
+	result := query.
 		Order("created_at DESC").
 		Limit(limit).
 		Find(&messages)
@@ -85,6 +90,21 @@ func (db *Database) GetChatMessages(chatID uint, limit int) ([]models.Message, e
 	return messages, nil
 }
 
+// CreateFileMessage создает сообщение с прикрепленным файлом и связывает их друг с другом
+func (db *Database) CreateFileMessage(message *models.Message, file *models.File) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(file).Error; err != nil {
+			return err
+		}
+		message.FileID = &file.ID
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+		file.MessageID = message.ID
+		return tx.Model(file).Update("message_id", message.ID).Error
+	})
+}
+
 // CreateMessage создает новое сообщение
 func (db *Database) CreateMessage(message *models.Message) error {
 	result := db.DB.Create(message)
@@ -117,6 +137,18 @@ func (db *Database) GetMessageByID(messageID uint) (*models.Message, error) {
 	return &message, nil
 }
 
+// MarkChatMessagesAsRead отмечает как прочитанные все сообщения чата от других участников.
+// Возвращает число новых отметок
+func (db *Database) MarkChatMessagesAsRead(chatID, userID uint) (int64, error) {
+	result := db.DB.Exec(`
+		INSERT INTO message_reads (message_id, user_id, read_at)
+		SELECT m.id, ?, ? FROM messages m
+		WHERE m.chat_id = ? AND m.user_id <> ? AND m.deleted_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM message_reads r WHERE r.message_id = m.id AND r.user_id = ?)`,
+		userID, time.Now(), chatID, userID, userID)
+	return result.RowsAffected, result.Error
+}
+
 // MarkMessageAsRead отмечает сообщение как прочитанное
 func (db *Database) MarkMessageAsRead(messageID, userID uint) error {
 	// Проверяем, существует ли запись о прочтении
@@ -111,15 +111,7 @@ func NewDatabase(cfg *config.Config) (*Database, error) {
 
 	// Автомиграция моделей
 	logger.Info("Запуск миграции моделей")
-	err = db.AutoMigrate(
-		&models.User{},
-		&models.Chat{},
-		&models.ChatUser{},
-		&models.Message{},
-		&models.File{},
-		&models.DirectMessage{},
-	)
-	if err != nil {
+	if err := Migrate(db); err != nil {
 		return nil, fmt.Errorf("ошибка миграции: %w", err)
 	}
 
@@ -135,6 +127,27 @@ func NewDatabase(cfg *config.Config) (*Database, error) {
 	return &Database{db}, nil
 }
 
+// Migrate создает и обновляет таблицы всех моделей
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(
+		&models.User{},
+		&models.Chat{},
+		&models.ChatUser{},
+		&models.Message{},
+		&models.File{},
+		&models.FileThumbnail{},
+		&models.DirectMessage{},
+		&models.Session{},
+		&models.RecoveryCode{},
+		&models.UserToken{},
+		&models.UserBlock{},
+		&models.Upload{},
+		&models.UploadPart{},
+		&models.MessageListen{},
+		&models.Blob{},
+	)
+}
+
 // Проверка, инициализирована ли система
 func (db *Database) IsInitialized() (bool, error) {
 	var count int64
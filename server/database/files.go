@@ -0,0 +1,124 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"messenger/models"
+)
+
+// GetFileByID возвращает запись о файле вместе с миниатюрами
+func (db *Database) GetFileByID(fileID uint) (*models.File, error) {
+	var file models.File
+	if err := db.DB.Preload("Thumbnails").First(&file, fileID).Error; err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// CanAccessFile сообщает, может ли пользователь получить файл: он должен состоять в чате
+// сообщения, к которому прикреплен файл. Файлы старых личных сообщений (DirectMessage)
+// доступны только отправителю и получателю
+func (db *Database) CanAccessFile(userID uint, file *models.File) (bool, error) {
+	var message models.Message
+	err := db.DB.Select("id", "chat_id").Where("file_id = ?", file.ID).First(&message).Error
+	if err == nil {
+		return db.IsUserInChat(userID, message.ChatID), nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+
+	var count int64
+	err = db.DB.Model(&models.DirectMessage{}).
+		Where("id = ? AND (sender_id = ? OR recipient_id = ?)", file.MessageID, userID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// GetFileLocations возвращает ID и пути всех файлов, включая удаленные, для переноса между хранилищами
+func (db *Database) GetFileLocations() ([]models.File, error) {
+	var files []models.File
+	err := db.DB.Unscoped().Select("id", "file_path", "blob_id").Order("id").Find(&files).Error
+	return files, err
+}
+
+// GetThumbnailKeys возвращает ключи всех миниатюр для переноса между хранилищами
+func (db *Database) GetThumbnailKeys() ([]string, error) {
+	var keys []string
+	err := db.DB.Model(&models.FileThumbnail{}).Distinct("storage_key").Order("storage_key").Pluck("storage_key", &keys).Error
+	return keys, err
+}
+
+// SetFilePath обновляет путь к содержимому файла
+func (db *Database) SetFilePath(fileID uint, filePath string) error {
+	return db.DB.Unscoped().Model(&models.File{}).Where("id = ?", fileID).
+		UpdateColumn("file_path", filePath).Error
+}
+
+// GetExpiredFiles возвращает файлы, пролежавшие в чате дольше срока хранения. Срок чата
+// (Chat.FileRetentionDays) важнее общего defaultDays; если оба равны 0, файлы не удаляются
+func (db *Database) GetExpiredFiles(defaultDays, limit int) ([]models.File, error) {
+	const retention = "COALESCE(NULLIF(chats.file_retention_days, 0), ?)"
+	var files []models.File
+	err := db.DB.Preload("Thumbnails").
+		Joins("JOIN messages ON messages.file_id = files.id").
+		Joins("JOIN chats ON chats.id = messages.chat_id").
+		Where(retention+" > 0", defaultDays).
+		Where("files.created_at < NOW() - "+retention+" * INTERVAL '1 day'", defaultDays).
+		Order("files.id").
+		Limit(limit).
+		Find(&files).Error
+	return files, err
+}
+
+// GetOrphanedFiles возвращает файлы старше before, на которые не ссылается ни одно сообщение:
+// сообщение удалено или так и не было создано. Учитываются и старые личные сообщения
+func (db *Database) GetOrphanedFiles(before time.Time, limit int) ([]models.File, error) {
+	var files []models.File
+	err := db.DB.Preload("Thumbnails").
+		Where("files.created_at < ?", before).
+		Where("NOT EXISTS (SELECT 1 FROM messages WHERE messages.file_id = files.id AND messages.deleted_at IS NULL)").
+		Where(`NOT EXISTS (SELECT 1 FROM direct_messages WHERE (direct_messages.file_id = files.id OR direct_messages.id = files.message_id)
+			AND direct_messages.deleted_at IS NULL)`).
+		Order("files.id").
+		Limit(limit).
+		Find(&files).Error
+	return files, err
+}
+
+// DeleteFileRecord помечает файл удаленным. Сообщение остается, но файл к нему больше
+// не подгружается. Ссылка на общее содержимое освобождается в той же транзакции; если она
+// была последней, возвращается содержимое, объекты которого нужно удалить. Записи о миниатюрах
+// таких файлов сохраняются: по ним находятся общие объекты миниатюр
+func (db *Database) DeleteFileRecord(file *models.File) (*models.Blob, error) {
+	var released *models.Blob
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if file.BlobID == nil {
+			if err := tx.Where("file_id = ?", file.ID).Delete(&models.FileThumbnail{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(file).Error
+		}
+
+		if err := tx.Delete(file).Error; err != nil {
+			return err
+		}
+		blob, err := releaseBlob(tx, *file.BlobID)
+		if err != nil {
+			return err
+		}
+		if blob.RefCount == 0 {
+			released = blob
+		}
+		return nil
+	})
+	return released, err
+}
+
+// SetChatFileRetention задает срок хранения файлов чата в днях
+func (db *Database) SetChatFileRetention(chatID uint, days int) error {
+	return db.DB.Model(&models.Chat{}).Where("id = ?", chatID).UpdateColumn("file_retention_days", days).Error
+}
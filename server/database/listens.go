@@ -0,0 +1,33 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"messenger/models"
+)
+
+// MarkMessageListened сохраняет отметку о прослушивании; повторная отметка не считается ошибкой
+func (db *Database) MarkMessageListened(messageID, userID uint) error {
+	listen := models.MessageListen{MessageID: messageID, UserID: userID, ListenedAt: time.Now()}
+	return db.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&listen).Error
+}
+
+// GetMessageListeners возвращает ID прослушавших пользователей для каждого из сообщений
+func (db *Database) GetMessageListeners(messageIDs []uint) (map[uint][]uint, error) {
+	listeners := make(map[uint][]uint)
+	if len(messageIDs) == 0 {
+		return listeners, nil
+	}
+
+	var listens []models.MessageListen
+	err := db.DB.Where("message_id IN ?", messageIDs).Order("listened_at").Find(&listens).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range listens {
+		listeners[l.MessageID] = append(listeners[l.MessageID], l.UserID)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"time"
+
+	"messenger/models"
+)
+
+// CreateSession сохраняет новую сессию
+func (db *Database) CreateSession(session *models.Session) error {
+	return db.DB.Create(session).Error
+}
+
+// GetSessionByID возвращает сессию по ID
+func (db *Database) GetSessionByID(sessionID uint) (*models.Session, error) {
+	var session models.Session
+	if err := db.DB.First(&session, sessionID).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetSessionByTokenHash ищет сессию по хешу текущего refresh токена
+func (db *Database) GetSessionByTokenHash(hash string) (*models.Session, error) {
+	var session models.Session
+	if err := db.DB.Where("refresh_token_hash = ?", hash).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetSessionByPreviousTokenHash ищет сессию, в которой токен с этим хешем уже был заменен
+func (db *Database) GetSessionByPreviousTokenHash(hash string) (*models.Session, error) {
+	var session models.Session
+	if err := db.DB.Where("previous_token_hash = ?", hash).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RotateSessionToken заменяет refresh токен сессии, если текущий хеш не изменился.
+// Возвращает false, если токен уже был заменен параллельным запросом
+func (db *Database) RotateSessionToken(sessionID uint, oldHash, newHash string, expiresAt time.Time) (bool, error) {
+	result := db.DB.Model(&models.Session{}).
+		Where("id = ? AND refresh_token_hash = ? AND revoked_at IS NULL", sessionID, oldHash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash":  newHash,
+			"previous_token_hash": oldHash,
+			"last_used_at":        time.Now(),
+			"expires_at":          expiresAt,
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+// GetActiveUserSessions возвращает действующие сессии пользователя, последние использованные первыми
+func (db *Database) GetActiveUserSessions(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := db.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession отзывает сессию
+func (db *Database) RevokeSession(sessionID uint) error {
+	return db.DB.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeUserSessions отзывает все сессии пользователя кроме exceptID (0 - отозвать все).
+// Возвращает ID отозванных сессий
+func (db *Database) RevokeUserSessions(userID, exceptID uint) ([]uint, error) {
+	var ids []uint
+	err := db.DB.Model(&models.Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, exceptID).
+		Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return ids, err
+	}
+
+	err = db.DB.Model(&models.Session{}).
+		Where("id IN ?", ids).
+		Update("revoked_at", time.Now()).Error
+	return ids, err
+}
+
+// DeleteStaleSessions удаляет сессии, истекшие или отозванные раньше before
+func (db *Database) DeleteStaleSessions(before time.Time) (int64, error) {
+	result := db.DB.Where("expires_at < ? OR revoked_at < ?", before, before).Delete(&models.Session{})
+	return result.RowsAffected, result.Error
+}
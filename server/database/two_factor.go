@@ -0,0 +1,88 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"messenger/models"
+)
+
+// SetTOTPSecret сохраняет зашифрованный секрет TOTP, который еще не подтвержден
+func (db *Database) SetTOTPSecret(userID uint, encryptedSecret string) error {
+	return db.DB.Model(&models.User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{
+			"totp_secret":       encryptedSecret,
+			"totp_enabled":      false,
+			"totp_last_counter": 0,
+		}).Error
+}
+
+// EnableTOTP включает 2FA и заменяет коды восстановления
+func (db *Database) EnableTOTP(userID uint, counter int64, codeHashes []string) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"totp_enabled": true, "totp_last_counter": counter}).Error; err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+}
+
+// DisableTOTP выключает 2FA, удаляет секрет и коды восстановления
+func (db *Database) DisableTOTP(userID uint) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"totp_secret": "", "totp_enabled": false, "totp_last_counter": 0}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+// AdvanceTOTPCounter запоминает принятый временной шаг, если он новее сохраненного.
+// Возвращает false, если код с этим шагом уже использован
+func (db *Database) AdvanceTOTPCounter(userID uint, counter int64) (bool, error) {
+	result := db.DB.Model(&models.User{}).
+		Where("id = ? AND totp_last_counter < ?", userID, counter).
+		Update("totp_last_counter", counter)
+	return result.RowsAffected == 1, result.Error
+}
+
+// ReplaceRecoveryCodes заменяет коды восстановления пользователя новыми
+func (db *Database) ReplaceRecoveryCodes(userID uint, codeHashes []string) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint, codeHashes []string) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	codes := make([]models.RecoveryCode, 0, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes = append(codes, models.RecoveryCode{UserID: userID, CodeHash: hash})
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+	return tx.Create(&codes).Error
+}
+
+// UseRecoveryCode погашает неиспользованный код восстановления. Возвращает false, если кода нет
+func (db *Database) UseRecoveryCode(userID uint, codeHash string) (bool, error) {
+	result := db.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// CountUnusedRecoveryCodes возвращает число оставшихся кодов восстановления
+func (db *Database) CountUnusedRecoveryCodes(userID uint) (int64, error) {
+	var count int64
+	err := db.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error
+	return count, err
+}
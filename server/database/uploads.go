@@ -0,0 +1,89 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"messenger/models"
+)
+
+// ErrUploadConflict - загрузка уже продвинулась дальше, завершается или удалена
+var ErrUploadConflict = errors.New("состояние загрузки изменилось")
+
+// CreateUpload создает загрузку по частям
+func (db *Database) CreateUpload(upload *models.Upload) error {
+	return db.DB.Create(upload).Error
+}
+
+// GetUpload возвращает загрузку пользователя вместе с частями, упорядоченными по смещению
+func (db *Database) GetUpload(uploadID string, userID uint) (*models.Upload, error) {
+	var upload models.Upload
+	err := db.DB.Preload("Parts", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("\"offset\"")
+	}).Where("id = ? AND user_id = ?", uploadID, userID).First(&upload).Error
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// CountUserUploads возвращает число незавершенных загрузок пользователя
+func (db *Database) CountUserUploads(userID uint) (int64, error) {
+	var count int64
+	err := db.DB.Model(&models.Upload{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// AddUploadPart сохраняет часть и сдвигает смещение загрузки. Смещение меняется только если
+// оно все еще равно part.Offset, поэтому из параллельных запросов с одним смещением проходит один
+func (db *Database) AddUploadPart(part *models.UploadPart, expiresAt time.Time) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Upload{}).
+			Where("id = ? AND \"offset\" = ? AND status = ?", part.UploadID, part.Offset, models.UploadStatusActive).
+			Updates(map[string]any{
+				"offset":     gorm.Expr("\"offset\" + ?", part.Size),
+				"expires_at": expiresAt,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrUploadConflict
+		}
+		return tx.Create(part).Error
+	})
+}
+
+// SetUploadStatus переводит загрузку из состояния from в to и продлевает ее срок
+func (db *Database) SetUploadStatus(uploadID, from, to string, expiresAt time.Time) error {
+	result := db.DB.Model(&models.Upload{}).
+		Where("id = ? AND status = ?", uploadID, from).
+		Updates(map[string]any{"status": to, "expires_at": expiresAt})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUploadConflict
+	}
+	return nil
+}
+
+// DeleteUpload удаляет загрузку и записи о ее частях. Объекты частей удаляет вызывающий
+func (db *Database) DeleteUpload(uploadID string) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("upload_id = ?", uploadID).Delete(&models.UploadPart{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Upload{}, "id = ?", uploadID).Error
+	})
+}
+
+// GetExpiredUploads возвращает до limit загрузок, срок которых истек до before, вместе с частями
+func (db *Database) GetExpiredUploads(before time.Time, limit int) ([]models.Upload, error) {
+	var uploads []models.Upload
+	err := db.DB.Preload("Parts").Where("expires_at < ?", before).
+		Order("expires_at").Limit(limit).Find(&uploads).Error
+	return uploads, err
+}
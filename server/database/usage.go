@@ -0,0 +1,98 @@
+package database
+
+import (
+	"messenger/models"
+)
+
+// Место, занятое файлами, считается по размерам оригиналов: миниатюры в сотни раз меньше
+// и не учитываются. Файл принадлежит отправителю сообщения, к которому прикреплен.
+// Квоты пользователей и чатов считают каждую ссылку на общее содержимое (Blob) отдельно,
+// общее занятое место - каждый объект один раз
+
+// UserStorageUsage - место, занятое файлами пользователя
+type UserStorageUsage struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Files    int64  `json:"files"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// ChatStorageUsage - место, занятое файлами чата
+type ChatStorageUsage struct {
+	ChatID            uint   `json:"chat_id"`
+	Name              string `json:"name"`
+	Type              string `json:"type"`
+	FileRetentionDays int    `json:"file_retention_days"`
+	Files             int64  `json:"files"`
+	Bytes             int64  `json:"bytes"`
+}
+
+// GetUserFileUsage возвращает суммарный размер файлов в сообщениях пользователя
+func (db *Database) GetUserFileUsage(userID uint) (int64, error) {
+	var bytes int64
+	err := db.DB.Model(&models.File{}).
+		Joins("JOIN messages ON messages.file_id = files.id AND messages.deleted_at IS NULL").
+		Where("messages.user_id = ?", userID).
+		Select("COALESCE(SUM(files.file_size), 0)").
+		Scan(&bytes).Error
+	return bytes, err
+}
+
+// GetTotalFileUsage возвращает число файлов и место, которое занимает их содержимое, включая
+// еще не удаленные файлы без сообщений и содержимое без ссылок
+func (db *Database) GetTotalFileUsage() (files, bytes int64, err error) {
+	var usage struct {
+		Files int64
+		Bytes int64
+	}
+	err = db.DB.Model(&models.File{}).
+		Select("COUNT(*) AS files, COALESCE(SUM(file_size) FILTER (WHERE blob_id IS NULL), 0) AS bytes").
+		Scan(&usage).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var blobBytes int64
+	err = db.DB.Model(&models.Blob{}).Select("COALESCE(SUM(size), 0)").Scan(&blobBytes).Error
+	return usage.Files, usage.Bytes + blobBytes, err
+}
+
+// GetUploadsSize возвращает суммарный размер незавершенных загрузок пользователя (всех
+// пользователей при userID = 0), кроме загрузки exceptID. Место под них считается занятым заранее
+func (db *Database) GetUploadsSize(userID uint, exceptID string) (int64, error) {
+	query := db.DB.Model(&models.Upload{}).Where("id <> ?", exceptID)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	var bytes int64
+	err := query.Select("COALESCE(SUM(size), 0)").Scan(&bytes).Error
+	return bytes, err
+}
+
+// GetUsersStorageUsage возвращает пользователей с файлами в порядке убывания занятого места
+func (db *Database) GetUsersStorageUsage(limit, offset int) ([]UserStorageUsage, error) {
+	var usage []UserStorageUsage
+	err := db.DB.Model(&models.File{}).
+		Select("messages.user_id, users.username, COUNT(*) AS files, SUM(files.file_size) AS bytes").
+		Joins("JOIN messages ON messages.file_id = files.id AND messages.deleted_at IS NULL").
+		Joins("JOIN users ON users.id = messages.user_id").
+		Group("messages.user_id, users.username").
+		Order("bytes DESC, messages.user_id").
+		Limit(limit).Offset(offset).
+		Scan(&usage).Error
+	return usage, err
+}
+
+// GetChatsStorageUsage возвращает чаты с файлами в порядке убывания занятого места
+func (db *Database) GetChatsStorageUsage(limit, offset int) ([]ChatStorageUsage, error) {
+	var usage []ChatStorageUsage
+	err := db.DB.Model(&models.File{}).
+		Select("messages.chat_id, chats.name, chats.type, chats.file_retention_days, COUNT(*) AS files, SUM(files.file_size) AS bytes").
+		Joins("JOIN messages ON messages.file_id = files.id AND messages.deleted_at IS NULL").
+		Joins("JOIN chats ON chats.id = messages.chat_id").
+		Group("messages.chat_id, chats.name, chats.type, chats.file_retention_days").
+		Order("bytes DESC, messages.chat_id").
+		Limit(limit).Offset(offset).
+		Scan(&usage).Error
+	return usage, err
+}
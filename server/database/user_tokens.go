@@ -0,0 +1,88 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"messenger/models"
+)
+
+// ErrTokenInvalid - токен не найден, уже использован или истек
+var ErrTokenInvalid = errors.New("токен недействителен или истек")
+
+// CreateUserToken сохраняет новый токен и гасит прежние неиспользованные токены того же назначения,
+// чтобы действовала только ссылка из последнего письма
+func (db *Database) CreateUserToken(token *models.UserToken) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, token.Purpose).
+			Update("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+// GetActiveUserToken возвращает действующий токен по хешу, не погашая его
+func (db *Database) GetActiveUserToken(purpose, tokenHash string) (*models.UserToken, error) {
+	var token models.UserToken
+	err := db.DB.Where("purpose = ? AND token_hash = ? AND used_at IS NULL AND expires_at > ?", purpose, tokenHash, time.Now()).
+		First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ConsumeUserToken погашает действующий токен по хешу. Условное обновление used_at не дает
+// использовать одну ссылку дважды при одновременных запросах
+func (db *Database) ConsumeUserToken(purpose, tokenHash string) (*models.UserToken, error) {
+	var token models.UserToken
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("purpose = ? AND token_hash = ? AND used_at IS NULL AND expires_at > ?", purpose, tokenHash, time.Now()).
+			First(&token).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		result := tx.Model(&models.UserToken{}).
+			Where("id = ? AND used_at IS NULL", token.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrTokenInvalid
+		}
+		token.UsedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// DeleteExpiredUserTokens удаляет истекшие и использованные токены старше указанного момента
+func (db *Database) DeleteExpiredUserTokens(before time.Time) (int64, error) {
+	result := db.DB.Where("expires_at < ? OR used_at < ?", before, before).Delete(&models.UserToken{})
+	return result.RowsAffected, result.Error
+}
+
+// SetEmailVerified отмечает email пользователя подтвержденным, если адрес не сменился после отправки письма
+func (db *Database) SetEmailVerified(userID uint, email string) (bool, error) {
+	result := db.DB.Model(&models.User{}).
+		Where("id = ? AND LOWER(email) = LOWER(?)", userID, email).
+		Update("email_verified", true)
+	return result.RowsAffected > 0, result.Error
+}
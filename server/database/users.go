@@ -0,0 +1,86 @@
+package database
+
+import (
+	"messenger/models"
+)
+
+// GetUserByOIDCSubject возвращает пользователя, связанного с OIDC subject
+func (db *Database) GetUserByOIDCSubject(subject string) (*models.User, error) {
+	var user models.User
+	if err := db.DB.Where("oidc_subject = ?", subject).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUserByEmail возвращает пользователя по email (без учета регистра)
+func (db *Database) GetUserByEmail(email string) (*models.User, error) {
+	var user models.User
+	if err := db.DB.Where("LOWER(email) = LOWER(?)", email).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UsernameExists сообщает, занято ли имя пользователя
+func (db *Database) UsernameExists(username string) (bool, error) {
+	var count int64
+	err := db.DB.Model(&models.User{}).Where("username = ?", username).Count(&count).Error
+	return count > 0, err
+}
+
+// GetUserByUsername возвращает пользователя по имени
+func (db *Database) GetUserByUsername(username string) (*models.User, error) {
+	var user models.User
+	if err := db.DB.Where("username = ?", username).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUsersBySource возвращает незаблокированных пользователей указанного источника учетных записей
+func (db *Database) GetUsersBySource(source string) ([]models.User, error) {
+	var users []models.User
+	err := db.DB.Where("auth_source = ? AND blocked = ?", source, false).Find(&users).Error
+	return users, err
+}
+
+// GetUserByAvatar возвращает пользователя с аватаром по URL avatarURL
+func (db *Database) GetUserByAvatar(avatarURL string) (*models.User, error) {
+	var user models.User
+	if err := db.DB.Where("avatar = ?", avatarURL).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetAvatarURLs возвращает URL аватаров всех пользователей, у которых аватар установлен
+func (db *Database) GetAvatarURLs() ([]string, error) {
+	var urls []string
+	err := db.DB.Model(&models.User{}).Unscoped().Where("avatar <> ''").Pluck("avatar", &urls).Error
+	return urls, err
+}
+
+// UpdatePassword сохраняет новый хеш пароля и снимает требование смены пароля
+func (db *Database) UpdatePassword(userID uint, passwordHash string) error {
+	return db.DB.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"password":                passwordHash,
+		"password_reset_required": false,
+	}).Error
+}
+
+// SetPasswordResetRequired устанавливает или снимает требование сменить пароль при следующем входе
+func (db *Database) SetPasswordResetRequired(userID uint, required bool) error {
+	return db.DB.Model(&models.User{}).Where("id = ?", userID).Update("password_reset_required", required).Error
+}
+
+// GetChatPartnerIDs возвращает ID пользователей, состоящих хотя бы в одном общем чате с пользователем
+func (db *Database) GetChatPartnerIDs(userID uint) ([]uint, error) {
+	var ids []uint
+	err := db.DB.Table("chat_users AS partners").
+		Distinct("partners.user_id").
+		Joins("JOIN chat_users AS own ON own.chat_id = partners.chat_id").
+		Where("own.user_id = ? AND partners.user_id <> ?", userID, userID).
+		Pluck("partners.user_id", &ids).Error
+	return ids, err
+}
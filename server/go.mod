@@ -6,49 +6,83 @@ module messenger
 go 1.24
 
 require (
+	github.com/coreos/go-oidc/v3 v3.14.1
+	github.com/fxamacker/cbor/v2 v2.9.0
+	github.com/gabriel-vasile/mimetype v1.4.8
 	github.com/gin-gonic/gin v1.10.0
+	github.com/go-ldap/ldap/v3 v3.4.12
 	github.com/go-playground/validator/v10 v10.26.0
 	github.com/golang-jwt/jwt/v5 v5.2.2
 	github.com/gorilla/websocket v1.5.3
+	github.com/minio/minio-go/v7 v7.0.97
+	github.com/pquerna/otp v1.5.0
 	github.com/redis/go-redis/v9 v9.7.3
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 	go.uber.org/zap v1.27.0
-	golang.org/x/crypto v0.35.0
+	golang.org/x/crypto v0.36.0
+	golang.org/x/image v0.25.0
+	golang.org/x/oauth2 v0.28.0
 	gorm.io/driver/postgres v1.5.11
 	gorm.io/gorm v1.25.12
 )
 
 require (
+	github.com/Azure/go-ntlmssp v0.0.0-20221128193559-754e69321358
+	github.com/boombuler/barcode v1.0.1-0.20190219062509-6c824513bacc
 	github.com/bytedance/sonic v1.13.2
 	github.com/cespare/xxhash/v2 v2.2.0
 	github.com/chenzhuoyu/base64x v0.0.0-20230717121745-296ad89f973d
 	github.com/chenzhuoyu/iasm v0.9.1
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f
-	github.com/gabriel-vasile/mimetype v1.4.3
+	github.com/dustin/go-humanize v1.0.1
 	github.com/gin-contrib/sse v0.1.0
+	github.com/glebarez/sqlite v1.11.0
+	github.com/go-asn1-ber/asn1-ber v1.5.8-0.20250403174932-29230038a667
+	github.com/go-ini/ini v1.67.0
+	github.com/go-jose/go-jose/v4 v4.0.5
 	github.com/go-playground/locales v0.14.1
 	github.com/go-playground/universal-translator v0.18.1
 	github.com/goccy/go-json v0.10.2
+	github.com/google/uuid v1.6.0
 	github.com/jackc/pgpassfile v1.0.0
 	github.com/jackc/pgservicefile v0.0.0-20231201235250-de7065d80cb9
-	github.com/jackc/pgx/v5 v5.5.1
+	github.com/jackc/pgx/v5 v5.5.5
 	github.com/jackc/puddle/v2 v2.2.1
 	github.com/jinzhu/inflection v1.0.0
 	github.com/jinzhu/now v1.1.5
 	github.com/json-iterator/go v1.1.12
-	github.com/klauspost/cpuid/v2 v2.2.6
+	github.com/klauspost/compress v1.18.0
+	github.com/klauspost/cpuid/v2 v2.2.11
+	github.com/klauspost/crc32 v1.3.0
 	github.com/leodido/go-urn v1.4.0
 	github.com/mattn/go-isatty v0.0.20
+	github.com/minio/crc64nvme v1.1.0
+	github.com/minio/md5-simd v1.1.2
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd
 	github.com/modern-go/reflect2 v1.0.2
-	github.com/pelletier/go-toml/v2 v2.1.1
+	github.com/pelletier/go-toml/v2 v2.2.2
+	github.com/philhofer/fwd v1.2.0
+	github.com/rs/xid v1.6.0
+	github.com/tinylib/msgp v1.3.0
 	github.com/twitchyliquid64/golang-asm v0.15.1
 	github.com/ugorji/go/codec v1.2.12
+	github.com/vmihailenco/tagparser/v2 v2.0.0
+	github.com/x448/float16 v0.8.4
 	go.uber.org/multierr v1.11.0
-	golang.org/x/arch v0.7.0
-	golang.org/x/net v0.21.0
-	golang.org/x/sync v0.5.0
-	golang.org/x/sys v0.17.0
-	golang.org/x/text v0.14.0
-	google.golang.org/protobuf v1.32.0
+	golang.org/x/arch v0.8.0
+	golang.org/x/net v0.38.0
+	golang.org/x/sync v0.15.0
+	golang.org/x/sys v0.34.0
+	golang.org/x/text v0.26.0
+	google.golang.org/protobuf v1.34.1
 	gopkg.in/yaml.v3 v3.0.1
-)
\ No newline at end of file
+)
+
+require (
+	github.com/glebarez/go-sqlite v1.21.2 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	modernc.org/libc v1.22.5 // indirect
+	modernc.org/mathutil v1.5.0 // indirect
+	modernc.org/memory v1.5.0 // indirect
+	modernc.org/sqlite v1.23.1 // indirect
+)
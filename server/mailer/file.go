@@ -0,0 +1,62 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"messenger/logger"
+)
+
+// File сохраняет письма в каталог как .eml файлы (для разработки и тестов)
+type File struct {
+	dir     string
+	from    string
+	counter atomic.Uint64
+}
+
+// NewFile создает отправителя, сохраняющего письма в каталог dir
+func NewFile(dir, from string) (*File, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("не указан каталог для писем")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("ошибка создания каталога для писем %s: %w", dir, err)
+	}
+	return &File{dir: dir, from: from}, nil
+}
+
+// Send сохраняет письмо в файл <время>-<номер>.eml
+func (f *File) Send(ctx context.Context, msg Message) error {
+	data, err := buildMessage(f.from, msg)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().Format("20060102-150405.000"), f.counter.Add(1))
+	path := filepath.Join(f.dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("ошибка сохранения письма: %w", err)
+	}
+
+	logger.Infof("Mailer: Письмо для %s сохранено в %s", msg.To, path)
+	return nil
+}
+
+// Log выводит письма в лог вместо отправки
+type Log struct{}
+
+// NewLog создает отправителя, пишущего письма в лог
+func NewLog() *Log {
+	return &Log{}
+}
+
+// Send выводит письмо в лог
+func (l *Log) Send(ctx context.Context, msg Message) error {
+	logger.Infof("Mailer: Письмо для %s, тема %q:\n%s", msg.To, msg.Subject, strings.TrimSpace(msg.Text))
+	return nil
+}
@@ -0,0 +1,70 @@
+// Пакет mailer отправляет служебные письма (подтверждение email, восстановление пароля)
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"messenger/config"
+)
+
+// Message - письмо с текстовым телом
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+// Mailer отправляет письма
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New создает отправителя по Mail.Driver: smtp, file или log
+func New(cfg config.MailConfig) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTP(cfg), nil
+	case "file":
+		return NewFile(cfg.FileDir, cfg.From)
+	case "log", "":
+		return NewLog(), nil
+	default:
+		return nil, fmt.Errorf("неизвестный способ отправки писем: %s", cfg.Driver)
+	}
+}
+
+// buildMessage формирует письмо в формате RFC 5322 с телом в quoted-printable
+func buildMessage(from string, msg Message) ([]byte, error) {
+	if _, err := mail.ParseAddress(msg.To); err != nil {
+		return nil, fmt.Errorf("некорректный адрес получателя %q: %w", msg.To, err)
+	}
+	// Перевод строки в заголовках позволил бы подставить свои заголовки
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return nil, fmt.Errorf("недопустимые символы в заголовках письма")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(msg.Text, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,113 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"messenger/config"
+)
+
+// Режимы шифрования соединения с SMTP сервером
+const (
+	SMTPSecurityNone     = "none"     // Без шифрования (локальный релей, тестовый сервер)
+	SMTPSecurityStartTLS = "starttls" // STARTTLS после подключения (обычно порт 587)
+	SMTPSecurityTLS      = "tls"      // TLS с самого начала (обычно порт 465)
+)
+
+// SMTP отправляет письма через SMTP сервер
+type SMTP struct {
+	cfg config.MailConfig
+}
+
+// NewSMTP создает отправителя через SMTP
+func NewSMTP(cfg config.MailConfig) *SMTP {
+	return &SMTP{cfg: cfg}
+}
+
+// Send отправляет письмо. Каждое письмо - отдельное соединение: писем мало, а пул усложнил бы код
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	data, err := buildMessage(s.cfg.From, msg)
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(s.cfg.From)
+	if err != nil {
+		return fmt.Errorf("некорректный адрес отправителя %q: %w", s.cfg.From, err)
+	}
+	to, _ := mail.ParseAddress(msg.To) // Проверен в buildMessage
+
+	client, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if s.cfg.SMTP.Username != "" {
+		auth := smtp.PlainAuth("", s.cfg.SMTP.Username, s.cfg.SMTP.Password, s.cfg.SMTP.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("ошибка авторизации SMTP: %w", err)
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return fmt.Errorf("ошибка MAIL FROM: %w", err)
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("ошибка RCPT TO: %w", err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("ошибка DATA: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return fmt.Errorf("ошибка передачи письма: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("сервер не принял письмо: %w", err)
+	}
+	return client.Quit()
+}
+
+// dial подключается к серверу с учетом режима шифрования
+func (s *SMTP) dial(ctx context.Context) (*smtp.Client, error) {
+	addr := net.JoinHostPort(s.cfg.SMTP.Host, strconv.Itoa(s.cfg.SMTP.Port))
+	timeout := time.Duration(s.cfg.SMTP.Timeout) * time.Second
+	tlsConfig := &tls.Config{ServerName: s.cfg.SMTP.Host}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if s.cfg.SMTP.Security == SMTPSecurityTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подключения к SMTP %s: %w", addr, err)
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	client, err := smtp.NewClient(conn, s.cfg.SMTP.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ошибка приветствия SMTP: %w", err)
+	}
+
+	if s.cfg.SMTP.Security == SMTPSecurityStartTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("ошибка STARTTLS: %w", err)
+		}
+	}
+	return client, nil
+}
@@ -0,0 +1,225 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+	"testing"
+
+	"messenger/config"
+)
+
+// receivedMail - то, что фейковый SMTP сервер получил за одну сессию
+type receivedMail struct {
+	auth string // Логин и пароль из AUTH PLAIN через ":"
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTP - SMTP сервер на net.Listen, принимающий одну сессию.
+// rejectRcpt заставляет отклонить получателя кодом 550
+type fakeSMTP struct {
+	listener   net.Listener
+	rejectRcpt bool
+	received   chan receivedMail
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return &fakeSMTP{listener: listener, received: make(chan receivedMail, 1)}
+}
+
+// start принимает одно соединение и ведет диалог до QUIT
+func (f *fakeSMTP) start() {
+	go func() {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		f.serve(conn)
+	}()
+}
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	var mail receivedMail
+
+	reply("220 fake.smtp ESMTP ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250-fake.smtp")
+			reply("250-AUTH PLAIN")
+			reply("250 8BITMIME")
+		case "AUTH":
+			// AUTH PLAIN <base64("\x00user\x00password")>
+			fields := strings.Fields(line)
+			decoded, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			parts := strings.Split(string(decoded), "\x00")
+			if len(parts) == 3 {
+				mail.auth = parts[1] + ":" + parts[2]
+			}
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			// Параметры после адреса (BODY=8BITMIME) не нужны
+			mail.from = strings.Fields(line[len("MAIL FROM:"):])[0]
+			reply("250 OK")
+		case "RCPT":
+			if f.rejectRcpt {
+				reply("550 5.1.1 No such user")
+				continue
+			}
+			mail.to = append(mail.to, line[len("RCPT TO:"):])
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				dataLine, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(dataLine, "."))
+			}
+			mail.data = data.String()
+			reply("250 OK: queued")
+			f.received <- mail
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func (f *fakeSMTP) config() config.MailConfig {
+	addr := f.listener.Addr().(*net.TCPAddr)
+	cfg := config.MailConfig{Driver: "smtp", From: "Messenger <noreply@example.com>"}
+	cfg.SMTP.Host = "127.0.0.1"
+	cfg.SMTP.Port = addr.Port
+	cfg.SMTP.Security = SMTPSecurityNone
+	cfg.SMTP.Timeout = 5
+	return cfg
+}
+
+func TestSMTPSend(t *testing.T) {
+	server := newFakeSMTP(t)
+	server.start()
+	cfg := server.config()
+	cfg.SMTP.Username = "mailer"
+	cfg.SMTP.Password = "smtp-secret"
+
+	msg := Message{
+		To:      "alice@example.com",
+		Subject: "Сброс пароля",
+		Text:    "Здравствуйте, alice!\n\nСсылка: https://chat.example.com/reset-password?token=abc\n",
+	}
+	if err := NewSMTP(cfg).Send(context.Background(), msg); err != nil {
+		t.Fatalf("ошибка отправки: %v", err)
+	}
+
+	got := <-server.received
+	if got.auth != "mailer:smtp-secret" {
+		t.Errorf("AUTH PLAIN %q", got.auth)
+	}
+	if got.from != "<noreply@example.com>" || len(got.to) != 1 || got.to[0] != "<alice@example.com>" {
+		t.Errorf("конверт: MAIL FROM %s, RCPT TO %v", got.from, got.to)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(got.data))
+	if err != nil {
+		t.Fatalf("письмо не разбирается: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != msg.Subject {
+		t.Errorf("тема %q (%v), ожидалась %q", subject, err, msg.Subject)
+	}
+	if parsed.Header.Get("To") != msg.To || parsed.Header.Get("From") != cfg.From {
+		t.Errorf("заголовки From %q, To %q", parsed.Header.Get("From"), parsed.Header.Get("To"))
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.ReplaceAll(msg.Text, "\n", "\r\n"); string(body) != want {
+		t.Errorf("тело письма:\n%q\nожидалось:\n%q", body, want)
+	}
+}
+
+func TestSMTPSendRejectedRecipient(t *testing.T) {
+	server := newFakeSMTP(t)
+	server.rejectRcpt = true
+	server.start()
+
+	err := NewSMTP(server.config()).Send(context.Background(), Message{To: "nobody@example.com", Subject: "Тест", Text: "тест"})
+	if err == nil || !strings.Contains(err.Error(), "RCPT TO") {
+		t.Fatalf("ошибка %v, ожидался отказ RCPT TO", err)
+	}
+}
+
+func TestSMTPStartTLSNotSupported(t *testing.T) {
+	server := newFakeSMTP(t)
+	server.start()
+	cfg := server.config()
+	cfg.SMTP.Security = SMTPSecurityStartTLS
+
+	// Сервер не предлагает STARTTLS: письмо не должно уйти открытым текстом
+	err := NewSMTP(cfg).Send(context.Background(), Message{To: "alice@example.com", Subject: "Тест", Text: "тест"})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("ошибка %v, ожидался отказ STARTTLS", err)
+	}
+	select {
+	case <-server.received:
+		t.Error("письмо отправлено без шифрования")
+	default:
+	}
+}
+
+func TestSMTPUnavailable(t *testing.T) {
+	server := newFakeSMTP(t)
+	cfg := server.config()
+	server.listener.Close()
+
+	err := NewSMTP(cfg).Send(context.Background(), Message{To: "alice@example.com", Subject: "Тест", Text: "тест"})
+	if err == nil || !strings.Contains(err.Error(), "127.0.0.1:"+strconv.Itoa(cfg.SMTP.Port)) {
+		t.Fatalf("ошибка %v, ожидалась ошибка подключения", err)
+	}
+}
+
+func TestBuildMessageRejectsHeaderInjection(t *testing.T) {
+	for name, msg := range map[string]Message{
+		"тема":       {To: "alice@example.com", Subject: "Тест\r\nBcc: victim@example.com"},
+		"получатель": {To: "alice@example.com\r\nBcc: victim@example.com"},
+		"адрес":      {To: "не адрес"},
+	} {
+		if _, err := buildMessage("noreply@example.com", msg); err == nil {
+			t.Errorf("%s: письмо с некорректными заголовками собрано", name)
+		}
+	}
+}
@@ -24,6 +24,12 @@ func main() {
 	isProduction := os.Getenv("APP_ENV") == "production"
 	logger.Init(logLevel, isProduction)
 
+	// Служебные команды выполняются вместо запуска сервера
+	if len(os.Args) > 1 && os.Args[1] == "migrate-storage" {
+		runMigrateStorage(os.Args[2:])
+		return
+	}
+
 	logger.Info("Запуск сервера мессенджера")
 
 	// Загрузка конфигурации
@@ -0,0 +1,398 @@
+package media
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Параметры огибающей голосовых сообщений
+const (
+	// Число точек огибающей. У коротких записей точек меньше - по одной на пакет
+	WaveformLength = 100
+	// Максимальное значение точки огибающей (5 бит, как у распространенных мессенджеров)
+	WaveformMax = 31
+
+	// Частота, в которой считаются гранулы Ogg/Opus, независимо от частоты исходной записи
+	opusSampleRate = 48000
+	// Предел числа сэмплов MP4: защищает от заведомо поддельных таблиц
+	maxMP4Samples = 1 << 22
+)
+
+// AudioInfo - сведения о голосовом сообщении. Waveform - огибающая громкости из значений
+// 0..WaveformMax через равные промежутки времени
+type AudioInfo struct {
+	Duration time.Duration
+	Waveform []byte
+}
+
+// audioFrame - пакет сжатого звука: начало и длительность в единицах дорожки и размер в байтах
+type audioFrame struct {
+	start    int64
+	duration int64
+	size     int
+}
+
+// AnalyzeAudio разбирает контейнер голосового сообщения (audio/ogg с Opus или audio/mp4)
+// и возвращает длительность и огибающую.
+//
+// Декодеров Opus и AAC на чистом Go нет, поэтому огибающая строится по плотности потока:
+// оба кодека тратят на тишину в разы меньше байт, чем на речь, и число байт на единицу
+// времени повторяет громкость. Для записей с постоянным битрейтом огибающая получается ровной
+func AnalyzeAudio(data []byte, mimeType string) (*AudioInfo, error) {
+	var (
+		frames   []audioFrame
+		duration time.Duration
+		err      error
+	)
+	switch mimeType {
+	case "audio/ogg":
+		frames, duration, err = parseOggOpus(data)
+	case "audio/mp4":
+		frames, duration, err = parseMP4Audio(data)
+	default:
+		return nil, fmt.Errorf("%w: неподдерживаемый формат аудио %s", ErrMalformed, mimeType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if duration <= 0 || len(frames) == 0 {
+		return nil, fmt.Errorf("%w: запись не содержит звука", ErrMalformed)
+	}
+	return &AudioInfo{Duration: duration, Waveform: buildWaveform(frames)}, nil
+}
+
+// parseOggOpus собирает пакеты первого логического потока Ogg. Первый пакет - заголовок
+// OpusHead, второй - OpusTags, остальные - звук. Длительность берется из гранулы последней
+// страницы за вычетом pre-skip, а при ее отсутствии - из суммы длительностей пакетов
+func parseOggOpus(data []byte) ([]audioFrame, time.Duration, error) {
+	var (
+		frames      []audioFrame
+		packet      []byte
+		packets     int
+		serial      uint32
+		preSkip     int64
+		position    int64
+		lastGranule int64 = -1
+	)
+
+	for offset := 0; offset < len(data); {
+		page, first := data[offset:], offset == 0
+		if len(page) < 27 || string(page[:4]) != "OggS" || page[4] != 0 {
+			return nil, 0, fmt.Errorf("%w: некорректная страница Ogg", ErrMalformed)
+		}
+		segments := int(page[26])
+		if len(page) < 27+segments {
+			return nil, 0, fmt.Errorf("%w: обрезанная страница Ogg", ErrMalformed)
+		}
+		lacing := page[27 : 27+segments]
+		bodyLen := 0
+		for _, l := range lacing {
+			bodyLen += int(l)
+		}
+		if len(page) < 27+segments+bodyLen {
+			return nil, 0, fmt.Errorf("%w: обрезанная страница Ogg", ErrMalformed)
+		}
+		body := page[27+segments : 27+segments+bodyLen]
+		offset += 27 + segments + bodyLen
+
+		// Потоки, кроме первого (например, видео в том же файле), пропускаются
+		pageSerial := binary.LittleEndian.Uint32(page[14:])
+		if first {
+			serial = pageSerial
+		} else if pageSerial != serial {
+			continue
+		}
+		// Страница без флага продолжения начинает новый пакет
+		if page[5]&0x01 == 0 {
+			packet = packet[:0]
+		}
+
+		for _, l := range lacing {
+			packet = append(packet, body[:l]...)
+			body = body[l:]
+			if l == 255 {
+				continue // Пакет продолжается в следующем сегменте
+			}
+
+			switch packets {
+			case 0:
+				if len(packet) < 19 || string(packet[:8]) != "OpusHead" {
+					return nil, 0, fmt.Errorf("%w: поток Ogg не содержит Opus", ErrMalformed)
+				}
+				preSkip = int64(binary.LittleEndian.Uint16(packet[10:]))
+			case 1:
+				// OpusTags: теги записи не нужны
+			default:
+				samples := opusPacketSamples(packet)
+				frames = append(frames, audioFrame{start: position, duration: samples, size: len(packet)})
+				position += samples
+			}
+			packets++
+			packet = packet[:0]
+		}
+
+		// Гранула -1 означает, что на странице не заканчивается ни один пакет
+		if granule := int64(binary.LittleEndian.Uint64(page[6:])); granule != -1 {
+			lastGranule = granule
+		}
+	}
+
+	samples := position - preSkip
+	if lastGranule > preSkip {
+		samples = lastGranule - preSkip
+	}
+	return frames, samplesToDuration(samples, opusSampleRate), nil
+}
+
+// opusPacketSamples возвращает длительность пакета Opus в сэмплах 48 кГц по байту TOC (RFC 6716, 3.1)
+func opusPacketSamples(packet []byte) int64 {
+	if len(packet) == 0 {
+		return 0 // Пустой пакет - пропуск при DTX
+	}
+	toc := packet[0]
+	config := toc >> 3
+
+	var frame int64
+	switch {
+	case config < 12: // SILK: 10, 20, 40, 60 мс
+		frame = [...]int64{480, 960, 1920, 2880}[config&3]
+	case config < 16: // Hybrid: 10, 20 мс
+		frame = [...]int64{480, 960}[config&1]
+	default: // CELT: 2.5, 5, 10, 20 мс
+		frame = [...]int64{120, 240, 480, 960}[config&3]
+	}
+
+	switch toc & 3 {
+	case 0:
+		return frame
+	case 1, 2:
+		return 2 * frame
+	default:
+		if len(packet) < 2 {
+			return 0
+		}
+		return int64(packet[1]&0x3F) * frame
+	}
+}
+
+// parseMP4Audio находит звуковую дорожку в moov и читает из таблиц stsz и stts размеры
+// и длительности сэмплов. Файлы с видеодорожкой голосовыми сообщениями не считаются
+func parseMP4Audio(data []byte) ([]audioFrame, time.Duration, error) {
+	moov, err := findBox(data, "moov")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var sound []byte
+	err = eachBox(moov, func(boxType string, trak []byte) error {
+		if boxType != "trak" {
+			return nil
+		}
+		hdlr, err := findBox(trak, "mdia", "hdlr")
+		if err != nil {
+			return err
+		}
+		if len(hdlr) < 12 {
+			return fmt.Errorf("%w: некорректный блок hdlr", ErrMalformed)
+		}
+		switch string(hdlr[8:12]) {
+		case "vide":
+			return fmt.Errorf("%w: файл содержит видео", ErrMalformed)
+		case "soun":
+			if sound == nil {
+				sound = trak
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if sound == nil {
+		return nil, 0, fmt.Errorf("%w: нет звуковой дорожки", ErrMalformed)
+	}
+
+	mdhd, err := findBox(sound, "mdia", "mdhd")
+	if err != nil {
+		return nil, 0, err
+	}
+	timescale, trackDuration, err := parseMDHD(mdhd)
+	if err != nil {
+		return nil, 0, err
+	}
+	stbl, err := findBox(sound, "mdia", "minf", "stbl")
+	if err != nil {
+		return nil, 0, err
+	}
+	frames, err := mp4Frames(stbl)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if trackDuration == 0 && len(frames) > 0 {
+		last := frames[len(frames)-1]
+		trackDuration = last.start + last.duration
+	}
+	return frames, samplesToDuration(trackDuration, timescale), nil
+}
+
+// parseMDHD возвращает масштаб времени дорожки и ее длительность в этом масштабе
+func parseMDHD(mdhd []byte) (int64, int64, error) {
+	var timescale, duration int64
+	switch {
+	case len(mdhd) >= 24 && mdhd[0] == 0:
+		timescale = int64(binary.BigEndian.Uint32(mdhd[12:]))
+		duration = int64(binary.BigEndian.Uint32(mdhd[16:]))
+	case len(mdhd) >= 32 && mdhd[0] == 1:
+		timescale = int64(binary.BigEndian.Uint32(mdhd[20:]))
+		duration = int64(binary.BigEndian.Uint64(mdhd[24:]) & math.MaxInt64)
+	default:
+		return 0, 0, fmt.Errorf("%w: некорректный блок mdhd", ErrMalformed)
+	}
+	if timescale == 0 {
+		return 0, 0, fmt.Errorf("%w: нулевой масштаб времени", ErrMalformed)
+	}
+	return timescale, duration, nil
+}
+
+// mp4Frames сопоставляет размеры сэмплов из stsz с длительностями из stts
+func mp4Frames(stbl []byte) ([]audioFrame, error) {
+	stsz, err := findBox(stbl, "stsz")
+	if err != nil {
+		return nil, err
+	}
+	stts, err := findBox(stbl, "stts")
+	if err != nil {
+		return nil, err
+	}
+	if len(stsz) < 12 || len(stts) < 8 {
+		return nil, fmt.Errorf("%w: некорректная таблица сэмплов", ErrMalformed)
+	}
+
+	sampleSize := binary.BigEndian.Uint32(stsz[4:])
+	sampleCount := int(binary.BigEndian.Uint32(stsz[8:]))
+	sizes := stsz[12:]
+	if sampleCount > maxMP4Samples || sampleSize == 0 && len(sizes) < sampleCount*4 {
+		return nil, fmt.Errorf("%w: некорректная таблица stsz", ErrMalformed)
+	}
+
+	entries := int(binary.BigEndian.Uint32(stts[4:]))
+	if len(stts) < 8+entries*8 {
+		return nil, fmt.Errorf("%w: некорректная таблица stts", ErrMalformed)
+	}
+
+	frames := make([]audioFrame, 0, sampleCount)
+	var position int64
+	for i := 0; i < entries && len(frames) < sampleCount; i++ {
+		entry := stts[8+i*8:]
+		count := int(binary.BigEndian.Uint32(entry))
+		delta := int64(binary.BigEndian.Uint32(entry[4:]))
+		for j := 0; j < count && len(frames) < sampleCount; j++ {
+			size := int(sampleSize)
+			if sampleSize == 0 {
+				size = int(binary.BigEndian.Uint32(sizes[len(frames)*4:]))
+			}
+			frames = append(frames, audioFrame{start: position, duration: delta, size: size})
+			position += delta
+		}
+	}
+	return frames, nil
+}
+
+// eachBox вызывает fn для каждого бокса MP4 верхнего уровня в data с его содержимым
+func eachBox(data []byte, fn func(boxType string, payload []byte) error) error {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return fmt.Errorf("%w: обрезанный бокс MP4", ErrMalformed)
+		}
+		size := uint64(binary.BigEndian.Uint32(data))
+		boxType := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0: // Бокс до конца файла
+			size = uint64(len(data))
+		case 1: // 64-битный размер
+			if len(data) < 16 {
+				return fmt.Errorf("%w: обрезанный бокс MP4", ErrMalformed)
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return fmt.Errorf("%w: некорректный размер бокса %q", ErrMalformed, boxType)
+		}
+		if err := fn(boxType, data[header:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// findBox возвращает содержимое первого бокса по пути вложенности
+func findBox(data []byte, path ...string) ([]byte, error) {
+	for _, name := range path {
+		var found []byte
+		err := eachBox(data, func(boxType string, payload []byte) error {
+			if found == nil && boxType == name {
+				found = payload
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if found == nil {
+			return nil, fmt.Errorf("%w: нет бокса %q", ErrMalformed, name)
+		}
+		data = found
+	}
+	return data, nil
+}
+
+// buildWaveform делит запись на равные промежутки и считает в каждом число байт на единицу
+// времени, нормируя результат к 0..WaveformMax
+func buildWaveform(frames []audioFrame) []byte {
+	last := frames[len(frames)-1]
+	total := last.start + last.duration
+	n := min(WaveformLength, len(frames))
+	if total <= 0 {
+		return nil
+	}
+
+	bytesPerBucket := make([]float64, n)
+	timePerBucket := make([]float64, n)
+	for _, f := range frames {
+		bucket := min(int(f.start*int64(n)/total), n-1)
+		bytesPerBucket[bucket] += float64(f.size)
+		timePerBucket[bucket] += float64(f.duration)
+	}
+
+	density := make([]float64, n)
+	peak := 0.0
+	for i := range density {
+		if timePerBucket[i] > 0 {
+			density[i] = bytesPerBucket[i] / timePerBucket[i]
+		}
+		peak = math.Max(peak, density[i])
+	}
+
+	waveform := make([]byte, n)
+	if peak == 0 {
+		return waveform
+	}
+	for i, d := range density {
+		waveform[i] = byte(math.Round(d / peak * WaveformMax))
+	}
+	return waveform
+}
+
+// samplesToDuration переводит число сэмплов в длительность без переполнения на длинных записях
+func samplesToDuration(samples, rate int64) time.Duration {
+	if samples <= 0 || rate <= 0 {
+		return 0
+	}
+	return time.Duration(samples/rate)*time.Second + time.Duration(samples%rate)*time.Second/time.Duration(rate)
+}
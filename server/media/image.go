@@ -0,0 +1,257 @@
+// Пакет media извлекает сведения из загруженных изображений и аудио и готовит производные
+// файлы (миниатюры). Все реализовано на Go без внешних программ
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Регистрация декодеров для image.Decode
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+// Параметры обработки изображений
+const (
+	// Изображения больше этого числа пикселей не декодируются: миниатюры для них не строятся
+	MaxImagePixels = 40_000_000
+
+	thumbnailJPEGQuality = 80
+	// Сторона уменьшенной копии, по которой считается BlurHash
+	blurhashSampleSize = 32
+)
+
+// ThumbnailSize - миниатюра, вписанная в квадрат со стороной MaxSide
+type ThumbnailSize struct {
+	Name    string
+	MaxSide int
+}
+
+// ThumbnailSizes - размеры миниатюр. Миниатюра строится, только если изображение больше нее
+var ThumbnailSizes = []ThumbnailSize{
+	{Name: "small", MaxSide: 160},  // Список файлов, ответы
+	{Name: "medium", MaxSide: 480}, // Сообщение в чате
+	{Name: "large", MaxSide: 1280}, // Просмотр
+}
+
+// ImageInfo - сведения об изображении. Width и Height - размеры с учетом ориентации,
+// то есть такие, какими изображение показывается
+type ImageInfo struct {
+	Width       int
+	Height      int
+	Orientation int
+	Blurhash    string
+	Thumbnails  []Thumbnail
+}
+
+// Thumbnail - закодированная миниатюра
+type Thumbnail struct {
+	Name     string
+	Width    int
+	Height   int
+	MimeType string
+	Data     []byte
+}
+
+// IsProcessableImage сообщает, умеет ли пакет обрабатывать изображения этого типа
+func IsProcessableImage(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// AnalyzeImage читает размеры изображения и, если оно не слишком большое, строит миниатюры
+// и BlurHash. orientation - значение EXIF Orientation, полученное от StripMetadata
+func AnalyzeImage(r io.ReadSeeker, orientation int) (*ImageInfo, error) {
+	cfg, _, err := image.DecodeConfig(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if orientation < 1 || orientation > 8 {
+		orientation = 1
+	}
+
+	info := &ImageInfo{Width: cfg.Width, Height: cfg.Height, Orientation: orientation}
+	if swapsAxes(orientation) {
+		info.Width, info.Height = cfg.Height, cfg.Width
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxImagePixels {
+		return info, nil
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	// Прозрачность сохраняется только в PNG, непрозрачные миниатюры кодируются в JPEG
+	opaque := true
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		opaque = o.Opaque()
+	}
+
+	for _, size := range ThumbnailSizes {
+		if max(cfg.Width, cfg.Height) <= size.MaxSide {
+			continue
+		}
+		thumb := orient(scaleToFit(img, size.MaxSide, opaque), orientation)
+		data, mimeType, err := encodeThumbnail(thumb, opaque)
+		if err != nil {
+			return nil, err
+		}
+		info.Thumbnails = append(info.Thumbnails, Thumbnail{
+			Name:     size.Name,
+			Width:    thumb.Bounds().Dx(),
+			Height:   thumb.Bounds().Dy(),
+			MimeType: mimeType,
+			Data:     data,
+		})
+	}
+
+	// 4 компоненты по длинной стороне и 3 по короткой - рекомендация авторов BlurHash
+	sample := orient(scaleToFit(img, blurhashSampleSize, true), orientation)
+	xComp, yComp := 4, 3
+	if info.Height > info.Width {
+		xComp, yComp = 3, 4
+	}
+	info.Blurhash = Blurhash(sample, xComp, yComp)
+
+	return info, nil
+}
+
+// AvatarThumbnails строит квадратные JPEG-миниатюры аватара со сторонами sides из центрального
+// квадрата изображения. Маленькие изображения увеличиваются: размеры аватаров фиксированы.
+// orientation - значение EXIF Orientation, полученное от StripMetadata
+func AvatarThumbnails(r io.ReadSeeker, orientation int, sides []int) ([]Thumbnail, error) {
+	cfg, _, err := image.DecodeConfig(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxImagePixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrTooLarge, cfg.Width, cfg.Height)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	// Центральный квадрат не зависит от поворота, поэтому ориентация применяется после уменьшения
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2))
+
+	thumbnails := make([]Thumbnail, 0, len(sides))
+	for _, size := range sides {
+		thumb := orient(scale(img, crop, size, size, true), orientation)
+		data, mimeType, err := encodeThumbnail(thumb, true)
+		if err != nil {
+			return nil, err
+		}
+		thumbnails = append(thumbnails, Thumbnail{
+			Name:     strconv.Itoa(size),
+			Width:    size,
+			Height:   size,
+			MimeType: mimeType,
+			Data:     data,
+		})
+	}
+	return thumbnails, nil
+}
+
+// scaleToFit уменьшает изображение, вписывая его в квадрат maxSide
+func scaleToFit(img image.Image, maxSide int, opaque bool) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w >= h {
+		w, h = maxSide, max(1, h*maxSide/w)
+	} else {
+		w, h = max(1, w*maxSide/h), maxSide
+	}
+	if w > b.Dx() || h > b.Dy() {
+		w, h = b.Dx(), b.Dy()
+	}
+	return scale(img, b, w, h, opaque)
+}
+
+// scale масштабирует область src изображения до w x h. Непрозрачный результат рисуется
+// на белом фоне: у JPEG нет прозрачности
+func scale(img image.Image, src image.Rectangle, w, h int, opaque bool) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	if opaque {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	}
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Over, nil)
+	return dst
+}
+
+func encodeThumbnail(img image.Image, opaque bool) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if opaque {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailJPEGQuality}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
+
+// swapsAxes сообщает, меняет ли ориентация ширину и высоту местами (повороты на 90°)
+func swapsAxes(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
+
+// orient поворачивает и отражает изображение согласно EXIF Orientation, чтобы миниатюры
+// не зависели от поддержки EXIF на клиенте
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if swapsAxes(orientation) {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Отражение по горизонтали
+				dx, dy = w-1-x, y
+			case 3: // Поворот на 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // Отражение по вертикали
+				dx, dy = x, h-1-y
+			case 5: // Транспонирование
+				dx, dy = y, x
+			case 6: // Поворот на 90° по часовой стрелке
+				dx, dy = h-1-y, x
+			case 7: // Транспонирование с поворотом на 180°
+				dx, dy = h-1-y, w-1-x
+			case 8: // Поворот на 90° против часовой стрелки
+				dx, dy = y, w-1-x
+			}
+			dst.SetRGBA(dx, dy, src.RGBAAt(x, y))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,257 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// ErrMalformed - файл не удалось разобрать как изображение или аудио заявленного формата
+var ErrMalformed = errors.New("некорректная структура файла")
+
+// ErrTooLarge - изображение слишком велико для декодирования (больше MaxImagePixels)
+var ErrTooLarge = errors.New("слишком большое изображение")
+
+const (
+	// Тег EXIF Orientation
+	exifOrientationTag = 0x0112
+	// Максимальный размер блока PNG с метаданными, который читается в память
+	maxPNGMetadataChunk = 1 << 20
+)
+
+var (
+	jpegExifPrefix = []byte("Exif\x00\x00")
+	jpegXMPPrefix  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	jpegXMPExt     = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	pngSignature   = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// StripMetadata копирует изображение из src в dst без EXIF, XMP и IPTC: в них бывают координаты
+// съемки, модель устройства и другие личные данные. Из EXIF сохраняется только ориентация,
+// чтобы изображение показывалось так же, как до очистки. Возвращает значение ориентации (1-8).
+// Форматы, кроме JPEG и PNG, копируются без изменений
+func StripMetadata(dst io.Writer, src io.Reader, mimeType string) (int, error) {
+	switch mimeType {
+	case "image/jpeg":
+		return stripJPEG(dst, bufio.NewReader(src))
+	case "image/png":
+		return stripPNG(dst, bufio.NewReader(src))
+	default:
+		_, err := io.Copy(dst, src)
+		return 1, err
+	}
+}
+
+// stripJPEG проходит по сегментам до начала данных изображения (SOS) и пропускает APP1 с EXIF
+// и XMP и APP13 (Photoshop/IPTC). Остальные сегменты, в том числе ICC-профиль, сохраняются
+func stripJPEG(dst io.Writer, r *bufio.Reader) (int, error) {
+	orientation := 1
+
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return 0, ErrMalformed
+	}
+	if _, err := dst.Write(soi[:]); err != nil {
+		return 0, err
+	}
+
+	for {
+		marker, err := readJPEGMarker(r)
+		if err != nil {
+			return 0, err
+		}
+
+		// После SOS идут сжатые данные: копируем остаток файла как есть
+		if marker == 0xDA {
+			if _, err := dst.Write([]byte{0xFF, marker}); err != nil {
+				return 0, err
+			}
+			_, err := io.Copy(dst, r)
+			return orientation, err
+		}
+		if marker == 0xD9 || marker == 0xD8 || marker >= 0xD0 && marker <= 0xD7 {
+			return 0, ErrMalformed
+		}
+
+		var lenBuf [2]byte
+		if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+			return 0, ErrMalformed
+		}
+		length := int(binary.BigEndian.Uint16(lenBuf[:]))
+		if length < 2 {
+			return 0, ErrMalformed
+		}
+		payload := make([]byte, length-2)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return 0, ErrMalformed
+		}
+
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, jpegExifPrefix):
+			if o := exifOrientation(payload[len(jpegExifPrefix):]); o > 1 {
+				orientation = o
+				// Вместо исходного EXIF записываем минимальный, только с ориентацией
+				segment := append(jpegExifPrefix[:len(jpegExifPrefix):len(jpegExifPrefix)], orientationTIFF(o)...)
+				if err := writeJPEGSegment(dst, 0xE1, segment); err != nil {
+					return 0, err
+				}
+			}
+			continue
+		case marker == 0xE1 && (bytes.HasPrefix(payload, jpegXMPPrefix) || bytes.HasPrefix(payload, jpegXMPExt)):
+			continue
+		case marker == 0xED:
+			continue
+		}
+
+		if err := writeJPEGSegment(dst, marker, payload); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// readJPEGMarker читает маркер сегмента, пропуская байты-заполнители 0xFF
+func readJPEGMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil || b != 0xFF {
+		return 0, ErrMalformed
+	}
+	for {
+		b, err = r.ReadByte()
+		if err != nil {
+			return 0, ErrMalformed
+		}
+		if b != 0xFF {
+			return b, nil
+		}
+	}
+}
+
+func writeJPEGSegment(dst io.Writer, marker byte, payload []byte) error {
+	header := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(payload)+2))
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+	_, err := dst.Write(payload)
+	return err
+}
+
+// stripPNG удаляет блоки eXIf (заменяя его минимальным с ориентацией) и текстовые блоки
+// tEXt, zTXt, iTXt, в которых хранятся XMP и произвольные метаданные
+func stripPNG(dst io.Writer, r *bufio.Reader) (int, error) {
+	orientation := 1
+
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, signature); err != nil || !bytes.Equal(signature, pngSignature) {
+		return 0, ErrMalformed
+	}
+	if _, err := dst.Write(signature); err != nil {
+		return 0, err
+	}
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return 0, ErrMalformed
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		chunkType := string(header[4:])
+
+		switch chunkType {
+		case "eXIf":
+			if length > maxPNGMetadataChunk {
+				return 0, fmt.Errorf("%w: слишком большой блок eXIf", ErrMalformed)
+			}
+			data := make([]byte, length+4)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return 0, ErrMalformed
+			}
+			if o := exifOrientation(data[:length]); o > 1 {
+				orientation = o
+				if err := writePNGChunk(dst, "eXIf", orientationTIFF(o)); err != nil {
+					return 0, err
+				}
+			}
+			continue
+		case "tEXt", "zTXt", "iTXt":
+			if _, err := io.CopyN(io.Discard, r, length+4); err != nil {
+				return 0, ErrMalformed
+			}
+			continue
+		}
+
+		if _, err := dst.Write(header[:]); err != nil {
+			return 0, err
+		}
+		if _, err := io.CopyN(dst, r, length+4); err != nil {
+			return 0, ErrMalformed
+		}
+		if chunkType == "IEND" {
+			// Данные после IEND не нужны для показа, но могут содержать что угодно
+			_, err := io.Copy(io.Discard, r)
+			return orientation, err
+		}
+	}
+}
+
+func writePNGChunk(dst io.Writer, chunkType string, data []byte) error {
+	buf := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], chunkType)
+	buf = append(buf, data...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	_, err := dst.Write(buf)
+	return err
+}
+
+// exifOrientation читает тег Orientation из IFD0 данных TIFF. Возвращает 1, если тега нет
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		// Тип 3 - SHORT, значение лежит в первых байтах поля значения
+		if order.Uint16(tiff[entry:]) == exifOrientationTag && order.Uint16(tiff[entry+2:]) == 3 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orientationTIFF строит данные TIFF с единственным тегом Orientation
+func orientationTIFF(orientation int) []byte {
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	tiff = binary.BigEndian.AppendUint16(tiff, 1) // Одна запись в IFD0
+	tiff = binary.BigEndian.AppendUint16(tiff, exifOrientationTag)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(orientation))
+	tiff = append(tiff, 0, 0)
+	return binary.BigEndian.AppendUint32(tiff, 0) // Следующего IFD нет
+}
@@ -1,145 +1,351 @@
 package middleware
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"messenger/logger"
+)
+
+// Префиксы ключей счетчиков попыток входа
+const (
+	LoginKeyIP   = "ip:"
+	LoginKeyUser = "user:"
 )
 
-// LoginAttempt хранит информацию о попытках входа
+// Максимальный размер тела запроса входа, которое читает лимитер
+const loginBodyLimit = 64 << 10
+
+// LoginAttempt хранит неудачные попытки входа по ключу (ip:<адрес> или user:<имя>)
 type LoginAttempt struct {
-	Count     int       // Количество попыток
-	FirstTry  time.Time // Время первой попытки
-	LastTry   time.Time // Время последней попытки
-	BlockedAt time.Time // Время блокировки (если установлено)
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`              // Неудачных попыток подряд
+	LastFailure time.Time `json:"last_failure"`          // Время последней неудачной попытки
+	LockedUntil time.Time `json:"locked_until,omitzero"` // Вход заблокирован до этого времени
 }
 
-// AuthLimiter защищает от атак грубой силы на API входа
-type AuthLimiter struct {
-	attempts    map[string]*LoginAttempt // IP -> попытки
-	mu          sync.RWMutex             // Мьютекс для синхронизации доступа к map
-	maxAttempts int                      // Максимальное число попыток
-	blockTime   time.Duration            // Время блокировки
-	resetTime   time.Duration            // Время сброса счетчика
+// Locked сообщает, действует ли блокировка на момент now
+func (a *LoginAttempt) Locked(now time.Time) bool {
+	return a != nil && now.Before(a.LockedUntil)
 }
 
-// NewAuthLimiter создает новый лимитер авторизации
-func NewAuthLimiter(maxAttempts int, blockTime, resetTime time.Duration) *AuthLimiter {
-	return &AuthLimiter{
-		attempts:    make(map[string]*LoginAttempt),
-		maxAttempts: maxAttempts,
-		blockTime:   blockTime,
-		resetTime:   resetTime,
+// LoginAttemptStore хранит счетчики попыток входа. Хранилище в Redis позволяет
+// нескольким экземплярам сервера видеть общие счетчики
+type LoginAttemptStore interface {
+	// Get возвращает попытки по ключу или nil, если их нет
+	Get(ctx context.Context, key string) (*LoginAttempt, error)
+	// Update атомарно изменяет попытки по ключу функцией fn и сохраняет их на ttl
+	Update(ctx context.Context, key string, ttl time.Duration, fn func(attempt *LoginAttempt)) (*LoginAttempt, error)
+	// Delete удаляет попытки по ключу
+	Delete(ctx context.Context, key string) error
+	// List возвращает все сохраненные попытки
+	List(ctx context.Context) ([]LoginAttempt, error)
+}
+
+// LoginThrottlePolicy задает лимиты попыток входа.
+// Нулевой лимит попыток отключает проверку по соответствующему ключу
+type LoginThrottlePolicy struct {
+	MaxUserAttempts int           // Неудачных попыток для имени пользователя до блокировки
+	MaxIPAttempts   int           // Неудачных попыток с одного IP до блокировки
+	BaseLockout     time.Duration // Блокировка после достижения лимита
+	MaxLockout      time.Duration // Предельная длительность блокировки
+	ResetWindow     time.Duration // Счетчик сбрасывается, если неудачных попыток не было это время
+}
+
+// lockoutFor возвращает длительность блокировки для числа неудачных попыток:
+// BaseLockout при достижении лимита, далее удваивается с каждой попыткой до MaxLockout
+func (p LoginThrottlePolicy) lockoutFor(failures, limit int) time.Duration {
+	if limit <= 0 || failures < limit {
+		return 0
+	}
+	lockout := p.BaseLockout
+	for i := limit; i < failures && lockout < p.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > p.MaxLockout {
+		lockout = p.MaxLockout
 	}
+	return lockout
 }
 
-// Middleware возвращает Gin middleware для ограничения попыток входа
-func (al *AuthLimiter) Middleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Только для эндпоинта логина
-		if c.Request.URL.Path != "/api/login" || c.Request.Method != "POST" {
-			c.Next()
-			return
-		}
+// AuthLimiter защищает вход от перебора паролей: считает неудачные попытки
+// по имени пользователя и по IP и блокирует вход с экспоненциально растущей задержкой
+type AuthLimiter struct {
+	store  LoginAttemptStore
+	policy LoginThrottlePolicy
+}
 
-		// Получаем IP-адрес клиента
-		clientIP := c.ClientIP()
+// NewAuthLimiter создает лимитер входа с указанным хранилищем
+func NewAuthLimiter(store LoginAttemptStore, policy LoginThrottlePolicy) *AuthLimiter {
+	return &AuthLimiter{store: store, policy: policy}
+}
 
-		// Проверяем, не заблокирован ли клиент
-		al.mu.RLock()
-		attempt, exists := al.attempts[clientIP]
-		al.mu.RUnlock()
+// Policy возвращает лимиты
+func (al *AuthLimiter) Policy() LoginThrottlePolicy {
+	return al.policy
+}
 
+// Middleware возвращает Gin middleware для маршрута входа.
+// Имя пользователя берется из поля username JSON-тела запроса
+func (al *AuthLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy := al.Policy()
+		ctx := c.Request.Context()
 		now := time.Now()
 
-		if exists {
-			// Если клиент заблокирован
-			if !attempt.BlockedAt.IsZero() && now.Sub(attempt.BlockedAt) < al.blockTime {
-				// Вычисляем время до разблокировки
-				remainingTime := al.blockTime - now.Sub(attempt.BlockedAt)
-				c.JSON(http.StatusTooManyRequests, gin.H{
-					"error": "Слишком много попыток входа. Попробуйте позже.",
-					"wait":  int(remainingTime.Seconds()),
-				})
-				c.Abort()
+		keys := al.requestKeys(c, policy)
+
+		// Проверяем, не заблокирован ли клиент или имя пользователя
+		for _, key := range keys {
+			attempt, err := al.store.Get(ctx, key)
+			if err != nil {
+				// Недоступность хранилища не должна блокировать вход
+				logger.Errorf("AuthLimiter: Ошибка чтения попыток %s: %v", key, err)
+				continue
+			}
+			if attempt.Locked(now) {
+				remaining := attempt.LockedUntil.Sub(now)
+				logger.Warnf("AuthLimiter: Вход отклонен, %s заблокирован еще на %s", key, remaining.Round(time.Second))
+				abortLocked(c, remaining)
 				return
 			}
+		}
 
-			// Проверяем, не нужно ли сбросить счетчик попыток
-			if now.Sub(attempt.LastTry) > al.resetTime {
-				al.mu.Lock()
-				al.attempts[clientIP] = &LoginAttempt{
-					Count:    1,
-					FirstTry: now,
-					LastTry:  now,
-				}
-				al.mu.Unlock()
-			} else {
-				// Увеличиваем счетчик попыток
-				al.mu.Lock()
-				attempt.Count++
-				attempt.LastTry = now
-
-				// Если достигнут лимит, блокируем
-				if attempt.Count > al.maxAttempts {
-					attempt.BlockedAt = now
-				}
-				al.mu.Unlock()
-
-				// Проверяем, не превышен ли лимит
-				if attempt.Count > al.maxAttempts {
-					c.JSON(http.StatusTooManyRequests, gin.H{
-						"error": "Слишком много попыток входа. Попробуйте позже.",
-						"wait":  int(al.blockTime.Seconds()),
-					})
-					c.Abort()
-					return
-				}
+		c.Next()
+
+		switch c.Writer.Status() {
+		case http.StatusUnauthorized:
+			// Неудачная попытка: увеличиваем счетчики
+			for _, key := range keys {
+				al.recordFailure(ctx, key, policy, now)
 			}
-		} else {
-			// Первая попытка
-			al.mu.Lock()
-			al.attempts[clientIP] = &LoginAttempt{
-				Count:    1,
-				FirstTry: now,
-				LastTry:  now,
+		case http.StatusOK:
+			// Успешный вход сбрасывает счетчик имени пользователя. Счетчик IP не сбрасываем:
+			// иначе перебор с одного адреса можно было бы прерывать входом в свою учетную запись
+			for _, key := range keys {
+				if strings.HasPrefix(key, LoginKeyUser) {
+					if err := al.store.Delete(ctx, key); err != nil {
+						logger.Errorf("AuthLimiter: Ошибка сброса попыток %s: %v", key, err)
+					}
+				}
 			}
-			al.mu.Unlock()
 		}
+	}
+}
 
-		// Продолжаем обработку запроса
-		c.Next()
+// requestKeys возвращает ключи счетчиков для запроса: IP и имя пользователя
+func (al *AuthLimiter) requestKeys(c *gin.Context, policy LoginThrottlePolicy) []string {
+	var keys []string
+	if policy.MaxIPAttempts > 0 {
+		keys = append(keys, LoginKeyIP+c.ClientIP())
+	}
+	if policy.MaxUserAttempts > 0 {
+		if username := peekUsername(c); username != "" {
+			keys = append(keys, LoginKeyUser+username)
+		}
+	}
+	return keys
+}
+
+// recordFailure учитывает неудачную попытку и при достижении лимита назначает блокировку
+func (al *AuthLimiter) recordFailure(ctx context.Context, key string, policy LoginThrottlePolicy, now time.Time) {
+	limit := policy.MaxUserAttempts
+	if strings.HasPrefix(key, LoginKeyIP) {
+		limit = policy.MaxIPAttempts
+	}
+
+	// Запись живет до конца самой долгой блокировки и окна сброса после нее
+	ttl := policy.MaxLockout + policy.ResetWindow
+
+	attempt, err := al.store.Update(ctx, key, ttl, func(attempt *LoginAttempt) {
+		// Окно сброса отсчитывается от последней попытки или конца блокировки:
+		// попытки сразу после истечения блокировки продолжают наращивать задержку
+		last := attempt.LastFailure
+		if attempt.LockedUntil.After(last) {
+			last = attempt.LockedUntil
+		}
+		if now.Sub(last) > policy.ResetWindow {
+			attempt.Failures = 0
+		}
+		attempt.Failures++
+		attempt.LastFailure = now
+		if lockout := policy.lockoutFor(attempt.Failures, limit); lockout > 0 {
+			attempt.LockedUntil = now.Add(lockout)
+		}
+	})
+	if err != nil {
+		logger.Errorf("AuthLimiter: Ошибка сохранения попыток %s: %v", key, err)
+		return
+	}
+	if attempt.Locked(now) {
+		logger.Warnf("AuthLimiter: %s заблокирован до %s после %d неудачных попыток", key, attempt.LockedUntil.Format(time.RFC3339), attempt.Failures)
+	}
+}
+
+// List возвращает сохраненные попытки, сначала активные блокировки
+func (al *AuthLimiter) List(ctx context.Context) ([]LoginAttempt, error) {
+	attempts, err := al.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(attempts, func(i, j int) bool {
+		if !attempts[i].LockedUntil.Equal(attempts[j].LockedUntil) {
+			return attempts[i].LockedUntil.After(attempts[j].LockedUntil)
+		}
+		return attempts[i].Key < attempts[j].Key
+	})
+	return attempts, nil
+}
+
+// Clear снимает блокировку и сбрасывает счетчик по ключу
+func (al *AuthLimiter) Clear(ctx context.Context, key string) error {
+	return al.store.Delete(ctx, key)
+}
+
+// ClearAll сбрасывает все счетчики и блокировки. Возвращает число удаленных записей
+func (al *AuthLimiter) ClearAll(ctx context.Context) (int, error) {
+	attempts, err := al.store.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, attempt := range attempts {
+		if err := al.store.Delete(ctx, attempt.Key); err != nil {
+			return 0, err
+		}
+	}
+	return len(attempts), nil
+}
+
+// peekUsername читает имя пользователя из тела запроса, не забирая тело у обработчика
+func peekUsername(c *gin.Context) string {
+	if c.Request.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, loginBodyLimit))
+	if err != nil {
+		return ""
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return ""
+	}
+	return normalizeLoginUsername(req.Username)
+}
+
+// normalizeLoginUsername приводит имя к виду ключа: перебор не должен обходить лимит сменой регистра
+func normalizeLoginUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// abortLocked отвечает 429 с временем до снятия блокировки
+func abortLocked(c *gin.Context, remaining time.Duration) {
+	seconds := int(remaining.Seconds() + 0.999)
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"code":           "RATE_LIMITED",
+		"error":          "Слишком много попыток входа. Попробуйте позже.",
+		"wait":           seconds,
+		"retry_after_ms": remaining.Milliseconds(),
+	})
+}
+
+// MemoryLoginAttemptStore хранит попытки входа в памяти процесса
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]*memoryLoginAttempt
+}
+
+type memoryLoginAttempt struct {
+	LoginAttempt
+	expires time.Time
+}
+
+// NewMemoryLoginAttemptStore создает хранилище попыток в памяти
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{attempts: make(map[string]*memoryLoginAttempt)}
+}
+
+// Get возвращает попытки по ключу
+func (s *MemoryLoginAttemptStore) Get(ctx context.Context, key string) (*LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.attempts[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, nil
+	}
+	attempt := entry.LoginAttempt
+	return &attempt, nil
+}
+
+// Update изменяет попытки по ключу под блокировкой хранилища
+func (s *MemoryLoginAttemptStore) Update(ctx context.Context, key string, ttl time.Duration, fn func(attempt *LoginAttempt)) (*LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := s.attempts[key]
+	if !ok || now.After(entry.expires) {
+		entry = &memoryLoginAttempt{LoginAttempt: LoginAttempt{Key: key}}
+		s.attempts[key] = entry
+	}
+	fn(&entry.LoginAttempt)
+	entry.expires = now.Add(ttl)
+
+	attempt := entry.LoginAttempt
+	return &attempt, nil
+}
+
+// Delete удаляет попытки по ключу
+func (s *MemoryLoginAttemptStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	delete(s.attempts, key)
+	s.mu.Unlock()
+	return nil
+}
+
+// List возвращает все действующие записи
+func (s *MemoryLoginAttemptStore) List(ctx context.Context) ([]LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		// После обработки проверяем статус ответа
-		if c.Writer.Status() == http.StatusUnauthorized {
-			// Это была неудачная попытка, но счетчик уже увеличен выше
-			return
-		} else if c.Writer.Status() == http.StatusOK {
-			// Успешный вход, сбрасываем счетчик
-			al.mu.Lock()
-			delete(al.attempts, clientIP)
-			al.mu.Unlock()
+	now := time.Now()
+	result := make([]LoginAttempt, 0, len(s.attempts))
+	for _, entry := range s.attempts {
+		if now.Before(entry.expires) {
+			result = append(result, entry.LoginAttempt)
 		}
 	}
+	return result, nil
 }
 
-// Cleanup запускает периодическую очистку старых записей
-func (al *AuthLimiter) Cleanup(interval time.Duration) {
+// Cleanup запускает периодическое удаление истекших записей
+func (s *MemoryLoginAttemptStore) Cleanup(interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	go func() {
 		for range ticker.C {
 			now := time.Now()
-			al.mu.Lock()
-			for ip, attempt := range al.attempts {
-				// Удаляем записи, если блокировка истекла или было долгое бездействие
-				if (!attempt.BlockedAt.IsZero() && now.Sub(attempt.BlockedAt) > al.blockTime) ||
-					now.Sub(attempt.LastTry) > al.resetTime*2 {
-					delete(al.attempts, ip)
+			s.mu.Lock()
+			for key, entry := range s.attempts {
+				if now.After(entry.expires) {
+					delete(s.attempts, key)
 				}
 			}
-			al.mu.Unlock()
+			s.mu.Unlock()
 		}
 	}()
 }
@@ -15,17 +15,51 @@ type JWTClaims struct {
 	UserID   uint   `json:"user_id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	// ID сессии, к которой привязан access токен
+	SessionID uint `json:"sid"`
+	// Назначение промежуточного токена (например, второй шаг входа); у access токена пустое
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// Генерация JWT токена
-func GenerateToken(userID uint, username, role, secret string, expiryHours int) (string, error) {
+// Назначения промежуточных токенов входа
+const (
+	PurposeTwoFactor       = "2fa"            // Ожидается код второго фактора
+	PurposeTwoFactorEnroll = "2fa_enroll"     // Требуется подключить второй фактор
+	PurposePasswordReset   = "password_reset" // Требуется сменить пароль
+)
+
+// ClaimsValidator выполняет дополнительную проверку claims после проверки подписи
+// (например, что сессия не отозвана) и может уточнить их, например роль из БД.
+// Ошибка отклоняет запрос с 401
+type ClaimsValidator func(claims *JWTClaims) error
+
+// Генерация JWT access токена для сессии
+func GenerateToken(userID uint, username, role string, sessionID uint, secret string, ttl time.Duration) (string, error) {
+	claims := JWTClaims{
+		UserID:    userID,
+		Username:  username,
+		Role:      role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
+// GeneratePendingToken выпускает короткоживущий промежуточный токен входа с указанным назначением.
+// Такой токен не принимается JWTAuth
+func GeneratePendingToken(userID uint, username, purpose, secret string, ttl time.Duration) (string, error) {
 	claims := JWTClaims{
 		UserID:   userID,
 		Username: username,
-		Role:     role,
+		Purpose:  purpose,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * time.Duration(expiryHours))),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
@@ -53,7 +87,7 @@ func ValidateToken(tokenString, secret string) (*JWTClaims, error) {
 }
 
 // Middleware для JWT аутентификации
-func JWTAuth(secret string) gin.HandlerFunc {
+func JWTAuth(secret string, validators ...ClaimsValidator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -80,10 +114,45 @@ func JWTAuth(secret string) gin.HandlerFunc {
 			return
 		}
 
+		// Промежуточные токены входа не дают доступа к API
+		if claims.Purpose != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			c.Abort()
+			return
+		}
+
+		for _, validate := range validators {
+			if err := validate(claims); err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+		}
+
 		// Устанавливаем данные пользователя в контекст
 		c.Set("userID", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("role", claims.Role)
+		c.Set("sessionID", claims.SessionID)
+
+		c.Next()
+	}
+}
+
+// PendingTokenAuth пропускает только промежуточные токены входа с указанным назначением
+func PendingTokenAuth(secret, purpose string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		claims, err := ValidateToken(tokenString, secret)
+		if err != nil || claims.Purpose != purpose {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			c.Abort()
+			return
+		}
+
+		c.Set("userID", claims.UserID)
+		c.Set("username", claims.Username)
+		c.Set("purpose", claims.Purpose)
 
 		c.Next()
 	}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"messenger/api"
+	"messenger/config"
+	"messenger/database"
+	"messenger/logger"
+	"messenger/storage"
+)
+
+// runMigrateStorage переносит содержимое загруженных файлов, их миниатюры и аватары между хранилищами:
+//
+//	server migrate-storage -from local -to s3 [-delete-source]
+//
+// Уже перенесенные объекты того же размера пропускаются, поэтому команду можно
+// запускать повторно. Пути старых записей приводятся к ключам хранилища
+func runMigrateStorage(args []string) {
+	fs := flag.NewFlagSet("migrate-storage", flag.ExitOnError)
+	from := fs.String("from", storage.BackendLocal, "исходное хранилище (local, s3)")
+	to := fs.String("to", storage.BackendS3, "целевое хранилище (local, s3)")
+	deleteSource := fs.Bool("delete-source", false, "удалять объекты из исходного хранилища после копирования")
+	fs.Parse(args)
+
+	if *from == *to {
+		logger.Fatalf("Исходное и целевое хранилища совпадают: %s", *from)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		logger.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+	db, err := database.NewDatabase(cfg)
+	if err != nil {
+		logger.Fatalf("Ошибка инициализации базы данных: %v", err)
+	}
+	sqlDB, _ := db.DB.DB()
+	defer sqlDB.Close()
+
+	src, err := storage.NewBackend(cfg, *from)
+	if err != nil {
+		logger.Fatalf("Ошибка инициализации хранилища %s: %v", *from, err)
+	}
+	dst, err := storage.NewBackend(cfg, *to)
+	if err != nil {
+		logger.Fatalf("Ошибка инициализации хранилища %s: %v", *to, err)
+	}
+
+	files, err := db.GetFileLocations()
+	if err != nil {
+		logger.Fatalf("Ошибка получения списка файлов: %v", err)
+	}
+
+	thumbnailKeys, err := db.GetThumbnailKeys()
+	if err != nil {
+		logger.Fatalf("Ошибка получения списка миниатюр: %v", err)
+	}
+
+	// Файлы с общим содержимым ссылаются на одни и те же объекты
+	keys := make([]string, 0, len(files)+len(thumbnailKeys))
+	seen := make(map[string]bool, len(files))
+	for i := range files {
+		key := files[i].StorageKey()
+		if files[i].FilePath != key {
+			if err := db.SetFilePath(files[i].ID, key); err != nil {
+				logger.Fatalf("Ошибка обновления пути файла #%d: %v", files[i].ID, err)
+			}
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	keys = append(keys, thumbnailKeys...)
+
+	avatars, err := db.GetAvatarURLs()
+	if err != nil {
+		logger.Fatalf("Ошибка получения списка аватаров: %v", err)
+	}
+	for _, avatar := range avatars {
+		keys = append(keys, api.AvatarStorageKeys(avatar)...)
+	}
+
+	logger.Infof("Перенос %d объектов из %s в %s", len(keys), src.Name(), dst.Name())
+	result := storage.Migrate(context.Background(), src, dst, keys, *deleteSource, func(key string, err error) {
+		if err != nil {
+			logger.Errorf("Перенос файлов: %s: %v", key, err)
+		}
+	})
+	logger.Infof("Перенос завершен: скопировано %d, пропущено %d, отсутствует %d, ошибок %d",
+		result.Copied, result.Skipped, result.Missing, result.Failed)
+	if result.Failed > 0 {
+		logger.Fatalf("Перенос завершился с ошибками")
+	}
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Blob - содержимое файла, общее для всех загрузок с одинаковым SHA-256: пересланный
+// несколько раз документ хранится в одном объекте. У каждой записи File остаются свои имя,
+// сообщение и права доступа, ключ объекта и ключ шифрования копируются из Blob.
+// Объект удаляется, когда на него не остается ссылок (RefCount = 0)
+type Blob struct {
+	ID uint `gorm:"primaryKey"`
+	// SHA-256 сохраненного содержимого (у изображений - после очистки метаданных).
+	// Уникален среди используемых записей: запись с RefCount = 0 ждет удаления объекта
+	Hash       string `gorm:"size:64;not null;uniqueIndex:idx_blobs_hash,where:ref_count > 0"`
+	Size       int64  `gorm:"not null"`
+	StorageKey string `gorm:"not null"` // blobs/<sha256>-<случайный суффикс>
+	WrappedKey []byte `gorm:"type:bytea"`
+	RefCount   int64  `gorm:"not null;default:0;index"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
@@ -8,13 +8,15 @@ import (
 
 // Chat представляет чат между пользователями
 type Chat struct {
-	ID           uint           `gorm:"primarykey" json:"id"`
-	Name         string         `json:"name"`                         // Название чата
-	Type         string         `gorm:"size:20;not null" json:"type"` // тип: "personal" или "group"
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	LastActivity time.Time      `json:"last_activity"` // Время последней активности
-	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+	ID           uint      `gorm:"primarykey" json:"id"`
+	Name         string    `json:"name"`                         // Название чата
+	Type         string    `gorm:"size:20;not null" json:"type"` // тип: "personal" или "group"
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	LastActivity time.Time `json:"last_activity"` // Время последней активности
+	// Через сколько дней удаляются файлы чата; 0 - общий срок FileStorage.RetentionDays
+	FileRetentionDays int            `json:"file_retention_days,omitempty"`
+	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
 
 	// Связи с другими моделями
 	Users    []User    `gorm:"many2many:chat_users;" json:"users,omitempty"`
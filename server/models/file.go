@@ -1,6 +1,11 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
 	"time"
 
 	"gorm.io/gorm"
@@ -15,19 +20,92 @@ const (
 	FileTypeAudio    FileType = "audio"
 	FileTypeVideo    FileType = "video"
 	FileTypeOther    FileType = "other"
+	FileTypeVoice    FileType = "voice" // Запись голосового сообщения (Ogg/Opus или M4A)
 )
 
 // File представляет информацию о загруженном файле
 type File struct {
-	ID            uint           `json:"id" gorm:"primaryKey"`
-	MessageID     uint           `json:"message_id" gorm:"index"`
-	FileName      string         `json:"file_name" gorm:"not null"`
-	FileSize      int64          `json:"file_size" gorm:"not null"`
-	FileType      FileType       `json:"file_type" gorm:"not null"`
-	FilePath      string         `json:"-" gorm:"not null"` // Скрываем от клиента
-	MimeType      string         `json:"mime_type" gorm:"not null"`
-	DownloadToken string         `json:"download_token" gorm:"not null;uniqueIndex"`
-	CreatedAt     time.Time      `json:"created_at"`
-	UpdatedAt     time.Time      `json:"updated_at"`
-	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index"`
+	ID        uint     `json:"id" gorm:"primaryKey"`
+	MessageID uint     `json:"message_id" gorm:"index"`
+	FileName  string   `json:"file_name" gorm:"not null"`
+	FileSize  int64    `json:"file_size" gorm:"not null"`
+	FileType  FileType `json:"file_type" gorm:"not null"`
+	FilePath  string   `json:"-" gorm:"not null"` // Ключ объекта в хранилище (у старых записей - путь в ./uploads)
+	// Общее содержимое, на которое ссылается файл. Пусто у файлов, загруженных до дедупликации
+	BlobID   *uint  `json:"-" gorm:"index"`
+	MimeType string `json:"mime_type" gorm:"not null"`
+	// Ключ шифрования файла, зашифрованный ключом сервера. Пустой у файлов, загруженных до шифрования
+	WrappedKey    []byte `json:"-" gorm:"type:bytea"`
+	DownloadToken string `json:"-" gorm:"not null;uniqueIndex"` // Уникальный токен файла; у старых записей - имя объекта в хранилище
+	// Сведения об изображениях: размеры с учетом ориентации, EXIF Orientation и BlurHash-заглушка
+	Width       int             `json:"width,omitempty"`
+	Height      int             `json:"height,omitempty"`
+	Orientation int             `json:"orientation,omitempty"`
+	Blurhash    string          `json:"blurhash,omitempty"`
+	Thumbnails  []FileThumbnail `json:"thumbnails,omitempty" gorm:"foreignKey:FileID"`
+	// Сведения о голосовых сообщениях: длительность и огибающая громкости
+	DurationMs int64          `json:"duration_ms,omitempty"`
+	Waveform   Waveform       `json:"waveform,omitempty" gorm:"type:bytea"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// StorageKey возвращает ключ объекта в хранилище. Старые записи хранят путь вида
+// ./uploads/<имя>, у них ключом служит имя файла в каталоге FileStorage.Path
+func (f *File) StorageKey() string {
+	if f.BlobID != nil {
+		return f.FilePath
+	}
+	return path.Base(filepath.ToSlash(f.FilePath))
+}
+
+// Waveform - огибающая громкости голосового сообщения: значения 0-31 через равные промежутки
+// времени. В JSON передается массивом чисел, а не строкой base64, как []byte
+type Waveform []byte
+
+// MarshalJSON кодирует огибающую массивом чисел
+func (w Waveform) MarshalJSON() ([]byte, error) {
+	values := make([]int, len(w))
+	for i, v := range w {
+		values[i] = int(v)
+	}
+	return json.Marshal(values)
+}
+
+// UnmarshalJSON читает огибающую из массива чисел
+func (w *Waveform) UnmarshalJSON(data []byte) error {
+	var values []int
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*w = make(Waveform, len(values))
+	for i, v := range values {
+		if v < 0 || v > 255 {
+			return fmt.Errorf("значение огибающей вне диапазона: %d", v)
+		}
+		(*w)[i] = byte(v)
+	}
+	return nil
+}
+
+// Value сохраняет огибающую в bytea
+func (w Waveform) Value() (driver.Value, error) {
+	if w == nil {
+		return nil, nil
+	}
+	return []byte(w), nil
+}
+
+// Scan читает огибающую из bytea
+func (w *Waveform) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*w = nil
+	case []byte:
+		*w = append(Waveform(nil), v...)
+	default:
+		return fmt.Errorf("неподдерживаемый тип огибающей: %T", src)
+	}
+	return nil
 }
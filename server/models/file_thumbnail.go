@@ -0,0 +1,15 @@
+package models
+
+// FileThumbnail - уменьшенная копия изображения. Хранится отдельным зашифрованным объектом
+// и отдается по /api/files/:fileId/download?variant=<name>
+type FileThumbnail struct {
+	ID         uint   `json:"-" gorm:"primaryKey"`
+	FileID     uint   `json:"-" gorm:"uniqueIndex:idx_file_thumbnails_name;not null"`
+	Name       string `json:"name" gorm:"uniqueIndex:idx_file_thumbnails_name;size:16;not null"` // small, medium, large
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Size       int64  `json:"size"`
+	MimeType   string `json:"mime_type"`
+	StorageKey string `json:"-" gorm:"not null"`
+	WrappedKey []byte `json:"-" gorm:"type:bytea"`
+}
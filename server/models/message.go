@@ -12,6 +12,8 @@ type MessageType string
 const (
 	MessageTypeText MessageType = "text"
 	MessageTypeFile MessageType = "file"
+	// Голосовое сообщение: файл с длительностью и огибающей, у получателей есть отметка о прослушивании
+	MessageTypeVoice MessageType = "voice"
 )
 
 // Message представляет сообщение в чате
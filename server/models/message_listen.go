@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// MessageListen - отметка о прослушивании голосового сообщения получателем.
+// Хранится отдельно от прочтения: сообщение могут увидеть, но не прослушать
+type MessageListen struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	MessageID  uint      `gorm:"uniqueIndex:idx_message_listen_user" json:"message_id"`
+	UserID     uint      `gorm:"uniqueIndex:idx_message_listen_user" json:"user_id"`
+	ListenedAt time.Time `json:"listened_at"`
+}
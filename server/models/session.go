@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// Session представляет сессию пользователя на устройстве.
+// Сессию продлевает refresh токен, в БД хранится только его SHA-256 хеш
+type Session struct {
+	ID                uint       `json:"id" gorm:"primaryKey"`
+	UserID            uint       `json:"user_id" gorm:"index;not null"`
+	RefreshTokenHash  string     `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	PreviousTokenHash string     `json:"-" gorm:"size:64;index"` // Хеш предыдущего токена для обнаружения повторного использования
+	UserAgent         string     `json:"user_agent"`
+	IP                string     `json:"ip"`
+	CreatedAt         time.Time  `json:"created_at"`
+	LastUsedAt        time.Time  `json:"last_used_at"`
+	ExpiresAt         time.Time  `json:"expires_at" gorm:"index"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+}
+
+// IsActive сообщает, можно ли использовать сессию
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+)
+
+// Состояния загрузки по частям
+const (
+	UploadStatusActive     = "active"     // Принимает части
+	UploadStatusCompleting = "completing" // Части собираются в файл
+)
+
+// Upload - незавершенная загрузка файла по частям. Части хранятся в хранилище
+// файлов отдельными зашифрованными объектами до завершения загрузки
+type Upload struct {
+	ID       string `json:"id" gorm:"primaryKey;size:32"`
+	UserID   uint   `json:"user_id" gorm:"index;not null"`
+	ChatID   uint   `json:"chat_id" gorm:"not null"`
+	FileName string `json:"file_name" gorm:"not null"`
+	MimeType string `json:"mime_type" gorm:"not null"`
+	// Тип будущего сообщения: file или voice
+	MessageType string       `json:"type" gorm:"size:20;not null;default:'file'"`
+	Size        int64        `json:"size" gorm:"not null"`
+	Offset      int64        `json:"offset" gorm:"not null;default:0"`  // Сколько байт уже принято
+	Checksum    string       `json:"checksum,omitempty" gorm:"size:64"` // Ожидаемый SHA-256 (hex), если указан при создании
+	Status      string       `json:"status" gorm:"size:16;not null"`
+	ExpiresAt   time.Time    `json:"expires_at" gorm:"index;not null"`
+	Parts       []UploadPart `json:"-" gorm:"foreignKey:UploadID"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// UploadPart - принятая часть загрузки, зашифрованная собственным ключом
+type UploadPart struct {
+	ID         uint   `gorm:"primaryKey"`
+	UploadID   string `gorm:"size:32;index;not null"`
+	Offset     int64  `gorm:"not null"`
+	Size       int64  `gorm:"not null"`
+	StorageKey string `gorm:"not null"`
+	WrappedKey []byte `gorm:"type:bytea"`
+	CreatedAt  time.Time
+}
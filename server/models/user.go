@@ -9,16 +9,35 @@ import (
 )
 
 type User struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	Username  string         `json:"username" gorm:"unique;not null"`
-	Email     string         `json:"email,omitempty" gorm:"unique;default:null"`
-	Password  string         `json:"-" gorm:"not null"` // не включаем в JSON
-	Role      string         `json:"role" gorm:"not null;default:user"`
-	Blocked   bool           `json:"blocked,omitempty" gorm:"default:false"`
-	Avatar    string         `json:"avatar,omitempty" gorm:"default:''"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	Username string `json:"username" gorm:"unique;not null"`
+	Email    string `json:"email,omitempty" gorm:"unique;default:null"`
+	// Владелец подтвердил email переходом по ссылке из письма
+	EmailVerified bool   `json:"email_verified" gorm:"default:false"`
+	Password      string `json:"-" gorm:"not null"` // не включаем в JSON
+	Role          string `json:"role" gorm:"not null;default:user"`
+	Blocked       bool   `json:"blocked,omitempty" gorm:"default:false"`
+	Avatar        string `json:"avatar,omitempty" gorm:"default:'';index"`
+	// Ключ шифрования миниатюр аватара, зашифрованный ключом сервера. Пустой у старых аватаров
+	AvatarKey []byte `json:"-" gorm:"type:bytea"`
+	// Профиль, который пользователь редактирует сам
+	DisplayName string `json:"display_name,omitempty"`
+	Bio         string `json:"bio,omitempty"`
+	StatusText  string `json:"status_text,omitempty"`
+	// Двухфакторная аутентификация (TOTP). Секрет хранится зашифрованным crypto.EncryptString
+	TOTPSecret      string `json:"-"`
+	TOTPEnabled     bool   `json:"totp_enabled" gorm:"default:false"`
+	TOTPLastCounter int64  `json:"-"` // Последний принятый временной шаг, защищает от повторного использования кода
+	// Идентификатор (sub) пользователя у OIDC провайдера, если учетная запись связана с SSO.
+	// Имя колонки задано явно: по умолчанию GORM разбивает аббревиатуру в o_id_c_subject
+	OIDCSubject string `json:"-" gorm:"column:oidc_subject;unique;default:null"`
+	// Источник учетной записи: local (пароль в базе) или ldap (каталог LDAP / Active Directory)
+	AuthSource string `json:"auth_source" gorm:"not null;default:local"`
+	// Пользователь обязан сменить пароль при следующем входе (назначается администратором)
+	PasswordResetRequired bool           `json:"password_reset_required" gorm:"default:false"`
+	CreatedAt             time.Time      `json:"created_at"`
+	UpdatedAt             time.Time      `json:"updated_at"`
+	DeletedAt             gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 // Хеширование пароля
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// UserBlock - пользователь BlockerID заблокировал пользователя BlockedID.
+// Блокировка не удаляет из общих групп, но скрывает сообщения и присутствие
+type UserBlock struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	BlockerID uint      `json:"blocker_id" gorm:"uniqueIndex:idx_user_blocks_pair;not null"`
+	BlockedID uint      `json:"blocked_id" gorm:"uniqueIndex:idx_user_blocks_pair;index;not null"`
+	Blocked   User      `json:"-" gorm:"foreignKey:BlockedID"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// Назначения одноразовых токенов из писем
+const (
+	TokenPurposeEmailVerify   = "email_verify"
+	TokenPurposePasswordReset = "password_reset"
+)
+
+// UserToken - одноразовый токен из письма (подтверждение email, сброс пароля).
+// Хранится только SHA-256 хеш токена
+type UserToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"index;not null"`
+	Purpose   string     `json:"purpose" gorm:"size:32;not null"`
+	TokenHash string     `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	Email     string     `json:"email"` // Адрес, на который отправлено письмо
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Bucket реализует алгоритм token bucket: токены пополняются со скоростью rate
+// в секунду до емкости burst, каждое событие забирает один токен
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64   // Скорость пополнения (токенов в секунду)
+	burst  float64   // Емкость бакета
+	tokens float64   // Текущее количество токенов
+	last   time.Time // Время последнего пополнения
+}
+
+// NewBucket создает заполненный бакет
+func NewBucket(rate float64, burst int) *Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Bucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow забирает токен. Если токенов нет, возвращает false и время до появления следующего
+func (b *Bucket) Allow() (bool, time.Duration) {
+	return b.AllowAt(time.Now())
+}
+
+// AllowAt аналогичен Allow, но использует переданное время
+func (b *Bucket) AllowAt(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Пополняем токены за прошедшее время
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	if b.rate <= 0 {
+		// Бакет без пополнения: токены больше не появятся
+		return false, time.Duration(math.MaxInt64)
+	}
+
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	return false, wait
+}
+
+// idle сообщает, простаивает ли бакет дольше указанного времени
+func (b *Bucket) idle(now time.Time, d time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return now.Sub(b.last) > d
+}
+
+// KeyedLimiter хранит отдельный бакет для каждого ключа (пользователя, IP и т.п.)
+type KeyedLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*Bucket
+	rate    float64
+	burst   int
+}
+
+// NewKeyedLimiter создает лимитер с одинаковыми параметрами для всех ключей
+func NewKeyedLimiter(rate float64, burst int) *KeyedLimiter {
+	return &KeyedLimiter{
+		buckets: make(map[string]*Bucket),
+		rate:    rate,
+		burst:   burst,
+	}
+}
+
+// Allow забирает токен из бакета ключа, создавая его при первом обращении
+func (l *KeyedLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = NewBucket(l.rate, l.burst)
+		l.buckets[key] = bucket
+	}
+	l.mu.Unlock()
+
+	return bucket.Allow()
+}
+
+// Cleanup запускает периодическое удаление бакетов, простаивающих дольше idle
+func (l *KeyedLimiter) Cleanup(interval, idle time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			now := time.Now()
+			l.mu.Lock()
+			for key, bucket := range l.buckets {
+				if bucket.idle(now, idle) {
+					delete(l.buckets, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}()
+}
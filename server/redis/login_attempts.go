@@ -0,0 +1,136 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"messenger/middleware"
+)
+
+// Префикс ключей счетчиков попыток входа
+const loginAttemptsPrefix = "login_attempts:"
+
+// Число повторов оптимистичной транзакции при одновременном изменении счетчика
+const loginAttemptsMaxRetries = 5
+
+// LoginAttemptStore хранит попытки входа в Redis, общие для всех экземпляров сервера
+type LoginAttemptStore struct {
+	client *redis.Client
+}
+
+// LoginAttempts возвращает хранилище попыток входа. Redis должен быть включен
+func (r *RedisClient) LoginAttempts() *LoginAttemptStore {
+	return &LoginAttemptStore{client: r.client}
+}
+
+// Get возвращает попытки по ключу или nil, если их нет
+func (s *LoginAttemptStore) Get(ctx context.Context, key string) (*middleware.LoginAttempt, error) {
+	data, err := s.client.Get(ctx, loginAttemptsPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeLoginAttempt(key, data)
+}
+
+// Update изменяет попытки в транзакции WATCH/MULTI, повторяя ее при конфликте с другим экземпляром
+func (s *LoginAttemptStore) Update(ctx context.Context, key string, ttl time.Duration, fn func(attempt *middleware.LoginAttempt)) (*middleware.LoginAttempt, error) {
+	redisKey := loginAttemptsPrefix + key
+	var result *middleware.LoginAttempt
+
+	txf := func(tx *redis.Tx) error {
+		attempt := &middleware.LoginAttempt{Key: key}
+		data, err := tx.Get(ctx, redisKey).Bytes()
+		switch {
+		case errors.Is(err, redis.Nil):
+		case err != nil:
+			return err
+		default:
+			if attempt, err = decodeLoginAttempt(key, data); err != nil {
+				return err
+			}
+		}
+
+		fn(attempt)
+		encoded, err := json.Marshal(attempt)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, redisKey, encoded, ttl)
+			return nil
+		})
+		if err == nil {
+			result = attempt
+		}
+		return err
+	}
+
+	for i := 0; i < loginAttemptsMaxRetries; i++ {
+		err := s.client.Watch(ctx, txf, redisKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return result, err
+	}
+	return nil, fmt.Errorf("не удалось обновить попытки входа %s: слишком много одновременных изменений", key)
+}
+
+// Delete удаляет попытки по ключу
+func (s *LoginAttemptStore) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, loginAttemptsPrefix+key).Err()
+}
+
+// List возвращает все сохраненные попытки
+func (s *LoginAttemptStore) List(ctx context.Context) ([]middleware.LoginAttempt, error) {
+	var keys []string
+	iter := s.client.Scan(ctx, 0, loginAttemptsPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]middleware.LoginAttempt, 0, len(keys))
+	if len(keys) == 0 {
+		return result, nil
+	}
+
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			// Запись истекла между SCAN и MGET
+			continue
+		}
+		attempt, err := decodeLoginAttempt(strings.TrimPrefix(keys[i], loginAttemptsPrefix), []byte(str))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *attempt)
+	}
+	return result, nil
+}
+
+// decodeLoginAttempt разбирает сохраненную запись попыток
+func decodeLoginAttempt(key string, data []byte) (*middleware.LoginAttempt, error) {
+	var attempt middleware.LoginAttempt
+	if err := json.Unmarshal(data, &attempt); err != nil {
+		return nil, fmt.Errorf("ошибка разбора попыток входа %s: %w", key, err)
+	}
+	attempt.Key = key
+	return &attempt, nil
+}
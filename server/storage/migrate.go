@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// MigrateResult - итог переноса объектов между хранилищами
+type MigrateResult struct {
+	Copied  int
+	Skipped int // Уже были в целевом хранилище с тем же размером
+	Missing int // Отсутствовали в исходном хранилище
+	Failed  int
+}
+
+// Migrate копирует объекты keys из src в dst. Объект, уже лежащий в dst с тем же размером,
+// не копируется повторно, поэтому прерванный перенос можно запустить снова.
+// При deleteSource объект удаляется из src после успешного копирования
+func Migrate(ctx context.Context, src, dst Storage, keys []string, deleteSource bool, report func(key string, err error)) MigrateResult {
+	var result MigrateResult
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+
+		copied, err := migrateObject(ctx, src, dst, key)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			result.Missing++
+		case err != nil:
+			result.Failed++
+		case copied:
+			result.Copied++
+		default:
+			result.Skipped++
+		}
+		if err == nil && deleteSource {
+			if err = src.Delete(ctx, key); err != nil {
+				err = fmt.Errorf("скопирован, но не удален из исходного хранилища: %w", err)
+			}
+		}
+		if report != nil {
+			report(key, err)
+		}
+	}
+	return result
+}
+
+// migrateObject копирует один объект и сверяет размер копии
+func migrateObject(ctx context.Context, src, dst Storage, key string) (bool, error) {
+	info, err := src.Stat(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if existing, err := dst.Stat(ctx, key); err == nil && existing.Size == info.Size {
+		return false, nil
+	}
+
+	body, err := src.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+
+	if err := dst.Put(ctx, key, body, info.Size); err != nil {
+		return false, err
+	}
+	copied, err := dst.Stat(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if copied.Size != info.Size {
+		return false, fmt.Errorf("размер копии %d не совпадает с исходным %d", copied.Size, info.Size)
+	}
+	return true, nil
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMigrateLocalToS3(t *testing.T) {
+	ctx := context.Background()
+	src, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeS3(t)
+	dst := newTestS3(t, fake)
+
+	files := map[string]string{
+		"abc.jpg":          "jpeg",
+		"avatars/user.png": "png",
+		"video.mp4":        strings.Repeat("кадр", 1000),
+	}
+	var keys []string
+	for key, content := range files {
+		if err := src.Put(ctx, key, strings.NewReader(content), int64(len(content))); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	keys = append(keys, "deleted.jpg") // Запись в базе есть, файла нет
+
+	reported := map[string]error{}
+	result := Migrate(ctx, src, dst, keys, false, func(key string, err error) { reported[key] = err })
+	if want := (MigrateResult{Copied: 3, Missing: 1}); result != want {
+		t.Fatalf("перенос: %+v, ожидалось %+v", result, want)
+	}
+	for key, content := range files {
+		if data, ok := fake.object("uploads/" + key); !ok || string(data) != content {
+			t.Errorf("%s в S3: %d байт (%v)", key, len(data), ok)
+		}
+		if _, err := src.Stat(ctx, key); err != nil {
+			t.Errorf("%s удален из исходного хранилища без deleteSource: %v", key, err)
+		}
+	}
+	if !errors.Is(reported["deleted.jpg"], ErrNotFound) || len(reported) != len(keys) {
+		t.Errorf("отчет о переносе: %v", reported)
+	}
+
+	// Повторный запуск ничего не копирует заново
+	puts := fake.puts
+	result = Migrate(ctx, src, dst, keys, false, nil)
+	if want := (MigrateResult{Skipped: 3, Missing: 1}); result != want {
+		t.Errorf("повторный перенос: %+v, ожидалось %+v", result, want)
+	}
+	if fake.puts != puts {
+		t.Errorf("повторный перенос загрузил объектов: %d", fake.puts-puts)
+	}
+}
+
+func TestMigrateRecopiesTruncatedObject(t *testing.T) {
+	ctx := context.Background()
+	src, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := newTestS3(t, newFakeS3(t))
+
+	// Прерванный перенос оставил в S3 неполную копию
+	if err := src.Put(ctx, "abc.jpg", strings.NewReader("полный файл"), int64(len("полный файл"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Put(ctx, "abc.jpg", strings.NewReader("пол"), int64(len("пол"))); err != nil {
+		t.Fatal(err)
+	}
+
+	result := Migrate(ctx, src, dst, []string{"abc.jpg"}, true, nil)
+	if want := (MigrateResult{Copied: 1}); result != want {
+		t.Fatalf("перенос: %+v, ожидалось %+v", result, want)
+	}
+	body, err := dst.Get(ctx, "abc.jpg")
+	if got := readAll(t, body, err); got != "полный файл" {
+		t.Errorf("в S3 %q", got)
+	}
+	// С deleteSource исходный файл удаляется после успешного копирования
+	if _, err := src.Stat(ctx, "abc.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("исходный файл не удален: %v", err)
+	}
+}
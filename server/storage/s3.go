@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"messenger/config"
+)
+
+// S3 хранит объекты в S3-совместимом хранилище
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 создает клиента S3. Наличие bucket проверяется при первом обращении
+func NewS3(cfg config.S3Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("для хранилища s3 нужно указать endpoint и bucket")
+	}
+
+	lookup := minio.BucketLookupAuto
+	if cfg.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure:       cfg.UseSSL,
+		Region:       cfg.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания клиента S3: %w", err)
+	}
+	return &S3{client: client, bucket: cfg.Bucket, prefix: cfg.Prefix}, nil
+}
+
+func (s *S3) Name() string {
+	return BackendS3
+}
+
+func (s *S3) objectName(key string) string {
+	return s.prefix + strings.TrimPrefix(key, "/")
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.objectName(key), r, size, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.GetRange(ctx, key, 0, -1)
+}
+
+// GetRange запрашивает часть объекта заголовком Range
+func (s *S3) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	switch {
+	case length > 0:
+		if err := opts.SetRange(offset, offset+length-1); err != nil {
+			return nil, err
+		}
+	case length == 0:
+		return io.NopCloser(strings.NewReader("")), nil
+	case offset > 0:
+		if err := opts.SetRange(offset, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	// Client.GetObject откладывает запрос до первого чтения, а вызов Stat до чтения сбрасывает
+	// Range и отдает объект целиком. Core.GetObject выполняет запрос с Range сразу,
+	// поэтому отсутствие объекта тоже видно сразу
+	body, _, _, err := minio.Core{Client: s.client}.GetObject(ctx, s.bucket, s.objectName(key), opts)
+	if err != nil {
+		return nil, translateS3Error(err)
+	}
+	return body, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, s.objectName(key), minio.RemoveObjectOptions{})
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, s.objectName(key), minio.StatObjectOptions{})
+	if err != nil {
+		return nil, translateS3Error(err)
+	}
+	return &ObjectInfo{Key: key, Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+// translateS3Error приводит ответ "объект не найден" к ErrNotFound
+func translateS3Error(err error) error {
+	// NoSuchBucket тоже приходит с кодом 404, но это ошибка настройки, а не отсутствие объекта
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrNotFound
+	}
+	return err
+}
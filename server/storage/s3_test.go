@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"messenger/config"
+)
+
+const testBucket = "messenger"
+
+// fakeS3 - S3 в памяти на httptest с адресацией bucket в пути. Понимает PUT, GET с Range,
+// HEAD и DELETE объектов, чего достаточно для клиента minio
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte // По пути "/bucket/объект"
+	puts    int
+	server  *httptest.Server
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	t.Helper()
+	f := &fakeS3{objects: map[string][]byte{}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeS3) handle(w http.ResponseWriter, r *http.Request) {
+	bucket, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != testBucket {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := readS3Body(r)
+		if err != nil {
+			writeS3Error(w, r, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[r.URL.Path] = data
+		f.puts++
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			writeS3Error(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		// ServeContent отвечает на Range кодом 206 и заголовком Content-Range, как S3
+		http.ServeContent(w, r, "", time.Unix(1700000000, 0), bytes.NewReader(data))
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// readS3Body читает тело PUT. По http без TLS minio подписывает данные по частям
+// (aws-chunked): "<размер hex>;chunk-signature=...\r\n<данные>\r\n" до части нулевого размера
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	var data bytes.Buffer
+	br := bufio.NewReader(r.Body)
+	for {
+		header, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			// Дальше могут идти трейлеры с контрольными суммами, они не нужны
+			return data.Bytes(), nil
+		}
+		if _, err := io.CopyN(&data, br, size); err != nil {
+			return nil, err
+		}
+		if _, err := br.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message><Resource>%s</Resource></Error>`, code, code, r.URL.Path)
+	}
+}
+
+func (f *fakeS3) object(name string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects["/"+testBucket+"/"+name]
+	return data, ok
+}
+
+func (f *fakeS3) config() config.S3Config {
+	return config.S3Config{
+		Endpoint:  strings.TrimPrefix(f.server.URL, "http://"),
+		Region:    "us-east-1", // С указанным регионом клиент не запрашивает расположение bucket
+		Bucket:    testBucket,
+		AccessKey: "access",
+		SecretKey: "secret",
+		PathStyle: true,
+		Prefix:    "uploads/",
+	}
+}
+
+func newTestS3(t *testing.T, f *fakeS3) *S3 {
+	t.Helper()
+	s, err := NewS3(f.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func readAll(t *testing.T, body io.ReadCloser, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestS3PutGetStatDelete(t *testing.T) {
+	fake := newFakeS3(t)
+	s := newTestS3(t, fake)
+	ctx := context.Background()
+	content := "содержимое файла"
+
+	if err := s.Put(ctx, "avatars/abc.jpg", strings.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// Ключ хранится с префиксом установки
+	if data, ok := fake.object("uploads/avatars/abc.jpg"); !ok || string(data) != content {
+		t.Fatalf("в bucket %q (%v)", data, ok)
+	}
+
+	body, err := s.Get(ctx, "avatars/abc.jpg")
+	if got := readAll(t, body, err); got != content {
+		t.Errorf("Get вернул %q", got)
+	}
+
+	info, err := s.Stat(ctx, "avatars/abc.jpg")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Key != "avatars/abc.jpg" || info.Size != int64(len(content)) || !info.ModTime.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Stat вернул %+v", info)
+	}
+
+	if err := s.Delete(ctx, "avatars/abc.jpg"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Stat(ctx, "avatars/abc.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat удаленного объекта: %v, ожидалась %v", err, ErrNotFound)
+	}
+	if _, err := s.Get(ctx, "avatars/abc.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get удаленного объекта: %v, ожидалась %v", err, ErrNotFound)
+	}
+	// Удаление отсутствующего объекта не ошибка
+	if err := s.Delete(ctx, "avatars/abc.jpg"); err != nil {
+		t.Errorf("повторный Delete: %v", err)
+	}
+}
+
+func TestS3GetRange(t *testing.T) {
+	fake := newFakeS3(t)
+	s := newTestS3(t, fake)
+	ctx := context.Background()
+	content := "0123456789"
+	if err := s.Put(ctx, "video.mp4", strings.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		offset, length int64
+		want           string
+	}{
+		{name: "середина", offset: 2, length: 3, want: "234"},
+		{name: "до конца", offset: 7, length: -1, want: "789"},
+		{name: "с начала", offset: 0, length: 4, want: "0123"},
+		{name: "целиком", offset: 0, length: -1, want: content},
+		{name: "нулевая длина", offset: 5, length: 0, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := s.GetRange(ctx, "video.mp4", tt.offset, tt.length)
+			if got := readAll(t, body, err); got != tt.want {
+				t.Errorf("GetRange(%d, %d) = %q, ожидалось %q", tt.offset, tt.length, got, tt.want)
+			}
+		})
+	}
+
+	// ReaderAt поверх S3 читает произвольные части, как http.ServeContent для видео
+	r := NewReaderAt(ctx, s, "video.mp4", int64(len(content)))
+	defer r.Close()
+	buf := make([]byte, 3)
+	if n, err := r.ReadAt(buf, 6); err != nil || string(buf[:n]) != "678" {
+		t.Errorf("ReadAt(6) = %q, %v", buf[:n], err)
+	}
+	if n, err := r.ReadAt(buf, 1); err != nil || string(buf[:n]) != "123" {
+		t.Errorf("ReadAt(1) = %q, %v", buf[:n], err)
+	}
+}
+
+func TestS3MissingBucketIsNotNotFound(t *testing.T) {
+	fake := newFakeS3(t)
+	cfg := fake.config()
+	cfg.Bucket = "missing"
+	s, err := NewS3(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ошибка настройки не должна выглядеть как отсутствие файла. На HEAD сервер отвечает
+	// без тела, поэтому различить их можно только по ответу на GET
+	if _, err := s.Get(context.Background(), "abc.jpg"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Get в несуществующем bucket: %v", err)
+	}
+}
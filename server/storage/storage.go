@@ -0,0 +1,114 @@
+// Пакет storage хранит содержимое загруженных файлов: в локальном каталоге или в S3-совместимом хранилище
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"messenger/config"
+)
+
+// Названия способов хранения в конфигурации
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// ErrNotFound - объекта нет в хранилище
+var ErrNotFound = errors.New("объект не найден в хранилище")
+
+// ObjectInfo описывает сохраненный объект
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage - хранилище объектов по ключу. Ключи - относительные пути вида "abc.jpg" или "dir/abc.jpg"
+type Storage interface {
+	// Name возвращает название способа хранения (local, s3)
+	Name() string
+	// Put сохраняет объект. size - размер данных или -1, если он неизвестен
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get открывает объект целиком
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange открывает часть объекта с offset; length < 0 означает до конца объекта
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Delete удаляет объект; удаление отсутствующего объекта не считается ошибкой
+	Delete(ctx context.Context, key string) error
+	// Stat возвращает сведения об объекте или ErrNotFound
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+}
+
+// New создает хранилище, выбранное в FileStorage.Backend
+func New(cfg *config.Config) (Storage, error) {
+	return NewBackend(cfg, cfg.FileStorage.Backend)
+}
+
+// NewBackend создает хранилище указанного типа с настройками из конфигурации.
+// Используется и для переноса файлов между хранилищами
+func NewBackend(cfg *config.Config, backend string) (Storage, error) {
+	switch backend {
+	case BackendLocal, "":
+		return NewLocal(cfg.FileStorage.Path)
+	case BackendS3:
+		return NewS3(cfg.FileStorage.S3)
+	default:
+		return nil, fmt.Errorf("неизвестный способ хранения файлов: %s", backend)
+	}
+}
+
+// ReaderAt дает произвольный доступ к объекту размером size через GetRange.
+// Последовательные чтения продолжают уже открытый поток, новый запрос делается только при переходе
+type ReaderAt struct {
+	ctx  context.Context
+	st   Storage
+	key  string
+	size int64
+
+	body io.ReadCloser
+	pos  int64
+}
+
+// NewReaderAt создает ReaderAt; Close закрывает открытый поток
+func NewReaderAt(ctx context.Context, st Storage, key string, size int64) *ReaderAt {
+	return &ReaderAt{ctx: ctx, st: st, key: key, size: size}
+}
+
+// ReadAt читает len(p) байт с позиции off
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil || off != r.pos {
+		r.Close()
+		body, err := r.st.GetRange(r.ctx, r.key, off, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body, r.pos = body, off
+	}
+
+	n, err := io.ReadFull(r.body, p)
+	r.pos += int64(n)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	if err != nil {
+		r.Close()
+	}
+	return n, err
+}
+
+// Close закрывает открытый поток
+func (r *ReaderAt) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
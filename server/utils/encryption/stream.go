@@ -0,0 +1,308 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Формат зашифрованного файла:
+//
+//	заголовок: "MENC" | версия (1 байт) | 3 резервных байта | размер блока (uint32, BE)
+//	блоки:     AES-256-GCM(блок открытого текста), тег 16 байт
+//
+// Файл шифруется собственным ключом (DEK), который хранится в базе зашифрованным
+// ключом сервера (WrapKey). Nonce блока - его номер, поэтому nonce не хранится:
+// для каждого файла ключ новый и пара ключ/nonce не повторяется. В AAD входят номер
+// блока и признак последнего блока, что не дает переставить или отрезать блоки.
+const (
+	streamMagic     = "MENC"
+	streamVersion   = 1
+	streamHeaderLen = 12
+
+	// DefaultChunkSize - размер блока открытого текста
+	DefaultChunkSize = 64 * 1024
+	// FileKeySize - размер ключа файла (AES-256)
+	FileKeySize = 32
+
+	streamTagSize   = 16
+	streamNonceSize = 12
+)
+
+// ErrInvalidStream - файл поврежден или не является зашифрованным файлом
+var ErrInvalidStream = errors.New("некорректный формат зашифрованного файла")
+
+// EncryptedSize возвращает размер зашифрованного файла для открытого текста plainSize
+func EncryptedSize(plainSize int64, chunkSize int) int64 {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	chunks := max((plainSize+int64(chunkSize)-1)/int64(chunkSize), 1)
+	return streamHeaderLen + plainSize + chunks*streamTagSize
+}
+
+// NewFileKey создает случайный ключ для шифрования одного файла
+func NewFileKey() ([]byte, error) {
+	key := make([]byte, FileKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WrapKey шифрует ключ файла ключом сервера для хранения в базе
+func WrapKey(fileKey []byte) ([]byte, error) {
+	return Encrypt(fileKey)
+}
+
+// UnwrapKey расшифровывает ключ файла, сохраненный WrapKey
+func UnwrapKey(wrapped []byte) ([]byte, error) {
+	key, err := Decrypt(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка расшифровки ключа файла: %w", err)
+	}
+	if len(key) != FileKeySize {
+		return nil, errors.New("некорректный размер ключа файла")
+	}
+	return key, nil
+}
+
+func newStreamAEAD(fileKey []byte) (cipher.AEAD, error) {
+	if len(fileKey) != FileKeySize {
+		return nil, errors.New("некорректный размер ключа файла")
+	}
+	block, err := aes.NewCipher(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkParams возвращает nonce и AAD блока с номером index
+func chunkParams(index uint64, final bool) (nonce, aad []byte) {
+	nonce = make([]byte, streamNonceSize)
+	binary.BigEndian.PutUint64(nonce[4:], index)
+	aad = make([]byte, 9)
+	binary.BigEndian.PutUint64(aad, index)
+	if final {
+		aad[8] = 1
+	}
+	return nonce, aad
+}
+
+// StreamWriter шифрует поток блоками, не держа в памяти больше одного блока
+type StreamWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	buf    []byte
+	out    []byte
+	index  uint64
+	closed bool
+}
+
+// NewStreamWriter записывает заголовок и возвращает писателя, шифрующего данные в w.
+// Close обязателен: он записывает последний блок
+func NewStreamWriter(w io.Writer, fileKey []byte, chunkSize int) (*StreamWriter, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	aead, err := newStreamAEAD(fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, streamHeaderLen)
+	copy(header, streamMagic)
+	header[4] = streamVersion
+	binary.BigEndian.PutUint32(header[8:], uint32(chunkSize))
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &StreamWriter{
+		w:    w,
+		aead: aead,
+		buf:  make([]byte, 0, chunkSize),
+		out:  make([]byte, 0, chunkSize+streamTagSize),
+	}, nil
+}
+
+// Write накапливает данные и шифрует заполненные блоки. Полный блок записывается только
+// когда приходят следующие данные: до этого неизвестно, последний ли он
+func (s *StreamWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("запись в закрытый поток")
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(s.buf) == cap(s.buf) {
+			if err := s.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(s.buf[len(s.buf):cap(s.buf)], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close шифрует и записывает последний блок (для пустого файла - пустой блок)
+func (s *StreamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.flush(true)
+}
+
+func (s *StreamWriter) flush(final bool) error {
+	nonce, aad := chunkParams(s.index, final)
+	s.out = s.aead.Seal(s.out[:0], nonce, s.buf, aad)
+	if _, err := s.w.Write(s.out); err != nil {
+		return err
+	}
+	s.index++
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// StreamReader расшифровывает файл с произвольным доступом. Реализует io.ReadSeeker,
+// поэтому подходит для http.ServeContent и запросов Range: расшифровываются только нужные блоки
+type StreamReader struct {
+	r         io.ReaderAt
+	aead      cipher.AEAD
+	chunkSize int64
+	chunks    int64
+	bodySize  int64
+	size      int64
+
+	pos int64
+
+	// Последний расшифрованный блок
+	cached     []byte
+	cachedIdx  int64
+	cipherBuf  []byte
+	cacheValid bool
+}
+
+// NewStreamReader проверяет заголовок файла размером size и возвращает читателя открытого текста
+func NewStreamReader(r io.ReaderAt, size int64, fileKey []byte) (*StreamReader, error) {
+	aead, err := newStreamAEAD(fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, streamHeaderLen)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidStream, err)
+	}
+	if string(header[:4]) != streamMagic || header[4] != streamVersion {
+		return nil, ErrInvalidStream
+	}
+	chunkSize := int64(binary.BigEndian.Uint32(header[8:]))
+	if chunkSize == 0 {
+		return nil, ErrInvalidStream
+	}
+
+	// Размер открытого текста вычисляется по размеру файла: все блоки, кроме последнего, полные
+	encChunk := chunkSize + streamTagSize
+	bodySize := size - streamHeaderLen
+	if bodySize < streamTagSize {
+		return nil, ErrInvalidStream
+	}
+	chunks := (bodySize + encChunk - 1) / encChunk
+	if bodySize-(chunks-1)*encChunk < streamTagSize {
+		return nil, ErrInvalidStream
+	}
+
+	return &StreamReader{
+		r:         r,
+		aead:      aead,
+		chunkSize: chunkSize,
+		chunks:    chunks,
+		bodySize:  bodySize,
+		size:      bodySize - chunks*streamTagSize,
+		cipherBuf: make([]byte, encChunk),
+	}, nil
+}
+
+// Size возвращает размер открытого текста
+func (s *StreamReader) Size() int64 {
+	return s.size
+}
+
+// Read читает открытый текст с текущей позиции
+func (s *StreamReader) Read(p []byte) (int, error) {
+	if s.pos >= s.size {
+		// Пустой файл все равно состоит из одного блока, его подлинность проверяется
+		if s.size == 0 && !s.cacheValid {
+			if _, err := s.chunk(0); err != nil {
+				return 0, err
+			}
+		}
+		return 0, io.EOF
+	}
+
+	read := 0
+	for read < len(p) && s.pos < s.size {
+		idx := s.pos / s.chunkSize
+		plain, err := s.chunk(idx)
+		if err != nil {
+			return read, err
+		}
+		n := copy(p[read:], plain[s.pos-idx*s.chunkSize:])
+		read += n
+		s.pos += int64(n)
+	}
+	return read, nil
+}
+
+// Seek меняет позицию чтения в открытом тексте
+func (s *StreamReader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = s.pos + offset
+	case io.SeekEnd:
+		pos = s.size + offset
+	default:
+		return 0, errors.New("некорректный whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("отрицательная позиция")
+	}
+	s.pos = pos
+	return pos, nil
+}
+
+// chunk расшифровывает блок idx и проверяет его подлинность
+func (s *StreamReader) chunk(idx int64) ([]byte, error) {
+	if s.cacheValid && s.cachedIdx == idx {
+		return s.cached, nil
+	}
+
+	encChunk := s.chunkSize + streamTagSize
+	start := idx * encChunk
+	length := min(encChunk, s.bodySize-start)
+	buf := s.cipherBuf[:length]
+	if _, err := s.r.ReadAt(buf, streamHeaderLen+start); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	nonce, aad := chunkParams(uint64(idx), idx == s.chunks-1)
+	plain, err := s.aead.Open(s.cached[:0], nonce, buf, aad)
+	if err != nil {
+		s.cacheValid = false
+		return nil, fmt.Errorf("%w: блок %d не прошел проверку подлинности", ErrInvalidStream, idx)
+	}
+	s.cached, s.cachedIdx, s.cacheValid = plain, idx, true
+	return plain, nil
+}
@@ -1,4 +1,5 @@
 import axios from 'axios';
+import { getAccessToken, handleUnauthorized } from '../utils/api';
 
 // Базовый URL для API
 const API_URL = '/api';
@@ -16,7 +17,7 @@ const apiClient = axios.create({
 // Перехватчик для добавления заголовка авторизации к запросу
 apiClient.interceptors.request.use(
   config => {
-    const token = getToken();
+    const token = getAccessToken();
     if (token) {
       config.headers['Authorization'] = `Bearer ${token}`;
     }
@@ -33,14 +34,9 @@ apiClient.interceptors.response.use(
     return response;
   },
   error => {
-    // Если сервер вернул ошибку 401 (Unauthorized), очищаем токен
+    // Если сервер вернул ошибку 401 (Unauthorized), обновляем токен и повторяем запрос
     if (error.response && error.response.status === 401) {
-      removeToken();
-      
-      // Перенаправляем на страницу входа, если не на ней
-      if (window.location.pathname !== '/login') {
-        window.location.href = '/login';
-      }
+      return handleUnauthorized(apiClient, error);
     }
     return Promise.reject(error);
   }
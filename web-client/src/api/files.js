@@ -10,16 +10,16 @@ class FilesAPI {
   /**
    * Загрузка файла
    * @param {File} file - Файл для загрузки
-   * @param {number} recipientId - ID получателя
+   * @param {number} chatId - ID чата
    * @param {string} message - Сопроводительное сообщение (опционально)
-   * @returns {Promise<Object>} Данные о загруженном файле
+   * @returns {Promise<Object>} Созданное сообщение с файлом
    */
-  async uploadFile(file, recipientId, message) {
+  async uploadFile(file, chatId, message) {
     try {
       // Создаем FormData для отправки файла
       const formData = new FormData();
       formData.append('file', file);
-      formData.append('recipient_id', recipientId);
+      formData.append('chat_id', chatId);
       if (message) {
         formData.append('message', message);
       }
@@ -45,13 +45,17 @@ class FilesAPI {
   }
 
   /**
-   * Получение URL для скачивания файла
-   * @param {string} token - Токен для скачивания файла
-   * @returns {string} URL для скачивания файла
+   * Получение временной подписанной ссылки на файл.
+   * Ссылка работает без заголовка Authorization, поэтому подходит для <img> и скачивания
+   * @param {number} fileId - ID файла
+   * @param {boolean} inline - Показывать файл в браузере, а не скачивать
+   * @returns {Promise<string>} URL файла
    */
-  getDownloadUrl(token) {
-    // URL остается прежним, так как он публичный и не требует Authorization
-    return `${API_URL}/files/download/${token}`;
+  async getSignedUrl(fileId, inline = false) {
+    const response = await API.get(`/files/${fileId}/url`, {
+      params: inline ? { inline: 1 } : undefined
+    });
+    return response.data.url;
   }
   
   /**
@@ -97,4 +101,4 @@ class FilesAPI {
 
 // Экспорт экземпляра класса
 const filesApi = new FilesAPI();
-export default filesApi; 
\ No newline at end of file
+export default filesApi; 
\ No newline at end of file
@@ -1,4 +1,4 @@
-import React from 'react';
+import React, { useEffect, useState } from 'react';
 import { 
   Box, 
   Typography, 
@@ -8,8 +8,7 @@ import {
   CardMedia,
   CardActions,
   Button,
-  IconButton,
-  Link
+  IconButton
 } from '@mui/material';
 import DoneIcon from '@mui/icons-material/Done';
 import DoneAllIcon from '@mui/icons-material/DoneAll';
@@ -22,6 +21,73 @@ import { format } from 'date-fns';
 import { ru } from 'date-fns/locale';
 import filesApi from '../../api/files';
 
+/**
+ * Файл, прикрепленный к сообщению. Ссылки на файл подписываются сервером и действуют ограниченное время
+ */
+const FileAttachment = ({ file, getFileIcon }) => {
+  const [previewUrl, setPreviewUrl] = useState(null);
+  const fileSize = filesApi.formatFileSize(file.file_size);
+
+  useEffect(() => {
+    if (file.file_type !== 'image') return undefined;
+
+    let cancelled = false;
+    filesApi.getSignedUrl(file.id, true)
+      .then((url) => { if (!cancelled) setPreviewUrl(url); })
+      .catch((error) => console.error('Ошибка получения ссылки на файл:', error));
+    return () => { cancelled = true; };
+  }, [file.id, file.file_type]);
+
+  // Ссылка для скачивания запрашивается при нажатии, чтобы не истекла заранее
+  const handleDownload = async () => {
+    try {
+      window.open(await filesApi.getSignedUrl(file.id), '_blank');
+    } catch (error) {
+      console.error('Ошибка получения ссылки на файл:', error);
+    }
+  };
+
+  return (
+    <Card sx={{ maxWidth: 300, mb: 1, backgroundColor: 'transparent' }}>
+      {file.file_type === 'image' ? (
+        <CardMedia
+          component="img"
+          height="140"
+          image={previewUrl || undefined}
+          alt={file.file_name}
+          sx={{ objectFit: 'contain' }}
+        />
+      ) : (
+        <Box sx={{ 
+          display: 'flex', 
+          justifyContent: 'center', 
+          p: 2, 
+          backgroundColor: 'background.default' 
+        }}>
+          {getFileIcon(file.file_type)}
+        </Box>
+      )}
+      <CardContent sx={{ p: 1 }}>
+        <Typography variant="body2" noWrap title={file.file_name}>
+          {file.file_name}
+        </Typography>
+        <Typography variant="caption" color="text.secondary">
+          {fileSize}
+        </Typography>
+      </CardContent>
+      <CardActions>
+        <Button 
+          size="small" 
+          startIcon={<GetAppIcon />}
+          onClick={handleDownload}
+        >
+          Скачать
+        </Button>
+      </CardActions>
+    </Card>
+  );
+};
+
 /**
  * Компонент для отображения одного сообщения в чате
  */
@@ -31,8 +97,9 @@ const MessageItem = ({ message, isOutgoing }) => {
     ? format(new Date(message.created_at), 'HH:mm', { locale: ru })
     : '';
   
-  // Проверяем, есть ли у сообщения файлы
-  const hasFiles = message.files && message.files.length > 0;
+  // Проверяем, есть ли у сообщения файлы (сервер присылает один файл в поле file)
+  const files = message.files || (message.file ? [message.file] : []);
+  const hasFiles = files.length > 0;
   const messageType = message.message_type || 'text';
   
   // Определяем иконку в зависимости от типа файла
@@ -52,53 +119,9 @@ const MessageItem = ({ message, isOutgoing }) => {
   };
   
   // Рендер файла в сообщении
-  const renderFile = (file) => {
-    const downloadUrl = filesApi.getDownloadUrl(file.download_token);
-    const fileSize = filesApi.formatFileSize(file.file_size);
-    
-    return (
-      <Card key={file.id} sx={{ maxWidth: 300, mb: 1, backgroundColor: 'transparent' }}>
-        {file.file_type === 'image' ? (
-          <CardMedia
-            component="img"
-            height="140"
-            image={downloadUrl}
-            alt={file.file_name}
-            sx={{ objectFit: 'contain' }}
-          />
-        ) : (
-          <Box sx={{ 
-            display: 'flex', 
-            justifyContent: 'center', 
-            p: 2, 
-            backgroundColor: 'background.default' 
-          }}>
-            {getFileIcon(file.file_type)}
-          </Box>
-        )}
-        <CardContent sx={{ p: 1 }}>
-          <Typography variant="body2" noWrap title={file.file_name}>
-            {file.file_name}
-          </Typography>
-          <Typography variant="caption" color="text.secondary">
-            {fileSize}
-          </Typography>
-        </CardContent>
-        <CardActions>
-          <Button 
-            size="small" 
-            startIcon={<GetAppIcon />}
-            component={Link}
-            href={downloadUrl}
-            target="_blank"
-            download
-          >
-            Скачать
-          </Button>
-        </CardActions>
-      </Card>
-    );
-  };
+  const renderFile = (file) => (
+    <FileAttachment key={file.id} file={file} getFileIcon={getFileIcon} />
+  );
 
   return (
     <Box
@@ -139,7 +162,7 @@ const MessageItem = ({ message, isOutgoing }) => {
         }}
       >
         {/* Файлы */}
-        {hasFiles && message.files.map(file => renderFile(file))}
+        {hasFiles && files.map(file => renderFile(file))}
         
         {/* Текстовое содержимое */}
         {message.content && (
@@ -1,5 +1,5 @@
 import React, { createContext, useContext, useState, useEffect, useCallback } from 'react';
-import API from '../utils/api'; // Используем стандартизированный API
+import API, { setAuthTokens, clearAuthTokens } from '../utils/api'; // Используем стандартизированный API
 import { jwtDecode } from 'jwt-decode'; // Импортируем jwt-decode, если еще не импортирован
 import { logger } from '../config';
 import wsService from '../utils/websocket'; // Импортируем WebSocket сервис
@@ -83,14 +83,10 @@ export const AuthProvider = ({ children }) => {
     console.log('AuthContext: Очистка данных аутентификации');
     
     try {
-      // Удаляем все связанные с аутентификацией данные
-      localStorage.removeItem('auth_token');
-      localStorage.removeItem('token');
+      // Удаляем токены, заголовок авторизации и данные пользователя
+      clearAuthTokens();
       localStorage.removeItem('user_data');
       
-      // Удаляем заголовок авторизации
-      delete API.defaults.headers.common['Authorization'];
-      
       return true;
     } catch (e) {
       console.error('AuthContext: Ошибка при очистке данных аутентификации:', e);
@@ -98,18 +94,23 @@ export const AuthProvider = ({ children }) => {
     }
   }, []);
   
-  // Функция выхода
-  const logout = useCallback(() => {
+  // Функция выхода: сервер отзывает сессию, после чего ее refresh токен перестает действовать
+  const logout = useCallback(async () => {
     logger.log('AuthContext: Выход из системы');
-    localStorage.removeItem('token');
+    if (localStorage.getItem('token')) {
+      try {
+        await API.post('/auth/logout');
+      } catch (err) {
+        logger.warn('AuthContext: Не удалось завершить сессию на сервере', err);
+      }
+    }
+    clearAuthTokens();
     localStorage.removeItem('user_data');
     setUser(null);
     setToken(null);
     setIsAuthenticated(false);
     setAdmin(false);
-    // Удаляем заголовок авторизации
-    delete API.defaults.headers.common['Authorization'];
-    logger.log('AuthContext: Удален заголовок Authorization');
+    logger.log('AuthContext: Удалены токены и заголовок Authorization');
     // Отключаем WebSocket
     // Эту логику лучше перенести в WebSocketContext
     // wsDisconnect();
@@ -174,18 +175,18 @@ export const AuthProvider = ({ children }) => {
 
     try {
       const response = await API.post('/auth/login', { username, password }); // Используем API
-      const { token, user } = response.data;
+      const { token, refresh_token: refreshToken, user } = response.data;
 
       if (token && user) {
         logger.log('AuthContext: Успешный вход, получен токен и данные пользователя');
-        localStorage.setItem('token', token);
+        // Сохраняем пару токенов и устанавливаем заголовок по умолчанию для будущих запросов API.
+        // Access токен живет недолго, refresh токен нужен, чтобы получить новый без повторного входа
+        setAuthTokens(token, refreshToken);
         localStorage.setItem('user_data', JSON.stringify(user));
         setUser(user);
         setToken(token);
         setIsAuthenticated(true);
         setAdmin(user.role === 'admin');
-        // Устанавливаем заголовок по умолчанию для будущих запросов API
-        API.defaults.headers.common['Authorization'] = `Bearer ${token}`;
         logger.log('AuthContext: Установлен заголовок Authorization для запросов');
 
         // Инициируем WebSocket соединение после успешного входа
@@ -281,6 +282,13 @@ export const AuthProvider = ({ children }) => {
     }
   }, [isAuthenticated]);
   
+  // Обновленный перехватчиком API access токен передаем потребителям контекста
+  useEffect(() => {
+    const handleTokens = (event) => setToken(event.detail.token);
+    window.addEventListener('auth:tokens', handleTokens);
+    return () => window.removeEventListener('auth:tokens', handleTokens);
+  }, []);
+
   // Проверка сессии при загрузке компонента
   useEffect(() => {
     console.log('AuthContext: Инициализация');
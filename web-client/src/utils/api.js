@@ -1,4 +1,9 @@
 import axios from 'axios';
+import { jwtDecode } from 'jwt-decode';
+
+// Ключи токенов в localStorage
+const TOKEN_KEY = 'token';
+const REFRESH_TOKEN_KEY = 'refresh_token';
 
 // Создаем экземпляр axios с базовыми настройками
 const API = axios.create({
@@ -9,10 +14,101 @@ const API = axios.create({
   timeout: 10000, // 10 секунд
 });
 
+export const getAccessToken = () => localStorage.getItem(TOKEN_KEY);
+export const getRefreshToken = () => localStorage.getItem(REFRESH_TOKEN_KEY);
+
+// Сохранение пары токенов после входа или обновления. Подписчики события auth:tokens
+// (AuthContext) получают новый access токен
+export const setAuthTokens = (token, refreshToken) => {
+  localStorage.setItem(TOKEN_KEY, token);
+  if (refreshToken) {
+    localStorage.setItem(REFRESH_TOKEN_KEY, refreshToken);
+  }
+  API.defaults.headers.common.Authorization = `Bearer ${token}`;
+  window.dispatchEvent(new CustomEvent('auth:tokens', { detail: { token } }));
+};
+
+// Удаление токенов при выходе
+export const clearAuthTokens = () => {
+  localStorage.removeItem(TOKEN_KEY);
+  localStorage.removeItem(REFRESH_TOKEN_KEY);
+  localStorage.removeItem('auth_token');
+  delete API.defaults.headers.common.Authorization;
+};
+
+// Текущий запрос обновления токенов. Refresh токен одноразовый: второй запрос с тем же
+// токеном сервер считает кражей и отзывает сессию, поэтому параллельные 401 ждут один запрос
+let refreshPromise = null;
+
+// Обновление access токена через POST /api/auth/refresh. Запрос идет мимо перехватчиков API,
+// чтобы его 401 не запускал новое обновление
+export const refreshAccessToken = () => {
+  if (!refreshPromise) {
+    const refreshToken = getRefreshToken();
+    const request = refreshToken
+      ? axios.post('/api/auth/refresh', { refresh_token: refreshToken })
+      : Promise.reject(new Error('Нет refresh токена'));
+
+    refreshPromise = request
+      .then(({ data }) => {
+        setAuthTokens(data.token, data.refresh_token);
+        return data.token;
+      })
+      .finally(() => {
+        refreshPromise = null;
+      });
+  }
+  return refreshPromise;
+};
+
+// Возвращает действующий access токен, заранее обновляя истекающий. Нужен там, где 401
+// не перехватить, например при переподключении WebSocket
+export const getFreshAccessToken = async () => {
+  const token = getAccessToken();
+  if (token) {
+    try {
+      if (jwtDecode(token).exp * 1000 - Date.now() > 30000) {
+        return token;
+      }
+    } catch (e) {
+      // Нечитаемый токен обновляем
+    }
+  }
+  return refreshAccessToken();
+};
+
+// Запросы, 401 на которые означает неверные данные, а не истекший токен
+const isTokenRequest = (url = '') => url.includes('/auth/login') || url.includes('/auth/refresh');
+
+// Обработка 401: обновляем токены и один раз повторяем запрос через client.
+// Если обновить не удалось, сессия завершена - выходим из системы
+export const handleUnauthorized = async (client, error) => {
+  const original = error.config;
+  if (original && !original._retried && !isTokenRequest(original.url) && getRefreshToken()) {
+    original._retried = true;
+    try {
+      const token = await refreshAccessToken();
+      original.headers.Authorization = `Bearer ${token}`;
+      return client(original);
+    } catch (refreshError) {
+      console.warn('API: Не удалось обновить токен', refreshError);
+    }
+  }
+
+  clearAuthTokens();
+  localStorage.removeItem('user');
+  localStorage.removeItem('user_data');
+  // Перенаправляем на страницу логина, если не там
+  if (window.location.pathname !== '/login') {
+    window.location.href = '/login';
+  }
+  return Promise.reject(error);
+};
+
 // Перехватчик для запросов - добавляем токен авторизации, если он есть
 API.interceptors.request.use(
   (config) => {
-    const token = localStorage.getItem('token');
+    const token = getAccessToken();
     if (token) {
       config.headers.Authorization = `Bearer ${token}`;
     }
@@ -29,14 +125,9 @@ API.interceptors.response.use(
     return response;
   },
   (error) => {
-    // Если ошибка 401 (не авторизован), выходим из системы
+    // Если ошибка 401 (не авторизован), обновляем токен и повторяем запрос
     if (error.response && error.response.status === 401) {
-      localStorage.removeItem('token');
-      localStorage.removeItem('user');
-      // Перенаправляем на страницу логина, если не там
-      if (window.location.pathname !== '/login') {
-        window.location.href = '/login';
-      }
+      return handleUnauthorized(API, error);
     }
 
     // Логируем ошибки в консоль в режиме разработки
@@ -3,6 +3,7 @@
  */
 import { toast } from 'react-toastify';
 import { WS_URL, API_URL } from '../config';
+import { getFreshAccessToken } from './api';
 
 class WebSocketService {
   constructor() {
@@ -178,8 +179,14 @@ class WebSocketService {
     const delay = this.baseReconnectDelay * Math.pow(2, this.reconnectAttempts);
     console.log(`WebSocketService: Попытка переподключения через ${delay}ms (попытка ${this.reconnectAttempts + 1}/${this.maxReconnectAttempts})`);
     
-    this.reconnectTimer = setTimeout(() => {
+    this.reconnectTimer = setTimeout(async () => {
       this.reconnectAttempts += 1;
+      // Access токен живет недолго: к моменту переподключения он мог истечь
+      try {
+        this.token = await getFreshAccessToken();
+      } catch (error) {
+        console.warn('WebSocketService: Не удалось обновить токен перед переподключением', error);
+      }
       this.connect();
     }, delay);
   }